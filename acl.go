@@ -0,0 +1,409 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ACL_DEFAULT_USER is the user connections act as before AUTH. Without
+// require_auth it needs no password, so clients that never authenticate
+// keep full access.
+const ACL_DEFAULT_USER = "default"
+
+// User is an ACL user and the commands and keys it may use
+type User struct {
+	username        string
+	hashedPassword  string        // Hex SHA-256 of the password, empty with nopass
+	nopass          bool          // Any password is accepted
+	allowedCommands map[byte]bool // nil allows every command
+	keyPattern      string        // Glob the command's key must match, empty for all keys
+}
+
+// ACLManager holds the ACL users and answers permission checks
+type ACLManager struct {
+	users map[string]*User
+	match func(pattern, key string) bool
+	mutex sync.RWMutex
+}
+
+func NewACLManager(match func(pattern, key string) bool) *ACLManager {
+	m := &ACLManager{users: make(map[string]*User), match: match}
+	m.users[ACL_DEFAULT_USER] = &User{username: ACL_DEFAULT_USER, nopass: true}
+	return m
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseACLUser parses a Redis-style user rule such as
+// "alice >secret ~cache:* +GET +SET". Supported rules are >password, nopass,
+// ~pattern, allkeys, +command, -command, +@all (or allcommands) and -@all.
+// Like in Redis, a new user may run no commands until rules grant them.
+func ParseACLUser(rule string) (*User, error) {
+	fields := strings.Fields(rule)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty ACL rule")
+	}
+
+	user := &User{username: fields[0], allowedCommands: make(map[byte]bool)}
+	for _, field := range fields[1:] {
+		switch {
+		case field == "nopass":
+			user.nopass = true
+			user.hashedPassword = ""
+		case strings.HasPrefix(field, ">"):
+			user.nopass = false
+			user.hashedPassword = hashPassword(field[1:])
+		case field == "allkeys":
+			user.keyPattern = ""
+		case strings.HasPrefix(field, "~"):
+			user.keyPattern = field[1:]
+		case field == "+@all" || field == "allcommands":
+			user.allowedCommands = nil
+		case field == "-@all" || field == "nocommands":
+			user.allowedCommands = make(map[byte]bool)
+		case strings.HasPrefix(field, "+") || strings.HasPrefix(field, "-"):
+			command, ok := commandsByName[strings.ToUpper(field[1:])]
+			if !ok {
+				return nil, fmt.Errorf("unknown command %q in ACL rule for %s", field[1:], user.username)
+			}
+			if user.allowedCommands == nil {
+				if field[0] == '+' {
+					continue // Already allowed
+				}
+				// Removing from everything starts from the full command set
+				user.allowedCommands = make(map[byte]bool, len(commandNames))
+				for c := range commandNames {
+					user.allowedCommands[c] = true
+				}
+			}
+			if field[0] == '+' {
+				user.allowedCommands[command] = true
+			} else {
+				delete(user.allowedCommands, command)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported ACL rule %q for %s", field, user.username)
+		}
+	}
+
+	if !user.nopass && user.hashedPassword == "" {
+		return nil, fmt.Errorf("ACL user %s needs a >password or nopass", user.username)
+	}
+	return user, nil
+}
+
+// Configure rebuilds the users from the config: the default user takes the
+// server password when require_auth is set, and acl_users add or replace
+// users
+func (m *ACLManager) Configure(config *Config) error {
+	users := make(map[string]*User)
+	defaultUser := &User{username: ACL_DEFAULT_USER, nopass: true}
+	if config.RequireAuth {
+		defaultUser.nopass = false
+		defaultUser.hashedPassword = hashPassword(config.Password)
+	}
+	users[ACL_DEFAULT_USER] = defaultUser
+
+	for _, rule := range config.ACLUsers {
+		user, err := ParseACLUser(rule)
+		if err != nil {
+			return err
+		}
+		users[user.username] = user
+	}
+
+	m.mutex.Lock()
+	m.users = users
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *ACLManager) SetUser(user *User) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.users[user.username] = user
+}
+
+func (m *ACLManager) DeleteUser(username string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.users, username)
+}
+
+// Authenticate reports whether password is valid for username
+func (m *ACLManager) Authenticate(username, password string) bool {
+	m.mutex.RLock()
+	user, ok := m.users[username]
+	m.mutex.RUnlock()
+	if !ok {
+		return false
+	}
+	if user.nopass {
+		return true
+	}
+	hashed := hashPassword(password)
+	return subtle.ConstantTimeCompare([]byte(hashed), []byte(user.hashedPassword)) == 1
+}
+
+// RequiresAuth reports whether connections must AUTH before running
+// commands, which is when the default user has a password
+func (m *ACLManager) RequiresAuth() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	user, ok := m.users[ACL_DEFAULT_USER]
+	return !ok || !user.nopass
+}
+
+// CanExecute reports whether username may run command on key. An empty key
+// is not checked against the user's key pattern.
+func (m *ACLManager) CanExecute(username string, command byte, key string) bool {
+	m.mutex.RLock()
+	user, ok := m.users[username]
+	m.mutex.RUnlock()
+	if !ok {
+		return false
+	}
+	if user.allowedCommands != nil && !user.allowedCommands[command] {
+		return false
+	}
+	if key != "" && user.keyPattern != "" && !m.match(user.keyPattern, key) {
+		return false
+	}
+	return true
+}
+
+// IsAdmin reports whether username may run every command, which
+// server-wide commands such as CLIENT KILL require
+func (m *ACLManager) IsAdmin(username string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	user, ok := m.users[username]
+	return ok && user.allowedCommands == nil
+}
+
+// RestrictsKeys reports whether username is limited to keys matching a
+// pattern, so the keys a command carries in its payload must be checked
+func (m *ACLManager) RestrictsKeys(username string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	user, ok := m.users[username]
+	return ok && user.keyPattern != ""
+}
+
+// CanAccessKey reports whether username may use key. Unlike CanExecute an
+// empty key is checked like any other.
+func (m *ACLManager) CanAccessKey(username, key string) bool {
+	m.mutex.RLock()
+	user, ok := m.users[username]
+	m.mutex.RUnlock()
+	if !ok {
+		return false
+	}
+	return user.keyPattern == "" || m.match(user.keyPattern, key)
+}
+
+// payloadKeys returns the keys msg carries in its payload besides msg.Key,
+// decoded the way their handlers read them. A malformed payload yields the
+// keys before the error, which are the only ones its handler can reach.
+func payloadKeys(msg *Message) []string {
+	args := newArgReader(msg.Value)
+	switch msg.Command {
+	case CMD_MGET, CMD_WATCH, CMD_BLPOP, CMD_BRPOP, CMD_LMPOP, CMD_BZPOPMIN, CMD_BZPOPMAX,
+		CMD_SUNION, CMD_SINTER, CMD_SDIFF, CMD_SUNIONSTORE, CMD_SINTERSTORE, CMD_SDIFFSTORE, CMD_SINTERCARD,
+		CMD_ZUNIONSTORE, CMD_ZINTERSTORE, CMD_ZDIFF, CMD_ZDIFFSTORE:
+		// [numkeys:4][key1len:4][key1]...
+		return args.keyList()
+
+	case CMD_SUBSCRIBE, CMD_PSUBSCRIBE:
+		// [numchannels:4][channel1len:4][channel1]..., checked like PUBLISH's
+		// channel, which is its key
+		return args.keyList()
+
+	case CMD_BZMPOP, CMD_XREAD:
+		// [timeout or count:4][numkeys:4][key1len:4][key1]...
+		args.uint32()
+		return args.keyList()
+
+	case CMD_XREADGROUP:
+		// [grouplen:4][group][consumerlen:4][consumer][count:4][flags:1][numkeys:4]...
+		args.string()
+		args.string()
+		args.uint32()
+		args.uint8()
+		return args.keyList()
+
+	case CMD_BITOP:
+		// [op:1][destlen:4][dest][numkeys:4][key1len:4][key1]...
+		args.uint8()
+		keys := []string{args.string()}
+		return append(keys, args.keyList()...)
+
+	case CMD_LMOVE, CMD_SMOVE, CMD_ZRANGESTORE:
+		// [destination or sourcelen:4][key]...
+		return []string{args.string()}
+
+	case CMD_XGROUP, CMD_XINFO, CMD_OBJECT:
+		// [subcommand:1][keylen:4][key]...
+		args.uint8()
+		return []string{args.string()}
+
+	case CMD_MSET:
+		// [count:4] then per pair [keylen:4][key][valuelen:4][value][ttl:4]
+		var keys []string
+		for count := args.uint32(); count > 0 && args.err == nil; count-- {
+			key := args.string()
+			args.next(int(args.uint32())) // Value
+			args.uint32()                 // TTL
+			if args.err != nil {
+				break
+			}
+			keys = append(keys, key)
+		}
+		return keys
+	}
+	return nil
+}
+
+// checkACL returns the error response when the connection may not run msg,
+// or nil when it may. A nil state is a trusted internal caller.
+func (s *GoFastServer) checkACL(state *connState, msg *Message) []byte {
+	if state == nil {
+		return nil
+	}
+
+	username := state.username
+	if username == "" {
+		if s.aclManager.RequiresAuth() {
+			return s.createResponse(RESP_ERROR, []byte("NOAUTH Authentication required"))
+		}
+		username = ACL_DEFAULT_USER
+	}
+	// A pipeline only carries commands, which are checked one by one
+	if msg.Command != CMD_PIPELINE && !s.aclManager.CanExecute(username, msg.Command, string(msg.Key)) {
+		return s.createResponse(RESP_ERROR, []byte("NOPERM this user has no permissions to run the command"))
+	}
+	if s.aclManager.RestrictsKeys(username) {
+		for _, key := range payloadKeys(msg) {
+			if !s.aclManager.CanAccessKey(username, key) {
+				return s.createResponse(RESP_ERROR, []byte("NOPERM this user has no permissions to access one of the keys used as arguments"))
+			}
+		}
+	}
+	return nil
+}
+
+// isAdmin reports whether the connection acts as an admin user. A nil
+// state is a trusted internal caller.
+func (s *GoFastServer) isAdmin(state *connState) bool {
+	if state == nil {
+		return true
+	}
+	username := state.username
+	if username == "" {
+		username = ACL_DEFAULT_USER
+	}
+	return s.aclManager.IsAdmin(username)
+}
+
+// handleAuth authenticates the connection as an ACL user. An empty
+// username means the default user, as in Redis' single-argument AUTH.
+// Format: [userlen:4][username][passlen:4][password]
+func (s *GoFastServer) handleAuth(state *connState, data []byte) []byte {
+	s.incrementStat("total_ops")
+
+	args := newArgReader(data)
+	username := args.string()
+	password := args.string()
+	if args.err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid AUTH data"))
+	}
+	if username == "" {
+		username = ACL_DEFAULT_USER
+	}
+
+	ok, retryAfter := s.authenticateClient(state.clientIP, username, password)
+	if retryAfter > 0 {
+		return s.createResponse(RESP_ERROR, []byte(fmt.Sprintf("ERR too many authentication failures, retry after %d seconds", retryAfter)))
+	}
+	if !ok {
+		return s.createResponse(RESP_ERROR, []byte("WRONGPASS invalid username-password pair or user is disabled"))
+	}
+	state.username = username
+	return s.createResponse(RESP_OK, nil)
+}
+
+// authMaxBackoff caps how long a client IP is locked out of AUTH
+const authMaxBackoff = 1800
+
+// AuthRecord counts a client IP's consecutive AUTH failures
+type AuthRecord struct {
+	FailCount   uint
+	LastFailAt  int64 // Unix seconds of the last failure
+	NextRetryAt int64 // Unix seconds until which AUTH is refused
+	mutex       sync.Mutex
+}
+
+// authenticateClient checks a password for a client IP. Once the IP has
+// failed max_auth_attempts times in a row, it is locked out for
+// 2^failures seconds and retryAfter reports the seconds left. Clients
+// without an IP, such as Unix socket peers, are not tracked.
+func (s *GoFastServer) authenticateClient(ip, username, password string) (ok bool, retryAfter int64) {
+	maxAttempts := 0
+	if s.config != nil {
+		maxAttempts = s.config.MaxAuthAttempts
+	}
+	if ip == "" || maxAttempts == 0 {
+		return s.aclManager.Authenticate(username, password), 0
+	}
+
+	value, _ := s.authTracker.LoadOrStore(ip, &AuthRecord{})
+	record := value.(*AuthRecord)
+	record.mutex.Lock()
+	defer record.mutex.Unlock()
+
+	now := time.Now().Unix()
+	if record.NextRetryAt > now {
+		return false, record.NextRetryAt - now
+	}
+
+	if s.aclManager.Authenticate(username, password) {
+		s.authTracker.Delete(ip)
+		return true, 0
+	}
+
+	record.FailCount++
+	record.LastFailAt = now
+	if record.FailCount >= uint(maxAttempts) {
+		backoff := int64(authMaxBackoff)
+		if record.FailCount < 11 { // 2^11 is past the cap
+			backoff = min(backoff, int64(1)<<record.FailCount)
+		}
+		record.NextRetryAt = now + backoff
+		logf(LOG_WARN, "Locked %s out of AUTH for %ds after %d failures", ip, backoff, record.FailCount)
+	}
+	return false, 0
+}
+
+// pruneAuthTracker forgets client IPs that have not failed AUTH or been
+// locked out for authMaxBackoff seconds, so IPs that fail once and never
+// come back do not pile up
+func (s *GoFastServer) pruneAuthTracker(now int64) {
+	s.authTracker.Range(func(key, value any) bool {
+		record := value.(*AuthRecord)
+		record.mutex.Lock()
+		idle := max(record.LastFailAt, record.NextRetryAt)+authMaxBackoff <= now
+		record.mutex.Unlock()
+		if idle {
+			s.authTracker.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gofast/persist"
+)
+
+// AOF_RESTORE is the record a rewrite uses for keys no single command can
+// recreate, such as streams with consumer groups. It holds [type:1] and the
+// RDB encoding of the value. No command uses this code.
+const AOF_RESTORE = 0xFF
+
+func isWriteCommand(command uint8) bool {
+	return singleKeyWrites[command] || multiKeyWrites[command]
+}
+
+// openAOF replays the AOF at path into the databases and opens it for
+// appending. A partly written last record is dropped so new records follow
+// valid ones.
+func (s *GoFastServer) openAOF(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create AOF directory: %v", err)
+	}
+	cipher, err := s.persistCipher()
+	if err != nil {
+		return err
+	}
+
+	replayed := 0
+	valid, err := persist.ReplayAOF(path, cipher, func(record persist.Record) error {
+		if int(record.DB) >= MAX_DATABASES {
+			return fmt.Errorf("AOF record for database %d", record.DB)
+		}
+		db := s.databases[record.DB]
+		if record.Command == AOF_RESTORE {
+			if len(record.Value) == 0 {
+				return fmt.Errorf("empty AOF restore record")
+			}
+			if err := db.restoreKey(string(record.Key), DataType(record.Value[0]), record.Value[1:], 0, record.Time); err != nil {
+				return err
+			}
+		} else {
+			msg := &Message{Command: record.Command, Key: record.Key, Value: record.Value, TTL: record.TTL}
+			db.runCommand(nil, msg, record.Time)
+		}
+		replayed++
+		return nil
+	})
+	if errors.Is(err, persist.ErrTruncatedAOF) {
+		logf(LOG_WARN, "AOF %s ends with a truncated record, dropping it", path)
+		err = persist.TruncateAOF(path, cipher, valid)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load AOF: %v", err)
+	}
+	if replayed > 0 {
+		logf(LOG_INFO, "Replayed %d commands from %s", replayed, path)
+	}
+
+	s.aof, err = persist.OpenAOF(path, cipher)
+	return err
+}
+
+// logWrite runs a command and, when it is a successful write, appends it to
+// the AOF followed by the pops it served to blocked clients. Writes are
+// serialized while logging so the file replays them in the order they took
+// effect. A blocking command lets the lock go while it waits.
+func (s *GoFastServer) logWrite(db *DatabaseState, msg *Message, now int64, run func() []byte) []byte {
+	if s.aof == nil || !isWriteCommand(msg.Command) {
+		return run()
+	}
+
+	s.aofMutex.Lock()
+	defer s.aofMutex.Unlock()
+	response := run()
+
+	if len(response) > 0 && response[0] == RESP_OK {
+		if record, ok := aofRecord(msg, response); ok {
+			record.Time = now
+			record.DB = uint8(db.index)
+			s.appendAOF(record)
+		}
+	}
+	s.flushServedPops(now)
+	return response
+}
+
+// logServedPop records a pop that handed data to a blocking command as the
+// plain pop with the same effect. The caller holds aofMutex.
+func (s *DatabaseState) logServedPop(command uint8, key string, count int) {
+	if s.aof == nil {
+		return
+	}
+	record := persist.Record{DB: uint8(s.index), Command: command, Key: []byte(key)}
+	if count > 0 {
+		record.Value = binary.BigEndian.AppendUint32(nil, uint32(count))
+	}
+	s.servedPops = append(s.servedPops, record)
+}
+
+// flushServedPops appends the pops logServedPop recorded. The caller holds
+// aofMutex.
+func (s *GoFastServer) flushServedPops(now int64) {
+	for _, record := range s.servedPops {
+		record.Time = now
+		s.appendAOF(record)
+	}
+	s.servedPops = s.servedPops[:0]
+}
+
+func (s *GoFastServer) appendAOF(record persist.Record) {
+	if err := s.aof.Append(record); err != nil {
+		logf(LOG_ERROR, "AOF append error: %v", err)
+	}
+}
+
+// aofRecord converts a command that succeeded into the record to log.
+// Commands whose effect depends on timing or chance are logged as the
+// deterministic command that has the same effect, taken from their reply:
+// SPOP as SREM and XADD with its assigned ID.
+func aofRecord(msg *Message, response []byte) (persist.Record, bool) {
+	record := persist.Record{Command: msg.Command, Key: msg.Key, Value: msg.Value, TTL: msg.TTL}
+	reply := response[5:]
+
+	switch msg.Command {
+	case CMD_BLPOP, CMD_BRPOP, CMD_BZPOPMIN, CMD_BZPOPMAX, CMD_BZMPOP:
+		// Logged as the pops they were served, by logServedPop
+		return record, false
+
+	case CMD_SPOP:
+		if len(reply) < 4 || binary.BigEndian.Uint32(reply) == 0 {
+			return record, false
+		}
+		record = persist.Record{Command: CMD_SREM, Key: msg.Key, Value: reply}
+
+	case CMD_XADD:
+		// Payload: [flags:1][thresholdlen:4][threshold]?[idlen:4][id][fields...]
+		args := newArgReader(msg.Value)
+		if args.uint8()&(XTRIM_MAXLEN|XTRIM_MINID) != 0 {
+			args.string()
+		}
+		idStart := args.offset
+		args.string()
+		if args.err != nil {
+			return record, false
+		}
+		value := append([]byte(nil), msg.Value[:idStart]...)
+		value = appendLenPrefixed(value, reply)
+		record.Value = append(value, msg.Value[args.offset:]...)
+	}
+	return record, true
+}
+
+// rewriteRecords returns the records recreating every live key, one
+// command per key followed by an EXPIREAT for keys with a TTL. The caller
+// holds aofMutex so no write lands while they are collected.
+func (s *GoFastServer) rewriteRecords(now int64) []persist.Record {
+	var records []persist.Record
+	for _, db := range s.databases {
+		db.storage.Range(func(key, value any) bool {
+			item := value.(*CacheItem)
+			if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+				return true
+			}
+
+			record := persist.Record{Time: now, DB: uint8(db.index), Key: []byte(key.(string))}
+			switch value := item.Value.(type) {
+			case []byte:
+				record.Command = CMD_SET
+				record.Value = item.stringValue()
+			case *List:
+				record.Command = CMD_RPUSH
+				record.Value = encodeSnapshotValue(item)
+			case *Set:
+				record.Command = CMD_SADD
+				record.Value = encodeSnapshotValue(item)
+			case *Hash:
+				record.Command = CMD_HMSET
+				record.Value = encodeSnapshotValue(item)
+			case *ZSet:
+				// Format: [flags:1][numentries:4][score:8][memberlen:4][member]...
+				entries := value.Range(0, -1, false)
+				record.Command = CMD_ZADD
+				record.Value = binary.BigEndian.AppendUint32([]byte{0}, uint32(len(entries)))
+				for _, entry := range entries {
+					record.Value = binary.BigEndian.AppendUint64(record.Value, math.Float64bits(entry.Score))
+					record.Value = appendLenPrefixed(record.Value, []byte(entry.Member))
+				}
+			default:
+				record.Command = AOF_RESTORE
+				record.Value = append([]byte{uint8(item.DataType)}, encodeSnapshotValue(item)...)
+			}
+			records = append(records, record)
+
+			if item.ExpiresAt > 0 {
+				records = append(records, persist.Record{
+					Time:    now,
+					DB:      uint8(db.index),
+					Command: CMD_EXPIREAT,
+					Key:     record.Key,
+					Value:   binary.BigEndian.AppendUint64(nil, uint64(item.ExpiresAt)),
+				})
+			}
+			return true
+		})
+	}
+	return records
+}
+
+// rewriteAOF compacts the AOF down to the records recreating the current
+// data. Writes are only held while the data is collected in memory; the
+// ones made while the new file is written are carried over from the old one.
+func (s *GoFastServer) rewriteAOF() (int, error) {
+	s.aofMutex.Lock()
+	records := s.rewriteRecords(time.Now().Unix())
+	base, err := s.aof.Offset()
+	s.aofMutex.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return len(records), s.aof.Rewrite(records, base)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// noWait is the gone channel of blocking commands in a pipeline or
+// transaction, which like Redis reply nil at once instead of waiting
+var noWait = func() <-chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// mustNotWait reports whether gone is already closed, so a blocking command
+// with nothing to pop replies nil instead of waiting
+func mustNotWait(gone <-chan struct{}) bool {
+	select {
+	case <-gone:
+		return true
+	default:
+		return false
+	}
+}
+
+// blockOn parks c on every one of its keys in registry
+func (s *GoFastServer) blockOn(registry *sync.Map, c *blockedClient) {
+	s.blockMutex.Lock()
+	defer s.blockMutex.Unlock()
+
+	for _, key := range c.keys {
+		queue, _ := registry.LoadOrStore(key, &[]*blockedClient{})
+		waiters := queue.(*[]*blockedClient)
+		*waiters = append(*waiters, c)
+	}
+}
+
+// removeBlocked drops c from all of its key queues. Caller holds blockMutex.
+func (s *GoFastServer) removeBlocked(registry *sync.Map, c *blockedClient) {
+	for _, key := range c.keys {
+		queue, ok := registry.Load(key)
+		if !ok {
+			continue
+		}
+
+		waiters := queue.(*[]*blockedClient)
+		remaining := (*waiters)[:0]
+		for _, waiter := range *waiters {
+			if waiter != c {
+				remaining = append(remaining, waiter)
+			}
+		}
+		*waiters = remaining
+
+		if len(remaining) == 0 {
+			registry.Delete(key)
+		}
+	}
+}
+
+// serveBlocked hands data arriving on key to the clients blocked on it,
+// oldest first, for as long as pop keeps producing replies
+func (s *GoFastServer) serveBlocked(registry *sync.Map, key string, pop func(c *blockedClient) ([][]byte, bool)) {
+	// Fast path: nobody is waiting on this key
+	if _, ok := registry.Load(key); !ok {
+		return
+	}
+
+	s.blockMutex.Lock()
+	defer s.blockMutex.Unlock()
+
+	for {
+		queue, ok := registry.Load(key)
+		if !ok {
+			return
+		}
+
+		waiters := *queue.(*[]*blockedClient)
+		if len(waiters) == 0 {
+			registry.Delete(key)
+			return
+		}
+
+		c := waiters[0]
+		reply, ok := pop(c)
+		if !ok {
+			return
+		}
+
+		c.done = true
+		s.removeBlocked(registry, c)
+		c.result <- reply
+	}
+}
+
+// waitBlocked waits for c to be served, giving up when gone is closed. A
+// zero timeout blocks until then. The caller runs under processCommand's
+// share of execMutex and, with an AOF, under logWrite's aofMutex. Both are
+// let go while waiting so they do not hold up the write that would wake c;
+// the pops served before blocking are logged first.
+func (s *GoFastServer) waitBlocked(registry *sync.Map, c *blockedClient, timeout time.Duration, gone <-chan struct{}) ([][]byte, bool) {
+	s.blockedClients.Add(1)
+	defer s.blockedClients.Add(-1)
+	if s.aof != nil {
+		s.flushServedPops(time.Now().Unix())
+		s.aofMutex.Unlock()
+	}
+	s.execMutex.RUnlock()
+	defer func() {
+		s.execMutex.RLock()
+		if s.aof != nil {
+			s.aofMutex.Lock()
+		}
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case reply := <-c.result:
+		return reply, true
+	case <-expired:
+	case <-gone:
+	}
+
+	s.blockMutex.Lock()
+	served := c.done
+	if !served {
+		c.done = true
+		s.removeBlocked(registry, c)
+	}
+	s.blockMutex.Unlock()
+
+	if served {
+		// Data arrived as the wait ended, the reply is already buffered
+		return <-c.result, true
+	}
+	return nil, false
+}
+
+// listPopCommand is the pop a list blocking command is logged as
+func listPopCommand(fromHead bool) uint8 {
+	if fromHead {
+		return CMD_LPOP
+	}
+	return CMD_RPOP
+}
+
+// zsetPopCommand is the pop a sorted set blocking command is logged as
+func zsetPopCommand(fromMax bool) uint8 {
+	if fromMax {
+		return CMD_ZPOPMAX
+	}
+	return CMD_ZPOPMIN
+}
+
+// serveListBlockers wakes clients blocked on a list that just received data
+func (s *DatabaseState) serveListBlockers(key string, list *List) {
+	s.serveBlocked(&s.listBlockers, key, func(c *blockedClient) ([][]byte, bool) {
+		var value []byte
+		var ok bool
+		if c.fromHead {
+			value, ok = list.LeftPop()
+		} else {
+			value, ok = list.RightPop()
+		}
+		if ok {
+			s.logServedPop(listPopCommand(c.fromHead), key, 0)
+		}
+		return [][]byte{[]byte(key), value}, ok
+	})
+
+	// If blocked clients drained the list, remove the key
+	if list.Length() == 0 {
+		s.storage.Delete(key)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, key)
+		s.ttlMutex.Unlock()
+	}
+}
+
+// serveZSetBlockers wakes clients blocked on a sorted set that just received
+// members. Each reply is the key followed by member and score pairs.
+func (s *DatabaseState) serveZSetBlockers(key string, zset *ZSet) {
+	s.serveBlocked(&s.zsetBlockers, key, func(c *blockedClient) ([][]byte, bool) {
+		entries := zset.Pop(c.count, !c.fromHead)
+		if len(entries) == 0 {
+			return nil, false
+		}
+		s.logServedPop(zsetPopCommand(!c.fromHead), key, len(entries))
+
+		reply := [][]byte{[]byte(key)}
+		for _, entry := range entries {
+			reply = append(reply, []byte(entry.Member), []byte(formatScore(entry.Score)))
+		}
+		return reply, true
+	})
+
+	// If blocked clients drained the sorted set, remove the key
+	if zset.Card() == 0 {
+		s.storage.Delete(key)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, key)
+		s.ttlMutex.Unlock()
+	}
+}
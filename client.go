@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Client speaks the version 1 binary protocol to a GoFast server. It backs
+// the CLI subcommands that work against a running server.
+type Client struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+	compressed bool // Frames carry the wire compression flags byte
+}
+
+// DialClient connects to the server described by config, preferring its
+// Unix socket, and authenticates as the default user when auth is required
+func DialClient(config *Config) (*Client, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	switch {
+	case config.UnixSocket != "":
+		conn, err = dialer.Dial("unix", config.UnixSocket)
+	case config.TLSEnabled:
+		var tlsConfig *tls.Config
+		if tlsConfig, err = clientTLSConfig(config); err == nil {
+			conn, err = tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), tlsConfig)
+		}
+	default:
+		conn, err = dialer.Dial("tcp", net.JoinHostPort(config.Host, strconv.Itoa(config.Port)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	c := &Client{
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		writer:     bufio.NewWriter(conn),
+		compressed: config.CompressionEnabled,
+	}
+	if config.RequireAuth {
+		payload := binary.BigEndian.AppendUint32(nil, 0) // Default user
+		payload = appendLenPrefixed(payload, []byte(config.Password))
+		if _, err := c.Call(CMD_AUTH, payload); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// clientTLSConfig trusts the configured CA bundle, or the system roots
+// when there is none
+func clientTLSConfig(config *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: config.Host, MinVersion: tls.VersionTLS12}
+	if config.TLSCAFile != "" {
+		caPEM, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in TLS CA file %s", config.TLSCAFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// Do sends one command and returns the response status and data
+// Request: [len:4][version:1][flags:1][cmd:1][payload], the flags byte
+// only being sent with wire compression
+func (c *Client) Do(command uint8, payload []byte) (uint8, []byte, error) {
+	header := binary.BigEndian.AppendUint32(nil, 0)
+	header = append(header, PROTOCOL_VERSION_1)
+	if c.compressed {
+		header = append(header, 0)
+	}
+	header = append(header, command)
+	binary.BigEndian.PutUint32(header[0:4], uint32(len(header)-4+len(payload)))
+
+	c.writer.Write(header)
+	c.writer.Write(payload)
+	if err := c.writer.Flush(); err != nil {
+		return 0, nil, err
+	}
+
+	// Response: [status:1][flags:1][len:4][data]
+	status, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var flags uint8
+	if c.compressed {
+		if flags, err = c.reader.ReadByte(); err != nil {
+			return 0, nil, err
+		}
+	}
+	var length [4]byte
+	if _, err := io.ReadFull(c.reader, length[:]); err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return 0, nil, err
+	}
+	if flags&COMPRESS_LZ4 != 0 {
+		if data, err = decompressPayload(data, math.MaxUint32); err != nil {
+			return 0, nil, err
+		}
+	}
+	return status, data, nil
+}
+
+// Call sends one command, turning error responses into errors
+func (c *Client) Call(command uint8, payload []byte) ([]byte, error) {
+	status, data, err := c.Do(command, payload)
+	if err != nil {
+		return nil, err
+	}
+	if status == RESP_ERROR {
+		return nil, fmt.Errorf("%s", data)
+	}
+	return data, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
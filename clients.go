@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientInfo describes a connected client for CLIENT commands
+type ClientInfo struct {
+	ID          uint64
+	addr        string // Remote "ip:port", or "unix"
+	connectedAt int64  // Unix seconds
+	conn        net.Conn
+
+	mutex    sync.Mutex // Protects the fields below, updated by the connection
+	name     string
+	activeDB int
+	lastCmd  string // Name of the last command run, empty before the first
+}
+
+// registerClient adds the client of state, connected over conn, to the
+// clients CLIENT LIST shows and CLIENT KILL closes
+func (s *GoFastServer) registerClient(state *connState, conn net.Conn) *ClientInfo {
+	client := &ClientInfo{
+		ID:          s.nextClientID.Add(1),
+		addr:        state.clientAddr,
+		connectedAt: time.Now().Unix(),
+		conn:        conn,
+	}
+	state.client = client
+	s.clients.Store(client.ID, client)
+	return client
+}
+
+func (s *GoFastServer) unregisterClient(client *ClientInfo) {
+	s.clients.Delete(client.ID)
+}
+
+// recordCommand notes the command a client ran last and the
+// database it is on
+func (c *ClientInfo) recordCommand(command uint8, activeDB int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastCmd = commandName(command)
+	c.activeDB = activeDB
+}
+
+// describe formats the client as a CLIENT LIST line
+func (c *ClientInfo) describe(now int64) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cmd := "NULL"
+	if c.lastCmd != "" {
+		cmd = strings.ToLower(c.lastCmd)
+	}
+	return fmt.Sprintf("id=%d addr=%s name=%s db=%d cmd=%s age=%d", c.ID, c.addr, c.name, c.activeDB, cmd, now-c.connectedAt)
+}
+
+// handleClient runs a CLIENT subcommand for the connection of state
+// Format: [subcommand:1] followed by [filterlen:4][filter][targetlen:4][target]
+// for KILL, the filter being ID or ADDR, and [namelen:4][name] for SETNAME
+func (s *GoFastServer) handleClient(state *connState, data []byte) []byte {
+	args := newArgReader(data)
+	subcommand := args.uint8()
+	if args.err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid CLIENT data"))
+	}
+
+	switch subcommand {
+	case CLIENT_LIST:
+		return s.createResponse(RESP_OK, []byte(s.clientList()))
+
+	case CLIENT_KILL:
+		filter := strings.ToUpper(args.string())
+		target := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid CLIENT data"))
+		}
+		if !s.isAdmin(state) {
+			return s.createResponse(RESP_ERROR, []byte("NOPERM CLIENT KILL needs a user allowed every command"))
+		}
+		client, err := s.findClient(filter, target)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte(err.Error()))
+		}
+		// The connection's goroutine exits on its next read error
+		client.conn.Close()
+		return s.createResponse(RESP_OK, []byte("OK"))
+
+	case CLIENT_SETNAME, CLIENT_GETNAME, CLIENT_ID:
+		if state == nil || state.client == nil {
+			return s.createResponse(RESP_ERROR, []byte("ERR no client connection"))
+		}
+		client := state.client
+		switch subcommand {
+		case CLIENT_SETNAME:
+			name := args.string()
+			if args.err != nil {
+				return s.createResponse(RESP_ERROR, []byte("Invalid CLIENT data"))
+			}
+			if !validClientName(name) {
+				return s.createResponse(RESP_ERROR, []byte("ERR Client names cannot contain spaces, newlines or special characters."))
+			}
+			client.mutex.Lock()
+			client.name = name
+			client.mutex.Unlock()
+			return s.createResponse(RESP_OK, []byte("OK"))
+		case CLIENT_GETNAME:
+			client.mutex.Lock()
+			defer client.mutex.Unlock()
+			return s.createResponse(RESP_OK, []byte(client.name))
+		default:
+			return s.createResponse(RESP_OK, []byte(strconv.FormatUint(client.ID, 10)))
+		}
+
+	default:
+		return s.createResponse(RESP_ERROR, []byte("Unknown CLIENT subcommand"))
+	}
+}
+
+// validClientName reports whether name is only printable ASCII without
+// spaces, so CLIENT LIST lines stay parseable. An empty name clears it.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// findClient returns the client whose ID or ADDR, as filter says,
+// matches target
+func (s *GoFastServer) findClient(filter, target string) (*ClientInfo, error) {
+	switch filter {
+	case "ID":
+		id, err := strconv.ParseUint(target, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ERR client-id should be greater than 0")
+		}
+		if client, ok := s.clients.Load(id); ok {
+			return client.(*ClientInfo), nil
+		}
+	case "ADDR":
+		var found *ClientInfo
+		s.clients.Range(func(_, value any) bool {
+			if client := value.(*ClientInfo); client.addr == target {
+				found = client
+				return false
+			}
+			return true
+		})
+		if found != nil {
+			return found, nil
+		}
+	default:
+		return nil, fmt.Errorf("ERR syntax error, CLIENT KILL takes ID id or ADDR ip:port")
+	}
+	return nil, fmt.Errorf("ERR no such client")
+}
+
+// clientList describes every connected client, one line each in the
+// order they connected
+func (s *GoFastServer) clientList() string {
+	var clients []*ClientInfo
+	s.clients.Range(func(_, value any) bool {
+		clients = append(clients, value.(*ClientInfo))
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+
+	now := time.Now().Unix()
+	var list strings.Builder
+	for _, client := range clients {
+		list.WriteString(client.describe(now))
+		list.WriteByte('\n')
+	}
+	return list.String()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"log"
 	"os"
@@ -54,11 +55,45 @@ func runServer(cmd *cobra.Command, args []string) error {
 	// Print startup info
 	fmt.Printf("🚀 Starting GoFast Server v%s\n", version)
 	fmt.Printf("📡 Listening on %s:%d\n", config.Host, config.Port)
-	fmt.Printf("💾 Max Memory: %s\n", config.MaxMemory)
+	if config.UnixSocket != "" {
+		fmt.Printf("🧦 Unix Socket: %s\n", config.UnixSocket)
+	}
+	if config.WSPort != 0 {
+		fmt.Printf("🌐 WebSocket: %s:%d\n", config.Host, config.WSPort)
+	}
+	if config.HTTPPort != 0 {
+		fmt.Printf("🔗 HTTP API: %s:%d\n", config.Host, config.HTTPPort)
+	}
+	if config.MonitoringAddr != "" {
+		fmt.Printf("📈 Metrics: %s/metrics\n", config.MonitoringAddr)
+	}
+	if config.RateLimitEnabled {
+		fmt.Printf("🚦 Rate Limit: %d ops per %ds per client IP\n", config.RateLimitOps, config.RateLimitWindowSec)
+	}
+	fmt.Printf("💾 Max Memory: %s (%s)\n", config.MaxMemory, config.MaxMemoryPolicy)
 	fmt.Printf("📊 Log Level: %s\n", config.LogLevel)
+	if config.SlowLogEnabled {
+		fmt.Printf("🐢 Slow Log: commands over %dµs\n", config.SlowLogThresholdUs)
+	}
 	if config.EnablePersist {
 		fmt.Printf("💽 Persistence: Enabled (save every %v)\n", config.SaveInterval)
 		fmt.Printf("📁 Data Directory: %s\n", config.DataDir)
+		fmt.Printf("📜 AOF: %s, RDB: %s\n", dataPath(config, config.AOFFile), dataPath(config, config.RDBFile))
+	}
+	if config.EncryptionEnabled {
+		fmt.Printf("🔐 Encryption at rest: AES-256-GCM (key from %s)\n", config.EncryptionKeyFile)
+	}
+	if config.CompressionEnabled {
+		fmt.Printf("🗜️  Compression: LZ4 above %d bytes\n", config.CompressionThreshold)
+	}
+	if config.CompressValues {
+		fmt.Printf("🗜️  Stored Values: LZ4 above %d bytes\n", config.CompressThreshold)
+	}
+	if config.TLSEnabled {
+		fmt.Printf("🔒 TLS: Enabled (cert %s)\n", config.TLSCertFile)
+	}
+	if config.TLSClientAuth {
+		fmt.Printf("🪪 Client Certificates: Required (CA %s)\n", config.TLSCAFile)
 	}
 
 	fmt.Println(strings.Repeat("=", 51))
@@ -71,19 +106,9 @@ func runServer(cmd *cobra.Command, args []string) error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	// Start server in goroutine
-	go func() {
-		if err := server.Start(); err != nil {
-			log.Fatalf("Server failed to start: %v", err)
-		}
-	}()
-
-	// Wait for shutdown signal
-	<-sigChan
-	fmt.Println("\n🛑 Shutting down GoFast server...")
-
-	// Graceful shutdown
-	server.Stop()
+	if err := server.RunUntilSignal(sigChan); err != nil {
+		log.Fatalf("Server failed to start: %v", err)
+	}
 	fmt.Println("✅ GoFast server stopped")
 
 	return nil
@@ -103,15 +128,39 @@ var configCmd = &cobra.Command{
 		fmt.Printf("Host: %s\n", config.Host)
 		fmt.Printf("Host: %s\n", config.Host)
 		fmt.Printf("Port: %d\n", config.Port)
+		fmt.Printf("Unix Socket: %s\n", config.UnixSocket)
+		fmt.Printf("Protocol Version: %d\n", config.ProtocolVersion)
+		fmt.Printf("Max Frame Size: %d bytes\n", config.MaxFrameSize)
+		fmt.Printf("WebSocket Port: %d\n", config.WSPort)
+		fmt.Printf("HTTP Port: %d\n", config.HTTPPort)
+		fmt.Printf("Monitoring Address: %q\n", config.MonitoringAddr)
+		fmt.Printf("Compression Enabled: %t (threshold %d bytes)\n", config.CompressionEnabled, config.CompressionThreshold)
+		fmt.Printf("Compress Values: %t (threshold %d bytes)\n", config.CompressValues, config.CompressThreshold)
 		fmt.Printf("Max Memory: %s\n", config.MaxMemory)
+		fmt.Printf("Max Memory Policy: %s\n", config.MaxMemoryPolicy)
+		fmt.Printf("LFU: log factor %d, decay every %d minutes\n", config.LFULogFactor, config.LFUDecayTime)
 		fmt.Printf("Max Clients: %d\n", config.MaxClients)
 		fmt.Printf("Timeout: %v\n", config.Timeout)
 		fmt.Printf("Log Level: %s\n", config.LogLevel)
 		fmt.Printf("Log Format: %s\n", config.LogFormat)
+		fmt.Printf("Slow Log: %t (threshold %dµs)\n", config.SlowLogEnabled, config.SlowLogThresholdUs)
 		fmt.Printf("Save Interval: %v\n", config.SaveInterval)
 		fmt.Printf("Data Directory: %s\n", config.DataDir)
 		fmt.Printf("Persistence Enabled: %t\n", config.EnablePersist)
+		fmt.Printf("AOF File: %s\n", config.AOFFile)
+		fmt.Printf("RDB File: %s\n", config.RDBFile)
+		fmt.Printf("Drain Timeout: %v\n", config.DrainTimeout)
+		fmt.Printf("Encryption Enabled: %t (key file %q)\n", config.EncryptionEnabled, config.EncryptionKeyFile)
+		fmt.Printf("Keyspace Events: %q\n", config.NotifyKeyspaceEvents)
 		fmt.Printf("Authentication Required: %t\n", config.RequireAuth)
+		fmt.Printf("ACL Users: %d\n", len(config.ACLUsers))
+		fmt.Printf("Max AUTH Attempts: %d\n", config.MaxAuthAttempts)
+		fmt.Printf("Allowed CIDRs: %v\n", config.AllowedCIDRs)
+		fmt.Printf("Denied CIDRs: %v\n", config.DeniedCIDRs)
+		fmt.Printf("Renamed Commands: %d\n", len(config.RenamedCommands))
+		fmt.Printf("Rate Limit: %t (%d ops per %ds)\n", config.RateLimitEnabled, config.RateLimitOps, config.RateLimitWindowSec)
+		fmt.Printf("TLS Enabled: %t\n", config.TLSEnabled)
+		fmt.Printf("TLS Client Auth: %t\n", config.TLSClientAuth)
 		fmt.Printf("TCP Keep-Alive: %t\n", config.TCPKeepAlive)
 		fmt.Printf("Read Timeout: %v\n", config.ReadTimeout)
 		fmt.Printf("Write Timeout: %v\n", config.WriteTimeout)
@@ -120,6 +169,71 @@ var configCmd = &cobra.Command{
 	},
 }
 
+// dumpCmd writes the keys of a running server to stdout as NDJSON
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Export keys from a running server as JSON lines",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := LoadConfig()
+		if err != nil {
+			return err
+		}
+		pattern, _ := cmd.Flags().GetString("pattern")
+
+		client, err := DialClient(config)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		writer := bufio.NewWriter(os.Stdout)
+		dumped, err := dumpKeys(client, pattern, writer)
+		if flushErr := writer.Flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Dumped %d keys\n", dumped)
+		return nil
+	},
+}
+
+// loadCmd writes the keys of an NDJSON dump to a running server
+var loadCmd = &cobra.Command{
+	Use:   "load [file]",
+	Short: "Import keys written by dump, from a file or stdin",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := LoadConfig()
+		if err != nil {
+			return err
+		}
+		pattern, _ := cmd.Flags().GetString("pattern")
+
+		input := os.Stdin
+		if len(args) == 1 && args[0] != "-" {
+			if input, err = os.Open(args[0]); err != nil {
+				return err
+			}
+			defer input.Close()
+		}
+
+		client, err := DialClient(config)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		loaded, err := loadKeys(client, pattern, input)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Loaded %d keys\n", loaded)
+		return nil
+	},
+}
+
 // versionCmd shows version information
 var versionCmd = &cobra.Command{
 	Use:   "version",
@@ -135,16 +249,50 @@ func init() {
 	// Global flags
 	rootCmd.PersistentFlags().StringP("host", "H", "localhost", "Host to bind to")
 	rootCmd.PersistentFlags().IntP("port", "p", 6379, "Port to listen on")
+	rootCmd.PersistentFlags().String("unix-socket", "", "Unix domain socket path to listen on in addition to TCP")
+	rootCmd.PersistentFlags().Int("ws-port", 0, "Port for the WebSocket gateway (0 disables it)")
+	rootCmd.PersistentFlags().Int("http-port", 0, "Port for the HTTP/JSON API gateway (0 disables it)")
+	rootCmd.PersistentFlags().String("monitoring-addr", "", "Address to serve Prometheus metrics on /metrics, e.g. :9090")
+	rootCmd.PersistentFlags().Int("protocol-version", PROTOCOL_VERSION, "Highest binary protocol version clients may negotiate (1 or 2)")
+	rootCmd.PersistentFlags().Int64("max-frame-size", DefaultMaxFrameSize, "Largest binary frame in bytes a client may send")
+	rootCmd.PersistentFlags().Bool("compression", false, "Enable LZ4 wire compression (adds a flags byte to every binary frame)")
+	rootCmd.PersistentFlags().Int("compression-threshold", 1024, "Minimum payload size in bytes before compressing")
+	rootCmd.PersistentFlags().Bool("compress-values", false, "Keep large string values LZ4 compressed in memory")
+	rootCmd.PersistentFlags().Int("compress-threshold", 1024, "Minimum string value size in bytes before compressing it in memory")
 	rootCmd.PersistentFlags().String("max-memory", "1GB", "Maximum memory to use (e.g., 512MB, 2GB)")
+	rootCmd.PersistentFlags().String("max-memory-policy", POLICY_NOEVICTION, "Keys to evict when max memory is reached (noeviction, allkeys-lru, allkeys-lfu)")
+	rootCmd.PersistentFlags().Int("lfu-log-factor", 10, "Accesses needed to grow the LFU count, higher is slower")
+	rootCmd.PersistentFlags().Int("lfu-decay-time", 1, "Minutes of idleness per LFU count lost (0 never decays)")
 	rootCmd.PersistentFlags().Int("max-clients", 10000, "Maximum number of clients")
 	rootCmd.PersistentFlags().Duration("timeout", 30*time.Second, "Client timeout")
 	rootCmd.PersistentFlags().String("log-level", "info", "Log level (trace, debug, info, warn, error, fatal)")
 	rootCmd.PersistentFlags().String("log-format", "text", "Log format (text, json)")
+	rootCmd.PersistentFlags().Bool("slowlog", false, "Record commands slower than the slow log threshold")
+	rootCmd.PersistentFlags().Int64("slowlog-threshold-us", 10000, "Microseconds a command must take to enter the slow log")
 	rootCmd.PersistentFlags().Duration("save-interval", 300*time.Second, "Persistence save interval")
 	rootCmd.PersistentFlags().String("data-dir", "./data", "Data directory for persistence")
 	rootCmd.PersistentFlags().Bool("enable-persist", false, "Enable persistence to disk")
+	rootCmd.PersistentFlags().String("aof-file", "appendonly.aof", "Append-only file logging every write, relative to the data directory")
+	rootCmd.PersistentFlags().String("rdb-file", "dump.rdb", "Snapshot file, relative to the data directory")
+	rootCmd.PersistentFlags().Duration("drain-timeout", 30*time.Second, "Longest the snapshot saved on shutdown may take")
+	rootCmd.PersistentFlags().Bool("encryption", false, "Encrypt the AOF and RDB files with AES-256-GCM")
+	rootCmd.PersistentFlags().String("encryption-key-file", "", "File holding the secret the encryption key is derived from")
+	rootCmd.PersistentFlags().String("notify-keyspace-events", "", "Keyspace notification classes to publish (e.g., Ex, KEA)")
 	rootCmd.PersistentFlags().Bool("require-auth", false, "Require authentication")
 	rootCmd.PersistentFlags().String("password", "", "Authentication password")
+	rootCmd.PersistentFlags().StringArray("acl-user", nil, "ACL user rule, e.g. \"alice >secret ~cache:* +GET +SET\" (repeatable)")
+	rootCmd.PersistentFlags().StringArray("allow-cidr", nil, "Only accept clients from this CIDR block (repeatable)")
+	rootCmd.PersistentFlags().StringArray("deny-cidr", nil, "Refuse clients from this CIDR block (repeatable)")
+	rootCmd.PersistentFlags().Bool("rate-limit", false, "Limit the request rate of each client IP")
+	rootCmd.PersistentFlags().Uint64("rate-limit-ops", 10000, "Requests each client IP may send per rate limit window")
+	rootCmd.PersistentFlags().Int("rate-limit-window", 1, "Rate limit window in seconds")
+	rootCmd.PersistentFlags().Int("max-auth-attempts", 5, "AUTH failures per client IP before exponential lockout (0 disables it)")
+	rootCmd.PersistentFlags().StringToString("rename-command", nil, "Rename a command, e.g. KEYS=ADMIN_KEYS, or disable it with KEYS= (repeatable)")
+	rootCmd.PersistentFlags().Bool("tls", false, "Enable TLS")
+	rootCmd.PersistentFlags().String("tls-cert", "", "TLS certificate file")
+	rootCmd.PersistentFlags().String("tls-key", "", "TLS private key file")
+	rootCmd.PersistentFlags().String("tls-ca", "", "CA certificate file used to verify client certificates")
+	rootCmd.PersistentFlags().Bool("tls-client-auth", false, "Require client certificates, authenticating as the ACL user named by their CN")
 	rootCmd.PersistentFlags().Bool("tcp-keepalive", true, "Enable TCP keep-alive")
 	rootCmd.PersistentFlags().Duration("read-timeout", 30*time.Second, "Read timeout")
 	rootCmd.PersistentFlags().Duration("write-timeout", 30*time.Second, "Write timeout")
@@ -152,16 +300,50 @@ func init() {
 	// Bind flags to viper
 	viper.BindPFlag("host", rootCmd.PersistentFlags().Lookup("host"))
 	viper.BindPFlag("port", rootCmd.PersistentFlags().Lookup("port"))
+	viper.BindPFlag("unix_socket", rootCmd.PersistentFlags().Lookup("unix-socket"))
+	viper.BindPFlag("ws_port", rootCmd.PersistentFlags().Lookup("ws-port"))
+	viper.BindPFlag("http_port", rootCmd.PersistentFlags().Lookup("http-port"))
+	viper.BindPFlag("monitoring_addr", rootCmd.PersistentFlags().Lookup("monitoring-addr"))
+	viper.BindPFlag("protocol_version", rootCmd.PersistentFlags().Lookup("protocol-version"))
+	viper.BindPFlag("max_frame_size", rootCmd.PersistentFlags().Lookup("max-frame-size"))
+	viper.BindPFlag("compression_enabled", rootCmd.PersistentFlags().Lookup("compression"))
+	viper.BindPFlag("compression_threshold", rootCmd.PersistentFlags().Lookup("compression-threshold"))
+	viper.BindPFlag("compress_values", rootCmd.PersistentFlags().Lookup("compress-values"))
+	viper.BindPFlag("compress_threshold", rootCmd.PersistentFlags().Lookup("compress-threshold"))
 	viper.BindPFlag("max_memory", rootCmd.PersistentFlags().Lookup("max-memory"))
+	viper.BindPFlag("max_memory_policy", rootCmd.PersistentFlags().Lookup("max-memory-policy"))
+	viper.BindPFlag("lfu_log_factor", rootCmd.PersistentFlags().Lookup("lfu-log-factor"))
+	viper.BindPFlag("lfu_decay_time", rootCmd.PersistentFlags().Lookup("lfu-decay-time"))
 	viper.BindPFlag("max_clients", rootCmd.PersistentFlags().Lookup("max-clients"))
 	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
 	viper.BindPFlag("log_level", rootCmd.PersistentFlags().Lookup("log-level"))
 	viper.BindPFlag("log_format", rootCmd.PersistentFlags().Lookup("log-format"))
+	viper.BindPFlag("slowlog_enabled", rootCmd.PersistentFlags().Lookup("slowlog"))
+	viper.BindPFlag("slowlog_threshold_us", rootCmd.PersistentFlags().Lookup("slowlog-threshold-us"))
 	viper.BindPFlag("save_interval", rootCmd.PersistentFlags().Lookup("save-interval"))
 	viper.BindPFlag("data_dir", rootCmd.PersistentFlags().Lookup("data-dir"))
 	viper.BindPFlag("enable_persist", rootCmd.PersistentFlags().Lookup("enable-persist"))
+	viper.BindPFlag("aof_file", rootCmd.PersistentFlags().Lookup("aof-file"))
+	viper.BindPFlag("rdb_file", rootCmd.PersistentFlags().Lookup("rdb-file"))
+	viper.BindPFlag("drain_timeout", rootCmd.PersistentFlags().Lookup("drain-timeout"))
+	viper.BindPFlag("encryption_enabled", rootCmd.PersistentFlags().Lookup("encryption"))
+	viper.BindPFlag("encryption_key_file", rootCmd.PersistentFlags().Lookup("encryption-key-file"))
+	viper.BindPFlag("notify_keyspace_events", rootCmd.PersistentFlags().Lookup("notify-keyspace-events"))
 	viper.BindPFlag("require_auth", rootCmd.PersistentFlags().Lookup("require-auth"))
 	viper.BindPFlag("password", rootCmd.PersistentFlags().Lookup("password"))
+	viper.BindPFlag("acl_users", rootCmd.PersistentFlags().Lookup("acl-user"))
+	viper.BindPFlag("allowed_cidrs", rootCmd.PersistentFlags().Lookup("allow-cidr"))
+	viper.BindPFlag("denied_cidrs", rootCmd.PersistentFlags().Lookup("deny-cidr"))
+	viper.BindPFlag("rate_limit_enabled", rootCmd.PersistentFlags().Lookup("rate-limit"))
+	viper.BindPFlag("rate_limit_ops", rootCmd.PersistentFlags().Lookup("rate-limit-ops"))
+	viper.BindPFlag("rate_limit_window_sec", rootCmd.PersistentFlags().Lookup("rate-limit-window"))
+	viper.BindPFlag("rename_commands", rootCmd.PersistentFlags().Lookup("rename-command"))
+	viper.BindPFlag("max_auth_attempts", rootCmd.PersistentFlags().Lookup("max-auth-attempts"))
+	viper.BindPFlag("tls_enabled", rootCmd.PersistentFlags().Lookup("tls"))
+	viper.BindPFlag("tls_cert_file", rootCmd.PersistentFlags().Lookup("tls-cert"))
+	viper.BindPFlag("tls_key_file", rootCmd.PersistentFlags().Lookup("tls-key"))
+	viper.BindPFlag("tls_ca_file", rootCmd.PersistentFlags().Lookup("tls-ca"))
+	viper.BindPFlag("tls_client_auth", rootCmd.PersistentFlags().Lookup("tls-client-auth"))
 	viper.BindPFlag("tcp_keepalive", rootCmd.PersistentFlags().Lookup("tcp-keepalive"))
 	viper.BindPFlag("read_timeout", rootCmd.PersistentFlags().Lookup("read-timeout"))
 	viper.BindPFlag("write_timeout", rootCmd.PersistentFlags().Lookup("write-timeout"))
@@ -169,6 +351,11 @@ func init() {
 	// Add subcommands
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(versionCmd)
+
+	dumpCmd.Flags().String("pattern", "*", "Only dump keys matching this glob-style pattern")
+	loadCmd.Flags().String("pattern", "*", "Only load keys matching this glob-style pattern")
+	rootCmd.AddCommand(dumpCmd)
+	rootCmd.AddCommand(loadCmd)
 }
 
 // Execute is the main entry point for the CLI
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// commandNames gives the Redis-style name of every binary command, used
+// wherever commands are referred to by name such as ACL rules
+var commandNames = map[uint8]string{
+	CMD_SET:              "SET",
+	CMD_GET:              "GET",
+	CMD_DEL:              "DEL",
+	CMD_EXISTS:           "EXISTS",
+	CMD_EXPIRE:           "EXPIRE",
+	CMD_TTL:              "TTL",
+	CMD_MGET:             "MGET",
+	CMD_MSET:             "MSET",
+	CMD_PIPELINE:         "PIPELINE",
+	CMD_LPUSH:            "LPUSH",
+	CMD_RPUSH:            "RPUSH",
+	CMD_LPOP:             "LPOP",
+	CMD_RPOP:             "RPOP",
+	CMD_LLEN:             "LLEN",
+	CMD_LINDEX:           "LINDEX",
+	CMD_LRANGE:           "LRANGE",
+	CMD_LSET:             "LSET",
+	CMD_LINSERT:          "LINSERT",
+	CMD_LTRIM:            "LTRIM",
+	CMD_LPOS:             "LPOS",
+	CMD_LMOVE:            "LMOVE",
+	CMD_BLPOP:            "BLPOP",
+	CMD_BRPOP:            "BRPOP",
+	CMD_LMPOP:            "LMPOP",
+	CMD_LREM:             "LREM",
+	CMD_SADD:             "SADD",
+	CMD_SREM:             "SREM",
+	CMD_SMEMBERS:         "SMEMBERS",
+	CMD_SCARD:            "SCARD",
+	CMD_SISMEMBER:        "SISMEMBER",
+	CMD_SUNION:           "SUNION",
+	CMD_SINTER:           "SINTER",
+	CMD_SDIFF:            "SDIFF",
+	CMD_SUNIONSTORE:      "SUNIONSTORE",
+	CMD_SINTERSTORE:      "SINTERSTORE",
+	CMD_SDIFFSTORE:       "SDIFFSTORE",
+	CMD_SMOVE:            "SMOVE",
+	CMD_SPOP:             "SPOP",
+	CMD_SRANDMEMBER:      "SRANDMEMBER",
+	CMD_SINTERCARD:       "SINTERCARD",
+	CMD_SSCAN:            "SSCAN",
+	CMD_HSET:             "HSET",
+	CMD_HGET:             "HGET",
+	CMD_HDEL:             "HDEL",
+	CMD_HGETALL:          "HGETALL",
+	CMD_HLEN:             "HLEN",
+	CMD_HEXISTS:          "HEXISTS",
+	CMD_HMSET:            "HMSET",
+	CMD_HMGET:            "HMGET",
+	CMD_HKEYS:            "HKEYS",
+	CMD_HVALS:            "HVALS",
+	CMD_HINCRBY:          "HINCRBY",
+	CMD_HINCRBYFLOAT:     "HINCRBYFLOAT",
+	CMD_HSCAN:            "HSCAN",
+	CMD_HRANDFIELD:       "HRANDFIELD",
+	CMD_HSETNX:           "HSETNX",
+	CMD_HGETDEL:          "HGETDEL",
+	CMD_INCR:             "INCR",
+	CMD_DECR:             "DECR",
+	CMD_GETSET:           "GETSET",
+	CMD_KEYS:             "KEYS",
+	CMD_SCAN:             "SCAN",
+	CMD_SETBIT:           "SETBIT",
+	CMD_GETBIT:           "GETBIT",
+	CMD_BITCOUNT:         "BITCOUNT",
+	CMD_BITOP:            "BITOP",
+	CMD_BITPOS:           "BITPOS",
+	CMD_BITFIELD:         "BITFIELD",
+	CMD_PUBLISH:          "PUBLISH",
+	CMD_SUBSCRIBE:        "SUBSCRIBE",
+	CMD_UNSUBSCRIBE:      "UNSUBSCRIBE",
+	CMD_PSUBSCRIBE:       "PSUBSCRIBE",
+	CMD_PUNSUBSCRIBE:     "PUNSUBSCRIBE",
+	CMD_PUBSUB:           "PUBSUB",
+	CMD_XADD:             "XADD",
+	CMD_XREAD:            "XREAD",
+	CMD_XRANGE:           "XRANGE",
+	CMD_XREVRANGE:        "XREVRANGE",
+	CMD_XLEN:             "XLEN",
+	CMD_XDEL:             "XDEL",
+	CMD_XTRIM:            "XTRIM",
+	CMD_XGROUP:           "XGROUP",
+	CMD_XREADGROUP:       "XREADGROUP",
+	CMD_XACK:             "XACK",
+	CMD_XPENDING:         "XPENDING",
+	CMD_XINFO:            "XINFO",
+	CMD_XAUTOCLAIM:       "XAUTOCLAIM",
+	CMD_ZADD:             "ZADD",
+	CMD_ZRANGE:           "ZRANGE",
+	CMD_ZRANK:            "ZRANK",
+	CMD_ZREVRANK:         "ZREVRANK",
+	CMD_ZSCORE:           "ZSCORE",
+	CMD_ZINCRBY:          "ZINCRBY",
+	CMD_ZREM:             "ZREM",
+	CMD_ZCARD:            "ZCARD",
+	CMD_ZCOUNT:           "ZCOUNT",
+	CMD_ZPOPMIN:          "ZPOPMIN",
+	CMD_ZPOPMAX:          "ZPOPMAX",
+	CMD_ZUNIONSTORE:      "ZUNIONSTORE",
+	CMD_ZINTERSTORE:      "ZINTERSTORE",
+	CMD_ZDIFF:            "ZDIFF",
+	CMD_ZDIFFSTORE:       "ZDIFFSTORE",
+	CMD_ZRANGEBYSCORE:    "ZRANGEBYSCORE",
+	CMD_ZREVRANGEBYSCORE: "ZREVRANGEBYSCORE",
+	CMD_ZRANGEBYLEX:      "ZRANGEBYLEX",
+	CMD_ZLEXCOUNT:        "ZLEXCOUNT",
+	CMD_ZREMRANGEBYSCORE: "ZREMRANGEBYSCORE",
+	CMD_ZREMRANGEBYRANK:  "ZREMRANGEBYRANK",
+	CMD_ZSCAN:            "ZSCAN",
+	CMD_BZPOPMIN:         "BZPOPMIN",
+	CMD_BZPOPMAX:         "BZPOPMAX",
+	CMD_ZRANGESTORE:      "ZRANGESTORE",
+	CMD_ZMSCORE:          "ZMSCORE",
+	CMD_BZMPOP:           "BZMPOP",
+	CMD_MULTI:            "MULTI",
+	CMD_EXEC:             "EXEC",
+	CMD_DISCARD:          "DISCARD",
+	CMD_WATCH:            "WATCH",
+	CMD_HELLO:            "HELLO",
+	CMD_AUTH:             "AUTH",
+	CMD_SELECT:           "SELECT",
+	CMD_BGSAVE:           "BGSAVE",
+	CMD_BGREWRITEAOF:     "BGREWRITEAOF",
+	CMD_EXPIREAT:         "EXPIREAT",
+	CMD_PEXPIREAT:        "PEXPIREAT",
+	CMD_EXPIRETIME:       "EXPIRETIME",
+	CMD_PEXPIRETIME:      "PEXPIRETIME",
+	CMD_OBJECT:           "OBJECT",
+	CMD_MEMORY_USAGE:     "MEMORY",
+	CMD_SLOWLOG:          "SLOWLOG",
+	CMD_INFO:             "INFO",
+	CMD_MONITOR:          "MONITOR",
+	CMD_LATENCY:          "LATENCY",
+	CMD_COMMANDSTATS:     "COMMANDSTATS",
+	CMD_SHUTDOWN:         "SHUTDOWN",
+	CMD_CONFIG:           "CONFIG",
+	CMD_RESET:            "RESET",
+	CMD_CLIENT:           "CLIENT",
+}
+
+// commandName returns the name of command, or its code when it has none
+func commandName(command uint8) string {
+	if name, ok := commandNames[command]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", command)
+}
+
+// commandsByName is the reverse of commandNames
+var commandsByName = func() map[string]uint8 {
+	byName := make(map[string]uint8, len(commandNames))
+	for command, name := range commandNames {
+		byName[name] = command
+	}
+	return byName
+}()
+
+// CommandRenames is the parsed rename_commands setting. Binary opcodes have
+// no names, so a rename only changes what RESP clients call a command, while
+// a disabled command is refused on every protocol.
+type CommandRenames struct {
+	disabled map[uint8]bool
+	renamed  map[string]string // Original name -> alias
+	aliases  map[string]string // Alias -> original name
+}
+
+// ParseCommandRenames parses a map of command names to their new names, an
+// empty new name disabling the command
+func ParseCommandRenames(renames map[string]string) (*CommandRenames, error) {
+	r := &CommandRenames{
+		disabled: make(map[uint8]bool),
+		renamed:  make(map[string]string),
+		aliases:  make(map[string]string),
+	}
+	for name, alias := range renames {
+		// Config keys arrive lowercased, and names are case-insensitive
+		name, alias = strings.ToUpper(name), strings.ToUpper(alias)
+		command, ok := commandsByName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown command %q", name)
+		}
+		if alias == "" {
+			r.disabled[command] = true
+			continue
+		}
+		if _, taken := commandsByName[alias]; taken {
+			return nil, fmt.Errorf("cannot rename %s to existing command %s", name, alias)
+		}
+		if other, taken := r.aliases[alias]; taken {
+			return nil, fmt.Errorf("cannot rename both %s and %s to %s", other, name, alias)
+		}
+		r.renamed[name] = alias
+		r.aliases[alias] = name
+	}
+	return r, nil
+}
+
+// Disabled reports whether command was renamed to nothing
+func (r *CommandRenames) Disabled(command uint8) bool {
+	return r != nil && r.disabled[command]
+}
+
+// Resolve maps the name a RESP client sent to the command it runs, reporting
+// false for disabled commands and for the original name of a renamed one
+func (r *CommandRenames) Resolve(name string) (string, bool) {
+	if r == nil {
+		return name, true
+	}
+	if original, ok := r.aliases[name]; ok {
+		return original, true
+	}
+	if _, ok := r.renamed[name]; ok {
+		return "", false
+	}
+	if command, ok := commandsByName[name]; ok && r.disabled[command] {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CmdStat counts the calls of one command and the time they took
+type CmdStat struct {
+	Calls     uint64
+	TotalUsec uint64
+}
+
+// recordCommandStat adds one call of command that took elapsed
+func (s *GoFastServer) recordCommandStat(command byte, elapsed time.Duration) {
+	s.cmdStatsMutex.Lock()
+	defer s.cmdStatsMutex.Unlock()
+
+	if s.cmdStats == nil {
+		s.cmdStats = make(map[byte]*CmdStat)
+	}
+	stat, ok := s.cmdStats[command]
+	if !ok {
+		stat = &CmdStat{}
+		s.cmdStats[command] = stat
+	}
+	stat.Calls++
+	stat.TotalUsec += uint64(elapsed.Microseconds())
+}
+
+// commandStats returns a copy of the stats of every command called, by
+// command name
+func (s *GoFastServer) commandStats() (names []string, stats map[string]CmdStat) {
+	s.cmdStatsMutex.Lock()
+	defer s.cmdStatsMutex.Unlock()
+
+	stats = make(map[string]CmdStat, len(s.cmdStats))
+	for command, stat := range s.cmdStats {
+		if stat.Calls > 0 {
+			name := commandName(command)
+			names = append(names, name)
+			stats[name] = *stat
+		}
+	}
+	sort.Strings(names)
+	return names, stats
+}
+
+// handleCommandStats returns the calls and total time of every command
+// called since the last CONFIG RESETSTAT, sorted by name
+// Response: [count:4] then per command [len:4][namelen:4][name][calls:8][totalusec:8]
+func (s *GoFastServer) handleCommandStats() []byte {
+	names, stats := s.commandStats()
+	values := make([][]byte, len(names))
+	for i, name := range names {
+		value := appendLenPrefixed(nil, []byte(name))
+		value = binary.BigEndian.AppendUint64(value, stats[name].Calls)
+		values[i] = binary.BigEndian.AppendUint64(value, stats[name].TotalUsec)
+	}
+	return s.createResponse(RESP_OK, s.encodeArray(values))
+}
+
+// writeCommandStats writes the INFO commandstats section
+func (s *GoFastServer) writeCommandStats(info *strings.Builder) {
+	names, stats := s.commandStats()
+	info.WriteString("# Commandstats\r\n")
+	for _, name := range names {
+		stat := stats[name]
+		fmt.Fprintf(info, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f\r\n",
+			strings.ToLower(name), stat.Calls, stat.TotalUsec, float64(stat.TotalUsec)/float64(stat.Calls))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// lz4MaxRatio bounds the size a compressed payload may claim to inflate to,
+// as LZ4 cannot shrink data by more than 255x
+const lz4MaxRatio = 255
+
+// compressPayload LZ4 compresses data as [rawlen:4][block]. It reports false
+// when the result would not be smaller than data.
+func compressPayload(data []byte) ([]byte, bool) {
+	if len(data) > math.MaxUint32 {
+		return nil, false
+	}
+
+	packed := make([]byte, 4+lz4.CompressBlockBound(len(data)))
+	n, err := lz4.CompressBlock(data, packed[4:], nil)
+	if err != nil || n == 0 || 4+n >= len(data) {
+		return nil, false
+	}
+	binary.BigEndian.PutUint32(packed[0:4], uint32(len(data)))
+	return packed[:4+n], true
+}
+
+// compressValue keeps a string item's value LZ4 compressed when value
+// compression is enabled, the value is over compress_threshold and
+// compressing makes it smaller
+func (s *GoFastServer) compressValue(item *CacheItem) {
+	if s.config == nil || !s.config.CompressValues {
+		return
+	}
+	value := item.Value.([]byte)
+	if len(value) <= s.config.CompressThreshold {
+		return
+	}
+	if packed, ok := compressPayload(value); ok {
+		item.Value = packed
+		item.Compressed = true
+	}
+}
+
+// stringValue returns a string item's value, inflating it when compressed
+func (item *CacheItem) stringValue() []byte {
+	value := item.Value.([]byte)
+	if !item.Compressed {
+		return value
+	}
+	raw, err := decompressPayload(value, math.MaxUint32)
+	if err != nil {
+		// Only compressPayload output is marked compressed
+		panic(fmt.Sprintf("stored value: %v", err))
+	}
+	return raw
+}
+
+// decompressPayload inflates a payload built by compressPayload, refusing
+// one that claims more than maxLen bytes before allocating for it
+func decompressPayload(packed []byte, maxLen uint64) ([]byte, error) {
+	rawLen := binary.BigEndian.Uint32(packed[0:4])
+	if uint64(rawLen) > maxLen {
+		return nil, fmt.Errorf("compressed payload claims %d bytes, over the %d limit", rawLen, maxLen)
+	}
+	if uint64(rawLen) > uint64(len(packed)-4)*lz4MaxRatio {
+		return nil, fmt.Errorf("compressed payload claims %d bytes from %d", rawLen, len(packed)-4)
+	}
+
+	data := make([]byte, rawLen)
+	n, err := lz4.UncompressBlock(packed[4:], data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed payload: %v", err)
+	}
+	if n != len(data) {
+		return nil, fmt.Errorf("compressed payload inflated to %d bytes, expected %d", n, len(data))
+	}
+	return data, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// BenchmarkCompressedGet compares GET of a compressible value stored
+// as is and LZ4 compressed, reporting the bytes each keeps in memory
+func BenchmarkCompressedGet(b *testing.B) {
+	value := []byte(strings.Repeat(`{"user":"alice","role":"admin","active":true},`, 100))
+
+	for _, compress := range []bool{false, true} {
+		name := "raw"
+		if compress {
+			name = "lz4"
+		}
+		b.Run(name, func(b *testing.B) {
+			s := NewGoFastServer(0)
+			config := DefaultConfig()
+			config.CompressValues = compress
+			s.SetConfig(config)
+
+			s.processCommand(nil, &Message{Command: CMD_SET, Key: []byte("doc"), Value: value})
+			get := &Message{Command: CMD_GET, Key: []byte("doc")}
+			for b.Loop() {
+				if response := s.processCommand(nil, get); response[0] != RESP_OK {
+					b.Fatalf("GET failed: %q", response)
+				}
+			}
+
+			stored, _ := s.databases[0].storage.Load("doc")
+			b.ReportMetric(float64(len(stored.(*CacheItem).Value.([]byte))), "stored-bytes")
+		})
+	}
+}
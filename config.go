@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"net"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,24 +18,83 @@ type Config struct {
 	Host string `mapstructure:"host"`
 	Port int    `mapstructure:"port"`
 
+	UnixSocket string `mapstructure:"unix_socket"`
+
+	ProtocolVersion int `mapstructure:"protocol_version"`
+
+	MaxFrameSize int64 `mapstructure:"max_frame_size"` // Largest binary frame accepted, in bytes
+
+	WSPort   int `mapstructure:"ws_port"`
+	HTTPPort int `mapstructure:"http_port"`
+
+	MonitoringAddr string `mapstructure:"monitoring_addr"` // Prometheus /metrics listen address, "" disables it
+
+	// Wire compression
+	CompressionEnabled   bool `mapstructure:"compression_enabled"`
+	CompressionThreshold int  `mapstructure:"compression_threshold"`
+
+	// Stored value compression
+	CompressValues    bool `mapstructure:"compress_values"`
+	CompressThreshold int  `mapstructure:"compress_threshold"` // Strings longer than this are kept LZ4 compressed
+
 	// Performance settings
 	MaxMemory  string        `mapstructure:"max_memory"`
 	MaxClients int           `mapstructure:"max_clients"`
 	Timeout    time.Duration `mapstructure:"timeout"`
 
+	MaxMemoryPolicy string `mapstructure:"max_memory_policy"` // What writes do once MaxMemory is reached
+	LFULogFactor    int    `mapstructure:"lfu_log_factor"`    // Higher needs more accesses to grow the LFU count
+	LFUDecayTime    int    `mapstructure:"lfu_decay_time"`    // Minutes of idleness per LFU count lost, 0 never decays
+
 	// Logging
 	LogLevel  string `mapstructure:"log_level"`
 	LogFormat string `mapstructure:"log_format"`
 
+	SlowLogEnabled     bool  `mapstructure:"slowlog_enabled"`
+	SlowLogThresholdUs int64 `mapstructure:"slowlog_threshold_us"` // Commands taking at least this many microseconds are logged
+
 	// Persistence
 	SaveInterval  time.Duration `mapstructure:"save_interval"`
 	DataDir       string        `mapstructure:"data_dir"`
 	EnablePersist bool          `mapstructure:"enable_persist"`
 
+	AOFFile string `mapstructure:"aof_file"` // Relative to DataDir unless absolute
+	RDBFile string `mapstructure:"rdb_file"` // Relative to DataDir unless absolute
+
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"` // Longest the snapshot on shutdown may take
+
+	EncryptionEnabled bool   `mapstructure:"encryption_enabled"`  // AES-256-GCM for the AOF and RDB files
+	EncryptionKeyFile string `mapstructure:"encryption_key_file"` // Secret the key is derived from
+
+	// Pub/Sub
+	NotifyKeyspaceEvents string `mapstructure:"notify_keyspace_events"`
+
 	// Security
 	RequireAuth bool   `mapstructure:"require_auth"`
 	Password    string `mapstructure:"password"`
 
+	ACLUsers []string `mapstructure:"acl_users"` // Redis-style user rules
+
+	AllowedCIDRs []string `mapstructure:"allowed_cidrs"` // Empty allows every address
+	DeniedCIDRs  []string `mapstructure:"denied_cidrs"`
+
+	// Rate limiting
+	RateLimitEnabled   bool   `mapstructure:"rate_limit_enabled"`
+	RateLimitOps       uint64 `mapstructure:"rate_limit_ops"` // Requests per window per client IP
+	RateLimitWindowSec int    `mapstructure:"rate_limit_window_sec"`
+
+	RenamedCommands map[string]string `mapstructure:"rename_commands"` // Command -> new name, "" disables it
+
+	MaxAuthAttempts int `mapstructure:"max_auth_attempts"` // AUTH failures per IP before backoff, 0 disables it
+
+	// TLS
+	TLSEnabled  bool   `mapstructure:"tls_enabled"`
+	TLSCertFile string `mapstructure:"tls_cert_file"`
+	TLSKeyFile  string `mapstructure:"tls_key_file"`
+	TLSCAFile   string `mapstructure:"tls_ca_file"`
+
+	TLSClientAuth bool `mapstructure:"tls_client_auth"` // Require client certificates, their CN naming the ACL user
+
 	// Advanced
 	TCPKeepAlive bool          `mapstructure:"tcp_keepalive"`
 	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
@@ -57,6 +119,60 @@ func DefaultConfig() *Config {
 		TCPKeepAlive:  true,
 		ReadTimeout:   30 * time.Second,
 		WriteTimeout:  30 * time.Second,
+
+		MaxMemoryPolicy: POLICY_NOEVICTION,
+		LFULogFactor:    10,
+		LFUDecayTime:    1,
+
+		AOFFile: "appendonly.aof",
+		RDBFile: "dump.rdb",
+
+		DrainTimeout: 30 * time.Second,
+
+		SlowLogEnabled:     false,
+		SlowLogThresholdUs: 10000,
+
+		EncryptionEnabled: false,
+		EncryptionKeyFile: "",
+
+		NotifyKeyspaceEvents: "",
+
+		TLSEnabled:  false,
+		TLSCertFile: "",
+		TLSKeyFile:  "",
+		TLSCAFile:   "",
+
+		TLSClientAuth: false,
+
+		UnixSocket: "",
+
+		ProtocolVersion: PROTOCOL_VERSION,
+
+		MaxFrameSize: DefaultMaxFrameSize,
+
+		WSPort:   0,
+		HTTPPort: 0,
+
+		MonitoringAddr: "",
+
+		CompressionEnabled:   false,
+		CompressionThreshold: 1024,
+
+		CompressValues:    false,
+		CompressThreshold: 1024,
+
+		ACLUsers: nil,
+
+		AllowedCIDRs: nil,
+		DeniedCIDRs:  nil,
+
+		RateLimitEnabled:   false,
+		RateLimitOps:       10000,
+		RateLimitWindowSec: 1,
+
+		RenamedCommands: nil,
+
+		MaxAuthAttempts: 5,
 	}
 }
 
@@ -79,16 +195,50 @@ func LoadConfig() (*Config, error) {
 	// Set defaults
 	viper.SetDefault("host", config.Host)
 	viper.SetDefault("port", config.Port)
+	viper.SetDefault("unix_socket", config.UnixSocket)
+	viper.SetDefault("protocol_version", config.ProtocolVersion)
+	viper.SetDefault("max_frame_size", config.MaxFrameSize)
+	viper.SetDefault("ws_port", config.WSPort)
+	viper.SetDefault("http_port", config.HTTPPort)
+	viper.SetDefault("monitoring_addr", config.MonitoringAddr)
+	viper.SetDefault("compression_enabled", config.CompressionEnabled)
+	viper.SetDefault("compression_threshold", config.CompressionThreshold)
+	viper.SetDefault("compress_values", config.CompressValues)
+	viper.SetDefault("compress_threshold", config.CompressThreshold)
 	viper.SetDefault("max_memory", config.MaxMemory)
+	viper.SetDefault("max_memory_policy", config.MaxMemoryPolicy)
+	viper.SetDefault("lfu_log_factor", config.LFULogFactor)
+	viper.SetDefault("lfu_decay_time", config.LFUDecayTime)
 	viper.SetDefault("max_clients", config.MaxClients)
 	viper.SetDefault("timeout", config.Timeout)
 	viper.SetDefault("log_level", config.LogLevel)
 	viper.SetDefault("log_format", config.LogFormat)
+	viper.SetDefault("slowlog_enabled", config.SlowLogEnabled)
+	viper.SetDefault("slowlog_threshold_us", config.SlowLogThresholdUs)
 	viper.SetDefault("save_interval", config.SaveInterval)
 	viper.SetDefault("data_dir", config.DataDir)
 	viper.SetDefault("enable_persist", config.EnablePersist)
+	viper.SetDefault("aof_file", config.AOFFile)
+	viper.SetDefault("rdb_file", config.RDBFile)
+	viper.SetDefault("drain_timeout", config.DrainTimeout)
+	viper.SetDefault("encryption_enabled", config.EncryptionEnabled)
+	viper.SetDefault("encryption_key_file", config.EncryptionKeyFile)
+	viper.SetDefault("notify_keyspace_events", config.NotifyKeyspaceEvents)
 	viper.SetDefault("require_auth", config.RequireAuth)
 	viper.SetDefault("password", config.Password)
+	viper.SetDefault("acl_users", config.ACLUsers)
+	viper.SetDefault("allowed_cidrs", config.AllowedCIDRs)
+	viper.SetDefault("denied_cidrs", config.DeniedCIDRs)
+	viper.SetDefault("rate_limit_enabled", config.RateLimitEnabled)
+	viper.SetDefault("rate_limit_ops", config.RateLimitOps)
+	viper.SetDefault("rate_limit_window_sec", config.RateLimitWindowSec)
+	viper.SetDefault("rename_commands", config.RenamedCommands)
+	viper.SetDefault("max_auth_attempts", config.MaxAuthAttempts)
+	viper.SetDefault("tls_enabled", config.TLSEnabled)
+	viper.SetDefault("tls_cert_file", config.TLSCertFile)
+	viper.SetDefault("tls_key_file", config.TLSKeyFile)
+	viper.SetDefault("tls_ca_file", config.TLSCAFile)
+	viper.SetDefault("tls_client_auth", config.TLSClientAuth)
 	viper.SetDefault("tcp_keepalive", config.TCPKeepAlive)
 	viper.SetDefault("read_timeout", config.ReadTimeout)
 	viper.SetDefault("write_timeout", config.WriteTimeout)
@@ -115,21 +265,107 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid port: %d (must be 1-65535)", c.Port)
 	}
 
+	if c.WSPort < 0 || c.WSPort > 65535 || (c.WSPort != 0 && c.WSPort == c.Port) {
+		return fmt.Errorf("invalid ws_port: %d (must be 1-65535 and differ from port, or 0 to disable)", c.WSPort)
+	}
+
+	if c.HTTPPort < 0 || c.HTTPPort > 65535 || (c.HTTPPort != 0 && (c.HTTPPort == c.Port || c.HTTPPort == c.WSPort)) {
+		return fmt.Errorf("invalid http_port: %d (must be 1-65535 and differ from the other ports, or 0 to disable)", c.HTTPPort)
+	}
+
+	if c.MonitoringAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MonitoringAddr); err != nil {
+			return fmt.Errorf("invalid monitoring_addr: %w", err)
+		}
+	}
+
+	if c.ProtocolVersion < PROTOCOL_VERSION_1 || c.ProtocolVersion > PROTOCOL_VERSION {
+		return fmt.Errorf("invalid protocol_version: %d (must be %d-%d)", c.ProtocolVersion, PROTOCOL_VERSION_1, PROTOCOL_VERSION)
+	}
+
+	if c.MaxFrameSize < 1 {
+		return fmt.Errorf("max_frame_size must be positive")
+	}
+
+	if _, err := c.ParseMemorySize(); err != nil {
+		return err
+	}
+
+	switch c.MaxMemoryPolicy {
+	case POLICY_NOEVICTION, POLICY_ALLKEYS_LRU, POLICY_ALLKEYS_LFU:
+	default:
+		return fmt.Errorf("invalid max_memory_policy: %s (must be %s, %s or %s)", c.MaxMemoryPolicy, POLICY_NOEVICTION, POLICY_ALLKEYS_LRU, POLICY_ALLKEYS_LFU)
+	}
+
+	if c.LFULogFactor < 0 || c.LFUDecayTime < 0 {
+		return fmt.Errorf("lfu_log_factor and lfu_decay_time must not be negative")
+	}
+
+	if c.EnablePersist && (c.AOFFile == "" || c.RDBFile == "") {
+		return fmt.Errorf("aof_file and rdb_file are required when persistence is enabled")
+	}
+
+	if c.EnablePersist && c.DrainTimeout <= 0 {
+		return fmt.Errorf("drain_timeout must be positive when persistence is enabled")
+	}
+
+	if c.EncryptionEnabled && c.EncryptionKeyFile == "" {
+		return fmt.Errorf("encryption_key_file is required when encryption is enabled")
+	}
+
+	if c.CompressionThreshold < 0 {
+		return fmt.Errorf("compression_threshold must not be negative")
+	}
+
+	if c.CompressThreshold < 0 {
+		return fmt.Errorf("compress_threshold must not be negative")
+	}
+
+	if c.SlowLogThresholdUs < 0 {
+		return fmt.Errorf("slowlog_threshold_us must not be negative")
+	}
+
 	if c.MaxClients < 1 {
 		return fmt.Errorf("max_clients must be at least 1")
 	}
 
-	validLogLevels := []string{"trace", "debug", "info", "warn", "error", "fatal"}
-	validLevel := false
-	for _, level := range validLogLevels {
-		if c.LogLevel == level {
-			validLevel = true
-			break
+	if c.TLSEnabled && (c.TLSCertFile == "" || c.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file are required when TLS is enabled")
+	}
+
+	if c.TLSClientAuth && (!c.TLSEnabled || c.TLSCAFile == "") {
+		return fmt.Errorf("tls_client_auth requires tls_enabled and tls_ca_file")
+	}
+
+	if _, err := ParseKeyspaceConfig(c.NotifyKeyspaceEvents); err != nil {
+		return fmt.Errorf("invalid notify_keyspace_events: %w", err)
+	}
+
+	for _, rule := range c.ACLUsers {
+		if _, err := ParseACLUser(rule); err != nil {
+			return fmt.Errorf("invalid acl_users: %w", err)
 		}
 	}
-	if !validLevel {
+
+	if c.MaxAuthAttempts < 0 {
+		return fmt.Errorf("invalid max_auth_attempts: %d (must be 0 or more)", c.MaxAuthAttempts)
+	}
+
+	if _, err := ParseIPFilter(c.AllowedCIDRs, c.DeniedCIDRs); err != nil {
+		return fmt.Errorf("invalid allowed_cidrs or denied_cidrs: %w", err)
+	}
+
+	if c.RateLimitEnabled && (c.RateLimitOps == 0 || c.RateLimitWindowSec < 1) {
+		return fmt.Errorf("invalid rate limit: %d ops per %ds (both must be positive)", c.RateLimitOps, c.RateLimitWindowSec)
+	}
+
+	if _, err := ParseCommandRenames(c.RenamedCommands); err != nil {
+		return fmt.Errorf("invalid rename_commands: %w", err)
+	}
+
+	if !slices.Contains(logLevelNames, c.LogLevel) {
 		return fmt.Errorf("invalid log_level: %s (must be one of: %s)",
-			c.LogLevel, strings.Join(validLogLevels, ", "))
+			c.LogLevel, strings.Join(logLevelNames, ", "))
 	}
 
 	return nil
@@ -168,3 +404,142 @@ func (c *Config) String() string {
 	return fmt.Sprintf("GoFast Config: %s:%d, MaxMemory: %s, LogLevel: %s",
 		c.Host, c.Port, c.MaxMemory, c.LogLevel)
 }
+
+// configParam reads one setting for CONFIG GET and, unless it only takes
+// effect on restart, changes it for CONFIG SET
+type configParam struct {
+	get func(c *Config) string
+	set func(c *Config, value string) error // nil when a restart is needed
+}
+
+// configParams are the settings CONFIG GET and CONFIG SET know, by their
+// config file name
+var configParams = map[string]configParam{
+	"host": {get: func(c *Config) string { return c.Host }},
+	"port": {get: func(c *Config) string { return strconv.Itoa(c.Port) }},
+
+	"max_memory": {get: func(c *Config) string { return c.MaxMemory }},
+	"log_level": {
+		get: func(c *Config) string { return c.LogLevel },
+		set: func(c *Config, value string) error {
+			c.LogLevel = strings.ToLower(value)
+			return nil
+		},
+	},
+	"max_clients": {
+		get: func(c *Config) string { return strconv.Itoa(c.MaxClients) },
+		set: func(c *Config, value string) (err error) {
+			c.MaxClients, err = strconv.Atoi(value)
+			return err
+		},
+	},
+
+	"slowlog_enabled": {
+		get: func(c *Config) string { return strconv.FormatBool(c.SlowLogEnabled) },
+		set: func(c *Config, value string) (err error) {
+			c.SlowLogEnabled, err = strconv.ParseBool(value)
+			return err
+		},
+	},
+	"slowlog_threshold_us": {
+		get: func(c *Config) string { return strconv.FormatInt(c.SlowLogThresholdUs, 10) },
+		set: func(c *Config, value string) (err error) {
+			c.SlowLogThresholdUs, err = strconv.ParseInt(value, 10, 64)
+			return err
+		},
+	},
+}
+
+// handleConfig runs a CONFIG subcommand
+// Format: [subcommand:1] followed by [patternlen:4][pattern] for GET, the
+// pattern defaulting to every setting, and [keylen:4][key][valuelen:4][value]
+// for SET
+func (s *GoFastServer) handleConfig(data []byte) []byte {
+	args := newArgReader(data)
+	subcommand := args.uint8()
+	if args.err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid CONFIG data"))
+	}
+
+	switch subcommand {
+	case CONFIG_RESETSTAT:
+		s.resetStats()
+		return s.createResponse(RESP_OK, []byte("OK"))
+
+	case CONFIG_GET:
+		pattern := "*"
+		if args.offset < len(data) {
+			pattern = args.string()
+		}
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid CONFIG data"))
+		}
+		if s.config == nil {
+			return s.createResponse(RESP_ERROR, []byte("ERR no configuration loaded"))
+		}
+		return s.createResponse(RESP_OK, s.encodeStringArray(s.configGet(strings.ToLower(pattern))))
+
+	case CONFIG_SET:
+		key := strings.ToLower(args.string())
+		value := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid CONFIG data"))
+		}
+		if s.config == nil {
+			return s.createResponse(RESP_ERROR, []byte("ERR no configuration loaded"))
+		}
+		if err := s.configSet(key, value); err != nil {
+			return s.createResponse(RESP_ERROR, []byte(err.Error()))
+		}
+		return s.createResponse(RESP_OK, []byte("OK"))
+
+	default:
+		return s.createResponse(RESP_ERROR, []byte("Unknown CONFIG subcommand"))
+	}
+}
+
+// configGet returns the name and value of every setting matching pattern,
+// sorted by name
+func (s *GoFastServer) configGet(pattern string) []string {
+	var names []string
+	for name := range configParams {
+		if s.matchPattern(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	pairs := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		pairs = append(pairs, name, configParams[name].get(s.config))
+	}
+	return pairs
+}
+
+// configSet changes a setting in place once the config it would give
+// passes Validate
+func (s *GoFastServer) configSet(key, value string) error {
+	param, ok := configParams[key]
+	if !ok {
+		return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", key)
+	}
+	if param.set == nil {
+		return fmt.Errorf("ERR CONFIG SET '%s' is read-only, change it in the config file and restart", key)
+	}
+
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	updated := *s.config
+	if err := param.set(&updated, value); err != nil {
+		return fmt.Errorf("ERR Invalid argument '%s' for CONFIG SET '%s'", value, key)
+	}
+	if err := updated.Validate(); err != nil {
+		return fmt.Errorf("ERR CONFIG SET failed: %v", err)
+	}
+	param.set(s.config, value)
+	setLogLevel(s.config.LogLevel)
+	return nil
+}
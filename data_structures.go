@@ -1,6 +1,15 @@
 package main
 
-import "maps"
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"unsafe"
+)
 
 // NewList creates a new list
 func NewList() *List {
@@ -21,6 +30,19 @@ func NewHash() *Hash {
 	}
 }
 
+// NewZSet creates a new sorted set
+func NewZSet() *ZSet {
+	return &ZSet{
+		dict: make(map[string]float64),
+		zsl:  newSkipList(),
+	}
+}
+
+// NewStream creates a new stream
+func NewStream() *Stream {
+	return &Stream{}
+}
+
 // List methods
 func (l *List) LeftPush(value []byte) int {
 	l.mutex.Lock()
@@ -146,6 +168,191 @@ func (l *List) Range(start, end int) [][]byte {
 	return result
 }
 
+// Set replaces the value at index, returning false if index is out of range
+func (l *List) Set(index int, value []byte) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if index < 0 || index >= l.length {
+		return false
+	}
+
+	current := l.head
+	for range index {
+		current = current.next
+	}
+	current.value = value
+	return true
+}
+
+// Insert adds value before or after the first element equal to pivot and
+// returns the new length, or -1 if pivot is not in the list
+func (l *List) Insert(pivot, value []byte, after bool) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	current := l.head
+	for current != nil && !bytes.Equal(current.value, pivot) {
+		current = current.next
+	}
+	if current == nil {
+		return -1
+	}
+
+	node := &ListNode{value: value}
+	if after {
+		node.prev = current
+		node.next = current.next
+		if current.next != nil {
+			current.next.prev = node
+		} else {
+			l.tail = node
+		}
+		current.next = node
+	} else {
+		node.next = current
+		node.prev = current.prev
+		if current.prev != nil {
+			current.prev.next = node
+		} else {
+			l.head = node
+		}
+		current.prev = node
+	}
+	l.length++
+	return l.length
+}
+
+// Trim keeps only the elements in [start, end] and returns the new length.
+// Negative indices count from the tail, as in Redis.
+func (l *List) Trim(start, end int) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if start < 0 {
+		start += l.length
+	}
+	if end < 0 {
+		end += l.length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= l.length {
+		end = l.length - 1
+	}
+	if start > end {
+		l.head = nil
+		l.tail = nil
+		l.length = 0
+		return 0
+	}
+
+	// Drop leading nodes
+	for range start {
+		l.head = l.head.next
+	}
+	l.head.prev = nil
+
+	// Drop trailing nodes
+	for range l.length - 1 - end {
+		l.tail = l.tail.prev
+	}
+	l.tail.next = nil
+
+	l.length = end - start + 1
+	return l.length
+}
+
+// Positions returns the 0-based indices of elements equal to element. A
+// negative rank scans from the tail and skips the first |rank|-1 matches,
+// count limits the number of results and maxLen limits how many elements
+// are compared (0 means no limit for both).
+func (l *List) Positions(element []byte, rank, count, maxLen int) []int {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	fromTail := rank < 0
+	if fromTail {
+		rank = -rank
+	}
+	if rank == 0 {
+		rank = 1
+	}
+
+	var positions []int
+	current, index := l.head, 0
+	if fromTail {
+		current, index = l.tail, l.length-1
+	}
+
+	for scanned := 0; current != nil; scanned++ {
+		if maxLen > 0 && scanned >= maxLen {
+			break
+		}
+		if bytes.Equal(current.value, element) {
+			if rank > 1 {
+				rank--
+			} else {
+				positions = append(positions, index)
+				if count > 0 && len(positions) >= count {
+					break
+				}
+			}
+		}
+
+		if fromTail {
+			current, index = current.prev, index-1
+		} else {
+			current, index = current.next, index+1
+		}
+	}
+	return positions
+}
+
+// Remove deletes up to |count| elements equal to element, scanning from the
+// head when count > 0 and from the tail when count < 0. A count of 0 removes
+// every match. Returns the number of removed elements.
+func (l *List) Remove(count int, element []byte) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	fromTail := count < 0
+	if fromTail {
+		count = -count
+	}
+
+	removed := 0
+	current := l.head
+	if fromTail {
+		current = l.tail
+	}
+
+	for current != nil && (count == 0 || removed < count) {
+		next := current.next
+		if fromTail {
+			next = current.prev
+		}
+
+		if bytes.Equal(current.value, element) {
+			if current.prev != nil {
+				current.prev.next = current.next
+			} else {
+				l.head = current.next
+			}
+			if current.next != nil {
+				current.next.prev = current.prev
+			} else {
+				l.tail = current.prev
+			}
+			l.length--
+			removed++
+		}
+		current = next
+	}
+	return removed
+}
+
 // Set methods
 func (s *Set) Add(member string) bool {
 	s.mutex.Lock()
@@ -191,6 +398,131 @@ func (s *Set) IsMember(member string) bool {
 	return exists
 }
 
+// MoveTo atomically moves member from s to dst, also reporting whether s
+// was left empty. Both mutexes are held for the membership check and the
+// move, taken in address order so concurrent moves in opposite directions
+// cannot deadlock.
+func (s *Set) MoveTo(dst *Set, member string) (moved, emptied bool) {
+	if s == dst {
+		return s.IsMember(member), false
+	}
+
+	first, second := s, dst
+	if uintptr(unsafe.Pointer(dst)) < uintptr(unsafe.Pointer(s)) {
+		first, second = dst, s
+	}
+	first.mutex.Lock()
+	defer first.mutex.Unlock()
+	second.mutex.Lock()
+	defer second.mutex.Unlock()
+
+	if _, exists := s.members[member]; !exists {
+		return false, false
+	}
+	delete(s.members, member)
+	dst.members[member] = struct{}{}
+	return true, len(s.members) == 0
+}
+
+// SetUnion returns the members present in any of the sets. Nil sets are
+// treated as empty.
+func SetUnion(sets []*Set) []string {
+	result := make(map[string]struct{})
+	for _, set := range sets {
+		if set == nil {
+			continue
+		}
+		set.mutex.RLock()
+		for member := range set.members {
+			result[member] = struct{}{}
+		}
+		set.mutex.RUnlock()
+	}
+	return memberSlice(result)
+}
+
+// SetInter returns the members present in every set
+func SetInter(sets []*Set) []string {
+	result := []string{}
+	intersect(sets, func(member string) bool {
+		result = append(result, member)
+		return true
+	})
+	return result
+}
+
+// SetInterCard counts the members present in every set, stopping once
+// limit is reached when limit > 0
+func SetInterCard(sets []*Set, limit int) int {
+	count := 0
+	intersect(sets, func(string) bool {
+		count++
+		return limit <= 0 || count < limit
+	})
+	return count
+}
+
+// intersect calls visit for each member present in every set, filtering the
+// smallest set against the others. Iteration stops when visit returns false.
+func intersect(sets []*Set, visit func(member string) bool) {
+	smallest := -1
+	for i, set := range sets {
+		if set == nil || set.Card() == 0 {
+			return
+		}
+		if smallest == -1 || set.Card() < sets[smallest].Card() {
+			smallest = i
+		}
+	}
+	if smallest == -1 {
+		return
+	}
+
+	for _, member := range sets[smallest].Members() {
+		inAll := true
+		for i, set := range sets {
+			if i != smallest && !set.IsMember(member) {
+				inAll = false
+				break
+			}
+		}
+		if inAll && !visit(member) {
+			return
+		}
+	}
+}
+
+// SetDiff returns the members of the first set that are not present in any
+// of the following sets
+func SetDiff(sets []*Set) []string {
+	if len(sets) == 0 || sets[0] == nil {
+		return []string{}
+	}
+
+	result := []string{}
+	for _, member := range sets[0].Members() {
+		found := false
+		for _, set := range sets[1:] {
+			if set != nil && set.IsMember(member) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+func memberSlice(members map[string]struct{}) []string {
+	result := make([]string, 0, len(members))
+	for member := range members {
+		result = append(result, member)
+	}
+	return result
+}
+
 // Hash methods
 func (h *Hash) Set(field string, value []byte) bool {
 	h.mutex.Lock()
@@ -201,6 +533,18 @@ func (h *Hash) Set(field string, value []byte) bool {
 	return !exists // return true if it was a new field
 }
 
+// SetNX sets field only if it doesn't exist yet, returning true if it was set
+func (h *Hash) SetNX(field string, value []byte) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, exists := h.fields[field]; exists {
+		return false
+	}
+	h.fields[field] = value
+	return true
+}
+
 func (h *Hash) Get(field string) ([]byte, bool) {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
@@ -209,6 +553,21 @@ func (h *Hash) Get(field string) ([]byte, bool) {
 	return value, exists
 }
 
+// Update replaces a field with the result of fn under the hash's write lock,
+// leaving the field unchanged if fn returns an error
+func (h *Hash) Update(field string, fn func(current []byte, exists bool) ([]byte, error)) ([]byte, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	current, exists := h.fields[field]
+	value, err := fn(current, exists)
+	if err != nil {
+		return nil, err
+	}
+	h.fields[field] = value
+	return value, nil
+}
+
 func (h *Hash) Del(field string) bool {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
@@ -220,6 +579,25 @@ func (h *Hash) Del(field string) bool {
 	return exists
 }
 
+// GetDel removes fields in a single critical section, returning the old
+// values (nil for absent fields) and the number of fields left in the hash
+func (h *Hash) GetDel(fields []string) ([][]byte, int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	values := make([][]byte, len(fields))
+	for i, field := range fields {
+		if value, exists := h.fields[field]; exists {
+			if value == nil {
+				value = []byte{}
+			}
+			values[i] = value
+			delete(h.fields, field)
+		}
+	}
+	return values, len(h.fields)
+}
+
 func (h *Hash) GetAll() map[string][]byte {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
@@ -229,6 +607,28 @@ func (h *Hash) GetAll() map[string][]byte {
 	return result
 }
 
+func (h *Hash) Keys() []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	keys := make([]string, 0, len(h.fields))
+	for field := range h.fields {
+		keys = append(keys, field)
+	}
+	return keys
+}
+
+func (h *Hash) Values() [][]byte {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	values := make([][]byte, 0, len(h.fields))
+	for _, value := range h.fields {
+		values = append(values, value)
+	}
+	return values
+}
+
 func (h *Hash) Len() int {
 	h.mutex.RLock()
 	defer h.mutex.RUnlock()
@@ -241,3 +641,988 @@ func (h *Hash) Exists(field string) bool {
 	_, exists := h.fields[field]
 	return exists
 }
+
+// Skip list parameters, matching Redis' zset implementation
+const (
+	skipListMaxLevel = 32
+	skipListP        = 0.25
+)
+
+func newSkipList() *SkipList {
+	return &SkipList{
+		header: &SkipListNode{levels: make([]SkipListLevel, skipListMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomSkipListLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// zslLess reports whether (score, member) sorts before node
+func zslLess(score float64, member string, node *SkipListNode) bool {
+	return node.score < score || (node.score == score && node.member < member)
+}
+
+// insert adds a new node; the caller must ensure member isn't present
+func (zsl *SkipList) insert(score float64, member string) *SkipListNode {
+	var update [skipListMaxLevel]*SkipListNode
+	var rank [skipListMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && zslLess(score, member, x.levels[i].forward) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomSkipListLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].levels[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &SkipListNode{member: member, score: score, levels: make([]SkipListLevel, level)}
+	for i := 0; i < level; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+
+	// Untouched levels skip one more node
+	for i := level; i < zsl.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+// delete removes the node matching score and member, returning false if it
+// isn't present
+func (zsl *SkipList) delete(score float64, member string) bool {
+	var update [skipListMaxLevel]*SkipListNode
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && zslLess(score, member, x.levels[i].forward) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.levels[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.levels[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// rank returns the 1-based rank of the node matching score and member, or 0
+// if it isn't present
+func (zsl *SkipList) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil &&
+			(zslLess(score, member, x.levels[i].forward) || (x.levels[i].forward.score == score && x.levels[i].forward.member == member)) {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if x != zsl.header && x.score == score && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the 1-based rank, or nil if out of range
+func (zsl *SkipList) byRank(rank int) *SkipListNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstWhere returns the first node satisfying pred, which must be false for
+// a prefix of the list and true for the rest
+func (zsl *SkipList) firstWhere(pred func(*SkipListNode) bool) *SkipListNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && !pred(x.levels[i].forward) {
+			x = x.levels[i].forward
+		}
+	}
+	return x.levels[0].forward
+}
+
+// lastWhere returns the last node satisfying pred, which must be true for a
+// prefix of the list and false for the rest
+func (zsl *SkipList) lastWhere(pred func(*SkipListNode) bool) *SkipListNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && pred(x.levels[i].forward) {
+			x = x.levels[i].forward
+		}
+	}
+	if x == zsl.header {
+		return nil
+	}
+	return x
+}
+
+// next steps one node forward, or backward when reverse is set
+func (zsl *SkipList) next(x *SkipListNode, reverse bool) *SkipListNode {
+	if reverse {
+		return x.backward
+	}
+	return x.levels[0].forward
+}
+
+func (b ScoreBound) below(node *SkipListNode) bool {
+	return node.score > b.Value || (!b.Exclusive && node.score == b.Value)
+}
+
+func (b ScoreBound) above(node *SkipListNode) bool {
+	return node.score < b.Value || (!b.Exclusive && node.score == b.Value)
+}
+
+func (b LexBound) below(node *SkipListNode) bool {
+	if b.Inf != 0 {
+		return b.Inf < 0
+	}
+	return node.member > b.Value || (!b.Exclusive && node.member == b.Value)
+}
+
+func (b LexBound) above(node *SkipListNode) bool {
+	if b.Inf != 0 {
+		return b.Inf > 0
+	}
+	return node.member < b.Value || (!b.Exclusive && node.member == b.Value)
+}
+
+// ZSet methods
+
+// Add sets member's score, returning true if the member is new
+func (z *ZSet) Add(member string, score float64) bool {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	return z.set(member, score)
+}
+
+// set inserts or repositions member; the caller must hold the write lock
+func (z *ZSet) set(member string, score float64) bool {
+	current, exists := z.dict[member]
+	if exists {
+		if current == score {
+			return false
+		}
+		z.zsl.delete(current, member)
+	}
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+	return !exists
+}
+
+// AddWithFlags applies a single ZADD entry under the write lock. It returns
+// the member's resulting score, whether the member was newly added and
+// whether the update was applied at all.
+func (z *ZSet) AddWithFlags(member string, score float64, flags ZAddFlags) (float64, bool, bool) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	current, exists := z.dict[member]
+	if (exists && flags&ZADD_NX != 0) || (!exists && flags&ZADD_XX != 0) {
+		return current, false, false
+	}
+
+	if flags&ZADD_INCR != 0 {
+		score += current
+		if math.IsNaN(score) {
+			return current, false, false
+		}
+	}
+
+	if exists && ((flags&ZADD_GT != 0 && score <= current) || (flags&ZADD_LT != 0 && score >= current)) {
+		return current, false, false
+	}
+
+	return score, z.set(member, score), true
+}
+
+// Remove deletes member, returning true if it was present
+func (z *ZSet) Remove(member string) bool {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	score, exists := z.dict[member]
+	if !exists {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	return true
+}
+
+func (z *ZSet) Score(member string) (float64, bool) {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+	score, exists := z.dict[member]
+	return score, exists
+}
+
+func (z *ZSet) Card() int {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+	return len(z.dict)
+}
+
+// Rank returns member's 0-based position by ascending score, or by
+// descending score when reverse is set, along with its score
+func (z *ZSet) Rank(member string, reverse bool) (int, float64, bool) {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+
+	score, exists := z.dict[member]
+	if !exists {
+		return 0, 0, false
+	}
+	rank := z.zsl.rank(score, member)
+	if reverse {
+		return z.zsl.length - rank, score, true
+	}
+	return rank - 1, score, true
+}
+
+// Scores returns a snapshot of the member to score map
+func (z *ZSet) Scores() map[string]float64 {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+
+	result := make(map[string]float64, len(z.dict))
+	maps.Copy(result, z.dict)
+	return result
+}
+
+// Count returns the number of entries with scores between min and max
+func (z *ZSet) Count(min, max ScoreBound) int {
+	return z.countBetween(min.below, max.above)
+}
+
+// LexCount returns the number of entries with members between min and max
+func (z *ZSet) LexCount(min, max LexBound) int {
+	return z.countBetween(min.below, max.above)
+}
+
+// countBetween counts the nodes for which both atLeastMin and atMostMax hold
+// using their ranks, in O(log N)
+func (z *ZSet) countBetween(atLeastMin, atMostMax func(*SkipListNode) bool) int {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+
+	first := z.zsl.firstWhere(atLeastMin)
+	last := z.zsl.lastWhere(atMostMax)
+	if first == nil || last == nil {
+		return 0
+	}
+	count := z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+	if count < 0 {
+		return 0
+	}
+	return count
+}
+
+// Pop removes and returns up to count entries from the lowest scores, or
+// from the highest when fromMax is set
+func (z *ZSet) Pop(count int, fromMax bool) []ZEntry {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	result := make([]ZEntry, 0, min(count, z.zsl.length))
+	for len(result) < count {
+		x := z.zsl.header.levels[0].forward
+		if fromMax {
+			x = z.zsl.tail
+		}
+		if x == nil {
+			break
+		}
+		result = append(result, ZEntry{Member: x.member, Score: x.score})
+		z.zsl.delete(x.score, x.member)
+		delete(z.dict, x.member)
+	}
+	return result
+}
+
+// RemoveRangeByScore deletes the entries with scores between min and max,
+// returning how many were removed
+func (z *ZSet) RemoveRangeByScore(min, max ScoreBound) int {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	removed := 0
+	x := z.zsl.firstWhere(min.below)
+	for x != nil && max.above(x) {
+		next := x.levels[0].forward
+		z.zsl.delete(x.score, x.member)
+		delete(z.dict, x.member)
+		removed++
+		x = next
+	}
+	return removed
+}
+
+// RemoveRangeByRank deletes the entries between the 0-based ranks start and
+// stop inclusive, returning how many were removed. Negative indices count
+// from the end.
+func (z *ZSet) RemoveRangeByRank(start, stop int) int {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	length := z.zsl.length
+	if start < 0 {
+		start = length + start
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return 0
+	}
+
+	removed := 0
+	x := z.zsl.byRank(start + 1)
+	for x != nil && removed <= stop-start {
+		next := x.levels[0].forward
+		z.zsl.delete(x.score, x.member)
+		delete(z.dict, x.member)
+		removed++
+		x = next
+	}
+	return removed
+}
+
+// RangeByScore returns the entries with scores between min and max, skipping
+// offset entries and returning at most count (all if count is negative)
+func (z *ZSet) RangeByScore(min, max ScoreBound, reverse bool, offset, count int) []ZEntry {
+	return z.rangeBetween(min.below, max.above, reverse, offset, count)
+}
+
+// RangeByLex returns the entries with members between min and max. It is
+// only meaningful when all members share the same score.
+func (z *ZSet) RangeByLex(min, max LexBound, reverse bool, offset, count int) []ZEntry {
+	return z.rangeBetween(min.below, max.above, reverse, offset, count)
+}
+
+// rangeBetween walks the nodes for which both atLeastMin and atMostMax hold
+func (z *ZSet) rangeBetween(atLeastMin, atMostMax func(*SkipListNode) bool, reverse bool, offset, count int) []ZEntry {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+
+	result := []ZEntry{}
+	if count == 0 {
+		return result
+	}
+
+	var x *SkipListNode
+	inRange := atMostMax
+	if reverse {
+		x = z.zsl.lastWhere(atMostMax)
+		inRange = atLeastMin
+	} else {
+		x = z.zsl.firstWhere(atLeastMin)
+	}
+
+	for ; x != nil && inRange(x); x = z.zsl.next(x, reverse) {
+		if offset > 0 {
+			offset--
+			continue
+		}
+		result = append(result, ZEntry{Member: x.member, Score: x.score})
+		if count > 0 && len(result) == count {
+			break
+		}
+	}
+	return result
+}
+
+// Range returns the entries between the 0-based ranks start and stop
+// inclusive. Negative indices count from the end.
+func (z *ZSet) Range(start, stop int, reverse bool) []ZEntry {
+	z.mutex.RLock()
+	defer z.mutex.RUnlock()
+
+	length := z.zsl.length
+	if start < 0 {
+		start = length + start
+	}
+	if stop < 0 {
+		stop = length + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return []ZEntry{}
+	}
+
+	result := make([]ZEntry, 0, stop-start+1)
+	if reverse {
+		for x := z.zsl.byRank(length - start); x != nil && len(result) < cap(result); x = x.backward {
+			result = append(result, ZEntry{Member: x.member, Score: x.score})
+		}
+	} else {
+		for x := z.zsl.byRank(start + 1); x != nil && len(result) < cap(result); x = x.levels[0].forward {
+			result = append(result, ZEntry{Member: x.member, Score: x.score})
+		}
+	}
+	return result
+}
+
+// Stream methods
+
+var (
+	errStreamIDTooSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	errStreamIDZero     = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+)
+
+// Less reports whether id sorts before other
+func (id StreamID) Less(other StreamID) bool {
+	return id.Millis < other.Millis || (id.Millis == other.Millis && id.Seq < other.Seq)
+}
+
+// String formats id as "<millis>-<seq>"
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Millis, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Add appends an entry holding fields, alternating field names and values.
+// With auto set the ID is generated from nowMillis; with autoSeq only the
+// sequence part of id is. The ID must be greater than any added before.
+func (st *Stream) Add(id StreamID, auto, autoSeq bool, fields []string, nowMillis uint64) (StreamID, error) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	last := st.lastID
+	switch {
+	case auto:
+		if nowMillis > last.Millis {
+			id = StreamID{Millis: nowMillis}
+		} else {
+			if last.Seq == math.MaxUint64 {
+				return StreamID{}, errStreamIDTooSmall
+			}
+			id = StreamID{Millis: last.Millis, Seq: last.Seq + 1}
+		}
+	case autoSeq:
+		if id.Millis == last.Millis {
+			if last.Seq == math.MaxUint64 {
+				return StreamID{}, errStreamIDTooSmall
+			}
+			id.Seq = last.Seq + 1
+		}
+	}
+
+	if id == (StreamID{}) {
+		return StreamID{}, errStreamIDZero
+	}
+	if !last.Less(id) {
+		return StreamID{}, errStreamIDTooSmall
+	}
+
+	st.entries = append(st.entries, StreamEntry{ID: id, Fields: fields})
+	st.lastID = id
+	return id, nil
+}
+
+// Len returns the number of entries
+func (st *Stream) Len() int {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+	return len(st.entries)
+}
+
+// LastID returns the highest ID ever added, even if that entry is gone
+func (st *Stream) LastID() StreamID {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+	return st.lastID
+}
+
+// search returns the index of the first entry with an ID not less than id.
+// Caller holds the mutex.
+func (st *Stream) search(id StreamID) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].ID.Less(id)
+	})
+}
+
+// Range returns up to count entries (all if count is negative) with IDs
+// between start and end inclusive, newest first when reverse is set
+func (st *Stream) Range(start, end StreamID, reverse bool, count int) []StreamEntry {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	lo := st.search(start)
+	hi := sort.Search(len(st.entries), func(i int) bool {
+		return end.Less(st.entries[i].ID)
+	})
+	if lo >= hi {
+		return []StreamEntry{}
+	}
+
+	n := hi - lo
+	if count >= 0 && count < n {
+		n = count
+	}
+
+	result := make([]StreamEntry, n)
+	for i := range result {
+		if reverse {
+			result[i] = st.entries[hi-1-i]
+		} else {
+			result[i] = st.entries[lo+i]
+		}
+	}
+	return result
+}
+
+// After returns up to count entries (all if count is not positive) with IDs
+// greater than id
+func (st *Stream) After(id StreamID, count int) []StreamEntry {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	lo := sort.Search(len(st.entries), func(i int) bool {
+		return id.Less(st.entries[i].ID)
+	})
+	entries := st.entries[lo:]
+	if count > 0 && count < len(entries) {
+		entries = entries[:count]
+	}
+	return append([]StreamEntry(nil), entries...)
+}
+
+// Delete removes the entries with the given IDs, returning how many existed
+func (st *Stream) Delete(ids []StreamID) int {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	deleted := 0
+	for _, id := range ids {
+		i := st.search(id)
+		if i < len(st.entries) && st.entries[i].ID == id {
+			st.entries = append(st.entries[:i], st.entries[i+1:]...)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// TrimMaxLen evicts the oldest entries until at most maxLen remain,
+// returning how many were removed
+func (st *Stream) TrimMaxLen(maxLen int) int {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if len(st.entries) <= maxLen {
+		return 0
+	}
+	removed := len(st.entries) - maxLen
+	st.entries = append(st.entries[:0:0], st.entries[removed:]...)
+	return removed
+}
+
+// TrimMinID evicts the entries with IDs less than minID, returning how many
+// were removed
+func (st *Stream) TrimMinID(minID StreamID) int {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	removed := st.search(minID)
+	if removed > 0 {
+		st.entries = append(st.entries[:0:0], st.entries[removed:]...)
+	}
+	return removed
+}
+
+// Consumer group methods
+
+// CreateGroup adds a consumer group that will deliver entries after id, or
+// after the current last ID when fromLast is set. It returns false if the
+// group already exists.
+func (st *Stream) CreateGroup(name string, id StreamID, fromLast bool) bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if _, exists := st.groups[name]; exists {
+		return false
+	}
+	if st.groups == nil {
+		st.groups = make(map[string]*ConsumerGroup)
+	}
+	if fromLast {
+		id = st.lastID
+	}
+	st.groups[name] = &ConsumerGroup{
+		name:            name,
+		lastDeliveredID: id,
+		pel:             make(map[StreamID]*PendingEntry),
+		consumers:       make(map[string]*StreamConsumer),
+	}
+	return true
+}
+
+// DestroyGroup removes a consumer group, reporting whether it existed
+func (st *Stream) DestroyGroup(name string) bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	if _, exists := st.groups[name]; !exists {
+		return false
+	}
+	delete(st.groups, name)
+	return true
+}
+
+// HasGroup reports whether the consumer group exists
+func (st *Stream) HasGroup(name string) bool {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	_, exists := st.groups[name]
+	return exists
+}
+
+// SetGroupID moves the group's last delivered ID to id, or to the current
+// last ID when fromLast is set. It returns false if the group is missing.
+func (st *Stream) SetGroupID(name string, id StreamID, fromLast bool) bool {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	group, exists := st.groups[name]
+	if !exists {
+		return false
+	}
+	if fromLast {
+		id = st.lastID
+	}
+	group.lastDeliveredID = id
+	return true
+}
+
+// CreateConsumer adds consumer to the group, reporting whether it was new.
+// ok is false if the group is missing.
+func (st *Stream) CreateConsumer(groupName, consumer string, nowMillis int64) (created, ok bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	group, exists := st.groups[groupName]
+	if !exists {
+		return false, false
+	}
+	if _, exists := group.consumers[consumer]; exists {
+		return false, true
+	}
+	group.consumers[consumer] = &StreamConsumer{name: consumer, seenAt: nowMillis}
+	return true, true
+}
+
+// DeleteConsumer removes consumer from the group along with its pending
+// entries, returning how many were pending. ok is false if the group is
+// missing.
+func (st *Stream) DeleteConsumer(groupName, consumer string) (pending int, ok bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	group, exists := st.groups[groupName]
+	if !exists {
+		return 0, false
+	}
+	if _, exists := group.consumers[consumer]; !exists {
+		return 0, true
+	}
+
+	for id, entry := range group.pel {
+		if entry.Consumer == consumer {
+			delete(group.pel, id)
+			pending++
+		}
+	}
+	delete(group.consumers, consumer)
+	return pending, true
+}
+
+// ReadGroup delivers entries to consumer on behalf of a group. With newOnly
+// it hands out up to count entries (all if count is not positive) never
+// delivered to the group, recording them as pending unless noAck is set.
+// Otherwise it replays the consumer's own pending entries with IDs greater
+// than after; entries deleted since delivery come back without fields. ok
+// is false if the group is missing.
+func (st *Stream) ReadGroup(groupName, consumer string, after StreamID, newOnly, noAck bool, count int, nowMillis int64) ([]StreamEntry, bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	group, exists := st.groups[groupName]
+	if !exists {
+		return nil, false
+	}
+	if c, exists := group.consumers[consumer]; exists {
+		c.seenAt = nowMillis
+	} else {
+		group.consumers[consumer] = &StreamConsumer{name: consumer, seenAt: nowMillis}
+	}
+
+	if newOnly {
+		lo := sort.Search(len(st.entries), func(i int) bool {
+			return group.lastDeliveredID.Less(st.entries[i].ID)
+		})
+		entries := st.entries[lo:]
+		if count > 0 && count < len(entries) {
+			entries = entries[:count]
+		}
+
+		for _, entry := range entries {
+			group.lastDeliveredID = entry.ID
+			if !noAck {
+				group.pel[entry.ID] = &PendingEntry{Consumer: consumer, DeliveredAt: nowMillis, DeliveryCount: 1}
+			}
+		}
+		return append([]StreamEntry(nil), entries...), true
+	}
+
+	var ids []StreamID
+	for id, pending := range group.pel {
+		if pending.Consumer == consumer && after.Less(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+	if count > 0 && count < len(ids) {
+		ids = ids[:count]
+	}
+
+	entries := make([]StreamEntry, len(ids))
+	for i, id := range ids {
+		entries[i] = StreamEntry{ID: id}
+		if j := st.search(id); j < len(st.entries) && st.entries[j].ID == id {
+			entries[i] = st.entries[j]
+		}
+	}
+	return entries, true
+}
+
+// Ack removes the given IDs from the group's PEL, returning how many were
+// pending. A missing group acknowledges nothing.
+func (st *Stream) Ack(groupName string, ids []StreamID) int {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	group, exists := st.groups[groupName]
+	if !exists {
+		return 0
+	}
+
+	acked := 0
+	for _, id := range ids {
+		if _, pending := group.pel[id]; pending {
+			delete(group.pel, id)
+			acked++
+		}
+	}
+	return acked
+}
+
+// PendingSummary returns the size of the group's PEL, its lowest and highest
+// IDs, and the number of pending entries per consumer. ok is false if the
+// group is missing.
+func (st *Stream) PendingSummary(groupName string) (total int, first, last StreamID, perConsumer map[string]int, ok bool) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	group, exists := st.groups[groupName]
+	if !exists {
+		return 0, StreamID{}, StreamID{}, nil, false
+	}
+
+	perConsumer = make(map[string]int)
+	for id, pending := range group.pel {
+		if total == 0 || id.Less(first) {
+			first = id
+		}
+		if total == 0 || last.Less(id) {
+			last = id
+		}
+		total++
+		perConsumer[pending.Consumer]++
+	}
+	return total, first, last, perConsumer, true
+}
+
+// Bounds returns the IDs of the first and last entries. ok is false for an
+// empty stream.
+func (st *Stream) Bounds() (first, last StreamID, ok bool) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	if len(st.entries) == 0 {
+		return StreamID{}, StreamID{}, false
+	}
+	return st.entries[0].ID, st.entries[len(st.entries)-1].ID, true
+}
+
+// GroupsInfo returns a snapshot of every consumer group, ordered by name
+func (st *Stream) GroupsInfo() []StreamGroupInfo {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	groups := make([]StreamGroupInfo, 0, len(st.groups))
+	for _, group := range st.groups {
+		groups = append(groups, StreamGroupInfo{
+			Name:            group.name,
+			Consumers:       len(group.consumers),
+			Pending:         len(group.pel),
+			LastDeliveredID: group.lastDeliveredID,
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// ConsumersInfo returns a snapshot of the group's consumers, ordered by
+// name. ok is false if the group is missing.
+func (st *Stream) ConsumersInfo(groupName string) ([]StreamConsumerInfo, bool) {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	group, exists := st.groups[groupName]
+	if !exists {
+		return nil, false
+	}
+
+	pending := make(map[string]int)
+	for _, entry := range group.pel {
+		pending[entry.Consumer]++
+	}
+
+	consumers := make([]StreamConsumerInfo, 0, len(group.consumers))
+	for _, consumer := range group.consumers {
+		consumers = append(consumers, StreamConsumerInfo{
+			Name:    consumer.name,
+			Pending: pending[consumer.name],
+			SeenAt:  consumer.seenAt,
+		})
+	}
+	sort.Slice(consumers, func(i, j int) bool { return consumers[i].Name < consumers[j].Name })
+	return consumers, true
+}
+
+// AutoClaim walks the group's PEL in ID order from start and hands up to
+// count entries idle for at least minIdle milliseconds over to consumer.
+// Entries deleted from the stream are dropped from the PEL instead. It
+// returns the claimed entries and the PEL ID to resume from, 0-0 once the
+// whole PEL was scanned. ok is false if the group is missing.
+func (st *Stream) AutoClaim(groupName, consumer string, minIdle int64, start StreamID, count int, nowMillis int64) (claimed []StreamEntry, next StreamID, ok bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	group, exists := st.groups[groupName]
+	if !exists {
+		return nil, StreamID{}, false
+	}
+	if c, exists := group.consumers[consumer]; exists {
+		c.seenAt = nowMillis
+	} else {
+		group.consumers[consumer] = &StreamConsumer{name: consumer, seenAt: nowMillis}
+	}
+
+	var ids []StreamID
+	for id := range group.pel {
+		if !id.Less(start) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+
+	claimed = []StreamEntry{}
+	for i, id := range ids {
+		if len(claimed) == count {
+			return claimed, ids[i], true
+		}
+
+		pending := group.pel[id]
+		if nowMillis-pending.DeliveredAt < minIdle {
+			continue
+		}
+
+		j := st.search(id)
+		if j >= len(st.entries) || st.entries[j].ID != id {
+			delete(group.pel, id)
+			continue
+		}
+
+		pending.Consumer = consumer
+		pending.DeliveredAt = nowMillis
+		pending.DeliveryCount++
+		claimed = append(claimed, st.entries[j])
+	}
+	return claimed, StreamID{}, true
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// database returns the database a connection has selected. Trusted
+// internal callers without a connection use database 0.
+func (s *GoFastServer) database(state *connState) *DatabaseState {
+	if state == nil {
+		return s.databases[0]
+	}
+	return s.databases[state.activeDB]
+}
+
+// handleSelect switches the connection to another logical database
+// Format: [dbindex:4]
+func (s *GoFastServer) handleSelect(state *connState, data []byte) []byte {
+	if state == nil || len(data) != 4 {
+		return s.createResponse(RESP_ERROR, []byte("Invalid SELECT data"))
+	}
+
+	index := binary.BigEndian.Uint32(data)
+	if index >= MAX_DATABASES {
+		return s.createResponse(RESP_ERROR, []byte(fmt.Sprintf("ERR DB index is out of range, must be below %d", MAX_DATABASES)))
+	}
+	state.activeDB = int(index)
+	return s.createResponse(RESP_OK, nil)
+}
@@ -1,6 +1,9 @@
 package main
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"math"
+)
 
 // Encoding helpers for complex responses
 func (s *GoFastServer) encodeArray(values [][]byte) []byte {
@@ -24,6 +27,18 @@ func (s *GoFastServer) encodeArray(values [][]byte) []byte {
 	return result
 }
 
+func (s *GoFastServer) encodeKeyedArray(key string, values [][]byte) []byte {
+	// Encoding: [keylen:4][key][count:4][len1:4][val1][len2:4][val2]...
+	array := s.encodeArray(values)
+
+	result := s.bytePool.Get(4 + len(key) + len(array))
+	binary.BigEndian.PutUint32(result[0:4], uint32(len(key)))
+	copy(result[4:], key)
+	copy(result[4+len(key):], array)
+
+	return result
+}
+
 func (s *GoFastServer) encodeStringArray(values []string) []byte {
 	byteValues := make([][]byte, len(values))
 	for i, v := range values {
@@ -34,6 +49,12 @@ func (s *GoFastServer) encodeStringArray(values []string) []byte {
 	return s.encodeArray(byteValues)
 }
 
+// encodeByteArray encodes raw byte values read out of a data structure using
+// the same [count:4][len1:4][val1]... layout as encodeArray
+func (s *GoFastServer) encodeByteArray(values [][]byte) []byte {
+	return s.encodeArray(values)
+}
+
 func (s *GoFastServer) encodeHashMap(fields map[string][]byte) []byte {
 	// Encoding: [count:4][field1_len:4][field1][val1_len:4][val1]...
 	totalLen := 4 // count field
@@ -141,3 +162,168 @@ func (s *GoFastServer) encodeScanResponse(cursor uint32, keys []string) []byte {
 
 	return result
 }
+
+func (s *GoFastServer) encodeZScanResponse(cursor uint32, entries []ZEntry) []byte {
+	// ZSCAN response format: [cursor:4][count:4][member1_len:4][member1][score1:8]...
+	totalLen := 4 + 4 // cursor + count
+	for _, entry := range entries {
+		totalLen += 4 + len(entry.Member) + 8
+	}
+
+	result := s.bytePool.Get(totalLen)
+
+	binary.BigEndian.PutUint32(result[0:4], cursor)
+	binary.BigEndian.PutUint32(result[4:8], uint32(len(entries)))
+
+	offset := 8
+	for _, entry := range entries {
+		binary.BigEndian.PutUint32(result[offset:offset+4], uint32(len(entry.Member)))
+		offset += 4
+		copy(result[offset:], entry.Member)
+		offset += len(entry.Member)
+
+		binary.BigEndian.PutUint64(result[offset:offset+8], math.Float64bits(entry.Score))
+		offset += 8
+	}
+
+	return result
+}
+
+func (s *GoFastServer) encodeHashScanResponse(cursor uint32, fields []string, values [][]byte) []byte {
+	// HSCAN response format: [cursor:4][count:4][field1_len:4][field1][val1_len:4][val1]...
+	totalLen := 4 + 4 // cursor + count
+	for i, field := range fields {
+		totalLen += 4 + len(field) + 4 + len(values[i])
+	}
+
+	result := s.bytePool.Get(totalLen)
+
+	binary.BigEndian.PutUint32(result[0:4], cursor)
+	binary.BigEndian.PutUint32(result[4:8], uint32(len(fields)))
+
+	offset := 8
+	for i, field := range fields {
+		binary.BigEndian.PutUint32(result[offset:offset+4], uint32(len(field)))
+		offset += 4
+		copy(result[offset:], field)
+		offset += len(field)
+
+		binary.BigEndian.PutUint32(result[offset:offset+4], uint32(len(values[i])))
+		offset += 4
+		copy(result[offset:], values[i])
+		offset += len(values[i])
+	}
+
+	return result
+}
+
+func (s *GoFastServer) encodeStreamEntries(entries []StreamEntry) []byte {
+	// Stream entries format: [count:4] then per entry
+	// [idlen:4][id][numargs:4][field1len:4][field1][value1len:4][value1]...
+	ids := make([]string, len(entries))
+	totalLen := 4 // count field
+	for i, entry := range entries {
+		ids[i] = entry.ID.String()
+		totalLen += 4 + len(ids[i]) + 4
+		for _, field := range entry.Fields {
+			totalLen += 4 + len(field)
+		}
+	}
+
+	result := s.bytePool.Get(totalLen)
+	binary.BigEndian.PutUint32(result[0:4], uint32(len(entries)))
+
+	offset := 4
+	for i, entry := range entries {
+		binary.BigEndian.PutUint32(result[offset:offset+4], uint32(len(ids[i])))
+		offset += 4
+		copy(result[offset:], ids[i])
+		offset += len(ids[i])
+
+		binary.BigEndian.PutUint32(result[offset:offset+4], uint32(len(entry.Fields)))
+		offset += 4
+		for _, field := range entry.Fields {
+			binary.BigEndian.PutUint32(result[offset:offset+4], uint32(len(field)))
+			offset += 4
+			copy(result[offset:], field)
+			offset += len(field)
+		}
+	}
+
+	return result
+}
+
+func (s *GoFastServer) encodeStreamRead(keys []string, results [][]StreamEntry) []byte {
+	// XREAD response format: [numstreams:4] then per stream [keylen:4][key][stream entries]
+	encoded := make([][]byte, len(keys))
+	totalLen := 4 // stream count
+	for i, key := range keys {
+		encoded[i] = s.encodeStreamEntries(results[i])
+		totalLen += 4 + len(key) + len(encoded[i])
+	}
+
+	result := s.bytePool.Get(totalLen)
+	binary.BigEndian.PutUint32(result[0:4], uint32(len(keys)))
+
+	offset := 4
+	for i, key := range keys {
+		binary.BigEndian.PutUint32(result[offset:offset+4], uint32(len(key)))
+		offset += 4
+		copy(result[offset:], key)
+		offset += len(key)
+		copy(result[offset:], encoded[i])
+		offset += len(encoded[i])
+	}
+
+	return result
+}
+
+func (s *GoFastServer) encodeAutoClaimResponse(cursor string, entries []StreamEntry) []byte {
+	// XAUTOCLAIM response format: [cursorlen:4][cursor][stream entries]
+	encoded := s.encodeStreamEntries(entries)
+
+	result := s.bytePool.Get(4 + len(cursor) + len(encoded))
+	binary.BigEndian.PutUint32(result[0:4], uint32(len(cursor)))
+	copy(result[4:], cursor)
+	copy(result[4+len(cursor):], encoded)
+
+	return result
+}
+
+// decodeArray splits [count:4][len1:4][val1]... into its values, where a
+// length of 0xFFFFFFFF marks a nil entry
+func decodeArray(data []byte, width int) ([][]byte, bool) {
+	if len(data) < 4 {
+		return nil, false
+	}
+	count := int(binary.BigEndian.Uint32(data[0:4])) * width
+	if count > len(data)/4 {
+		return nil, false
+	}
+
+	values := make([][]byte, count)
+	offset := 4
+	for i := range values {
+		if offset+4 > len(data) {
+			return nil, false
+		}
+		n := binary.BigEndian.Uint32(data[offset : offset+4])
+		offset += 4
+		if n == 0xFFFFFFFF {
+			continue
+		}
+		if offset+int(n) > len(data) {
+			return nil, false
+		}
+		values[i] = data[offset : offset+int(n)]
+		offset += int(n)
+	}
+	return values, true
+}
+
+// appendLenPrefixed appends arg as [len:4][arg], the layout of keys and
+// values in request payloads
+func appendLenPrefixed(buf, arg []byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(arg)))
+	return append(buf, arg...)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"gofast/persist"
+)
+
+// Eviction policies, chosen with max_memory_policy
+const (
+	POLICY_NOEVICTION  = "noeviction"  // Never remove keys to make room
+	POLICY_ALLKEYS_LRU = "allkeys-lru" // Remove the least recently used key
+	POLICY_ALLKEYS_LFU = "allkeys-lfu" // Remove the least frequently used key
+)
+
+// ERR_OOM refuses writes over max_memory that eviction cannot make room for
+const ERR_OOM = "OOM command not allowed when used memory > maxmemory"
+
+// LFU_INIT_VAL is the access count keys start with, so a key just written
+// is not the first to go
+const LFU_INIT_VAL = 5
+
+// EVICTION_SAMPLES is how many keys each database offers as candidates
+// for one eviction. Sampling approximates the policy without keeping keys
+// ordered by access.
+const EVICTION_SAMPLES = 5
+
+// memoryCheckInterval bounds how often runtime.ReadMemStats, which stops
+// the world, runs on the write path
+const memoryCheckInterval = 100 * time.Millisecond
+
+// overMemoryLimit reports whether the heap exceeds max_memory. The heap
+// size is sampled at most every memoryCheckInterval.
+func (s *GoFastServer) overMemoryLimit() bool {
+	if s.maxMemory <= 0 {
+		return false
+	}
+
+	now := time.Now().UnixNano()
+	checked := s.memoryCheckedAt.Load()
+	if now-checked >= int64(memoryCheckInterval) && s.memoryCheckedAt.CompareAndSwap(checked, now) {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		s.memoryUsed.Store(stats.Alloc)
+	}
+	return s.memoryUsed.Load() > uint64(s.maxMemory)
+}
+
+// evict removes one key chosen by policy from a sample of every database,
+// returning false when policy never evicts or there was nothing to remove.
+// Freed memory only shows once the garbage collector runs, so writes keep
+// evicting a key each until the heap is back under the limit.
+func evict(s *GoFastServer, policy string) bool {
+	// rank orders the candidates, the lowest being evicted
+	var rank func(item *CacheItem) int64
+	switch policy {
+	case POLICY_ALLKEYS_LRU:
+		rank = (*CacheItem).lastAccess
+	case POLICY_ALLKEYS_LFU:
+		now := time.Now().Unix()
+		rank = func(item *CacheItem) int64 {
+			return int64(item.accessCount(now, s.config.LFUDecayTime))
+		}
+	default:
+		return false
+	}
+
+	var victimDB *DatabaseState
+	var victim string
+	var lowest int64
+	for _, db := range s.databases {
+		sampled := 0
+		// Range starts at a random point of the map, so the first keys it
+		// visits are a random sample
+		db.storage.Range(func(key, value any) bool {
+			r := rank(value.(*CacheItem))
+			if victimDB == nil || r < lowest {
+				victimDB, victim, lowest = db, key.(string), r
+			}
+			sampled++
+			return sampled < EVICTION_SAMPLES
+		})
+	}
+	if victimDB == nil {
+		return false
+	}
+
+	victimDB.evictKey(victim)
+	return true
+}
+
+// evictKey deletes a key to free memory. Unlike expiry, eviction does not
+// follow from the data, so it is logged to the AOF as a DEL for replay to
+// drop the key too.
+func (s *DatabaseState) evictKey(key string) {
+	if s.aof != nil {
+		s.aofMutex.Lock()
+		defer s.aofMutex.Unlock()
+	}
+
+	if _, existed := s.storage.LoadAndDelete(key); !existed {
+		return
+	}
+	s.ttlMutex.Lock()
+	delete(s.ttlIndex, key)
+	s.ttlMutex.Unlock()
+	s.touchKey(key)
+	s.incrementStat("evicted_keys")
+
+	if s.aof != nil {
+		record := persist.Record{Time: time.Now().Unix(), DB: uint8(s.index), Command: CMD_DEL, Key: []byte(key)}
+		if err := s.aof.Append(record); err != nil {
+			logf(LOG_ERROR, "AOF append error: %v", err)
+		}
+	}
+}
+
+// shrinkingWrites only remove data, so they still run over max_memory and
+// can be used to free memory by hand
+var shrinkingWrites = map[uint8]bool{
+	CMD_DEL: true, CMD_EXPIRE: true, CMD_EXPIREAT: true, CMD_PEXPIREAT: true,
+	CMD_LPOP: true, CMD_RPOP: true, CMD_LTRIM: true, CMD_LREM: true,
+	CMD_BLPOP: true, CMD_BRPOP: true, CMD_LMPOP: true,
+	CMD_SREM: true, CMD_SPOP: true, CMD_HDEL: true, CMD_HGETDEL: true,
+	CMD_ZREM: true, CMD_ZPOPMIN: true, CMD_ZPOPMAX: true,
+	CMD_ZREMRANGEBYSCORE: true, CMD_ZREMRANGEBYRANK: true,
+	CMD_XDEL: true, CMD_XTRIM: true, CMD_XACK: true,
+}
+
+// enforceMemoryLimit makes room before a write when the heap is over
+// max_memory, returning false when the write must be refused because the
+// policy could not evict anything
+func (s *GoFastServer) enforceMemoryLimit(msg *Message) bool {
+	if !isWriteCommand(msg.Command) || shrinkingWrites[msg.Command] || !s.overMemoryLimit() {
+		return true
+	}
+	return evict(s, s.config.MaxMemoryPolicy)
+}
+
+// recordAccess stamps the key msg ran against as used now, for LRU, and
+// counts the access for LFU
+func (s *DatabaseState) recordAccess(msg *Message, now int64) {
+	if len(msg.Key) == 0 {
+		return
+	}
+	value, ok := s.storage.Load(string(msg.Key))
+	if !ok {
+		return
+	}
+	item := value.(*CacheItem)
+	if s.config != nil {
+		count := item.accessCount(now, s.config.LFUDecayTime)
+		atomic.StoreUint64(&item.AccessCount, lfuIncrement(count, s.config.LFULogFactor))
+	}
+	atomic.StoreInt64(&item.LastAccessedAt, now)
+}
+
+// lfuIncrement counts one access with a Morris counter: the count grows
+// with probability 1/((count-LFU_INIT_VAL)*factor+1), so it tracks the
+// logarithm of the accesses and a larger factor needs more of them
+func lfuIncrement(count uint64, factor int) uint64 {
+	base := count - min(count, LFU_INIT_VAL)
+	if rand.Float64() < 1/(float64(base)*float64(factor)+1) {
+		count++
+	}
+	return count
+}
+
+// accessCount returns the item's LFU count, decremented once for every
+// decayMinutes since it was last used so that keys that were hot once
+// become evictable. Items never used yet count as LFU_INIT_VAL.
+func (item *CacheItem) accessCount(now int64, decayMinutes int) uint64 {
+	accessedAt := atomic.LoadInt64(&item.LastAccessedAt)
+	if accessedAt == 0 {
+		return LFU_INIT_VAL
+	}
+	count := atomic.LoadUint64(&item.AccessCount)
+	if decayMinutes > 0 && now > accessedAt {
+		periods := uint64((now - accessedAt) / 60 / int64(decayMinutes))
+		count -= min(count, periods)
+	}
+	return count
+}
+
+// lastAccess returns when the item was last used, items never read since
+// they were stored counting from their creation
+func (item *CacheItem) lastAccess() int64 {
+	if accessedAt := atomic.LoadInt64(&item.LastAccessedAt); accessedAt != 0 {
+		return accessedAt
+	}
+	return item.CreatedAt
+}
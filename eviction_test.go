@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestNoEvictionOOM fills the heap past max_memory under noeviction and
+// checks writes are refused while reads and deletes still run
+func TestNoEvictionOOM(t *testing.T) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+
+	s := NewGoFastServer(0)
+	config := DefaultConfig()
+	config.MaxMemory = strconv.FormatUint(stats.Alloc+4<<20, 10)
+	config.MaxMemoryPolicy = POLICY_NOEVICTION
+	s.SetConfig(config)
+
+	var response []byte
+	written := 0
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		key := fmt.Sprintf("fill:%d", written)
+		value := make([]byte, 64<<10) // SET keeps the slice it is given
+		response = s.processCommand(nil, &Message{Command: CMD_SET, Key: []byte(key), Value: value})
+		if response[0] != RESP_OK {
+			break
+		}
+		written++
+		time.Sleep(time.Millisecond) // Let the heap be sampled again
+	}
+	if response[0] != RESP_ERROR || string(response[5:]) != ERR_OOM {
+		t.Fatalf("after %d writes SET replied %q, want %q", written, response, ERR_OOM)
+	}
+	if written == 0 {
+		t.Fatal("the first write was already refused")
+	}
+
+	response = s.processCommand(nil, &Message{Command: CMD_GET, Key: []byte("fill:0")})
+	if response[0] != RESP_OK {
+		t.Errorf("GET over the limit replied %q", response)
+	}
+	response = s.processCommand(nil, &Message{Command: CMD_DEL, Key: []byte("fill:0")})
+	if response[0] != RESP_OK || string(response[5:]) != "1" {
+		t.Errorf("DEL over the limit replied %q", response)
+	}
+}
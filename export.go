@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// exportRecord is one key of a dump, written as a line of JSON. Values are
+// base64: a string for strings, an array for lists and sets, and an object
+// of fields for hashes.
+type exportRecord struct {
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	TTL   int64           `json:"ttl"` // Seconds left, -1 for no expiration
+	Value json.RawMessage `json:"value"`
+}
+
+// exportProbe reads a key as one data type. The server has no TYPE
+// command, so a key's type is the first probe that does not fail with
+// WRONGTYPE.
+type exportProbe struct {
+	name    string
+	command uint8
+	payload func(keyPayload []byte) []byte
+	decode  func(data []byte) (any, bool, error) // false when the key is empty
+}
+
+var exportProbes = []exportProbe{
+	{"string", CMD_GET, keyOnly, func(data []byte) (any, bool, error) {
+		return data, true, nil
+	}},
+	{"list", CMD_LRANGE, func(keyPayload []byte) []byte {
+		payload := binary.BigEndian.AppendUint32(keyPayload, 0)
+		return binary.BigEndian.AppendUint32(payload, math.MaxInt32)
+	}, decodeExportArray},
+	{"set", CMD_SMEMBERS, keyOnly, decodeExportArray},
+	{"hash", CMD_HGETALL, keyOnly, func(data []byte) (any, bool, error) {
+		args := newArgReader(data)
+		fields := make(map[string][]byte)
+		for range args.uint32() {
+			field := args.string()
+			fields[field] = args.bytes()
+		}
+		return fields, len(fields) > 0, args.err
+	}},
+}
+
+func keyOnly(keyPayload []byte) []byte {
+	return keyPayload
+}
+
+func decodeExportArray(data []byte) (any, bool, error) {
+	args := newArgReader(data)
+	var values [][]byte
+	for range args.uint32() {
+		values = append(values, args.bytes())
+	}
+	return values, len(values) > 0, args.err
+}
+
+// dumpKeys writes every key matching pattern to w as NDJSON, returning the
+// number of keys written. Keys of other types are skipped with a warning.
+func dumpKeys(client *Client, pattern string, w io.Writer) (int, error) {
+	data, err := client.Call(CMD_KEYS, appendLenPrefixed(nil, []byte(pattern)))
+	if err != nil {
+		return 0, fmt.Errorf("KEYS failed: %w", err)
+	}
+	args := newArgReader(data)
+	keys := make([]string, 0, args.uint32())
+	for range cap(keys) {
+		keys = append(keys, args.string())
+	}
+	if args.err != nil {
+		return 0, fmt.Errorf("invalid KEYS response: %v", args.err)
+	}
+
+	encoder := json.NewEncoder(w)
+	dumped := 0
+	for _, key := range keys {
+		record, ok, err := exportKey(client, key)
+		if err != nil {
+			return dumped, fmt.Errorf("key %q: %w", key, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := encoder.Encode(record); err != nil {
+			return dumped, err
+		}
+		dumped++
+	}
+	return dumped, nil
+}
+
+// exportKey reads one key, returning false when it no longer exists or
+// holds a type dumps cannot represent
+func exportKey(client *Client, key string) (*exportRecord, bool, error) {
+	keyPayload := appendLenPrefixed(nil, []byte(key))
+	data, err := client.Call(CMD_TTL, keyPayload)
+	if err != nil {
+		return nil, false, err
+	}
+	ttl, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid TTL response %q", data)
+	}
+	if ttl == -2 {
+		return nil, false, nil // Expired since KEYS
+	}
+
+	for _, probe := range exportProbes {
+		status, data, err := client.Do(probe.command, probe.payload(bytes.Clone(keyPayload)))
+		if err != nil {
+			return nil, false, err
+		}
+		switch status {
+		case RESP_NOT_FOUND:
+			return nil, false, nil
+		case RESP_ERROR:
+			if strings.HasPrefix(string(data), "WRONGTYPE") {
+				continue
+			}
+			return nil, false, fmt.Errorf("%s", data)
+		}
+
+		value, ok, err := probe.decode(data)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, false, err
+		}
+		return &exportRecord{Key: key, Type: probe.name, TTL: ttl, Value: encoded}, true, nil
+	}
+
+	logf(LOG_WARN, "Skipping key %q: only strings, lists, sets and hashes can be dumped", key)
+	return nil, false, nil
+}
+
+// loadKeys recreates the keys of an NDJSON dump read from r, replacing
+// existing keys of the same name, and returns the number of keys loaded.
+// Only keys matching pattern are loaded.
+func loadKeys(client *Client, pattern string, r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+	loaded := 0
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			var record exportRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return loaded, fmt.Errorf("line %d: %v", line, err)
+			}
+			if wildcardMatch(pattern, record.Key) {
+				if err := importKey(client, &record); err != nil {
+					return loaded, fmt.Errorf("line %d: key %q: %w", line, record.Key, err)
+				}
+				loaded++
+			}
+		}
+		if err == io.EOF {
+			return loaded, nil
+		}
+		if err != nil {
+			return loaded, err
+		}
+	}
+}
+
+// importKey writes one dumped key. Strings are set with their TTL in one
+// command; other types replace the key and then set the expiration.
+func importKey(client *Client, record *exportRecord) error {
+	keyPayload := appendLenPrefixed(nil, []byte(record.Key))
+	ttl := uint32(max(record.TTL, 0))
+
+	if record.Type == "string" {
+		var value []byte
+		if err := json.Unmarshal(record.Value, &value); err != nil {
+			return err
+		}
+		payload := binary.BigEndian.AppendUint32(keyPayload, ttl)
+		_, err := client.Call(CMD_SET, appendLenPrefixed(payload, value))
+		return err
+	}
+
+	var command uint8
+	var payload []byte
+	switch record.Type {
+	case "list", "set":
+		var values [][]byte
+		if err := json.Unmarshal(record.Value, &values); err != nil {
+			return err
+		}
+		command = CMD_RPUSH // Keeps the dumped order
+		if record.Type == "set" {
+			command = CMD_SADD
+		}
+		payload = binary.BigEndian.AppendUint32(bytes.Clone(keyPayload), uint32(len(values)))
+		for _, value := range values {
+			payload = appendLenPrefixed(payload, value)
+		}
+
+	case "hash":
+		var fields map[string][]byte
+		if err := json.Unmarshal(record.Value, &fields); err != nil {
+			return err
+		}
+		command = CMD_HMSET
+		payload = binary.BigEndian.AppendUint32(bytes.Clone(keyPayload), uint32(len(fields)))
+		for field, value := range fields {
+			payload = appendLenPrefixed(payload, []byte(field))
+			payload = appendLenPrefixed(payload, value)
+		}
+
+	default:
+		return fmt.Errorf("unknown type %q", record.Type)
+	}
+
+	if _, err := client.Call(CMD_DEL, keyPayload); err != nil {
+		return err
+	}
+	if _, err := client.Call(command, payload); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		_, err := client.Call(CMD_EXPIRE, binary.BigEndian.AppendUint32(keyPayload, ttl))
+		return err
+	}
+	return nil
+}
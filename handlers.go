@@ -3,11 +3,20 @@ package main
 import (
 	"encoding/binary"
 	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
+	"unsafe"
 )
 
-func (s *GoFastServer) handleMGet(data []byte, now int64) []byte {
+func (s *DatabaseState) handleMGet(data []byte, now int64) []byte {
 	// Parse multiple keys from data: [count:4][key1_len:4][key1][key2_len:4][key2]...
 	if len(data) < 4 {
 		return s.createResponse(RESP_ERROR, []byte("Invalid MGET data"))
@@ -46,13 +55,10 @@ func (s *GoFastServer) handleMGet(data []byte, now int64) []byte {
 
 			// Check if expired
 			if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-				s.storage.Delete(key)
-				s.ttlMutex.Lock()
-				delete(s.ttlIndex, key)
-				s.ttlMutex.Unlock()
+				s.expireKey(key)
 				values[i] = nil // Expired/not found
 			} else if item.DataType == TYPE_STRING {
-				values[i] = item.Value.([]byte)
+				values[i] = item.stringValue()
 			} else {
 				values[i] = nil // Wrong type
 			}
@@ -66,7 +72,7 @@ func (s *GoFastServer) handleMGet(data []byte, now int64) []byte {
 
 // STEP 4: Add the MSET handler to main.go (add after handleMGet function)
 
-func (s *GoFastServer) handleMSet(data []byte, now int64) []byte {
+func (s *DatabaseState) handleMSet(data []byte, now int64) []byte {
 	// Parse multiple key-value pairs: [count:4][key1_len:4][key1][val1_len:4][val1][ttl1:4]...
 	if len(data) < 4 {
 		return s.createResponse(RESP_ERROR, []byte("Invalid MSET data"))
@@ -127,6 +133,7 @@ func (s *GoFastServer) handleMSet(data []byte, now int64) []byte {
 			Value:     value,
 			CreatedAt: now,
 		}
+		s.compressValue(item)
 
 		if ttl > 0 {
 			item.ExpiresAt = now + int64(ttl)
@@ -142,7 +149,7 @@ func (s *GoFastServer) handleMSet(data []byte, now int64) []byte {
 	return s.createResponse(RESP_OK, []byte(fmt.Sprintf("%d", successCount)))
 }
 
-func (s *GoFastServer) handlePipeline(data []byte, now int64) []byte {
+func (s *GoFastServer) handlePipeline(state *connState, data []byte, now int64) []byte {
 	// Parse pipeline: [count:4][msg1][msg2][msg3]...
 	if len(data) < 4 {
 		return s.createResponse(RESP_ERROR, []byte("Invalid PIPELINE data"))
@@ -171,8 +178,35 @@ func (s *GoFastServer) handlePipeline(data []byte, now int64) []byte {
 			continue
 		}
 
+		// Every command is checked on its own, the pipeline only carries them
+		if denied := s.checkACL(state, msg); denied != nil {
+			responses[i] = denied
+			offset = newOffset
+			continue
+		}
+
+		// SELECT switches the database for the rest of the pipeline
+		if msg.Command == CMD_SELECT {
+			responses[i] = s.handleSelect(state, msg.Value)
+			offset = newOffset
+			continue
+		}
+
+		if !s.enforceMemoryLimit(msg) {
+			responses[i] = s.createResponse(RESP_ERROR, []byte(ERR_OOM))
+			offset = newOffset
+			continue
+		}
+
 		// Process the individual command
-		response := s.processIndividualCommand(msg, now)
+		db := s.database(state)
+		response := s.logWrite(db, msg, now, func() []byte {
+			return db.processIndividualCommand(msg, now)
+		})
+		db.notifyCommand(msg, response)
+		db.touchCommand(msg, response)
+		db.recordAccess(msg, now)
+		s.feedMonitors(state, msg)
 		responses[i] = response
 		offset = newOffset
 	}
@@ -180,6 +214,61 @@ func (s *GoFastServer) handlePipeline(data []byte, now int64) []byte {
 	return s.createResponse(RESP_OK, s.encodePipelineResponse(responses))
 }
 
+// handleBGSave starts writing a snapshot to the RDB file in the background,
+// refusing while another one is being written
+func (s *GoFastServer) handleBGSave() []byte {
+	if s.config == nil {
+		return s.createResponse(RESP_ERROR, []byte("ERR no data directory configured"))
+	}
+	if !s.savingRDB.CompareAndSwap(false, true) {
+		return s.createResponse(RESP_ERROR, []byte("ERR Background save already in progress"))
+	}
+
+	path := dataPath(s.config, s.config.RDBFile)
+	go func() {
+		defer s.savingRDB.Store(false)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			logf(LOG_ERROR, "Background save failed: %v", err)
+			return
+		}
+		saved, err := s.saveRDB(path)
+		if err != nil {
+			logf(LOG_ERROR, "Background save failed: %v", err)
+			return
+		}
+
+		s.stats.mutex.Lock()
+		s.stats.LastSaveTime = time.Now().Unix()
+		s.stats.mutex.Unlock()
+		logf(LOG_INFO, "Background save of %d keys to %s finished", saved, path)
+	}()
+	return s.createResponse(RESP_OK, []byte("Background saving started"))
+}
+
+// handleBGRewriteAOF starts compacting the AOF in the background, refusing
+// while another rewrite is running
+func (s *GoFastServer) handleBGRewriteAOF() []byte {
+	if s.aof == nil {
+		return s.createResponse(RESP_ERROR, []byte("ERR persistence is disabled"))
+	}
+	if !s.rewritingAOF.CompareAndSwap(false, true) {
+		return s.createResponse(RESP_ERROR, []byte("ERR Background append only file rewriting already in progress"))
+	}
+
+	go func() {
+		defer s.rewritingAOF.Store(false)
+
+		records, err := s.rewriteAOF()
+		if err != nil {
+			logf(LOG_ERROR, "AOF rewrite failed: %v", err)
+			return
+		}
+		logf(LOG_INFO, "AOF rewrite finished with %d records", records)
+	}()
+	return s.createResponse(RESP_OK, []byte("Background append only file rewriting started"))
+}
+
 //  New parsePipelineMessage() function (add after handlePipeline()):
 
 func (s *GoFastServer) parsePipelineMessage(data []byte, offset int) (*Message, int, error) {
@@ -201,7 +290,7 @@ func (s *GoFastServer) parsePipelineMessage(data []byte, offset int) (*Message,
 	offset += 2
 
 	msg := &Message{
-		Length:  msgLen,
+		Length:  uint64(msgLen),
 		Version: version,
 		Command: command,
 	}
@@ -242,8 +331,43 @@ func (s *GoFastServer) parsePipelineMessage(data []byte, offset int) (*Message,
 		offset += int(keyLen)
 		msg.TTL = binary.BigEndian.Uint32(data[offset : offset+4])
 
-	case CMD_LPUSH, CMD_RPUSH, CMD_SADD, CMD_GETSET:
-		// Parse list/set/getset operations: [keylen:4][key][valuelen:4][value]
+	case CMD_MEMORY_USAGE:
+		// Parse MEMORY USAGE: [keylen:4][key][samples:4]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid MEMORY USAGE message in pipeline")
+		}
+		keyLen := binary.BigEndian.Uint32(data[offset : offset+4])
+		offset += 4
+		if remaining < 8+int(keyLen) {
+			return nil, endOffset, fmt.Errorf("invalid MEMORY USAGE message in pipeline")
+		}
+		msg.Key = make([]byte, keyLen)
+		copy(msg.Key, data[offset:offset+int(keyLen)])
+		offset += int(keyLen)
+		msg.TTL = binary.BigEndian.Uint32(data[offset : offset+4])
+
+	case CMD_LPUSH, CMD_RPUSH:
+		// Parse list push: [keylen:4][key][numvalues:4][val1len:4][val1]...
+		// or the single-value form [keylen:4][key][valuelen:4][value]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid list push in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_SADD, CMD_SREM:
+		// Parse set member operations: [keylen:4][key][nummembers:4][members...]
+		// or the single-member form [keylen:4][key][memberlen:4][member]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid set operation in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_GETSET:
+		// Parse getset operations: [keylen:4][key][valuelen:4][value]
 		if remaining < 8 {
 			return nil, endOffset, fmt.Errorf("invalid list/set operation in pipeline")
 		}
@@ -258,6 +382,127 @@ func (s *GoFastServer) parsePipelineMessage(data []byte, offset int) (*Message,
 		msg.Value = make([]byte, valueLen)
 		copy(msg.Value, data[offset:offset+int(valueLen)])
 
+	case CMD_SETBIT, CMD_GETBIT:
+		// Parse bit operations: [keylen:4][key][offset:4][bit:1]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid bit operation in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_BITCOUNT:
+		// Parse BITCOUNT: [keylen:4][key][rangeflags:1][start:4][end:4]
+		if remaining < 13 {
+			return nil, endOffset, fmt.Errorf("invalid BITCOUNT message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_BITPOS:
+		// Parse BITPOS: [keylen:4][key][bit:1][rangeflags:1][start:4][end:4]
+		if remaining < 14 {
+			return nil, endOffset, fmt.Errorf("invalid BITPOS message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_BITFIELD:
+		// Parse BITFIELD: [keylen:4][key][numops:4][subcommands...]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid BITFIELD message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_BITOP:
+		// Parse BITOP: [op:1][dstlen:4][dst][numkeys:4][key1len:4][key1]...
+		if remaining < 9 {
+			return nil, endOffset, fmt.Errorf("invalid BITOP message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_XADD:
+		// Parse XADD: [keylen:4][key][flags:1][threshold][id][numargs:4][fields and values...]
+		if remaining < 13 {
+			return nil, endOffset, fmt.Errorf("invalid XADD message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_XRANGE, CMD_XREVRANGE:
+		// Parse XRANGE: [keylen:4][key][startlen:4][start][endlen:4][end][count:4]
+		if remaining < 16 {
+			return nil, endOffset, fmt.Errorf("invalid XRANGE message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_XDEL, CMD_XTRIM:
+		// Parse XDEL and XTRIM: [keylen:4][key][ids or trim arguments...]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid stream operation in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_XACK, CMD_XPENDING:
+		// Parse XACK and XPENDING: [keylen:4][key][grouplen:4][group][ids...]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid consumer group operation in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_XAUTOCLAIM:
+		// Parse XAUTOCLAIM: [keylen:4][key][group][consumer][minidle:8][startid][count:4]
+		if remaining < 28 {
+			return nil, endOffset, fmt.Errorf("invalid XAUTOCLAIM message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_XINFO:
+		// Parse XINFO: [subcommand:1][keylen:4][key][grouplen:4][group]
+		if remaining < 5 {
+			return nil, endOffset, fmt.Errorf("invalid XINFO message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_XGROUP:
+		// Parse XGROUP: [subcommand:1][keylen:4][key][grouplen:4][group][arguments...]
+		if remaining < 9 {
+			return nil, endOffset, fmt.Errorf("invalid XGROUP message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_XREADGROUP:
+		// Parse XREADGROUP: [group][consumer][count:4][flags:1][numkeys:4][keys...][numids:4][ids...]
+		if remaining < 21 {
+			return nil, endOffset, fmt.Errorf("invalid XREADGROUP message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_XREAD:
+		// Parse XREAD: [count:4][numkeys:4][keys...][numids:4][ids...]
+		if remaining < 12 {
+			return nil, endOffset, fmt.Errorf("invalid XREAD message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
 	case CMD_SCAN:
 		// Parse SCAN: [cursor:4][patternlen:4][pattern]
 		if remaining < 8 {
@@ -314,6 +559,149 @@ func (s *GoFastServer) parsePipelineMessage(data []byte, offset int) (*Message,
 		msg.Value = make([]byte, fieldLen)
 		copy(msg.Value, data[offset:offset+int(fieldLen)])
 
+	case CMD_HSETNX:
+		// Parse HSETNX: [keylen:4][key][fieldlen:4][field][valuelen:4][value]
+		if remaining < 12 {
+			return nil, endOffset, fmt.Errorf("invalid HSETNX message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_HRANDFIELD:
+		// Parse HRANDFIELD: [keylen:4][key][count:4][flags:1]
+		if remaining < 9 {
+			return nil, endOffset, fmt.Errorf("invalid HRANDFIELD message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_HINCRBY, CMD_HINCRBYFLOAT:
+		// Parse hash increments: [keylen:4][key][fieldlen:4][field][delta:8]
+		if remaining < 16 {
+			return nil, endOffset, fmt.Errorf("invalid hash increment in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZADD:
+		// Parse ZADD: [keylen:4][key][flags:1][numentries:4][score:8][memberlen:4][member]...
+		if remaining < 21 {
+			return nil, endOffset, fmt.Errorf("invalid ZADD message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZINCRBY:
+		// Parse ZINCRBY: [keylen:4][key][delta:8][memberlen:4][member]
+		if remaining < 16 {
+			return nil, endOffset, fmt.Errorf("invalid ZINCRBY message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZREM, CMD_ZMSCORE:
+		// Parse ZREM and ZMSCORE: [keylen:4][key][count:4][member1len:4][member1]...
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid ZREM message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZDIFF:
+		// Parse ZDIFF: [numkeys:4][key1len:4][key1]...[flags:1]
+		if remaining < 4 {
+			return nil, endOffset, fmt.Errorf("invalid ZDIFF message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_ZUNIONSTORE, CMD_ZINTERSTORE, CMD_ZDIFFSTORE:
+		// Parse sorted set stores: [dstlen:4][dst][numkeys:4][keys...][flags:1][weights][aggregate:1]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid sorted set store in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZREMRANGEBYSCORE, CMD_ZREMRANGEBYRANK:
+		// Parse sorted set range removals: [keylen:4][key][min:8][max:8]
+		if remaining < 20 {
+			return nil, endOffset, fmt.Errorf("invalid sorted set range removal in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZCOUNT, CMD_ZLEXCOUNT:
+		// Parse ZCOUNT: [keylen:4][key][minlen:4][min][maxlen:4][max]
+		if remaining < 12 {
+			return nil, endOffset, fmt.Errorf("invalid ZCOUNT message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZPOPMIN, CMD_ZPOPMAX:
+		// Parse sorted set pops: [keylen:4][key] or [keylen:4][key][count:4]
+		if remaining < 4 {
+			return nil, endOffset, fmt.Errorf("invalid sorted set pop in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZSCORE:
+		// Parse ZSCORE: [keylen:4][key][memberlen:4][member]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid ZSCORE message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZRANK, CMD_ZREVRANK:
+		// Parse ZRANK: [keylen:4][key][memberlen:4][member][flags:1]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid ZRANK message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZRANGE, CMD_ZRANGEBYSCORE, CMD_ZREVRANGEBYSCORE, CMD_ZRANGEBYLEX:
+		// Parse ZRANGE: [keylen:4][key][flags:1][start][stop][limitoffset:4][limitcount:4]
+		if remaining < 13 {
+			return nil, endOffset, fmt.Errorf("invalid ZRANGE message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_ZRANGESTORE:
+		// Parse ZRANGESTORE: [dstlen:4][dst][srclen:4][src][ZRANGE flags and args]
+		if remaining < 17 {
+			return nil, endOffset, fmt.Errorf("invalid ZRANGESTORE message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_HMSET, CMD_HMGET, CMD_HGETDEL:
+		// Parse hash multi-field operations: [keylen:4][key][numfields:4][fields...]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid hash multi-field operation in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
 	case CMD_LINDEX:
 		// Parse LINDEX: [keylen:4][key][index:4]
 		if remaining < 8 {
@@ -345,7 +733,16 @@ func (s *GoFastServer) parsePipelineMessage(data []byte, offset int) (*Message,
 		endBytes := data[offset : offset+4]
 		copy(msg.Value, endBytes)
 
-	case CMD_LPOP, CMD_RPOP, CMD_SREM, CMD_SISMEMBER:
+	case CMD_LPOP, CMD_RPOP:
+		// Parse list pop: [keylen:4][key] or [keylen:4][key][flags:1][count:4]
+		if remaining < 4 {
+			return nil, endOffset, fmt.Errorf("invalid list pop in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_SISMEMBER:
 		// These were already handled in the original code, but let's be explicit
 		keyLen := binary.BigEndian.Uint32(data[offset : offset+4])
 		offset += 4
@@ -353,9 +750,9 @@ func (s *GoFastServer) parsePipelineMessage(data []byte, offset int) (*Message,
 		copy(msg.Key, data[offset:offset+int(keyLen)])
 		offset += int(keyLen)
 
-		// For SREM and SISMEMBER, read value if present
+		// For SISMEMBER, read value if present
 		remainingAfterKey := remaining - 4 - int(keyLen)
-		if remainingAfterKey > 0 && (msg.Command == CMD_SREM || msg.Command == CMD_SISMEMBER) {
+		if remainingAfterKey > 0 {
 			valueLenBytes := data[offset : offset+4]
 			valueLen := binary.BigEndian.Uint32(valueLenBytes)
 			offset += 4
@@ -363,7 +760,7 @@ func (s *GoFastServer) parsePipelineMessage(data []byte, offset int) (*Message,
 			copy(msg.Value, data[offset:offset+int(valueLen)])
 		}
 
-	case CMD_GET, CMD_DEL, CMD_EXISTS, CMD_TTL, CMD_LLEN, CMD_SMEMBERS, CMD_SCARD, CMD_HGETALL, CMD_HLEN, CMD_INCR, CMD_DECR, CMD_KEYS:
+	case CMD_GET, CMD_DEL, CMD_EXISTS, CMD_TTL, CMD_LLEN, CMD_SMEMBERS, CMD_SCARD, CMD_HGETALL, CMD_HLEN, CMD_HKEYS, CMD_HVALS, CMD_ZCARD, CMD_XLEN, CMD_INCR, CMD_DECR, CMD_KEYS, CMD_EXPIRETIME, CMD_PEXPIRETIME:
 		// Parse simple key-only commands: [keylen:4][key]
 		if remaining < 4 {
 			return nil, endOffset, fmt.Errorf("invalid key-only message in pipeline")
@@ -373,6 +770,141 @@ func (s *GoFastServer) parsePipelineMessage(data []byte, offset int) (*Message,
 		msg.Key = make([]byte, keyLen)
 		copy(msg.Key, data[offset:offset+int(keyLen)])
 
+	case CMD_EXPIREAT, CMD_PEXPIREAT:
+		// Parse EXPIREAT: [keylen:4][key][at:8]
+		if remaining < 12 {
+			return nil, endOffset, fmt.Errorf("invalid EXPIREAT message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_LSET, CMD_LINSERT, CMD_LTRIM, CMD_LPOS, CMD_LMOVE, CMD_LREM:
+		// Parse list operations with arguments: [keylen:4][key][arguments...]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid list operation in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_SUNIONSTORE, CMD_SINTERSTORE, CMD_SDIFFSTORE:
+		// Parse set algebra store: [dstlen:4][dst][numkeys:4][key list]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid set algebra store in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_SMOVE:
+		// Parse SMOVE: [srclen:4][src][dstlen:4][dst][memberlen:4][member]
+		if remaining < 12 {
+			return nil, endOffset, fmt.Errorf("invalid SMOVE message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_SSCAN, CMD_HSCAN, CMD_ZSCAN:
+		// Parse key scans: [keylen:4][key][cursor:4][patternlen:4][pattern][count:4]?
+		if remaining < 12 {
+			return nil, endOffset, fmt.Errorf("invalid key scan message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_SPOP, CMD_SRANDMEMBER:
+		// Parse set sampling: [keylen:4][key][count:4]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid set sampling in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_BLPOP, CMD_BRPOP, CMD_BZPOPMIN, CMD_BZPOPMAX:
+		// Parse blocking pop: [numkeys:4][key1len:4][key1]...[timeout:4]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid blocking pop in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_SUNION, CMD_SINTER, CMD_SDIFF:
+		// Parse set algebra: [numkeys:4][key1len:4][key1]...[keyNlen:4][keyN]
+		if remaining < 4 {
+			return nil, endOffset, fmt.Errorf("invalid set algebra in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_SINTERCARD:
+		// Parse SINTERCARD: [numkeys:4][key1len:4][key1]...[limit:4]
+		if remaining < 8 {
+			return nil, endOffset, fmt.Errorf("invalid SINTERCARD message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_LMPOP:
+		// Parse LMPOP: [numkeys:4][key1len:4][key1]...[direction:1][count:4]
+		if remaining < 9 {
+			return nil, endOffset, fmt.Errorf("invalid LMPOP message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_BZMPOP:
+		// Parse BZMPOP: [timeout:4][numkeys:4][key1len:4][key1]...[direction:1][count:4]
+		if remaining < 13 {
+			return nil, endOffset, fmt.Errorf("invalid BZMPOP message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_SELECT:
+		// Parse SELECT: [dbindex:4]
+		if remaining != 4 {
+			return nil, endOffset, fmt.Errorf("invalid SELECT message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_OBJECT:
+		// Parse OBJECT: [subcommand:1][keylen:4][key]
+		if remaining < 5 {
+			return nil, endOffset, fmt.Errorf("invalid OBJECT message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_PUBLISH:
+		// Parse PUBLISH: [channellen:4][channel][message]
+		if remaining < 4 {
+			return nil, endOffset, fmt.Errorf("invalid PUBLISH message in pipeline")
+		}
+		if err := parseKeyPayload(data, offset, endOffset, msg); err != nil {
+			return nil, endOffset, err
+		}
+
+	case CMD_COMMANDSTATS:
+		// Parse COMMANDSTATS: no payload
+
+	case CMD_INFO:
+		// Parse INFO: [section], empty for every section
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
+	case CMD_SUBSCRIBE, CMD_UNSUBSCRIBE, CMD_PSUBSCRIBE, CMD_PUNSUBSCRIBE, CMD_PUBSUB, CMD_SLOWLOG, CMD_LATENCY, CMD_CONFIG:
+		// Parse subscriptions: [numchannels:4][channels...], PUBSUB, SLOWLOG, LATENCY and CONFIG: [subcommand:1][arguments...]
+		if remaining < 1 {
+			return nil, endOffset, fmt.Errorf("invalid pub/sub message in pipeline")
+		}
+		msg.Value = make([]byte, remaining)
+		copy(msg.Value, data[offset:endOffset])
+
 	default:
 		return nil, endOffset, fmt.Errorf("unsupported command in pipeline: %d", command)
 	}
@@ -380,17 +912,62 @@ func (s *GoFastServer) parsePipelineMessage(data []byte, offset int) (*Message,
 	return msg, endOffset, nil
 }
 
+// parseKeyPayload is the pipeline counterpart of readKeyPayload: it copies
+// [keylen:4][key] into msg.Key and the rest of the message into msg.Value
+func parseKeyPayload(data []byte, offset, endOffset int, msg *Message) error {
+	keyLen := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+	offset += 4
+	if offset+keyLen > endOffset {
+		return fmt.Errorf("key length exceeds message length")
+	}
+
+	msg.Key = make([]byte, keyLen)
+	copy(msg.Key, data[offset:offset+keyLen])
+	offset += keyLen
+
+	msg.Value = make([]byte, endOffset-offset)
+	copy(msg.Value, data[offset:endOffset])
+	return nil
+}
+
+// parseValueList decodes [numvalues:4][val1len:4][val1]... and falls back to
+// the single-value [valuelen:4][value] form when the payload doesn't parse
+// as a complete multi-value list
+func parseValueList(data []byte) ([][]byte, error) {
+	args := newArgReader(data)
+	count := args.uint32()
+	if args.err == nil && int(count) <= len(data)/4 {
+		values := make([][]byte, 0, count)
+		for range count {
+			values = append(values, args.bytes())
+		}
+		if args.err == nil && args.offset == len(data) && len(values) > 0 {
+			return values, nil
+		}
+	}
+
+	// Single-value form
+	args = newArgReader(data)
+	value := args.bytes()
+	if args.err != nil || args.offset != len(data) {
+		return nil, fmt.Errorf("invalid value list")
+	}
+	return [][]byte{value}, nil
+}
+
 // List operation handlers
-func (s *GoFastServer) handleListPush(key string, value []byte, isLeft bool, now int64) []byte {
+func (s *DatabaseState) handleListPush(key string, data []byte, isLeft bool, now int64) []byte {
+	values, err := parseValueList(data)
+	if err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid list push data"))
+	}
+
 	var list *List
 
 	if existing, exists := s.storage.Load(key); exists {
 		item := existing.(*CacheItem)
 		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
+			s.expireKey(key)
 		} else if item.DataType != TYPE_LIST {
 			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
 		} else {
@@ -409,16 +986,22 @@ func (s *GoFastServer) handleListPush(key string, value []byte, isLeft bool, now
 	}
 
 	var length int
-	if isLeft {
-		length = list.LeftPush(value)
-	} else {
-		length = list.RightPush(value)
+	for _, value := range values {
+		if isLeft {
+			length = list.LeftPush(value)
+		} else {
+			length = list.RightPush(value)
+		}
 	}
 
+	s.serveListBlockers(key, list)
+
 	return s.createResponse(RESP_OK, []byte(fmt.Sprintf("%d", length)))
 }
 
-func (s *GoFastServer) handleListPop(key string, isLeft bool, now int64) []byte {
+// handleListPop pops one element, or with a count (-1 when none was sent)
+// an array of up to count elements, even when only one is available
+func (s *DatabaseState) handleListPop(key string, isLeft bool, count int, now int64) []byte {
 	existing, exists := s.storage.Load(key)
 	if !exists {
 		return s.createResponse(RESP_NOT_FOUND, nil)
@@ -426,10 +1009,7 @@ func (s *GoFastServer) handleListPop(key string, isLeft bool, now int64) []byte
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
+		s.expireKey(key)
 		return s.createResponse(RESP_NOT_FOUND, nil)
 	}
 
@@ -438,6 +1018,34 @@ func (s *GoFastServer) handleListPop(key string, isLeft bool, now int64) []byte
 	}
 
 	list := item.Value.(*List)
+
+	// Bulk pop returns whatever is available, up to count elements
+	if count >= 0 {
+		values := make([][]byte, 0, min(count, list.Length()))
+		for len(values) < count {
+			var value []byte
+			var ok bool
+			if isLeft {
+				value, ok = list.LeftPop()
+			} else {
+				value, ok = list.RightPop()
+			}
+			if !ok {
+				break
+			}
+			values = append(values, value)
+		}
+
+		if list.Length() == 0 {
+			s.storage.Delete(key)
+			s.ttlMutex.Lock()
+			delete(s.ttlIndex, key)
+			s.ttlMutex.Unlock()
+		}
+
+		return s.createResponse(RESP_OK, s.encodeArray(values))
+	}
+
 	var value []byte
 	var ok bool
 
@@ -462,7 +1070,7 @@ func (s *GoFastServer) handleListPop(key string, isLeft bool, now int64) []byte
 	return s.createResponse(RESP_OK, value)
 }
 
-func (s *GoFastServer) handleListLen(key string, now int64) []byte {
+func (s *DatabaseState) handleListLen(key string, now int64) []byte {
 	existing, exists := s.storage.Load(key)
 	if !exists {
 		return s.createResponse(RESP_OK, []byte("0"))
@@ -470,10 +1078,7 @@ func (s *GoFastServer) handleListLen(key string, now int64) []byte {
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
+		s.expireKey(key)
 		return s.createResponse(RESP_OK, []byte("0"))
 	}
 
@@ -485,7 +1090,7 @@ func (s *GoFastServer) handleListLen(key string, now int64) []byte {
 	return s.createResponse(RESP_OK, []byte(fmt.Sprintf("%d", list.Length())))
 }
 
-func (s *GoFastServer) handleListIndex(key string, index int, now int64) []byte {
+func (s *DatabaseState) handleListIndex(key string, index int, now int64) []byte {
 	existing, exists := s.storage.Load(key)
 	if !exists {
 		return s.createResponse(RESP_NOT_FOUND, nil)
@@ -493,10 +1098,7 @@ func (s *GoFastServer) handleListIndex(key string, index int, now int64) []byte
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
+		s.expireKey(key)
 		return s.createResponse(RESP_NOT_FOUND, nil)
 	}
 
@@ -513,7 +1115,7 @@ func (s *GoFastServer) handleListIndex(key string, index int, now int64) []byte
 	return s.createResponse(RESP_OK, value)
 }
 
-func (s *GoFastServer) handleListRange(key string, start, end int, now int64) []byte {
+func (s *DatabaseState) handleListRange(key string, start, end int, now int64) []byte {
 	existing, exists := s.storage.Load(key)
 	if !exists {
 		return s.createResponse(RESP_OK, s.encodeArray([][]byte{}))
@@ -521,10 +1123,7 @@ func (s *GoFastServer) handleListRange(key string, start, end int, now int64) []
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
+		s.expireKey(key)
 		return s.createResponse(RESP_OK, s.encodeArray([][]byte{}))
 	}
 
@@ -538,42 +1137,31 @@ func (s *GoFastServer) handleListRange(key string, start, end int, now int64) []
 	return s.createResponse(RESP_OK, s.encodeArray(values))
 }
 
-// Set operation handlers
-func (s *GoFastServer) handleSetAdd(key string, member string, now int64) []byte {
-	var set *Set
-
-	if existing, exists := s.storage.Load(key); exists {
-		item := existing.(*CacheItem)
-		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
-		} else if item.DataType != TYPE_SET {
-			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
-		} else {
-			set = item.Value.(*Set)
-		}
+func (s *DatabaseState) handleListSet(key string, index int, value []byte, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_ERROR, []byte("ERR no such key"))
 	}
 
-	if set == nil {
-		set = NewSet()
-		item := &CacheItem{
-			DataType:  TYPE_SET,
-			Value:     set,
-			CreatedAt: now,
-		}
-		s.storage.Store(key, item)
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_ERROR, []byte("ERR no such key"))
 	}
 
-	wasNew := set.Add(member)
-	if wasNew {
-		return s.createResponse(RESP_OK, []byte("1"))
+	if item.DataType != TYPE_LIST {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
 	}
-	return s.createResponse(RESP_OK, []byte("0"))
+
+	list := item.Value.(*List)
+	if !list.Set(index, value) {
+		return s.createResponse(RESP_ERROR, []byte("ERR index out of range"))
+	}
+
+	return s.createResponse(RESP_OK, []byte("OK"))
 }
 
-func (s *GoFastServer) handleSetRem(key string, member string, now int64) []byte {
+func (s *DatabaseState) handleListInsert(key string, pivot, value []byte, after bool, now int64) []byte {
 	existing, exists := s.storage.Load(key)
 	if !exists {
 		return s.createResponse(RESP_OK, []byte("0"))
@@ -581,83 +1169,157 @@ func (s *GoFastServer) handleSetRem(key string, member string, now int64) []byte
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
+		s.expireKey(key)
 		return s.createResponse(RESP_OK, []byte("0"))
 	}
 
-	if item.DataType != TYPE_SET {
+	if item.DataType != TYPE_LIST {
 		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
 	}
 
-	set := item.Value.(*Set)
-	removed := set.Remove(member)
+	list := item.Value.(*List)
+	length := list.Insert(pivot, value, after)
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(length)))
+}
 
-	// If set is now empty, remove the key
-	if set.Card() == 0 {
+func (s *DatabaseState) handleListTrim(key string, start, end int, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, []byte("OK"))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, []byte("OK"))
+	}
+
+	if item.DataType != TYPE_LIST {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	list := item.Value.(*List)
+
+	// If everything was trimmed away, remove the key
+	if list.Trim(start, end) == 0 {
 		s.storage.Delete(key)
 		s.ttlMutex.Lock()
 		delete(s.ttlIndex, key)
 		s.ttlMutex.Unlock()
 	}
 
-	if removed {
-		return s.createResponse(RESP_OK, []byte("1"))
-	}
-	return s.createResponse(RESP_OK, []byte("0"))
+	return s.createResponse(RESP_OK, []byte("OK"))
 }
 
-func (s *GoFastServer) handleSetMembers(key string, now int64) []byte {
+func (s *DatabaseState) handleListPos(key string, element []byte, rank, count, maxLen int, now int64) []byte {
 	existing, exists := s.storage.Load(key)
 	if !exists {
-		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+		return s.createResponse(RESP_NOT_FOUND, nil)
 	}
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
-		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+		s.expireKey(key)
+		return s.createResponse(RESP_NOT_FOUND, nil)
 	}
 
-	if item.DataType != TYPE_SET {
+	if item.DataType != TYPE_LIST {
 		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
 	}
 
-	set := item.Value.(*Set)
-	members := set.Members()
+	// A count of 0 behaves like 1 and returns a single position
+	single := count <= 1
+	if single {
+		count = 1
+	}
 
-	return s.createResponse(RESP_OK, s.encodeStringArray(members))
+	list := item.Value.(*List)
+	positions := list.Positions(element, rank, count, maxLen)
+	if len(positions) == 0 {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	if single {
+		return s.createResponse(RESP_OK, []byte(strconv.Itoa(positions[0])))
+	}
+
+	values := make([]string, len(positions))
+	for i, pos := range positions {
+		values[i] = strconv.Itoa(pos)
+	}
+	return s.createResponse(RESP_OK, s.encodeStringArray(values))
 }
 
-func (s *GoFastServer) handleSetCard(key string, now int64) []byte {
-	existing, exists := s.storage.Load(key)
+func (s *DatabaseState) handleListMove(src, dst string, srcLeft, dstLeft bool, now int64) []byte {
+	existing, exists := s.storage.Load(src)
 	if !exists {
-		return s.createResponse(RESP_OK, []byte("0"))
+		return s.createResponse(RESP_NOT_FOUND, nil)
 	}
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
+		s.expireKey(src)
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	if item.DataType != TYPE_LIST {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+	srcList := item.Value.(*List)
+
+	// Resolve the destination before popping so a type error leaves src intact
+	var dstList *List
+	if existing, exists := s.storage.Load(dst); exists {
+		dstItem := existing.(*CacheItem)
+		if dstItem.ExpiresAt > 0 && dstItem.ExpiresAt <= now {
+			s.expireKey(dst)
+		} else if dstItem.DataType != TYPE_LIST {
+			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		} else {
+			dstList = dstItem.Value.(*List)
+		}
+	}
+
+	var value []byte
+	var ok bool
+	if srcLeft {
+		value, ok = srcList.LeftPop()
+	} else {
+		value, ok = srcList.RightPop()
+	}
+	if !ok {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	// If source list is now empty, remove the key
+	if srcList.Length() == 0 && src != dst {
+		s.storage.Delete(src)
 		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
+		delete(s.ttlIndex, src)
 		s.ttlMutex.Unlock()
-		return s.createResponse(RESP_OK, []byte("0"))
 	}
 
-	if item.DataType != TYPE_SET {
-		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	if dstList == nil {
+		dstList = NewList()
+		s.storage.Store(dst, &CacheItem{
+			DataType:  TYPE_LIST,
+			Value:     dstList,
+			CreatedAt: now,
+		})
 	}
 
-	set := item.Value.(*Set)
-	return s.createResponse(RESP_OK, []byte(fmt.Sprintf("%d", set.Card())))
+	if dstLeft {
+		dstList.LeftPush(value)
+	} else {
+		dstList.RightPush(value)
+	}
+
+	s.serveListBlockers(dst, dstList)
+
+	return s.createResponse(RESP_OK, value)
 }
 
-func (s *GoFastServer) handleSetIsMember(key string, member string, now int64) []byte {
+func (s *DatabaseState) handleListRem(key string, count int, element []byte, now int64) []byte {
 	existing, exists := s.storage.Load(key)
 	if !exists {
 		return s.createResponse(RESP_OK, []byte("0"))
@@ -665,162 +1327,3022 @@ func (s *GoFastServer) handleSetIsMember(key string, member string, now int64) [
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
+		s.expireKey(key)
 		return s.createResponse(RESP_OK, []byte("0"))
 	}
 
-	if item.DataType != TYPE_SET {
+	if item.DataType != TYPE_LIST {
 		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
 	}
 
-	set := item.Value.(*Set)
-	if set.IsMember(member) {
-		return s.createResponse(RESP_OK, []byte("1"))
+	list := item.Value.(*List)
+	removed := list.Remove(count, element)
+
+	// If list is now empty, remove the key
+	if list.Length() == 0 {
+		s.storage.Delete(key)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, key)
+		s.ttlMutex.Unlock()
 	}
-	return s.createResponse(RESP_OK, []byte("0"))
+
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(removed)))
 }
 
-// Hash operation handlers
-func (s *GoFastServer) handleHashSet(key string, data []byte, now int64) []byte {
-	// Parse field and value from data: [fieldlen:4][field][value]
-	if len(data) < 4 {
-		return s.createResponse(RESP_ERROR, []byte("Invalid HSET data"))
+func (s *DatabaseState) handleListMPop(keys []string, isLeft bool, count int, now int64) []byte {
+	if count < 1 {
+		count = 1
 	}
 
-	fieldLen := binary.BigEndian.Uint32(data[0:4])
-	if len(data) < int(4+fieldLen) {
-		return s.createResponse(RESP_ERROR, []byte("Invalid HSET data"))
+	for _, key := range keys {
+		existing, exists := s.storage.Load(key)
+		if !exists {
+			continue
+		}
+
+		item := existing.(*CacheItem)
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+			continue
+		}
+
+		if item.DataType != TYPE_LIST {
+			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		}
+
+		list := item.Value.(*List)
+		values := make([][]byte, 0, min(count, list.Length()))
+		for len(values) < count {
+			var value []byte
+			var ok bool
+			if isLeft {
+				value, ok = list.LeftPop()
+			} else {
+				value, ok = list.RightPop()
+			}
+			if !ok {
+				break
+			}
+			values = append(values, value)
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		if list.Length() == 0 {
+			s.storage.Delete(key)
+			s.ttlMutex.Lock()
+			delete(s.ttlIndex, key)
+			s.ttlMutex.Unlock()
+		}
+
+		return s.createResponse(RESP_OK, s.encodeKeyedArray(key, values))
 	}
 
-	field := string(data[4 : 4+fieldLen])
-	value := data[4+fieldLen:]
+	return s.createResponse(RESP_NOT_FOUND, nil)
+}
 
-	var hash *Hash
+func (s *DatabaseState) handleBlockingPop(keys []string, timeout time.Duration, isLeft bool, gone <-chan struct{}, now int64) []byte {
+	// Serve immediately from the first non-empty list
+	for _, key := range keys {
+		existing, exists := s.storage.Load(key)
+		if !exists {
+			continue
+		}
 
-	if existing, exists := s.storage.Load(key); exists {
 		item := existing.(*CacheItem)
 		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+			continue
+		}
+
+		if item.DataType != TYPE_LIST {
+			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		}
+
+		list := item.Value.(*List)
+		var value []byte
+		var ok bool
+		if isLeft {
+			value, ok = list.LeftPop()
+		} else {
+			value, ok = list.RightPop()
+		}
+		if !ok {
+			continue
+		}
+		s.logServedPop(listPopCommand(isLeft), key, 0)
+
+		if list.Length() == 0 {
 			s.storage.Delete(key)
 			s.ttlMutex.Lock()
 			delete(s.ttlIndex, key)
 			s.ttlMutex.Unlock()
-		} else if item.DataType != TYPE_HASH {
+		}
+
+		return s.createResponse(RESP_OK, s.encodeArray([][]byte{[]byte(key), value}))
+	}
+
+	if mustNotWait(gone) {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	// All lists are empty, wait for a push
+	client := &blockedClient{
+		keys:     keys,
+		fromHead: isLeft,
+		result:   make(chan [][]byte, 1),
+	}
+	s.blockOn(&s.listBlockers, client)
+
+	// Re-check in case a push landed before we were registered
+	for _, key := range keys {
+		existing, exists := s.storage.Load(key)
+		if !exists {
+			continue
+		}
+		item := existing.(*CacheItem)
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+			continue
+		}
+		if item.DataType == TYPE_LIST {
+			s.serveListBlockers(key, item.Value.(*List))
+		}
+	}
+
+	reply, ok := s.waitBlocked(&s.listBlockers, client, timeout, gone)
+	if !ok {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+	return s.createResponse(RESP_OK, s.encodeArray(reply))
+}
+
+// Set operation handlers
+func (s *DatabaseState) handleSetAdd(key string, data []byte, now int64) []byte {
+	members, err := parseValueList(data)
+	if err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid SADD data"))
+	}
+
+	var set *Set
+
+	if existing, exists := s.storage.Load(key); exists {
+		item := existing.(*CacheItem)
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+		} else if item.DataType != TYPE_SET {
 			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
 		} else {
-			hash = item.Value.(*Hash)
+			set = item.Value.(*Set)
 		}
 	}
 
-	if hash == nil {
-		hash = NewHash()
+	if set == nil {
+		set = NewSet()
 		item := &CacheItem{
-			DataType:  TYPE_HASH,
-			Value:     hash,
+			DataType:  TYPE_SET,
+			Value:     set,
 			CreatedAt: now,
 		}
 		s.storage.Store(key, item)
 	}
 
-	wasNew := hash.Set(field, value)
-	if wasNew {
-		return s.createResponse(RESP_OK, []byte("1"))
+	added := 0
+	for _, member := range members {
+		if set.Add(string(member)) {
+			added++
+		}
 	}
-	return s.createResponse(RESP_OK, []byte("0"))
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(added)))
 }
 
-func (s *GoFastServer) handleHashGet(key string, field string, now int64) []byte {
+func (s *DatabaseState) handleSetRem(key string, data []byte, now int64) []byte {
+	members, err := parseValueList(data)
+	if err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid SREM data"))
+	}
+
 	existing, exists := s.storage.Load(key)
 	if !exists {
-		return s.createResponse(RESP_NOT_FOUND, nil)
+		return s.createResponse(RESP_OK, []byte("0"))
 	}
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
-		return s.createResponse(RESP_NOT_FOUND, nil)
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, []byte("0"))
 	}
 
-	if item.DataType != TYPE_HASH {
+	if item.DataType != TYPE_SET {
 		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
 	}
 
-	hash := item.Value.(*Hash)
-	value, exists := hash.Get(field)
-	if !exists {
-		return s.createResponse(RESP_NOT_FOUND, nil)
+	set := item.Value.(*Set)
+	removed := 0
+	for _, member := range members {
+		if set.Remove(string(member)) {
+			removed++
+		}
 	}
 
-	return s.createResponse(RESP_OK, value)
+	// If set is now empty, remove the key
+	if set.Card() == 0 {
+		s.storage.Delete(key)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, key)
+		s.ttlMutex.Unlock()
+	}
+
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(removed)))
+}
+
+func (s *DatabaseState) handleSetMembers(key string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	if item.DataType != TYPE_SET {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	set := item.Value.(*Set)
+	members := set.Members()
+
+	return s.createResponse(RESP_OK, s.encodeStringArray(members))
+}
+
+// handleSetPop removes and returns up to count random members of a set
+func (s *DatabaseState) handleSetPop(key string, count int, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	if item.DataType != TYPE_SET {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	if count <= 0 {
+		count = 1
+	}
+
+	set := item.Value.(*Set)
+	members := set.Members()
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if count < len(members) {
+		members = members[:count]
+	}
+
+	popped := make([]string, 0, len(members))
+	for _, member := range members {
+		// Skip members removed concurrently since the snapshot was taken
+		if set.Remove(member) {
+			popped = append(popped, member)
+		}
+	}
+
+	// If set is now empty, remove the key
+	if set.Card() == 0 {
+		s.storage.Delete(key)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, key)
+		s.ttlMutex.Unlock()
+	}
+
+	return s.createResponse(RESP_OK, s.encodeStringArray(popped))
+}
+
+// maxRandCount bounds the repeating negative counts of SRANDMEMBER and
+// HRANDFIELD, which unlike positive counts are not limited by the size of
+// the collection
+const maxRandCount = 1 << 20
+
+// handleSetRandMember returns random members without removing them. A
+// positive count returns distinct members, a negative count allows repeats.
+func (s *DatabaseState) handleSetRandMember(key string, count int, now int64) []byte {
+	if count < -maxRandCount {
+		return s.createResponse(RESP_ERROR, []byte("ERR value is out of range"))
+	}
+
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	if item.DataType != TYPE_SET {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	members := item.Value.(*Set).Members()
+	if count == 0 || len(members) == 0 {
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	if count < 0 {
+		result := make([]string, -count)
+		for i := range result {
+			result[i] = members[rand.Intn(len(members))]
+		}
+		return s.createResponse(RESP_OK, s.encodeStringArray(result))
+	}
+
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	if count < len(members) {
+		members = members[:count]
+	}
+	return s.createResponse(RESP_OK, s.encodeStringArray(members))
+}
+
+func (s *DatabaseState) handleSetCard(key string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	if item.DataType != TYPE_SET {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	set := item.Value.(*Set)
+	return s.createResponse(RESP_OK, []byte(fmt.Sprintf("%d", set.Card())))
+}
+
+func (s *DatabaseState) handleSetIsMember(key string, member string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	if item.DataType != TYPE_SET {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	set := item.Value.(*Set)
+	if set.IsMember(member) {
+		return s.createResponse(RESP_OK, []byte("1"))
+	}
+	return s.createResponse(RESP_OK, []byte("0"))
+}
+
+// handleSetMove moves member from the set at src to the set at dst,
+// creating dst if needed
+func (s *DatabaseState) handleSetMove(src, dst, member string, now int64) []byte {
+	sets, ok := s.loadSets([]string{src, dst}, now)
+	if !ok {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	srcSet, dstSet := sets[0], sets[1]
+	if srcSet == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	// A missing destination is only stored once the member has moved into
+	// it, so a failed move leaves no empty set behind
+	created := dstSet == nil
+	if created {
+		dstSet = NewSet()
+	}
+
+	moved, emptied := srcSet.MoveTo(dstSet, member)
+	if !moved {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	if created {
+		s.storage.Store(dst, &CacheItem{
+			DataType:  TYPE_SET,
+			Value:     dstSet,
+			CreatedAt: now,
+		})
+	}
+
+	// If source set is now empty, remove the key
+	if emptied {
+		s.storage.Delete(src)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, src)
+		s.ttlMutex.Unlock()
+	}
+
+	return s.createResponse(RESP_OK, []byte("1"))
+}
+
+// loadSets returns the live sets stored at keys, using nil for missing or
+// expired keys. ok is false if any key holds a value of another type.
+func (s *DatabaseState) loadSets(keys []string, now int64) (sets []*Set, ok bool) {
+	sets = make([]*Set, len(keys))
+	for i, key := range keys {
+		existing, exists := s.storage.Load(key)
+		if !exists {
+			continue
+		}
+
+		item := existing.(*CacheItem)
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+			continue
+		}
+
+		if item.DataType != TYPE_SET {
+			return nil, false
+		}
+		sets[i] = item.Value.(*Set)
+	}
+	return sets, true
+}
+
+// setAlgebra computes SUNION, SINTER or SDIFF over the sets stored at keys
+func (s *DatabaseState) setAlgebra(op uint8, keys []string, now int64) ([]string, bool) {
+	sets, ok := s.loadSets(keys, now)
+	if !ok {
+		return nil, false
+	}
+
+	switch op {
+	case CMD_SINTER:
+		return SetInter(sets), true
+	case CMD_SDIFF:
+		return SetDiff(sets), true
+	default:
+		return SetUnion(sets), true
+	}
+}
+
+func (s *DatabaseState) handleSetAlgebra(op uint8, keys []string, now int64) []byte {
+	members, ok := s.setAlgebra(op, keys, now)
+	if !ok {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	return s.createResponse(RESP_OK, s.encodeStringArray(members))
+}
+
+// handleSetInterCard returns the cardinality of the intersection of the sets
+// at keys, capped at limit when limit > 0
+func (s *DatabaseState) handleSetInterCard(keys []string, limit int, now int64) []byte {
+	sets, ok := s.loadSets(keys, now)
+	if !ok {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(SetInterCard(sets, limit))))
+}
+
+// handleSetAlgebraStore computes SUNION, SINTER or SDIFF and stores the
+// result as a new set at dst, replacing any existing value. An empty result
+// deletes dst.
+func (s *DatabaseState) handleSetAlgebraStore(op uint8, dst string, keys []string, now int64) []byte {
+	var base uint8
+	switch op {
+	case CMD_SINTERSTORE:
+		base = CMD_SINTER
+	case CMD_SDIFFSTORE:
+		base = CMD_SDIFF
+	default:
+		base = CMD_SUNION
+	}
+
+	members, ok := s.setAlgebra(base, keys, now)
+	if !ok {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	s.ttlMutex.Lock()
+	delete(s.ttlIndex, dst)
+	s.ttlMutex.Unlock()
+
+	if len(members) == 0 {
+		s.storage.Delete(dst)
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	set := NewSet()
+	for _, member := range members {
+		set.Add(member)
+	}
+
+	s.storage.Store(dst, &CacheItem{
+		DataType:  TYPE_SET,
+		Value:     set,
+		CreatedAt: now,
+	})
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(len(members))))
+}
+
+// Hash operation handlers
+func (s *DatabaseState) handleHashSet(key string, data []byte, now int64) []byte {
+	// Parse field and value from data: [fieldlen:4][field][value]
+	if len(data) < 4 {
+		return s.createResponse(RESP_ERROR, []byte("Invalid HSET data"))
+	}
+
+	fieldLen := binary.BigEndian.Uint32(data[0:4])
+	if len(data) < int(4+fieldLen) {
+		return s.createResponse(RESP_ERROR, []byte("Invalid HSET data"))
+	}
+
+	field := string(data[4 : 4+fieldLen])
+	value := data[4+fieldLen:]
+
+	var hash *Hash
+
+	if existing, exists := s.storage.Load(key); exists {
+		item := existing.(*CacheItem)
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+		} else if item.DataType != TYPE_HASH {
+			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		} else {
+			hash = item.Value.(*Hash)
+		}
+	}
+
+	if hash == nil {
+		hash = NewHash()
+		item := &CacheItem{
+			DataType:  TYPE_HASH,
+			Value:     hash,
+			CreatedAt: now,
+		}
+		s.storage.Store(key, item)
+	}
+
+	wasNew := hash.Set(field, value)
+	if wasNew {
+		return s.createResponse(RESP_OK, []byte("1"))
+	}
+	return s.createResponse(RESP_OK, []byte("0"))
+}
+
+func (s *DatabaseState) handleHashGet(key string, field string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	hash := item.Value.(*Hash)
+	value, exists := hash.Get(field)
+	if !exists {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	return s.createResponse(RESP_OK, value)
+}
+
+func (s *DatabaseState) handleHashDel(key string, field string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	hash := item.Value.(*Hash)
+	removed := hash.Del(field)
+
+	// If hash is now empty, remove the key
+	if hash.Len() == 0 {
+		s.storage.Delete(key)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, key)
+		s.ttlMutex.Unlock()
+	}
+
+	if removed {
+		return s.createResponse(RESP_OK, []byte("1"))
+	}
+	return s.createResponse(RESP_OK, []byte("0"))
+}
+
+func (s *DatabaseState) handleHashGetAll(key string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeHashMap(map[string][]byte{}))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeHashMap(map[string][]byte{}))
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	hash := item.Value.(*Hash)
+	fields := hash.GetAll()
+
+	return s.createResponse(RESP_OK, s.encodeHashMap(fields))
+}
+
+func (s *DatabaseState) handleHashSetNX(key string, field string, value []byte, now int64) []byte {
+	hash, errResp := s.loadOrCreateHash(key, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	if hash.SetNX(field, value) {
+		return s.createResponse(RESP_OK, []byte("1"))
+	}
+	return s.createResponse(RESP_OK, []byte("0"))
+}
+
+func (s *DatabaseState) handleHashGetDel(key string, fields []string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeMGetResponse(make([][]byte, len(fields))))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeMGetResponse(make([][]byte, len(fields))))
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	values, remaining := item.Value.(*Hash).GetDel(fields)
+
+	// If hash is now empty, remove the key
+	if remaining == 0 {
+		s.storage.Delete(key)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, key)
+		s.ttlMutex.Unlock()
+	}
+
+	return s.createResponse(RESP_OK, s.encodeMGetResponse(values))
+}
+
+func (s *DatabaseState) handleHashIncrBy(key string, field string, delta int64, now int64) []byte {
+	hash, errResp := s.loadOrCreateHash(key, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	value, err := hash.Update(field, func(current []byte, exists bool) ([]byte, error) {
+		var n int64
+		if exists {
+			parsed, err := strconv.ParseInt(string(current), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("ERR hash value is not an integer")
+			}
+			n = parsed
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return nil, fmt.Errorf("ERR increment or decrement would overflow")
+		}
+		return []byte(strconv.FormatInt(n+delta, 10)), nil
+	})
+	if err != nil {
+		return s.createResponse(RESP_ERROR, []byte(err.Error()))
+	}
+	return s.createResponse(RESP_OK, value)
+}
+
+// handleHashIncrByFloat adds delta to a hash field. A NaN or infinite delta
+// is refused before the hash is created, as it would fail on a new field
+// and leave an empty hash behind.
+func (s *DatabaseState) handleHashIncrByFloat(key string, field string, delta float64, now int64) []byte {
+	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return s.createResponse(RESP_ERROR, []byte("ERR increment would produce NaN or Infinity"))
+	}
+
+	hash, errResp := s.loadOrCreateHash(key, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	value, err := hash.Update(field, func(current []byte, exists bool) ([]byte, error) {
+		var f float64
+		if exists {
+			parsed, err := strconv.ParseFloat(string(current), 64)
+			if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+				return nil, fmt.Errorf("ERR hash value is not a float")
+			}
+			f = parsed
+		}
+		result := f + delta
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return nil, fmt.Errorf("ERR increment would produce NaN or Infinity")
+		}
+		return []byte(strconv.FormatFloat(result, 'f', -1, 64)), nil
+	})
+	if err != nil {
+		return s.createResponse(RESP_ERROR, []byte(err.Error()))
+	}
+	return s.createResponse(RESP_OK, value)
+}
+
+// handleHashRandField returns random fields without removing them. A
+// positive count returns distinct fields, a negative count allows repeats.
+func (s *DatabaseState) handleHashRandField(key string, count int, withValues bool, now int64) []byte {
+	if count < -maxRandCount {
+		return s.createResponse(RESP_ERROR, []byte("ERR value is out of range"))
+	}
+
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	snapshot := item.Value.(*Hash).GetAll()
+	fields := make([]string, 0, len(snapshot))
+	for field := range snapshot {
+		fields = append(fields, field)
+	}
+	if count == 0 || len(fields) == 0 {
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	var picked []string
+	if count < 0 {
+		picked = make([]string, -count)
+		for i := range picked {
+			picked[i] = fields[rand.Intn(len(fields))]
+		}
+	} else {
+		rand.Shuffle(len(fields), func(i, j int) {
+			fields[i], fields[j] = fields[j], fields[i]
+		})
+		picked = fields[:min(count, len(fields))]
+	}
+
+	if !withValues {
+		return s.createResponse(RESP_OK, s.encodeStringArray(picked))
+	}
+
+	pairs := make([][]byte, 0, 2*len(picked))
+	for _, field := range picked {
+		pairs = append(pairs, []byte(field), snapshot[field])
+	}
+	return s.createResponse(RESP_OK, s.encodeArray(pairs))
+}
+
+// loadOrCreateHash returns the live hash at key, storing a new empty hash if
+// the key is missing or expired. On a type mismatch it returns a ready
+// WRONGTYPE response instead.
+func (s *DatabaseState) loadOrCreateHash(key string, now int64) (*Hash, []byte) {
+	if existing, exists := s.storage.Load(key); exists {
+		item := existing.(*CacheItem)
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+		} else if item.DataType != TYPE_HASH {
+			return nil, s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		} else {
+			return item.Value.(*Hash), nil
+		}
+	}
+
+	hash := NewHash()
+	s.storage.Store(key, &CacheItem{
+		DataType:  TYPE_HASH,
+		Value:     hash,
+		CreatedAt: now,
+	})
+	return hash, nil
+}
+
+func (s *DatabaseState) handleHashKeys(key string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	hash := item.Value.(*Hash)
+	return s.createResponse(RESP_OK, s.encodeStringArray(hash.Keys()))
+}
+
+func (s *DatabaseState) handleHashVals(key string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeByteArray([][]byte{}))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeByteArray([][]byte{}))
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	hash := item.Value.(*Hash)
+	return s.createResponse(RESP_OK, s.encodeByteArray(hash.Values()))
+}
+
+func (s *DatabaseState) handleHashLen(key string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	hash := item.Value.(*Hash)
+	return s.createResponse(RESP_OK, []byte(fmt.Sprintf("%d", hash.Len())))
+}
+
+func (s *DatabaseState) handleHashExists(key string, field string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	hash := item.Value.(*Hash)
+	if hash.Exists(field) {
+		return s.createResponse(RESP_OK, []byte("1"))
+	}
+	return s.createResponse(RESP_OK, []byte("0"))
+}
+
+func (s *DatabaseState) handleHashMSet(key string, data []byte, now int64) []byte {
+	// Parse field/value pairs: [numfields:4][field1len:4][field1][val1len:4][val1]...
+	args := newArgReader(data)
+	count := args.uint32()
+	if args.err != nil || count == 0 || int(count) > len(data)/8 {
+		return s.createResponse(RESP_ERROR, []byte("Invalid HMSET data"))
+	}
+
+	fields := make([]string, count)
+	values := make([][]byte, count)
+	for i := range fields {
+		fields[i] = args.string()
+		values[i] = args.bytes()
+	}
+	if args.err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid HMSET data"))
+	}
+
+	hash, errResp := s.loadOrCreateHash(key, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	added := 0
+	for i, field := range fields {
+		if hash.Set(field, values[i]) {
+			added++
+		}
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(added)))
+}
+
+func (s *DatabaseState) handleHashMGet(key string, fields []string, now int64) []byte {
+	values := make([][]byte, len(fields))
+
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeMGetResponse(values))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeMGetResponse(values))
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	hash := item.Value.(*Hash)
+	for i, field := range fields {
+		if value, ok := hash.Get(field); ok {
+			if value == nil {
+				value = []byte{} // Keep empty values distinct from missing fields
+			}
+			values[i] = value
+		}
+	}
+	return s.createResponse(RESP_OK, s.encodeMGetResponse(values))
+}
+
+// Sorted set operation handlers
+
+// parseZAddArgs decodes [flags:1][numentries:4][score:8][memberlen:4][member]...
+func parseZAddArgs(data []byte) ([]ZEntry, ZAddFlags, error) {
+	args := newArgReader(data)
+	flags := ZAddFlags(args.uint8())
+	count := args.uint32()
+	if args.err != nil || count == 0 || int(count) > len(data)/12 {
+		return nil, 0, fmt.Errorf("invalid entry count")
+	}
+
+	entries := make([]ZEntry, count)
+	for i := range entries {
+		entries[i].Score = math.Float64frombits(args.uint64())
+		entries[i].Member = args.string()
+		if math.IsNaN(entries[i].Score) {
+			return nil, 0, fmt.Errorf("invalid score")
+		}
+	}
+	return entries, flags, args.err
+}
+
+func (s *DatabaseState) handleZAdd(key string, entries []ZEntry, flags ZAddFlags, now int64) []byte {
+	if flags&ZADD_NX != 0 && flags&ZADD_XX != 0 {
+		return s.createResponse(RESP_ERROR, []byte("ERR XX and NX options at the same time are not compatible"))
+	}
+	if (flags&ZADD_GT != 0 && flags&ZADD_LT != 0) || (flags&ZADD_NX != 0 && flags&(ZADD_GT|ZADD_LT) != 0) {
+		return s.createResponse(RESP_ERROR, []byte("ERR GT, LT, and/or NX options at the same time are not compatible"))
+	}
+	if flags&ZADD_INCR != 0 && len(entries) != 1 {
+		return s.createResponse(RESP_ERROR, []byte("ERR INCR option supports a single increment-element pair"))
+	}
+
+	// XX only updates members, so it must not create the key
+	var zset *ZSet
+	var errResp []byte
+	if flags&ZADD_XX != 0 {
+		zset, errResp = s.loadZSet(key, now)
+	} else {
+		zset, errResp = s.loadOrCreateZSet(key, now)
+	}
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		if flags&ZADD_INCR != 0 {
+			return s.createResponse(RESP_NOT_FOUND, nil)
+		}
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	added := 0
+	var score float64
+	applied := false
+	for _, entry := range entries {
+		var wasAdded bool
+		score, wasAdded, applied = zset.AddWithFlags(entry.Member, entry.Score, flags)
+		if wasAdded {
+			added++
+		}
+	}
+
+	if flags&ZADD_INCR != 0 {
+		if !applied {
+			return s.createResponse(RESP_NOT_FOUND, nil)
+		}
+		s.serveZSetBlockers(key, zset)
+		return s.createResponse(RESP_OK, []byte(formatScore(score)))
+	}
+
+	s.serveZSetBlockers(key, zset)
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(added)))
+}
+
+// handleZRange returns a range of members by rank, by score or
+// lexicographically depending on opts
+func (s *DatabaseState) handleZRange(key string, start, stop string, opts ZRangeOpts, now int64) []byte {
+	if errResp := s.checkZRangeOpts(opts); errResp != nil {
+		return errResp
+	}
+	if opts.WithScores && opts.ByLex {
+		return s.createResponse(RESP_ERROR, []byte("ERR syntax error, WITHSCORES not supported in combination with BYLEX"))
+	}
+
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		return s.createResponse(RESP_OK, s.encodeStringArray([]string{}))
+	}
+
+	entries, errResp := s.rangeZSet(zset, start, stop, opts)
+	if errResp != nil {
+		return errResp
+	}
+	return s.createResponse(RESP_OK, s.encodeZEntries(entries, opts.WithScores))
+}
+
+// handleZRangeStore stores the ZRANGE selection of src at dst, keeping the
+// original scores
+func (s *DatabaseState) handleZRangeStore(dst, src string, start, stop string, opts ZRangeOpts, now int64) []byte {
+	if errResp := s.checkZRangeOpts(opts); errResp != nil {
+		return errResp
+	}
+	if opts.WithScores {
+		return s.createResponse(RESP_ERROR, []byte("ERR syntax error"))
+	}
+
+	zset, errResp := s.loadZSet(src, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	scores := make(map[string]float64)
+	if zset != nil {
+		entries, errResp := s.rangeZSet(zset, start, stop, opts)
+		if errResp != nil {
+			return errResp
+		}
+		for _, entry := range entries {
+			scores[entry.Member] = entry.Score
+		}
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(s.storeZSet(dst, scores, now))))
+}
+
+// checkZRangeOpts rejects ZRANGE option combinations Redis refuses
+func (s *GoFastServer) checkZRangeOpts(opts ZRangeOpts) []byte {
+	if opts.ByScore && opts.ByLex {
+		return s.createResponse(RESP_ERROR, []byte("ERR syntax error"))
+	}
+	if opts.Count >= 0 && !opts.ByScore && !opts.ByLex {
+		return s.createResponse(RESP_ERROR, []byte("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"))
+	}
+	return nil
+}
+
+// rangeZSet selects the ZRANGE entries of zset by rank, score or lex
+// depending on opts. Invalid bounds come back as a ready error response.
+func (s *GoFastServer) rangeZSet(zset *ZSet, start, stop string, opts ZRangeOpts) ([]ZEntry, []byte) {
+	// With REV the first bound is the upper one, as in Redis
+	if opts.Rev && (opts.ByScore || opts.ByLex) {
+		start, stop = stop, start
+	}
+
+	var entries []ZEntry
+	switch {
+	case opts.ByScore:
+		min, err1 := parseScoreBound(start)
+		max, err2 := parseScoreBound(stop)
+		if err1 != nil || err2 != nil {
+			return nil, s.createResponse(RESP_ERROR, []byte("ERR min or max is not a float"))
+		}
+		entries = zset.RangeByScore(min, max, opts.Rev, opts.Offset, opts.Count)
+
+	case opts.ByLex:
+		min, err1 := parseLexBound(start)
+		max, err2 := parseLexBound(stop)
+		if err1 != nil || err2 != nil {
+			return nil, s.createResponse(RESP_ERROR, []byte("ERR min or max not valid string range item"))
+		}
+		entries = zset.RangeByLex(min, max, opts.Rev, opts.Offset, opts.Count)
+
+	default:
+		startIndex, err1 := strconv.Atoi(start)
+		stopIndex, err2 := strconv.Atoi(stop)
+		if err1 != nil || err2 != nil {
+			return nil, s.createResponse(RESP_ERROR, []byte("ERR value is not an integer or out of range"))
+		}
+		entries = zset.Range(startIndex, stopIndex, opts.Rev)
+	}
+	return entries, nil
+}
+
+// handleZRank returns member's 0-based rank, counting from the highest score
+// when reverse is set
+func (s *DatabaseState) handleZRank(key, member string, withScore, reverse bool, now int64) []byte {
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	rank, score, ok := zset.Rank(member, reverse)
+	if !ok {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	if withScore {
+		return s.createResponse(RESP_OK, s.encodeArray([][]byte{
+			[]byte(strconv.Itoa(rank)),
+			[]byte(formatScore(score)),
+		}))
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(rank)))
+}
+
+func (s *DatabaseState) handleZScore(key, member string, now int64) []byte {
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	score, ok := zset.Score(member)
+	if !ok {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+	return s.createResponse(RESP_OK, []byte(formatScore(score)))
+}
+
+// handleZMScore returns the score of each member as an 8-byte float64, with
+// nil for members (or a key) that do not exist
+func (s *DatabaseState) handleZMScore(key string, members []string, now int64) []byte {
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	values := make([][]byte, len(members))
+	if zset != nil {
+		for i, member := range members {
+			if score, ok := zset.Score(member); ok {
+				values[i] = make([]byte, 8)
+				binary.BigEndian.PutUint64(values[i], math.Float64bits(score))
+			}
+		}
+	}
+	return s.createResponse(RESP_OK, s.encodeMGetResponse(values))
+}
+
+func (s *DatabaseState) handleZIncrBy(key, member string, delta float64, now int64) []byte {
+	zset, errResp := s.loadOrCreateZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	score, _, applied := zset.AddWithFlags(member, delta, ZADD_INCR)
+	if !applied {
+		// Only a NaN result (e.g. +inf plus -inf) is rejected
+		if zset.Card() == 0 {
+			s.storage.Delete(key)
+		}
+		return s.createResponse(RESP_ERROR, []byte("ERR resulting score is not a number (NaN)"))
+	}
+
+	s.serveZSetBlockers(key, zset)
+	return s.createResponse(RESP_OK, []byte(formatScore(score)))
+}
+
+func (s *DatabaseState) handleZRem(key string, members []string, now int64) []byte {
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	removed := 0
+	for _, member := range members {
+		if zset.Remove(member) {
+			removed++
+		}
+	}
+
+	// If sorted set is now empty, remove the key
+	if zset.Card() == 0 {
+		s.storage.Delete(key)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, key)
+		s.ttlMutex.Unlock()
+	}
+
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(removed)))
+}
+
+func (s *DatabaseState) handleZCard(key string, now int64) []byte {
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(zset.Card())))
+}
+
+func (s *DatabaseState) handleZCount(key string, min, max string, now int64) []byte {
+	minBound, err1 := parseScoreBound(min)
+	maxBound, err2 := parseScoreBound(max)
+	if err1 != nil || err2 != nil {
+		return s.createResponse(RESP_ERROR, []byte("ERR min or max is not a float"))
+	}
+
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(zset.Count(minBound, maxBound))))
+}
+
+func (s *DatabaseState) handleZLexCount(key string, min, max string, now int64) []byte {
+	minBound, err1 := parseLexBound(min)
+	maxBound, err2 := parseLexBound(max)
+	if err1 != nil || err2 != nil {
+		return s.createResponse(RESP_ERROR, []byte("ERR min or max not valid string range item"))
+	}
+
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(zset.LexCount(minBound, maxBound))))
+}
+
+// handleZRemRange removes the members within a score range or rank range,
+// depending on byScore, returning how many were removed
+func (s *DatabaseState) handleZRemRange(key string, byScore bool, min, max ScoreBound, start, stop int, now int64) []byte {
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	var removed int
+	if byScore {
+		removed = zset.RemoveRangeByScore(min, max)
+	} else {
+		removed = zset.RemoveRangeByRank(start, stop)
+	}
+
+	// If sorted set is now empty, remove the key
+	if zset.Card() == 0 {
+		s.storage.Delete(key)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, key)
+		s.ttlMutex.Unlock()
+	}
+
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(removed)))
+}
+
+// handleZPop removes up to count members with the lowest scores, or the
+// highest when fromMax is set, returning member/score pairs
+func (s *DatabaseState) handleZPop(key string, count int, fromMax bool, now int64) []byte {
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		return s.createResponse(RESP_OK, s.encodeZEntries([]ZEntry{}, true))
+	}
+
+	entries := zset.Pop(count, fromMax)
+
+	// If sorted set is now empty, remove the key
+	if zset.Card() == 0 {
+		s.storage.Delete(key)
+		s.ttlMutex.Lock()
+		delete(s.ttlIndex, key)
+		s.ttlMutex.Unlock()
+	}
+
+	return s.createResponse(RESP_OK, s.encodeZEntries(entries, true))
+}
+
+func (s *DatabaseState) handleBlockingZPop(keys []string, timeout time.Duration, fromMax bool, gone <-chan struct{}, now int64) []byte {
+	// Serve immediately from the first non-empty sorted set
+	for _, key := range keys {
+		zset, errResp := s.loadZSet(key, now)
+		if errResp != nil {
+			return errResp
+		}
+		if zset == nil {
+			continue
+		}
+
+		entries := zset.Pop(1, fromMax)
+		if len(entries) == 0 {
+			continue
+		}
+		s.logServedPop(zsetPopCommand(fromMax), key, 0)
+
+		if zset.Card() == 0 {
+			s.storage.Delete(key)
+			s.ttlMutex.Lock()
+			delete(s.ttlIndex, key)
+			s.ttlMutex.Unlock()
+		}
+
+		return s.createResponse(RESP_OK, s.encodeArray([][]byte{
+			[]byte(key), []byte(entries[0].Member), []byte(formatScore(entries[0].Score)),
+		}))
+	}
+
+	if mustNotWait(gone) {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	// All sorted sets are empty, wait for a ZADD or ZINCRBY
+	client := &blockedClient{
+		keys:     keys,
+		fromHead: !fromMax,
+		count:    1,
+		result:   make(chan [][]byte, 1),
+	}
+	s.blockOn(&s.zsetBlockers, client)
+
+	// Re-check in case an add landed before we were registered
+	for _, key := range keys {
+		existing, exists := s.storage.Load(key)
+		if !exists {
+			continue
+		}
+		item := existing.(*CacheItem)
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+			continue
+		}
+		if item.DataType == TYPE_ZSET {
+			s.serveZSetBlockers(key, item.Value.(*ZSet))
+		}
+	}
+
+	reply, ok := s.waitBlocked(&s.zsetBlockers, client, timeout, gone)
+	if !ok {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+	return s.createResponse(RESP_OK, s.encodeArray(reply))
+}
+
+func (s *DatabaseState) handleBlockingZMPop(keys []string, timeout time.Duration, fromMax bool, count int, gone <-chan struct{}, now int64) []byte {
+	if count < 1 {
+		count = 1
+	}
+
+	// Serve immediately from the first non-empty sorted set
+	for _, key := range keys {
+		zset, errResp := s.loadZSet(key, now)
+		if errResp != nil {
+			return errResp
+		}
+		if zset == nil {
+			continue
+		}
+
+		entries := zset.Pop(count, fromMax)
+		if len(entries) == 0 {
+			continue
+		}
+		s.logServedPop(zsetPopCommand(fromMax), key, len(entries))
+
+		if zset.Card() == 0 {
+			s.storage.Delete(key)
+			s.ttlMutex.Lock()
+			delete(s.ttlIndex, key)
+			s.ttlMutex.Unlock()
+		}
+
+		values := make([][]byte, 0, 2*len(entries))
+		for _, entry := range entries {
+			values = append(values, []byte(entry.Member), []byte(formatScore(entry.Score)))
+		}
+		return s.createResponse(RESP_OK, s.encodeKeyedArray(key, values))
+	}
+
+	if mustNotWait(gone) {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	// All sorted sets are empty, wait for a ZADD or ZINCRBY
+	client := &blockedClient{
+		keys:     keys,
+		fromHead: !fromMax,
+		count:    count,
+		result:   make(chan [][]byte, 1),
+	}
+	s.blockOn(&s.zsetBlockers, client)
+
+	// Re-check in case an add landed before we were registered
+	for _, key := range keys {
+		existing, exists := s.storage.Load(key)
+		if !exists {
+			continue
+		}
+		item := existing.(*CacheItem)
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+			continue
+		}
+		if item.DataType == TYPE_ZSET {
+			s.serveZSetBlockers(key, item.Value.(*ZSet))
+		}
+	}
+
+	reply, ok := s.waitBlocked(&s.zsetBlockers, client, timeout, gone)
+	if !ok {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+	return s.createResponse(RESP_OK, s.encodeKeyedArray(string(reply[0]), reply[1:]))
+}
+
+// handleZSetAggregateStore computes ZUNIONSTORE or ZINTERSTORE over the
+// sorted sets (or plain sets, scored as 1) at keys and stores the result at
+// dst
+func (s *DatabaseState) handleZSetAggregateStore(op uint8, dst string, keys []string, weights []float64, aggregate uint8, now int64) []byte {
+	sources, errResp := s.loadZSources(keys, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	combine := func(a, b float64) float64 {
+		switch aggregate {
+		case ZAGGREGATE_MIN:
+			return math.Min(a, b)
+		case ZAGGREGATE_MAX:
+			return math.Max(a, b)
+		}
+		if sum := a + b; !math.IsNaN(sum) {
+			return sum
+		}
+		return 0 // +inf plus -inf
+	}
+	weighted := func(i int, score float64) float64 {
+		if weights == nil {
+			return score
+		}
+		if result := score * weights[i]; !math.IsNaN(result) {
+			return result
+		}
+		return 0 // inf times zero
+	}
+
+	result := make(map[string]float64)
+	if op == CMD_ZUNIONSTORE {
+		for i, source := range sources {
+			for member, score := range source {
+				score = weighted(i, score)
+				if current, exists := result[member]; exists {
+					score = combine(current, score)
+				}
+				result[member] = score
+			}
+		}
+	} else if len(sources) > 0 {
+		smallest := 0
+		for i, source := range sources {
+			if len(source) < len(sources[smallest]) {
+				smallest = i
+			}
+		}
+	members:
+		for member := range sources[smallest] {
+			var score float64
+			for i, source := range sources {
+				value, exists := source[member]
+				if !exists {
+					continue members
+				}
+				if i == 0 {
+					score = weighted(i, value)
+				} else {
+					score = combine(score, weighted(i, value))
+				}
+			}
+			result[member] = score
+		}
+	}
+
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(s.storeZSet(dst, result, now))))
+}
+
+// handleZDiff returns the members of the first sorted set that are absent
+// from all the others, ordered by score
+func (s *DatabaseState) handleZDiff(keys []string, withScores bool, now int64) []byte {
+	sources, errResp := s.loadZSources(keys, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	entries := make([]ZEntry, 0)
+	for member, score := range zsetDiff(sources) {
+		entries = append(entries, ZEntry{Member: member, Score: score})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score < entries[j].Score
+		}
+		return entries[i].Member < entries[j].Member
+	})
+	return s.createResponse(RESP_OK, s.encodeZEntries(entries, withScores))
+}
+
+func (s *DatabaseState) handleZDiffStore(dst string, keys []string, now int64) []byte {
+	sources, errResp := s.loadZSources(keys, now)
+	if errResp != nil {
+		return errResp
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(s.storeZSet(dst, zsetDiff(sources), now))))
+}
+
+// zsetDiff returns the entries of the first source not present in any of
+// the following sources
+func zsetDiff(sources []map[string]float64) map[string]float64 {
+	result := make(map[string]float64)
+	if len(sources) == 0 {
+		return result
+	}
+
+members:
+	for member, score := range sources[0] {
+		for _, source := range sources[1:] {
+			if _, exists := source[member]; exists {
+				continue members
+			}
+		}
+		result[member] = score
+	}
+	return result
+}
+
+// loadZSources snapshots the scores of the sorted sets at keys, treating
+// plain sets as members scored 1 and missing keys as empty
+func (s *DatabaseState) loadZSources(keys []string, now int64) ([]map[string]float64, []byte) {
+	sources := make([]map[string]float64, len(keys))
+	for i, key := range keys {
+		existing, exists := s.storage.Load(key)
+		if !exists {
+			continue
+		}
+
+		item := existing.(*CacheItem)
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+			continue
+		}
+
+		switch item.DataType {
+		case TYPE_ZSET:
+			sources[i] = item.Value.(*ZSet).Scores()
+		case TYPE_SET:
+			members := item.Value.(*Set).Members()
+			sources[i] = make(map[string]float64, len(members))
+			for _, member := range members {
+				sources[i][member] = 1
+			}
+		default:
+			return nil, s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		}
+	}
+	return sources, nil
+}
+
+// storeZSet replaces dst with a new sorted set holding scores, deleting dst
+// when scores is empty. It returns the stored cardinality.
+func (s *DatabaseState) storeZSet(dst string, scores map[string]float64, now int64) int {
+	s.ttlMutex.Lock()
+	delete(s.ttlIndex, dst)
+	s.ttlMutex.Unlock()
+
+	if len(scores) == 0 {
+		s.storage.Delete(dst)
+		return 0
+	}
+
+	zset := NewZSet()
+	for member, score := range scores {
+		zset.Add(member, score)
+	}
+	s.storage.Store(dst, &CacheItem{
+		DataType:  TYPE_ZSET,
+		Value:     zset,
+		CreatedAt: now,
+	})
+	return len(scores)
+}
+
+// parseZStoreArgs decodes [numkeys:4][keys...][flags:1][weights:8*N][aggregate:1]
+func parseZStoreArgs(data []byte) (keys []string, weights []float64, aggregate uint8, err error) {
+	args := newArgReader(data)
+	keys = args.keyList()
+	if args.err != nil || len(keys) == 0 {
+		return nil, nil, 0, fmt.Errorf("invalid key list")
+	}
+
+	var flags uint8
+	if args.offset < len(data) {
+		flags = args.uint8()
+	}
+	if flags&ZSTORE_WEIGHTS != 0 {
+		weights = make([]float64, len(keys))
+		for i := range weights {
+			weights[i] = math.Float64frombits(args.uint64())
+		}
+	}
+	if flags&ZSTORE_AGGREGATE != 0 {
+		aggregate = args.uint8()
+		if aggregate > ZAGGREGATE_MAX {
+			return nil, nil, 0, fmt.Errorf("invalid aggregate")
+		}
+	}
+	return keys, weights, aggregate, args.err
+}
+
+// encodeZEntries encodes members, interleaved with their scores when
+// withScores is set
+func (s *GoFastServer) encodeZEntries(entries []ZEntry, withScores bool) []byte {
+	if !withScores {
+		members := make([]string, len(entries))
+		for i, entry := range entries {
+			members[i] = entry.Member
+		}
+		return s.encodeStringArray(members)
+	}
+
+	values := make([][]byte, 0, 2*len(entries))
+	for _, entry := range entries {
+		values = append(values, []byte(entry.Member), []byte(formatScore(entry.Score)))
+	}
+	return s.encodeArray(values)
+}
+
+// parseZRangeArgs decodes [flags:1][startlen:4][start][stoplen:4][stop]
+// followed by an optional [limitoffset:4][limitcount:4]
+func parseZRangeArgs(data []byte) (start, stop string, opts ZRangeOpts, err error) {
+	args := newArgReader(data)
+	flags := args.uint8()
+	start = args.string()
+	stop = args.string()
+	opts = ZRangeOpts{
+		ByScore:    flags&ZRANGE_BYSCORE != 0,
+		ByLex:      flags&ZRANGE_BYLEX != 0,
+		Rev:        flags&ZRANGE_REV != 0,
+		WithScores: flags&ZRANGE_WITHSCORES != 0,
+		Count:      -1,
+	}
+	if flags&ZRANGE_LIMIT != 0 {
+		opts.Offset = int(args.int32())
+		opts.Count = int(args.int32())
+		if opts.Offset < 0 {
+			// A negative offset returns nothing, as in Redis
+			opts.Count = 0
+		}
+	}
+	return start, stop, opts, args.err
+}
+
+// parseScoreBound parses "5", "(5", "-inf" and "+inf"
+func parseScoreBound(s string) (ScoreBound, error) {
+	var bound ScoreBound
+	if strings.HasPrefix(s, "(") {
+		bound.Exclusive = true
+		s = s[1:]
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return bound, fmt.Errorf("invalid score bound")
+	}
+	bound.Value = value
+	return bound, nil
+}
+
+// parseLexBound parses "[member", "(member", "-" and "+"
+func parseLexBound(s string) (LexBound, error) {
+	switch {
+	case s == "-":
+		return LexBound{Inf: -1}, nil
+	case s == "+":
+		return LexBound{Inf: 1}, nil
+	case strings.HasPrefix(s, "["):
+		return LexBound{Value: s[1:]}, nil
+	case strings.HasPrefix(s, "("):
+		return LexBound{Value: s[1:], Exclusive: true}, nil
+	}
+	return LexBound{}, fmt.Errorf("invalid lex bound")
+}
+
+// formatScore formats a sorted set score the way Redis replies with it
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// loadZSet returns the live sorted set at key, or nil if the key is missing
+// or expired. On a type mismatch it returns a ready WRONGTYPE response.
+func (s *DatabaseState) loadZSet(key string, now int64) (*ZSet, []byte) {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return nil, nil
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return nil, nil
+	}
+
+	if item.DataType != TYPE_ZSET {
+		return nil, s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+	return item.Value.(*ZSet), nil
+}
+
+// loadOrCreateZSet returns the live sorted set at key, storing a new empty
+// one if the key is missing or expired. On a type mismatch it returns a ready
+// WRONGTYPE response instead.
+func (s *DatabaseState) loadOrCreateZSet(key string, now int64) (*ZSet, []byte) {
+	if existing, exists := s.storage.Load(key); exists {
+		item := existing.(*CacheItem)
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+		} else if item.DataType != TYPE_ZSET {
+			return nil, s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		} else {
+			return item.Value.(*ZSet), nil
+		}
+	}
+
+	zset := NewZSet()
+	s.storage.Store(key, &CacheItem{
+		DataType:  TYPE_ZSET,
+		Value:     zset,
+		CreatedAt: now,
+	})
+	return zset, nil
+}
+
+// Stream operation handlers
+
+// commandMillis is the time in milliseconds a command running as of now, in
+// Unix seconds, sees. Live commands get the clock; one replayed from the AOF
+// runs at its record's time, so delivery and idle times come out the same.
+func commandMillis(now int64) int64 {
+	millis := time.Now().UnixMilli()
+	if millis/1000 != now {
+		return now * 1000
+	}
+	return millis
+}
+func (s *DatabaseState) handleXAdd(key, idSpec string, fields []string, flags uint8, threshold string, now int64) []byte {
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return s.createResponse(RESP_ERROR, []byte("ERR wrong number of arguments for 'xadd' command"))
+	}
+
+	// "*" generates the whole ID, "<millis>-*" only the sequence number
+	var id StreamID
+	auto, autoSeq := idSpec == "*", false
+	if !auto {
+		var err error
+		if millis, found := strings.CutSuffix(idSpec, "-*"); found {
+			autoSeq = true
+			id.Millis, err = strconv.ParseUint(millis, 10, 64)
+		} else {
+			id, err = parseStreamID(idSpec, 0)
+		}
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("ERR Invalid stream ID specified as stream command argument"))
+		}
+	}
+
+	trim, errResp := s.parseStreamTrim(flags, threshold)
+	if errResp != nil {
+		return errResp
+	}
+
+	stream, errResp := s.loadStream(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	created := false
+	if stream == nil {
+		if flags&XADD_NOMKSTREAM != 0 {
+			return s.createResponse(RESP_NOT_FOUND, nil)
+		}
+		stream = NewStream()
+		created = true
+	}
+
+	id, err := stream.Add(id, auto, autoSeq, fields, uint64(time.Now().UnixMilli()))
+	if err != nil {
+		return s.createResponse(RESP_ERROR, []byte(err.Error()))
+	}
+
+	// A new stream is only stored once it holds its first entry
+	if created {
+		s.storage.Store(key, &CacheItem{
+			DataType:  TYPE_STREAM,
+			Value:     stream,
+			CreatedAt: now,
+		})
+	}
+	if trim != nil {
+		trim(stream)
+	}
+
+	return s.createResponse(RESP_OK, []byte(id.String()))
+}
+
+// handleXRead returns the entries newer than the matching ID of each stream,
+// skipping streams with nothing new. "$" stands for the stream's last ID.
+func (s *DatabaseState) handleXRead(keys, ids []string, count int, now int64) []byte {
+	if len(ids) != len(keys) {
+		return s.createResponse(RESP_ERROR, []byte("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."))
+	}
+
+	var readKeys []string
+	var results [][]StreamEntry
+	for i, key := range keys {
+		stream, errResp := s.loadStream(key, now)
+		if errResp != nil {
+			return errResp
+		}
+
+		var after StreamID
+		if ids[i] == "$" {
+			if stream == nil {
+				continue
+			}
+			after = stream.LastID()
+		} else {
+			var err error
+			if after, err = parseStreamID(ids[i], 0); err != nil {
+				return s.createResponse(RESP_ERROR, []byte("ERR Invalid stream ID specified as stream command argument"))
+			}
+		}
+
+		if stream == nil {
+			continue
+		}
+		if entries := stream.After(after, count); len(entries) > 0 {
+			readKeys = append(readKeys, key)
+			results = append(results, entries)
+		}
+	}
+
+	if len(readKeys) == 0 {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+	return s.createResponse(RESP_OK, s.encodeStreamRead(readKeys, results))
+}
+
+// handleXRange returns up to count entries (all if count is negative) with
+// IDs between start and end. XREVRANGE passes end first and gets the newest
+// entries first.
+func (s *DatabaseState) handleXRange(key, start, end string, count int, reverse bool, now int64) []byte {
+	if reverse {
+		start, end = end, start
+	}
+
+	startID, err1 := parseStreamBound(start, false)
+	endID, err2 := parseStreamBound(end, true)
+	if err1 != nil || err2 != nil {
+		return s.createResponse(RESP_ERROR, []byte("ERR Invalid stream ID specified as stream command argument"))
+	}
+
+	stream, errResp := s.loadStream(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if stream == nil {
+		return s.createResponse(RESP_OK, s.encodeStreamEntries([]StreamEntry{}))
+	}
+	return s.createResponse(RESP_OK, s.encodeStreamEntries(stream.Range(startID, endID, reverse, count)))
+}
+
+func (s *DatabaseState) handleXLen(key string, now int64) []byte {
+	stream, errResp := s.loadStream(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if stream == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(stream.Len())))
+}
+
+func (s *DatabaseState) handleXDel(key string, ids []string, now int64) []byte {
+	streamIDs := make([]StreamID, len(ids))
+	for i, id := range ids {
+		var err error
+		if streamIDs[i], err = parseStreamID(id, 0); err != nil {
+			return s.createResponse(RESP_ERROR, []byte("ERR Invalid stream ID specified as stream command argument"))
+		}
+	}
+
+	stream, errResp := s.loadStream(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if stream == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(stream.Delete(streamIDs))))
+}
+
+func (s *DatabaseState) handleXTrim(key string, flags uint8, threshold string, now int64) []byte {
+	if flags&(XTRIM_MAXLEN|XTRIM_MINID) == 0 {
+		return s.createResponse(RESP_ERROR, []byte("ERR syntax error"))
+	}
+
+	trim, errResp := s.parseStreamTrim(flags, threshold)
+	if errResp != nil {
+		return errResp
+	}
+
+	stream, errResp := s.loadStream(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if stream == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(trim(stream))))
+}
+
+// handleXGroup manages the consumer groups of a stream
+func (s *DatabaseState) handleXGroup(data []byte, now int64) []byte {
+	args := newArgReader(data)
+	subcommand := args.uint8()
+	key := args.string()
+	group := args.string()
+	if args.err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid XGROUP data"))
+	}
+
+	stream, errResp := s.loadStream(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	nowMillis := commandMillis(now)
+
+	switch subcommand {
+	case XGROUP_CREATE:
+		idSpec := args.string()
+		flags := args.uint8()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XGROUP data"))
+		}
+
+		var id StreamID
+		if idSpec != "$" {
+			var err error
+			if id, err = parseStreamID(idSpec, 0); err != nil {
+				return s.createResponse(RESP_ERROR, []byte("ERR Invalid stream ID specified as stream command argument"))
+			}
+		}
+
+		if stream == nil {
+			if flags&XGROUP_MKSTREAM == 0 {
+				return s.createResponse(RESP_ERROR, []byte("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."))
+			}
+			stream = NewStream()
+			s.storage.Store(key, &CacheItem{
+				DataType:  TYPE_STREAM,
+				Value:     stream,
+				CreatedAt: now,
+			})
+		}
+
+		if !stream.CreateGroup(group, id, idSpec == "$") {
+			return s.createResponse(RESP_ERROR, []byte("BUSYGROUP Consumer Group name already exists"))
+		}
+		return s.createResponse(RESP_OK, []byte("OK"))
+
+	case XGROUP_DESTROY:
+		if stream == nil || !stream.DestroyGroup(group) {
+			return s.createResponse(RESP_OK, []byte("0"))
+		}
+		return s.createResponse(RESP_OK, []byte("1"))
+
+	case XGROUP_SETID:
+		idSpec := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XGROUP data"))
+		}
+
+		var id StreamID
+		if idSpec != "$" {
+			var err error
+			if id, err = parseStreamID(idSpec, 0); err != nil {
+				return s.createResponse(RESP_ERROR, []byte("ERR Invalid stream ID specified as stream command argument"))
+			}
+		}
+		if stream == nil || !stream.SetGroupID(group, id, idSpec == "$") {
+			return s.noGroupResponse(key, group)
+		}
+		return s.createResponse(RESP_OK, []byte("OK"))
+
+	case XGROUP_CREATECONSUMER:
+		consumer := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XGROUP data"))
+		}
+		if stream == nil {
+			return s.noGroupResponse(key, group)
+		}
+
+		created, ok := stream.CreateConsumer(group, consumer, nowMillis)
+		if !ok {
+			return s.noGroupResponse(key, group)
+		}
+		if created {
+			return s.createResponse(RESP_OK, []byte("1"))
+		}
+		return s.createResponse(RESP_OK, []byte("0"))
+
+	case XGROUP_DELCONSUMER:
+		consumer := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XGROUP data"))
+		}
+		if stream == nil {
+			return s.noGroupResponse(key, group)
+		}
+
+		pending, ok := stream.DeleteConsumer(group, consumer)
+		if !ok {
+			return s.noGroupResponse(key, group)
+		}
+		return s.createResponse(RESP_OK, []byte(strconv.Itoa(pending)))
+
+	default:
+		return s.createResponse(RESP_ERROR, []byte("ERR unknown XGROUP subcommand"))
+	}
+}
+
+// handleXReadGroup reads from each stream on behalf of a consumer group. The
+// ID ">" asks for entries never delivered to the group; any other ID replays
+// the consumer's pending entries after it.
+func (s *DatabaseState) handleXReadGroup(group, consumer string, keys, ids []string, count int, noAck bool, now int64) []byte {
+	if len(ids) != len(keys) {
+		return s.createResponse(RESP_ERROR, []byte("ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified."))
+	}
+
+	// Check every stream before delivering anything
+	streams := make([]*Stream, len(keys))
+	afters := make([]StreamID, len(keys))
+	for i, key := range keys {
+		stream, errResp := s.loadStream(key, now)
+		if errResp != nil {
+			return errResp
+		}
+		if stream == nil || !stream.HasGroup(group) {
+			return s.noGroupResponse(key, group)
+		}
+		streams[i] = stream
+
+		if ids[i] != ">" {
+			var err error
+			if afters[i], err = parseStreamID(ids[i], 0); err != nil {
+				return s.createResponse(RESP_ERROR, []byte("ERR Invalid stream ID specified as stream command argument"))
+			}
+		}
+	}
+
+	nowMillis := commandMillis(now)
+	var readKeys []string
+	var results [][]StreamEntry
+	for i, key := range keys {
+		entries, ok := streams[i].ReadGroup(group, consumer, afters[i], ids[i] == ">", noAck, count, nowMillis)
+		if !ok {
+			return s.noGroupResponse(key, group)
+		}
+		if len(entries) > 0 || ids[i] != ">" {
+			readKeys = append(readKeys, key)
+			results = append(results, entries)
+		}
+	}
+
+	if len(readKeys) == 0 {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+	return s.createResponse(RESP_OK, s.encodeStreamRead(readKeys, results))
+}
+
+// handleXAck acknowledges entries delivered to a consumer group
+func (s *DatabaseState) handleXAck(key, group string, ids []string, now int64) []byte {
+	streamIDs := make([]StreamID, len(ids))
+	for i, id := range ids {
+		var err error
+		if streamIDs[i], err = parseStreamID(id, 0); err != nil {
+			return s.createResponse(RESP_ERROR, []byte("ERR Invalid stream ID specified as stream command argument"))
+		}
+	}
+
+	stream, errResp := s.loadStream(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if stream == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(stream.Ack(group, streamIDs))))
+}
+
+// handleXPending summarizes a consumer group's pending entries as the total,
+// the lowest and highest pending IDs (nil when nothing is pending), then a
+// name and count per consumer
+func (s *DatabaseState) handleXPending(key, group string, now int64) []byte {
+	stream, errResp := s.loadStream(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if stream == nil {
+		return s.noGroupResponse(key, group)
+	}
+
+	total, first, last, perConsumer, ok := stream.PendingSummary(group)
+	if !ok {
+		return s.noGroupResponse(key, group)
+	}
+
+	values := [][]byte{[]byte(strconv.Itoa(total)), nil, nil}
+	if total > 0 {
+		values[1] = []byte(first.String())
+		values[2] = []byte(last.String())
+	}
+
+	consumers := make([]string, 0, len(perConsumer))
+	for consumer := range perConsumer {
+		consumers = append(consumers, consumer)
+	}
+	sort.Strings(consumers)
+	for _, consumer := range consumers {
+		values = append(values, []byte(consumer), []byte(strconv.Itoa(perConsumer[consumer])))
+	}
+	return s.createResponse(RESP_OK, s.encodeMGetResponse(values))
+}
+
+// handleXAutoClaim transfers pending entries idle for at least minIdleMs to
+// consumer, scanning the PEL from startID. The reply is the cursor to resume
+// from (0-0 once the scan is complete) followed by the claimed entries.
+func (s *DatabaseState) handleXAutoClaim(key, group, consumer string, minIdleMs int64, startID string, count int, now int64) []byte {
+	if minIdleMs < 0 {
+		minIdleMs = 0
+	}
+	if count == 0 {
+		count = 100
+	}
+
+	start, err := parseStreamBound(startID, false)
+	if err != nil {
+		return s.createResponse(RESP_ERROR, []byte("ERR Invalid stream ID specified as stream command argument"))
+	}
+
+	stream, errResp := s.loadStream(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if stream == nil {
+		return s.noGroupResponse(key, group)
+	}
+
+	claimed, next, ok := stream.AutoClaim(group, consumer, minIdleMs, start, count, commandMillis(now))
+	if !ok {
+		return s.noGroupResponse(key, group)
+	}
+
+	return s.createResponse(RESP_OK, s.encodeAutoClaimResponse(next.String(), claimed))
+}
+
+// Estimated stream entries per radix tree node, Redis' stream-node-max-entries
+const streamNodeMaxEntries = 100
+
+// handleXInfo reports on a stream, its consumer groups or the consumers of
+// one group. Each record is encoded as a field to value map; GROUPS and
+// CONSUMERS return an array of them.
+func (s *DatabaseState) handleXInfo(data []byte, now int64) []byte {
+	args := newArgReader(data)
+	subcommand := args.uint8()
+	key := args.string()
+	if args.err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid XINFO data"))
+	}
+
+	stream, errResp := s.loadStream(key, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	switch subcommand {
+	case XINFO_STREAM:
+		if stream == nil {
+			return s.createResponse(RESP_ERROR, []byte("ERR no such key"))
+		}
+
+		length := stream.Len()
+		radixKeys := (length + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+		info := map[string][]byte{
+			"length":            []byte(strconv.Itoa(length)),
+			"radix-tree-keys":   []byte(strconv.Itoa(radixKeys)),
+			"radix-tree-nodes":  []byte(strconv.Itoa(radixKeys + 1)),
+			"last-generated-id": []byte(stream.LastID().String()),
+			"groups":            []byte(strconv.Itoa(len(stream.GroupsInfo()))),
+		}
+		if first, last, ok := stream.Bounds(); ok {
+			info["first-entry-id"] = []byte(first.String())
+			info["last-entry-id"] = []byte(last.String())
+		}
+		return s.createResponse(RESP_OK, s.encodeHashMap(info))
+
+	case XINFO_GROUPS:
+		if stream == nil {
+			return s.createResponse(RESP_ERROR, []byte("ERR no such key"))
+		}
+
+		groups := stream.GroupsInfo()
+		records := make([][]byte, len(groups))
+		for i, group := range groups {
+			records[i] = s.encodeHashMap(map[string][]byte{
+				"name":              []byte(group.Name),
+				"consumers":         []byte(strconv.Itoa(group.Consumers)),
+				"pending":           []byte(strconv.Itoa(group.Pending)),
+				"last-delivered-id": []byte(group.LastDeliveredID.String()),
+			})
+		}
+		return s.createResponse(RESP_OK, s.encodeArray(records))
+
+	case XINFO_CONSUMERS:
+		group := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XINFO data"))
+		}
+		if stream == nil {
+			return s.noGroupResponse(key, group)
+		}
+
+		consumers, ok := stream.ConsumersInfo(group)
+		if !ok {
+			return s.noGroupResponse(key, group)
+		}
+
+		nowMillis := time.Now().UnixMilli()
+		records := make([][]byte, len(consumers))
+		for i, consumer := range consumers {
+			records[i] = s.encodeHashMap(map[string][]byte{
+				"name":    []byte(consumer.Name),
+				"pending": []byte(strconv.Itoa(consumer.Pending)),
+				"idle":    []byte(strconv.FormatInt(max(nowMillis-consumer.SeenAt, 0), 10)),
+			})
+		}
+		return s.createResponse(RESP_OK, s.encodeArray(records))
+
+	default:
+		return s.createResponse(RESP_ERROR, []byte("ERR unknown XINFO subcommand"))
+	}
+}
+
+// noGroupResponse is the NOGROUP error for a missing stream or group
+func (s *GoFastServer) noGroupResponse(key, group string) []byte {
+	return s.createResponse(RESP_ERROR, []byte(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, group)))
+}
+
+// parseStreamTrim validates the MAXLEN or MINID threshold selected by flags
+// and returns a function applying it, or nil when no trimming was requested
+func (s *GoFastServer) parseStreamTrim(flags uint8, threshold string) (func(*Stream) int, []byte) {
+	switch flags & (XTRIM_MAXLEN | XTRIM_MINID) {
+	case 0:
+		return nil, nil
+
+	case XTRIM_MAXLEN:
+		maxLen, err := strconv.Atoi(threshold)
+		if err != nil || maxLen < 0 {
+			return nil, s.createResponse(RESP_ERROR, []byte("ERR The MAXLEN argument must be >= 0."))
+		}
+		return func(stream *Stream) int { return stream.TrimMaxLen(maxLen) }, nil
+
+	case XTRIM_MINID:
+		minID, err := parseStreamID(threshold, 0)
+		if err != nil {
+			return nil, s.createResponse(RESP_ERROR, []byte("ERR Invalid stream ID specified as stream command argument"))
+		}
+		return func(stream *Stream) int { return stream.TrimMinID(minID) }, nil
+
+	default:
+		return nil, s.createResponse(RESP_ERROR, []byte("ERR syntax error"))
+	}
+}
+
+// parseStreamID parses "<millis>-<seq>", or a bare "<millis>" completed with
+// missingSeq
+func parseStreamID(s string, missingSeq uint64) (StreamID, error) {
+	millis, seq, hasSeq := strings.Cut(s, "-")
+
+	var id StreamID
+	var err error
+	if id.Millis, err = strconv.ParseUint(millis, 10, 64); err != nil {
+		return StreamID{}, err
+	}
+	id.Seq = missingSeq
+	if hasSeq {
+		if id.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return StreamID{}, err
+		}
+	}
+	return id, nil
+}
+
+// parseStreamBound parses an XRANGE bound: "-", "+", an ID, or an ID
+// prefixed with "(" to exclude it. A bare millisecond time covers all of
+// its sequence numbers.
+func parseStreamBound(s string, isEnd bool) (StreamID, error) {
+	switch s {
+	case "-":
+		return StreamID{}, nil
+	case "+":
+		return StreamID{Millis: math.MaxUint64, Seq: math.MaxUint64}, nil
+	}
+
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+
+	var missingSeq uint64
+	if isEnd {
+		missingSeq = math.MaxUint64
+	}
+	id, err := parseStreamID(s, missingSeq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+
+	// Step past the excluded ID
+	if isEnd {
+		if id == (StreamID{}) {
+			return StreamID{}, fmt.Errorf("invalid end ID for the interval")
+		}
+		if id.Seq == 0 {
+			return StreamID{Millis: id.Millis - 1, Seq: math.MaxUint64}, nil
+		}
+		id.Seq--
+		return id, nil
+	}
+	if id.Seq == math.MaxUint64 {
+		if id.Millis == math.MaxUint64 {
+			return StreamID{}, fmt.Errorf("invalid start ID for the interval")
+		}
+		return StreamID{Millis: id.Millis + 1}, nil
+	}
+	id.Seq++
+	return id, nil
+}
+
+// loadStream returns the live stream at key, or nil if the key is missing or
+// expired. On a type mismatch it returns a ready WRONGTYPE response.
+func (s *DatabaseState) loadStream(key string, now int64) (*Stream, []byte) {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return nil, nil
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return nil, nil
+	}
+
+	if item.DataType != TYPE_STREAM {
+		return nil, s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+	return item.Value.(*Stream), nil
+}
+
+// Add to handlers.go
+
+func (s *DatabaseState) handleIncr(key string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+
+	var currentValue int64 = 0
+
+	if exists {
+		item := existing.(*CacheItem)
+
+		// Check if expired
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+			// Will create new key with value 1
+		} else if item.DataType != TYPE_STRING {
+			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		} else {
+			// Parse current value
+			valueStr := string(item.stringValue())
+			if parsed, err := strconv.ParseInt(valueStr, 10, 64); err != nil {
+				return s.createResponse(RESP_ERROR, []byte("ERR value is not an integer or out of range"))
+			} else {
+				currentValue = parsed
+			}
+		}
+	}
+
+	// Increment
+	newValue := currentValue + 1
+	newValueStr := strconv.FormatInt(newValue, 10)
+
+	// Store the new value
+	item := &CacheItem{
+		DataType:  TYPE_STRING,
+		Value:     []byte(newValueStr),
+		CreatedAt: now,
+	}
+
+	// Preserve TTL if it existed
+	if exists {
+		if existingItem := existing.(*CacheItem); existingItem.ExpiresAt > 0 {
+			item.ExpiresAt = existingItem.ExpiresAt
+		}
+	}
+
+	s.storage.Store(key, item)
+	return s.createResponse(RESP_OK, []byte(newValueStr))
+}
+
+func (s *DatabaseState) handleDecr(key string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+
+	var currentValue int64 = 0
+
+	if exists {
+		item := existing.(*CacheItem)
+
+		// Check if expired
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+			// Will create new key with value -1
+		} else if item.DataType != TYPE_STRING {
+			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		} else {
+			// Parse current value
+			valueStr := string(item.stringValue())
+			if parsed, err := strconv.ParseInt(valueStr, 10, 64); err != nil {
+				return s.createResponse(RESP_ERROR, []byte("ERR value is not an integer or out of range"))
+			} else {
+				currentValue = parsed
+			}
+		}
+	}
+
+	// Decrement
+	newValue := currentValue - 1
+	newValueStr := strconv.FormatInt(newValue, 10)
+
+	// Store the new value
+	item := &CacheItem{
+		DataType:  TYPE_STRING,
+		Value:     []byte(newValueStr),
+		CreatedAt: now,
+	}
+
+	// Preserve TTL if it existed
+	if exists {
+		if existingItem := existing.(*CacheItem); existingItem.ExpiresAt > 0 {
+			item.ExpiresAt = existingItem.ExpiresAt
+		}
+	}
+
+	s.storage.Store(key, item)
+	return s.createResponse(RESP_OK, []byte(newValueStr))
+}
+
+func (s *DatabaseState) handleGetSet(key string, newValue []byte, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+
+	var oldValue []byte
+	var preserveTTL int64 = 0
+
+	if exists {
+		item := existing.(*CacheItem)
+
+		// Check if expired
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			s.expireKey(key)
+			// Treat as if key didn't exist
+		} else if item.DataType != TYPE_STRING {
+			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+		} else {
+			oldValue = item.stringValue()
+			preserveTTL = item.ExpiresAt
+		}
+	}
+
+	// Set the new value
+	item := &CacheItem{
+		DataType:  TYPE_STRING,
+		Value:     newValue,
+		CreatedAt: now,
+		ExpiresAt: preserveTTL, // Preserve existing TTL
+	}
+
+	s.storage.Store(key, item)
+
+	// Return old value or nil if key didn't exist
+	if oldValue != nil {
+		return s.createResponse(RESP_OK, oldValue)
+	}
+	return s.createResponse(RESP_NOT_FOUND, nil)
+}
+
+// Bit operation handlers
+
+// handleSetBit sets or clears the bit at offset, growing the string with zero
+// bytes as needed, and returns the previous bit
+func (s *DatabaseState) handleSetBit(key string, offset int, bit byte, now int64) []byte {
+	if bit > 1 {
+		return s.createResponse(RESP_ERROR, []byte("ERR bit is not an integer or out of range"))
+	}
+
+	item, errResp := s.loadString(key, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	var value []byte
+	var expiresAt int64
+	if item != nil {
+		value = item.stringValue()
+		expiresAt = item.ExpiresAt
+	}
+
+	// Write to a copy so readers holding the old value never see the change
+	byteIndex := offset >> 3
+	updated := make([]byte, max(len(value), byteIndex+1))
+	copy(updated, value)
+
+	mask := byte(0x80) >> (offset & 7)
+	old := "0"
+	if updated[byteIndex]&mask != 0 {
+		old = "1"
+	}
+	if bit == 1 {
+		updated[byteIndex] |= mask
+	} else {
+		updated[byteIndex] &^= mask
+	}
+
+	s.storage.Store(key, &CacheItem{
+		DataType:  TYPE_STRING,
+		Value:     updated,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	})
+	return s.createResponse(RESP_OK, []byte(old))
+}
+
+// handleGetBit returns the bit at offset, reading past the end of the string
+// (or a missing key) as 0
+func (s *DatabaseState) handleGetBit(key string, offset int, now int64) []byte {
+	item, errResp := s.loadString(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if item == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	value := item.stringValue()
+	byteIndex := offset >> 3
+	if byteIndex >= len(value) || value[byteIndex]&(byte(0x80)>>(offset&7)) == 0 {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+	return s.createResponse(RESP_OK, []byte("1"))
+}
+
+// handleBitCount counts the set bits of the string at key within the
+// inclusive byte (or bit) range start..end
+func (s *DatabaseState) handleBitCount(key string, start, end int, byBit bool, now int64) []byte {
+	item, errResp := s.loadString(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if item == nil {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	value := item.stringValue()
+	first, last, ok := bitRange(len(value), start, end, byBit)
+	if !ok {
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	count := 0
+	for i := first >> 3; i <= last>>3; i++ {
+		b := value[i]
+		if i == first>>3 {
+			b &= 0xFF >> (first & 7)
+		}
+		if i == last>>3 {
+			b &= 0xFF << (7 - last&7)
+		}
+		count += bits.OnesCount8(b)
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(count)))
+}
+
+// handleBitField runs the BITFIELD subcommands in order against the string
+// at key and returns one reply per GET, SET and INCRBY. A write refused by
+// OVERFLOW FAIL replies nil.
+func (s *DatabaseState) handleBitField(key string, ops []BitFieldOp, now int64) []byte {
+	for _, op := range ops {
+		if op.Op == BITFIELD_OVERFLOW {
+			if op.Overflow > BITFIELD_FAIL {
+				return s.createResponse(RESP_ERROR, []byte("ERR Invalid OVERFLOW type specified"))
+			}
+			continue
+		}
+		if op.Width < 1 || (op.Signed && op.Width > 64) || (!op.Signed && op.Width > 63) {
+			return s.createResponse(RESP_ERROR, []byte("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."))
+		}
+	}
+
+	item, errResp := s.loadString(key, now)
+	if errResp != nil {
+		return errResp
+	}
+
+	var value []byte
+	var expiresAt int64
+	if item != nil {
+		value = item.stringValue()
+		expiresAt = item.ExpiresAt
+	}
+
+	// Writes go to a copy so readers holding the old value never see them
+	written := false
+	overflow := uint8(BITFIELD_WRAP)
+	results := make([][]byte, 0, len(ops))
+	for _, op := range ops {
+		if op.Op == BITFIELD_OVERFLOW {
+			overflow = op.Overflow
+			continue
+		}
+
+		old := readBitField(value, op.Offset, op.Width, op.Signed)
+		if op.Op == BITFIELD_GET {
+			results = append(results, []byte(strconv.FormatInt(old, 10)))
+			continue
+		}
+
+		// SET checks the new value itself, INCRBY the sum
+		base, delta := op.Value, int64(0)
+		if op.Op == BITFIELD_INCRBY {
+			base, delta = old, op.Value
+		}
+		updated, ok := bitFieldAdd(base, delta, op.Width, op.Signed, overflow)
+		if !ok {
+			results = append(results, nil)
+			continue
+		}
+
+		if !written {
+			value = append([]byte(nil), value...)
+			written = true
+		}
+		if need := (op.Offset + op.Width + 7) >> 3; need > len(value) {
+			value = append(value, make([]byte, need-len(value))...)
+		}
+		writeBitField(value, op.Offset, op.Width, uint64(updated))
+
+		if op.Op == BITFIELD_SET {
+			results = append(results, []byte(strconv.FormatInt(old, 10)))
+		} else {
+			results = append(results, []byte(strconv.FormatInt(updated, 10)))
+		}
+	}
+
+	if written {
+		s.storage.Store(key, &CacheItem{
+			DataType:  TYPE_STRING,
+			Value:     value,
+			CreatedAt: now,
+			ExpiresAt: expiresAt,
+		})
+	}
+	return s.createResponse(RESP_OK, s.encodeMGetResponse(results))
+}
+
+// readBitField reads the width-bit integer stored most significant bit first
+// at bit offset, treating bits past the end of value as zero
+func readBitField(value []byte, offset, width int, signed bool) int64 {
+	var v uint64
+	for i := offset; i < offset+width; i++ {
+		v = v<<1 | uint64(byteAt(value, i>>3)>>(7-i&7)&1)
+	}
+	if signed && width < 64 && v&(1<<(width-1)) != 0 {
+		v |= ^uint64(0) << width
+	}
+	return int64(v)
+}
+
+// writeBitField stores the low width bits of v at bit offset. value must
+// already be long enough.
+func writeBitField(value []byte, offset, width int, v uint64) {
+	for i := offset + width - 1; i >= offset; i-- {
+		mask := byte(0x80) >> (i & 7)
+		if v&1 != 0 {
+			value[i>>3] |= mask
+		} else {
+			value[i>>3] &^= mask
+		}
+		v >>= 1
+	}
+}
+
+// bitFieldAdd computes value+incr for a width-bit integer, applying the
+// overflow behavior when the result does not fit. ok is false when FAIL
+// refuses the operation.
+func bitFieldAdd(value, incr int64, width int, signed bool, overflow uint8) (int64, bool) {
+	var max, min int64
+	if signed {
+		max = math.MaxInt64
+		if width < 64 {
+			max = 1<<(width-1) - 1
+		}
+		min = -max - 1
+	} else {
+		max = 1<<width - 1
+	}
+
+	// Written so that none of the comparisons can overflow an int64
+	high := value > max || (incr > 0 && value >= 0 && incr > max-value) || (incr > 0 && value < 0 && width < 64 && incr > max-value)
+	low := !high && (value < min || (incr < 0 && value <= 0 && incr < min-value) || (incr < 0 && value > 0 && width < 64 && incr < min-value))
+	if !high && !low {
+		return value + incr, true
+	}
+
+	switch overflow {
+	case BITFIELD_FAIL:
+		return 0, false
+	case BITFIELD_SAT:
+		if high {
+			return max, true
+		}
+		return min, true
+	}
+
+	// Wrap: keep the low width bits, sign-extending signed results
+	sum := uint64(value) + uint64(incr)
+	if width < 64 {
+		sum &= 1<<width - 1
+		if signed && sum&(1<<(width-1)) != 0 {
+			sum |= ^uint64(0) << width
+		}
+	}
+	return int64(sum), true
+}
+
+// handleBitPos returns the index of the first bit set to bit within the
+// inclusive byte (or bit) range start..end, or -1 if there is none. When
+// looking for a clear bit without an explicit range, the bits past the end of
+// the string count as clear, as in Redis.
+func (s *DatabaseState) handleBitPos(key string, bit byte, start, end int, byBit, hasRange bool, now int64) []byte {
+	if bit > 1 {
+		return s.createResponse(RESP_ERROR, []byte("ERR The bit argument must be 1 or 0."))
+	}
+
+	item, errResp := s.loadString(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if item == nil {
+		if bit == 0 {
+			return s.createResponse(RESP_OK, []byte("0"))
+		}
+		return s.createResponse(RESP_OK, []byte("-1"))
+	}
+
+	value := item.stringValue()
+	first, last, ok := bitRange(len(value), start, end, byBit)
+	if !ok {
+		return s.createResponse(RESP_OK, []byte("-1"))
+	}
+
+	for i := first >> 3; i <= last>>3; i++ {
+		b := value[i]
+		if bit == 0 {
+			b = ^b
+		}
+		if i == first>>3 {
+			b &= 0xFF >> (first & 7)
+		}
+		if i == last>>3 {
+			b &= 0xFF << (7 - last&7)
+		}
+		if b != 0 {
+			return s.createResponse(RESP_OK, []byte(strconv.Itoa(i*8+bits.LeadingZeros8(b))))
+		}
+	}
+
+	if bit == 0 && !hasRange {
+		return s.createResponse(RESP_OK, []byte(strconv.Itoa(len(value)*8)))
+	}
+	return s.createResponse(RESP_OK, []byte("-1"))
+}
+
+// handleBitOp combines the strings at keys byte by byte, treating shorter
+// or missing strings as zero-padded, and stores the result at dst. It returns
+// the length of the stored string.
+func (s *DatabaseState) handleBitOp(op uint8, dst string, keys []string, now int64) []byte {
+	if op > BITOP_NOT {
+		return s.createResponse(RESP_ERROR, []byte("ERR syntax error"))
+	}
+	if op == BITOP_NOT && len(keys) != 1 {
+		return s.createResponse(RESP_ERROR, []byte("ERR BITOP NOT must be called with a single source key."))
+	}
+
+	sources := make([][]byte, len(keys))
+	length := 0
+	for i, key := range keys {
+		item, errResp := s.loadString(key, now)
+		if errResp != nil {
+			return errResp
+		}
+		if item != nil {
+			sources[i] = item.stringValue()
+			length = max(length, len(sources[i]))
+		}
+	}
+
+	s.ttlMutex.Lock()
+	delete(s.ttlIndex, dst)
+	s.ttlMutex.Unlock()
+
+	if length == 0 {
+		s.storage.Delete(dst)
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	result := make([]byte, length)
+	for i := range result {
+		b := byteAt(sources[0], i)
+		for _, src := range sources[1:] {
+			switch op {
+			case BITOP_AND:
+				b &= byteAt(src, i)
+			case BITOP_OR:
+				b |= byteAt(src, i)
+			case BITOP_XOR:
+				b ^= byteAt(src, i)
+			}
+		}
+		if op == BITOP_NOT {
+			b = ^b
+		}
+		result[i] = b
+	}
+
+	s.storage.Store(dst, &CacheItem{
+		DataType:  TYPE_STRING,
+		Value:     result,
+		CreatedAt: now,
+	})
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(length)))
 }
 
-func (s *GoFastServer) handleHashDel(key string, field string, now int64) []byte {
-	existing, exists := s.storage.Load(key)
-	if !exists {
-		return s.createResponse(RESP_OK, []byte("0"))
+// byteAt returns value[i], reading past the end as a zero byte
+func byteAt(value []byte, i int) byte {
+	if i < len(value) {
+		return value[i]
 	}
+	return 0
+}
 
-	item := existing.(*CacheItem)
-	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
-		return s.createResponse(RESP_OK, []byte("0"))
+// bitRange resolves a Redis style inclusive range over a string of length
+// bytes, counting negative indices from the end, into absolute first and last
+// bit indices. ok is false when the range is empty.
+func bitRange(length, start, end int, byBit bool) (first, last int, ok bool) {
+	size := length
+	if byBit {
+		size = length * 8
 	}
 
-	if item.DataType != TYPE_HASH {
-		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	if start < 0 {
+		start += size
 	}
-
-	hash := item.Value.(*Hash)
-	removed := hash.Del(field)
-
-	// If hash is now empty, remove the key
-	if hash.Len() == 0 {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
+	if end < 0 {
+		end += size
+	}
+	start = max(start, 0)
+	end = min(max(end, 0), size-1)
+	if size == 0 || start > end {
+		return 0, 0, false
 	}
 
-	if removed {
-		return s.createResponse(RESP_OK, []byte("1"))
+	if byBit {
+		return start, end, true
 	}
-	return s.createResponse(RESP_OK, []byte("0"))
+	return start * 8, end*8 + 7, true
 }
 
-func (s *GoFastServer) handleHashGetAll(key string, now int64) []byte {
+// loadString returns the live string item at key, or nil if the key is missing
+// or expired. On a type mismatch it returns a ready WRONGTYPE response.
+func (s *DatabaseState) loadString(key string, now int64) (*CacheItem, []byte) {
 	existing, exists := s.storage.Load(key)
 	if !exists {
-		return s.createResponse(RESP_OK, s.encodeHashMap(map[string][]byte{}))
+		return nil, nil
 	}
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
-		return s.createResponse(RESP_OK, s.encodeHashMap(map[string][]byte{}))
+		s.expireKey(key)
+		return nil, nil
 	}
 
-	if item.DataType != TYPE_HASH {
-		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	if item.DataType != TYPE_STRING {
+		return nil, s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
 	}
-
-	hash := item.Value.(*Hash)
-	fields := hash.GetAll()
-
-	return s.createResponse(RESP_OK, s.encodeHashMap(fields))
+	return item, nil
 }
 
-func (s *GoFastServer) handleHashLen(key string, now int64) []byte {
+func (s *DatabaseState) handleExpireAt(key string, at int64, now int64) []byte {
 	existing, exists := s.storage.Load(key)
 	if !exists {
 		return s.createResponse(RESP_OK, []byte("0"))
@@ -828,6 +4350,12 @@ func (s *GoFastServer) handleHashLen(key string, now int64) []byte {
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, []byte("0"))
+	}
+
+	// A timestamp in the past expires the key immediately
+	if at <= now {
 		s.storage.Delete(key)
 		s.ttlMutex.Lock()
 		delete(s.ttlIndex, key)
@@ -835,186 +4363,287 @@ func (s *GoFastServer) handleHashLen(key string, now int64) []byte {
 		return s.createResponse(RESP_OK, []byte("0"))
 	}
 
-	if item.DataType != TYPE_HASH {
-		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
-	}
+	item.ExpiresAt = at
+	s.ttlMutex.Lock()
+	s.ttlIndex[key] = at
+	s.ttlMutex.Unlock()
 
-	hash := item.Value.(*Hash)
-	return s.createResponse(RESP_OK, []byte(fmt.Sprintf("%d", hash.Len())))
+	s.storage.Store(key, item)
+	return s.createResponse(RESP_OK, []byte("1"))
 }
 
-func (s *GoFastServer) handleHashExists(key string, field string, now int64) []byte {
+func (s *DatabaseState) handleExpireTime(key string, millis bool, now int64) []byte {
 	existing, exists := s.storage.Load(key)
 	if !exists {
-		return s.createResponse(RESP_OK, []byte("0"))
+		return s.createResponse(RESP_OK, []byte("-2"))
 	}
 
 	item := existing.(*CacheItem)
 	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-		s.storage.Delete(key)
-		s.ttlMutex.Lock()
-		delete(s.ttlIndex, key)
-		s.ttlMutex.Unlock()
-		return s.createResponse(RESP_OK, []byte("0"))
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, []byte("-2"))
 	}
 
-	if item.DataType != TYPE_HASH {
-		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	if item.ExpiresAt == 0 {
+		return s.createResponse(RESP_OK, []byte("-1")) // No expiration
 	}
 
-	hash := item.Value.(*Hash)
-	if hash.Exists(field) {
-		return s.createResponse(RESP_OK, []byte("1"))
+	expiresAt := item.ExpiresAt
+	if millis {
+		expiresAt *= 1000
 	}
-	return s.createResponse(RESP_OK, []byte("0"))
+	return s.createResponse(RESP_OK, []byte(strconv.FormatInt(expiresAt, 10)))
 }
 
-// Add to handlers.go
+// Encoding thresholds mirroring Redis defaults for compact representations
+const (
+	embstrMaxLen        = 44
+	listpackMaxEntries  = 128
+	listpackMaxValueLen = 64
+	intsetMaxEntries    = 512
+)
 
-func (s *GoFastServer) handleIncr(key string, now int64) []byte {
-	existing, exists := s.storage.Load(key)
+func (s *DatabaseState) handleObject(data []byte, now int64) []byte {
+	// Parse subcommand and key: [subcommand:1][keylen:4][key]
+	if len(data) < 5 {
+		return s.createResponse(RESP_ERROR, []byte("Invalid OBJECT data"))
+	}
 
-	var currentValue int64 = 0
+	keyLen := binary.BigEndian.Uint32(data[1:5])
+	if len(data) < 5+int(keyLen) {
+		return s.createResponse(RESP_ERROR, []byte("Invalid OBJECT data - key too long"))
+	}
+	key := string(data[5 : 5+keyLen])
 
-	if exists {
-		item := existing.(*CacheItem)
+	switch data[0] {
+	case CMD_OBJECT_ENCODING:
+		return s.handleObjectEncoding(key, now)
+	default:
+		return s.createResponse(RESP_ERROR, []byte("ERR unknown OBJECT subcommand"))
+	}
+}
 
-		// Check if expired
-		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
-			// Will create new key with value 1
-		} else if item.DataType != TYPE_STRING {
-			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
-		} else {
-			// Parse current value
-			valueStr := string(item.Value.([]byte))
-			if parsed, err := strconv.ParseInt(valueStr, 10, 64); err != nil {
-				return s.createResponse(RESP_ERROR, []byte("ERR value is not an integer or out of range"))
-			} else {
-				currentValue = parsed
-			}
-		}
+// handleMemoryUsage estimates the bytes key takes, sampling up to samples
+// elements of a collection (0 for all) and scaling to its size
+func (s *DatabaseState) handleMemoryUsage(key string, samples int, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_NOT_FOUND, nil)
 	}
 
-	// Increment
-	newValue := currentValue + 1
-	newValueStr := strconv.FormatInt(newValue, 10)
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
 
-	// Store the new value
-	item := &CacheItem{
-		DataType:  TYPE_STRING,
-		Value:     []byte(newValueStr),
-		CreatedAt: now,
+	usage := len(key) + int(unsafe.Sizeof(CacheItem{})) + valueMemoryUsage(item.Value, samples)
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(usage)))
+}
+
+// infoSections are the INFO sections in the order they are listed by
+// default
+var infoSections = []string{"server", "clients", "memory", "stats", "persistence", "keyspace"}
+
+// handleInfo reports server state as Redis-style "field:value" lines under
+// a "# Section" header. An empty section or "default" lists the default
+// sections, "all" adds commandstats, and an unknown one returns nothing.
+func (s *GoFastServer) handleInfo(section string) []byte {
+	section = strings.ToLower(section)
+	sections := []string{section}
+	switch section {
+	case "", "default":
+		sections = infoSections
+	case "all":
+		sections = append(infoSections[:len(infoSections):len(infoSections)], "commandstats")
 	}
 
-	// Preserve TTL if it existed
-	if exists {
-		if existingItem := existing.(*CacheItem); existingItem.ExpiresAt > 0 {
-			item.ExpiresAt = existingItem.ExpiresAt
+	var info strings.Builder
+	for _, name := range sections {
+		if info.Len() > 0 {
+			info.WriteString("\r\n")
 		}
+		s.writeInfoSection(&info, name)
 	}
-
-	s.storage.Store(key, item)
-	return s.createResponse(RESP_OK, []byte(newValueStr))
+	return s.createResponse(RESP_OK, []byte(info.String()))
 }
 
-func (s *GoFastServer) handleDecr(key string, now int64) []byte {
-	existing, exists := s.storage.Load(key)
-
-	var currentValue int64 = 0
+func (s *GoFastServer) writeInfoSection(info *strings.Builder, section string) {
+	field := func(name string, value any) {
+		fmt.Fprintf(info, "%s:%v\r\n", name, value)
+	}
+	flag := func(set bool) int {
+		if set {
+			return 1
+		}
+		return 0
+	}
 
-	if exists {
-		item := existing.(*CacheItem)
+	switch section {
+	case "server":
+		info.WriteString("# Server\r\n")
+		field("gofast_version", version)
+		field("go_version", runtime.Version())
+		field("os", runtime.GOOS+" "+runtime.GOARCH)
+		field("process_id", os.Getpid())
+		field("tcp_port", s.port)
+		field("uptime_in_seconds", int64(time.Since(s.startTime).Seconds()))
+
+	case "clients":
+		info.WriteString("# Clients\r\n")
+		field("connected_clients", s.connectedClients.Load())
+		field("blocked_clients", s.blockedClients.Load())
+
+	case "memory":
+		var memStats runtime.MemStats
+		runtime.ReadMemStats(&memStats)
+		policy := POLICY_NOEVICTION
+		if s.config != nil {
+			policy = s.config.MaxMemoryPolicy
+		}
+		info.WriteString("# Memory\r\n")
+		field("used_memory", memStats.Alloc)
+		field("used_memory_sys", memStats.Sys)
+		field("maxmemory", s.maxMemory)
+		field("maxmemory_policy", policy)
+
+	case "stats":
+		stats := s.GetStats()
+		info.WriteString("# Stats\r\n")
+		field("total_connections_received", stats.Connections)
+		field("total_commands_processed", stats.TotalOps)
+		field("total_net_input_bytes", stats.BytesRead)
+		field("total_net_output_bytes", stats.BytesWritten)
+		field("keyspace_hits", stats.Hits)
+		field("keyspace_misses", stats.Misses)
+		field("evicted_keys", stats.EvictedKeys)
+
+		// Latency percentiles in microseconds, by command name
+		names := make([]string, 0, len(stats.CmdLatencies))
+		latencies := make(map[string]*LatencyBuckets, len(stats.CmdLatencies))
+		for command, buckets := range stats.CmdLatencies {
+			name := strings.ToLower(commandName(command))
+			names = append(names, name)
+			latencies[name] = buckets
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			buckets := latencies[name]
+			field("latency_percentiles_usec_"+name, fmt.Sprintf("p50=%.3f,p95=%.3f,p99=%.3f",
+				float64(buckets.Percentile(50))/1e3, float64(buckets.Percentile(95))/1e3, float64(buckets.Percentile(99))/1e3))
+		}
 
-		// Check if expired
-		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
-			// Will create new key with value -1
-		} else if item.DataType != TYPE_STRING {
-			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
-		} else {
-			// Parse current value
-			valueStr := string(item.Value.([]byte))
-			if parsed, err := strconv.ParseInt(valueStr, 10, 64); err != nil {
-				return s.createResponse(RESP_ERROR, []byte("ERR value is not an integer or out of range"))
-			} else {
-				currentValue = parsed
+	case "persistence":
+		stats := s.GetStats()
+		info.WriteString("# Persistence\r\n")
+		field("aof_enabled", flag(s.aof != nil))
+		field("aof_rewrite_in_progress", flag(s.rewritingAOF.Load()))
+		field("rdb_bgsave_in_progress", flag(s.savingRDB.Load()))
+		field("rdb_last_save_time", stats.LastSaveTime)
+
+	case "commandstats":
+		s.writeCommandStats(info)
+
+	case "keyspace":
+		info.WriteString("# Keyspace\r\n")
+		for _, db := range s.databases {
+			keys := db.storage.Len()
+			if keys == 0 {
+				continue
 			}
+			db.ttlMutex.RLock()
+			expires := len(db.ttlIndex)
+			db.ttlMutex.RUnlock()
+			field(fmt.Sprintf("db%d", db.index), fmt.Sprintf("keys=%d,expires=%d", keys, expires))
 		}
 	}
+}
 
-	// Decrement
-	newValue := currentValue - 1
-	newValueStr := strconv.FormatInt(newValue, 10)
-
-	// Store the new value
-	item := &CacheItem{
-		DataType:  TYPE_STRING,
-		Value:     []byte(newValueStr),
-		CreatedAt: now,
+func (s *DatabaseState) handleObjectEncoding(key string, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_ERROR, []byte("ERR no such key"))
 	}
 
-	// Preserve TTL if it existed
-	if exists {
-		if existingItem := existing.(*CacheItem); existingItem.ExpiresAt > 0 {
-			item.ExpiresAt = existingItem.ExpiresAt
-		}
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_ERROR, []byte("ERR no such key"))
 	}
 
-	s.storage.Store(key, item)
-	return s.createResponse(RESP_OK, []byte(newValueStr))
-}
-
-func (s *GoFastServer) handleGetSet(key string, newValue []byte, now int64) []byte {
-	existing, exists := s.storage.Load(key)
+	var encoding string
+	switch item.DataType {
+	case TYPE_STRING:
+		value := item.stringValue()
+		if _, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			encoding = "int"
+		} else if len(value) <= embstrMaxLen {
+			encoding = "embstr"
+		} else {
+			encoding = "raw"
+		}
 
-	var oldValue []byte
-	var preserveTTL int64 = 0
+	case TYPE_LIST:
+		encoding = "quicklist"
+		if list := item.Value.(*List); list.Length() <= listpackMaxEntries {
+			encoding = "listpack"
+			for _, value := range list.Range(0, list.Length()-1) {
+				if len(value) > listpackMaxValueLen {
+					encoding = "quicklist"
+					break
+				}
+			}
+		}
 
-	if exists {
-		item := existing.(*CacheItem)
+	case TYPE_SET:
+		encoding = "hashtable"
+		if members := item.Value.(*Set).Members(); len(members) <= intsetMaxEntries {
+			encoding = "intset"
+			for _, member := range members {
+				if _, err := strconv.ParseInt(member, 10, 64); err != nil {
+					encoding = "hashtable"
+					break
+				}
+			}
+		}
 
-		// Check if expired
-		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
-			// Treat as if key didn't exist
-		} else if item.DataType != TYPE_STRING {
-			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
-		} else {
-			oldValue = item.Value.([]byte)
-			preserveTTL = item.ExpiresAt
+	case TYPE_HASH:
+		encoding = "hashtable"
+		if fields := item.Value.(*Hash).GetAll(); len(fields) <= listpackMaxEntries {
+			encoding = "listpack"
+			for field, value := range fields {
+				if len(field) > listpackMaxValueLen || len(value) > listpackMaxValueLen {
+					encoding = "hashtable"
+					break
+				}
+			}
 		}
-	}
 
-	// Set the new value
-	item := &CacheItem{
-		DataType:  TYPE_STRING,
-		Value:     newValue,
-		CreatedAt: now,
-		ExpiresAt: preserveTTL, // Preserve existing TTL
-	}
+	case TYPE_ZSET:
+		encoding = "skiplist"
+		if entries := item.Value.(*ZSet).Range(0, -1, false); len(entries) <= listpackMaxEntries {
+			encoding = "listpack"
+			for _, entry := range entries {
+				if len(entry.Member) > listpackMaxValueLen {
+					encoding = "skiplist"
+					break
+				}
+			}
+		}
 
-	s.storage.Store(key, item)
+	case TYPE_STREAM:
+		encoding = "stream"
 
-	// Return old value or nil if key didn't exist
-	if oldValue != nil {
-		return s.createResponse(RESP_OK, oldValue)
+	default:
+		return s.createResponse(RESP_ERROR, []byte("ERR unknown object type"))
 	}
-	return s.createResponse(RESP_NOT_FOUND, nil)
+
+	return s.createResponse(RESP_OK, []byte(encoding))
 }
 
 // Add to handlers.go
 
-func (s *GoFastServer) handleKeys(pattern string, now int64) []byte {
+func (s *DatabaseState) handleKeys(pattern string, now int64) []byte {
 	var matchingKeys []string
 
 	// Iterate through all keys in storage
@@ -1025,12 +4654,7 @@ func (s *GoFastServer) handleKeys(pattern string, now int64) []byte {
 		// Check if key is expired
 		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
 			// Mark for deletion (we'll clean up later)
-			go func(k string) {
-				s.storage.Delete(k)
-				s.ttlMutex.Lock()
-				delete(s.ttlIndex, k)
-				s.ttlMutex.Unlock()
-			}(keyStr)
+			go s.expireKey(keyStr)
 			return true // Continue iteration
 		}
 
@@ -1045,10 +4669,8 @@ func (s *GoFastServer) handleKeys(pattern string, now int64) []byte {
 	return s.createResponse(RESP_OK, s.encodeStringArray(matchingKeys))
 }
 
-func (s *GoFastServer) handleScan(cursor uint32, pattern string, count int, now int64) []byte {
-	var matchingKeys []string
+func (s *DatabaseState) handleScan(cursor uint32, pattern string, count int, now int64) []byte {
 	var keys []string
-	nextCursor := uint32(0)
 
 	// First, collect all non-expired keys
 	s.storage.Range(func(key, value any) bool {
@@ -1058,12 +4680,7 @@ func (s *GoFastServer) handleScan(cursor uint32, pattern string, count int, now
 		// Check if key is expired
 		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
 			// Mark for deletion
-			go func(k string) {
-				s.storage.Delete(k)
-				s.ttlMutex.Lock()
-				delete(s.ttlIndex, k)
-				s.ttlMutex.Unlock()
-			}(keyStr)
+			go s.expireKey(keyStr)
 			return true
 		}
 
@@ -1071,33 +4688,141 @@ func (s *GoFastServer) handleScan(cursor uint32, pattern string, count int, now
 		return true
 	})
 
-	// Sort keys for consistent iteration
-	sort.Strings(keys)
+	nextCursor, matchingKeys := s.scanPage(keys, cursor, pattern, count)
+	return s.createResponse(RESP_OK, s.encodeScanResponse(nextCursor, matchingKeys))
+}
+
+// handleSetScan iterates the members of a set with the same cursor
+// semantics as SCAN
+func (s *DatabaseState) handleSetScan(key string, cursor uint32, pattern string, count int, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeScanResponse(0, []string{}))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeScanResponse(0, []string{}))
+	}
+
+	if item.DataType != TYPE_SET {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	nextCursor, members := s.scanPage(item.Value.(*Set).Members(), cursor, pattern, count)
+	return s.createResponse(RESP_OK, s.encodeScanResponse(nextCursor, members))
+}
+
+// handleHashScan iterates the fields of a hash with the same cursor semantics
+// as SCAN, returning each matching field with its value
+func (s *DatabaseState) handleHashScan(key string, cursor uint32, pattern string, count int, now int64) []byte {
+	existing, exists := s.storage.Load(key)
+	if !exists {
+		return s.createResponse(RESP_OK, s.encodeHashScanResponse(0, nil, nil))
+	}
+
+	item := existing.(*CacheItem)
+	if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+		s.expireKey(key)
+		return s.createResponse(RESP_OK, s.encodeHashScanResponse(0, nil, nil))
+	}
+
+	if item.DataType != TYPE_HASH {
+		return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
+	}
+
+	// Snapshot the hash so fields and values stay consistent
+	snapshot := item.Value.(*Hash).GetAll()
+	fields := make([]string, 0, len(snapshot))
+	for field := range snapshot {
+		fields = append(fields, field)
+	}
+
+	nextCursor, fields := s.scanPage(fields, cursor, pattern, count)
+	values := make([][]byte, len(fields))
+	for i, field := range fields {
+		values[i] = snapshot[field]
+	}
+	return s.createResponse(RESP_OK, s.encodeHashScanResponse(nextCursor, fields, values))
+}
 
+// handleZScan iterates the members of a sorted set in score order with the
+// same cursor semantics as SCAN, returning each matching member with its score
+func (s *DatabaseState) handleZScan(key string, cursor uint32, pattern string, count int, now int64) []byte {
+	zset, errResp := s.loadZSet(key, now)
+	if errResp != nil {
+		return errResp
+	}
+	if zset == nil {
+		return s.createResponse(RESP_OK, s.encodeZScanResponse(0, nil))
+	}
+
+	entries := zset.Range(0, -1, false)
+	members := make([]string, len(entries))
+	scores := make(map[string]float64, len(entries))
+	for i, entry := range entries {
+		members[i] = entry.Member
+		scores[entry.Member] = entry.Score
+	}
+
+	nextCursor, members := s.scanOrdered(members, cursor, pattern, count)
+	page := make([]ZEntry, len(members))
+	for i, member := range members {
+		page[i] = ZEntry{Member: member, Score: scores[member]}
+	}
+	return s.createResponse(RESP_OK, s.encodeZScanResponse(nextCursor, page))
+}
+
+// scanPage sorts items for a stable iteration order, takes up to count
+// entries starting at cursor and filters them by pattern. The returned
+// cursor is 0 once iteration is complete.
+func (s *DatabaseState) scanPage(items []string, cursor uint32, pattern string, count int) (uint32, []string) {
+	sort.Strings(items)
+	return s.scanOrdered(items, cursor, pattern, count)
+}
+
+// scanOrdered is scanPage for items that already have a stable order
+func (s *GoFastServer) scanOrdered(items []string, cursor uint32, pattern string, count int) (uint32, []string) {
 	// Apply cursor-based pagination
 	startIndex := int(cursor)
-	if startIndex >= len(keys) {
-		// Cursor is beyond available keys, return empty result
-		return s.createResponse(RESP_OK, s.encodeScanResponse(0, []string{}))
+	if startIndex >= len(items) {
+		// Cursor is beyond available items, return empty result
+		return 0, []string{}
 	}
 
-	// Collect up to 'count' keys starting from cursor position
+	// Collect up to 'count' items starting from cursor position
+	nextCursor := uint32(0)
 	endIndex := startIndex + count
-	if endIndex > len(keys) {
-		endIndex = len(keys)
-		nextCursor = 0 // No more keys
+	if endIndex >= len(items) {
+		endIndex = len(items) // No more items
 	} else {
 		nextCursor = uint32(endIndex)
 	}
 
 	// Filter by pattern
+	matching := []string{}
 	for i := startIndex; i < endIndex; i++ {
-		if s.matchPattern(pattern, keys[i]) {
-			matchingKeys = append(matchingKeys, keys[i])
+		if s.matchPattern(pattern, items[i]) {
+			matching = append(matching, items[i])
 		}
 	}
+	return nextCursor, matching
+}
 
-	return s.createResponse(RESP_OK, s.encodeScanResponse(nextCursor, matchingKeys))
+// parseScanArgs decodes the [cursor:4][patternlen:4][pattern] arguments of
+// the per-key SCAN variants, followed by an optional [count:4]
+func parseScanArgs(data []byte) (cursor uint32, pattern string, count int, err error) {
+	args := newArgReader(data)
+	cursor = args.uint32()
+	pattern = args.string()
+	count = 10
+	if args.err == nil && args.offset < len(data) {
+		if n := int(args.uint32()); n > 0 {
+			count = n
+		}
+	}
+	return cursor, pattern, count, args.err
 }
 
 // Helper function for pattern matching (supports * and ? wildcards)
@@ -1108,11 +4833,11 @@ func (s *GoFastServer) matchPattern(pattern, key string) bool {
 	}
 
 	// Simple pattern matching implementation
-	return s.wildcardMatch(pattern, key)
+	return wildcardMatch(pattern, key)
 }
 
 // Wildcard matching function
-func (s *GoFastServer) wildcardMatch(pattern, str string) bool {
+func wildcardMatch(pattern, str string) bool {
 	i, j := 0, 0
 	starIdx, match := -1, 0
 
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// BenchmarkListPush compares pushing N values with N single-value LPUSH
+// commands against one LPUSH carrying all N
+func BenchmarkListPush(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		values := make([][]byte, n)
+		batch := binary.BigEndian.AppendUint32(nil, uint32(n))
+		for i := range values {
+			values[i] = appendLenPrefixed(nil, fmt.Appendf(nil, "value-%d", i))
+			batch = append(batch, values[i]...)
+		}
+
+		b.Run(fmt.Sprintf("single/%d", n), func(b *testing.B) {
+			db := NewGoFastServer(0).databases[0]
+			for b.Loop() {
+				for _, value := range values {
+					db.handleListPush("list", value, true, 0)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("batch/%d", n), func(b *testing.B) {
+			db := NewGoFastServer(0).databases[0]
+			for b.Loop() {
+				db.handleListPush("list", batch, true, 0)
+			}
+		})
+	}
+}
+
+// TestConfigSetLogLevel changes log_level at runtime and checks a level
+// outside the config file's list is refused
+func TestConfigSetLogLevel(t *testing.T) {
+	s := NewGoFastServer(0)
+	s.SetConfig(DefaultConfig())
+	defer setLogLevel(DefaultConfig().LogLevel)
+
+	if err := s.configSet("log_level", "DEBUG"); err != nil {
+		t.Fatalf("CONFIG SET log_level DEBUG: %v", err)
+	}
+	if s.config.LogLevel != "debug" || minLogLevel.Load() != LOG_DEBUG {
+		t.Errorf("log_level = %q at level %d, want debug", s.config.LogLevel, minLogLevel.Load())
+	}
+	if err := s.configSet("log_level", "verbose"); err == nil {
+		t.Error("CONFIG SET log_level verbose succeeded")
+	}
+	if s.config.LogLevel != "debug" {
+		t.Errorf("log_level = %q after a refused SET, want debug", s.config.LogLevel)
+	}
+}
+
+// TestListPopCountReturnsArray checks LPOP with a count replies with an
+// array even when it pops a single element
+func TestListPopCountReturnsArray(t *testing.T) {
+	s := NewGoFastServer(0)
+	key := []byte("list")
+	push := binary.BigEndian.AppendUint32(nil, 1)
+	for _, count := range []uint32{1, 5} {
+		s.processCommand(nil, &Message{Command: CMD_RPUSH, Key: key, Value: appendLenPrefixed(push, []byte("a"))})
+		response := s.processCommand(nil, &Message{Command: CMD_LPOP, Key: key,
+			Value: binary.BigEndian.AppendUint32([]byte{POP_FLAG_COUNT}, count)})
+		values, ok := decodeArray(response[5:], 1)
+		if response[0] != RESP_OK || !ok || len(values) != 1 || string(values[0]) != "a" {
+			t.Errorf("LPOP list %d = %q, want [a]", count, response)
+		}
+	}
+
+	response := s.processCommand(nil, &Message{Command: CMD_LPOP, Key: key})
+	if response[0] != RESP_NOT_FOUND {
+		t.Errorf("LPOP on an empty list = %q, want nil", response)
+	}
+}
+
+// TestHashIncrByFloatInfinityLeavesNoKey checks a refused HINCRBYFLOAT on a
+// missing key does not create it
+func TestHashIncrByFloatInfinityLeavesNoKey(t *testing.T) {
+	db := NewGoFastServer(0).databases[0]
+	if response := db.handleHashIncrByFloat("hash", "field", math.Inf(1), 0); response[0] != RESP_ERROR {
+		t.Fatalf("HINCRBYFLOAT hash field inf = %q, want an error", response)
+	}
+	if _, exists := db.storage.Load("hash"); exists {
+		t.Error("refused HINCRBYFLOAT left the key behind")
+	}
+}
+
+// TestBlockingTimeoutRejectsNonFinite checks NaN and infinite timeouts are
+// refused rather than blocking for an undefined time
+func TestBlockingTimeoutRejectsNonFinite(t *testing.T) {
+	keys := appendLenPrefixed(binary.BigEndian.AppendUint32(nil, 1), []byte("list"))
+	for _, seconds := range []float32{float32(math.NaN()), float32(math.Inf(1)), float32(math.Inf(-1)), -1, math.MaxFloat32} {
+		if _, _, err := parseBlockingArgs(binary.BigEndian.AppendUint32(keys, math.Float32bits(seconds))); err == nil {
+			t.Errorf("timeout %v accepted", seconds)
+		}
+	}
+	if _, timeout, err := parseBlockingArgs(binary.BigEndian.AppendUint32(keys, math.Float32bits(1.5))); err != nil || timeout != 1500*time.Millisecond {
+		t.Errorf("timeout 1.5 = %v, %v", timeout, err)
+	}
+}
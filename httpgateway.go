@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// httpMaxBodySize bounds PUT request bodies
+const httpMaxBodySize = 64 * 1024 * 1024
+
+// HTTPGateway exposes string keys over a JSON REST API for scripts and
+// dashboards without a binary driver:
+//
+//	GET    /keys/{key}       read a value
+//	PUT    /keys/{key}       write {"value": "...", "ttl": seconds}
+//	DELETE /keys/{key}       delete a key
+//	GET    /keys?pattern=*   list matching keys
+type HTTPGateway struct {
+	server     *GoFastServer
+	httpServer *http.Server
+}
+
+// httpSetRequest is the body of PUT /keys/{key}
+type httpSetRequest struct {
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl"` // Seconds, 0 for no expiry
+}
+
+// httpUserKey is the request context key holding the authenticated ACL user
+type httpUserKey struct{}
+
+// httpKeyResponse is the body returned for a single key
+type httpKeyResponse struct {
+	Key     string `json:"key"`
+	Value   string `json:"value,omitempty"`
+	Deleted int    `json:"deleted,omitempty"`
+}
+
+func NewHTTPGateway(server *GoFastServer, address string) *HTTPGateway {
+	g := &HTTPGateway{server: server}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys/{key}", g.handleGet)
+	mux.HandleFunc("PUT /keys/{key}", g.handleSet)
+	mux.HandleFunc("DELETE /keys/{key}", g.handleDel)
+	mux.HandleFunc("GET /keys", g.handleKeys)
+
+	g.httpServer = &http.Server{Addr: address, Handler: g.authenticate(mux)}
+	return g
+}
+
+// Start listens on the gateway address and serves requests in the
+// background, over HTTPS when TLS is enabled
+func (g *HTTPGateway) Start() error {
+	listener, err := net.Listen("tcp", g.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start HTTP gateway: %v", err)
+	}
+
+	config := g.server.config
+	if config != nil && config.TLSEnabled {
+		tlsConfig, err := loadTLSConfig(config)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to start HTTP gateway: %v", err)
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	go func() {
+		if err := g.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logf(LOG_ERROR, "HTTP gateway error: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (g *HTTPGateway) Stop() {
+	g.httpServer.Close()
+}
+
+// authenticate resolves the request's ACL user: Basic credentials name a
+// user, while "Authorization: Bearer <password>" authenticates the default
+// user. Anonymous requests act as the default user unless require_auth is set.
+func (g *HTTPGateway) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.server.ipFilter.AllowsAddr(r.RemoteAddr) {
+			writeJSONError(w, http.StatusForbidden, "client IP not allowed")
+			return
+		}
+
+		ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+		username := ACL_DEFAULT_USER
+		authenticated := !g.server.aclManager.RequiresAuth()
+		var retryAfter int64
+		if r.TLS != nil && g.server.clientCertUser(*r.TLS) != "" {
+			username = g.server.clientCertUser(*r.TLS)
+			authenticated = true
+		} else if user, password, ok := r.BasicAuth(); ok {
+			username = user
+			authenticated, retryAfter = g.server.authenticateClient(ip, user, password)
+		} else if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			authenticated, retryAfter = g.server.authenticateClient(ip, ACL_DEFAULT_USER, token)
+		}
+
+		if retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+			writeJSONError(w, http.StatusTooManyRequests, "too many authentication failures")
+			return
+		}
+
+		if !authenticated {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gofast"`)
+			w.Header().Add("WWW-Authenticate", `Basic realm="gofast"`)
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), httpUserKey{}, username)))
+	})
+}
+
+func (g *HTTPGateway) handleGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	response, ok := g.run(w, r, CMD_GET, appendLenPrefixed(nil, []byte(key)))
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, httpKeyResponse{Key: key, Value: string(response[5:])})
+}
+
+func (g *HTTPGateway) handleSet(w http.ResponseWriter, r *http.Request) {
+	var body httpSetRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, httpMaxBodySize)).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	key := r.PathValue("key")
+	payload := appendLenPrefixed(nil, []byte(key))
+	payload = binary.BigEndian.AppendUint32(payload, body.TTL)
+	payload = appendLenPrefixed(payload, []byte(body.Value))
+	if _, ok := g.run(w, r, CMD_SET, payload); !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, httpKeyResponse{Key: key, Value: body.Value})
+}
+
+func (g *HTTPGateway) handleDel(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	response, ok := g.run(w, r, CMD_DEL, appendLenPrefixed(nil, []byte(key)))
+	if !ok {
+		return
+	}
+	deleted, _ := strconv.Atoi(string(response[5:]))
+	if deleted == 0 {
+		writeJSONError(w, http.StatusNotFound, "key not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, httpKeyResponse{Key: key, Deleted: deleted})
+}
+
+func (g *HTTPGateway) handleKeys(w http.ResponseWriter, r *http.Request) {
+	pattern := r.URL.Query().Get("pattern")
+	if pattern == "" {
+		pattern = "*"
+	}
+	response, ok := g.run(w, r, CMD_KEYS, appendLenPrefixed(nil, []byte(pattern)))
+	if !ok {
+		return
+	}
+
+	values, valid := decodeArray(response[5:], 1)
+	if !valid {
+		writeJSONError(w, http.StatusInternalServerError, "malformed reply")
+		return
+	}
+	keys := make([]string, len(values))
+	for i, value := range values {
+		keys[i] = string(value)
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"keys": keys})
+}
+
+// run executes one command and writes the error reply itself when the
+// command fails, reporting whether the caller should write a success body
+func (g *HTTPGateway) run(w http.ResponseWriter, r *http.Request, command uint8, payload []byte) ([]byte, bool) {
+	msg, err := g.server.decodeMessage(command, payload)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	state := &connState{username: r.Context().Value(httpUserKey{}).(string)}
+	state.clientIP, _, _ = net.SplitHostPort(r.RemoteAddr)
+	if g.server.rateLimited(state) {
+		writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return nil, false
+	}
+
+	response := g.server.processCommand(state, msg)
+	switch response[0] {
+	case RESP_NOT_FOUND:
+		writeJSONError(w, http.StatusNotFound, "key not found")
+		return nil, false
+	case RESP_ERROR:
+		status := http.StatusBadRequest
+		if strings.HasPrefix(string(response[5:]), "WRONGTYPE") {
+			status = http.StatusConflict
+		} else if strings.HasPrefix(string(response[5:]), "NOPERM") {
+			status = http.StatusForbidden
+		}
+		writeJSONError(w, status, string(response[5:]))
+		return nil, false
+	}
+	return response, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
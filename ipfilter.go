@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// IPFilter decides which client addresses may connect. A denied block
+// always wins; with no allowed blocks every other address is accepted.
+type IPFilter struct {
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+}
+
+// ParseIPFilter parses IPv4 and IPv6 CIDR blocks such as "10.0.0.0/8" or
+// "fd00::/8"
+func ParseIPFilter(allowed, denied []string) (*IPFilter, error) {
+	filter := &IPFilter{}
+	var err error
+	if filter.allowed, err = parseCIDRs(allowed); err != nil {
+		return nil, err
+	}
+	if filter.denied, err = parseCIDRs(denied); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	blocks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, block, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// Allows reports whether a client at ip may connect
+func (f *IPFilter) Allows(ip net.IP) bool {
+	if f == nil {
+		return true
+	}
+	for _, block := range f.denied {
+		if block.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allowed) == 0 {
+		return true
+	}
+	for _, block := range f.allowed {
+		if block.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP of a connection's peer, or "" when it has none
+func remoteIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil || net.ParseIP(host) == nil {
+		return ""
+	}
+	return host
+}
+
+// AllowsAddr checks a "host:port" remote address. Addresses without an IP,
+// such as Unix socket peers, are always allowed.
+func (f *IPFilter) AllowsAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return true
+	}
+	return f.Allows(ip)
+}
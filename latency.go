@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LatencyMaxSamples is how many samples each latency event keeps. With
+// one sample per second this is the last six minutes.
+const LatencyMaxSamples = 360
+
+// latencyGraphWidth is the length of the longest LATENCY GRAPH bar
+const latencyGraphWidth = 50
+
+// Latency events
+const (
+	LATENCY_EVENT_COMMAND      = "command"      // Every command processed
+	LATENCY_EVENT_EXPIRY_CYCLE = "expiry-cycle" // A pass removing expired keys
+)
+
+// LatencySample is the highest latency of an event within one second
+type LatencySample struct {
+	ts        int64 // Unix seconds
+	latencyMs float64
+}
+
+// recordLatencySample adds an occurrence of event that took elapsed,
+// folding it into the sample of the current second
+func (s *GoFastServer) recordLatencySample(event string, elapsed time.Duration) {
+	now := time.Now().Unix()
+	latencyMs := float64(elapsed) / float64(time.Millisecond)
+
+	s.latencyMutex.Lock()
+	defer s.latencyMutex.Unlock()
+
+	if s.latencySamples == nil {
+		s.latencySamples = make(map[string][]LatencySample)
+	}
+	samples := s.latencySamples[event]
+	if last := len(samples) - 1; last >= 0 && samples[last].ts == now {
+		samples[last].latencyMs = max(samples[last].latencyMs, latencyMs)
+		return
+	}
+	if len(samples) == LatencyMaxSamples {
+		copy(samples, samples[1:])
+		samples = samples[:LatencyMaxSamples-1]
+	}
+	s.latencySamples[event] = append(samples, LatencySample{ts: now, latencyMs: latencyMs})
+}
+
+// handleLatency reads or clears the latency history of an event
+// Format: [subcommand:1][eventlen:4][event], the event being optional for
+// RESET, which then clears every event
+func (s *GoFastServer) handleLatency(data []byte) []byte {
+	args := newArgReader(data)
+	subcommand := args.uint8()
+	event := ""
+	if args.offset < len(data) {
+		event = args.string()
+	}
+	if args.err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid LATENCY data"))
+	}
+
+	s.latencyMutex.Lock()
+	defer s.latencyMutex.Unlock()
+
+	switch subcommand {
+	case LATENCY_HISTORY:
+		// Each element is [ts:8][ms:8 float64]
+		samples := s.latencySamples[event]
+		values := make([][]byte, len(samples))
+		for i, sample := range samples {
+			value := binary.BigEndian.AppendUint64(nil, uint64(sample.ts))
+			values[i] = binary.BigEndian.AppendUint64(value, math.Float64bits(sample.latencyMs))
+		}
+		return s.createResponse(RESP_OK, s.encodeArray(values))
+
+	case LATENCY_RESET:
+		reset := len(s.latencySamples)
+		if event == "" {
+			s.latencySamples = nil
+		} else if _, ok := s.latencySamples[event]; ok {
+			delete(s.latencySamples, event)
+			reset = 1
+		} else {
+			reset = 0
+		}
+		return s.createResponse(RESP_OK, []byte(strconv.Itoa(reset)))
+
+	case LATENCY_GRAPH:
+		samples := s.latencySamples[event]
+		if len(samples) == 0 {
+			return s.createResponse(RESP_ERROR, []byte(fmt.Sprintf("ERR No samples available for event '%s'", event)))
+		}
+		return s.createResponse(RESP_OK, []byte(latencyGraph(event, samples)))
+
+	default:
+		return s.createResponse(RESP_ERROR, []byte("Unknown LATENCY subcommand"))
+	}
+}
+
+// latencyGraph draws one bar per sample, oldest first, scaled so the
+// highest latency fills latencyGraphWidth columns
+func latencyGraph(event string, samples []LatencySample) string {
+	high, low := samples[0].latencyMs, samples[0].latencyMs
+	for _, sample := range samples {
+		high = max(high, sample.latencyMs)
+		low = min(low, sample.latencyMs)
+	}
+
+	var graph strings.Builder
+	fmt.Fprintf(&graph, "%s - high %.3f ms, low %.3f ms\n", event, high, low)
+	for _, sample := range samples {
+		width := 1
+		if high > 0 {
+			width = max(1, int(math.Round(sample.latencyMs/high*latencyGraphWidth)))
+		}
+		fmt.Fprintf(&graph, "%s %10.3f ms |%-*s|\n",
+			time.Unix(sample.ts, 0).Format(time.TimeOnly), sample.latencyMs, latencyGraphWidth, strings.Repeat("#", width))
+	}
+	return graph.String()
+}
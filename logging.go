@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"sync/atomic"
+)
+
+// Log levels, from the most to the least verbose
+const (
+	LOG_TRACE = iota
+	LOG_DEBUG
+	LOG_INFO
+	LOG_WARN
+	LOG_ERROR
+	LOG_FATAL
+)
+
+// logLevelNames are the log_level settings, indexed by level
+var logLevelNames = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// minLogLevel is the least severe level logf writes, set by log_level
+var minLogLevel atomic.Int32
+
+func init() {
+	minLogLevel.Store(LOG_INFO)
+}
+
+// setLogLevel makes logf drop messages below the named level. An unknown
+// name, which Validate refuses, leaves the level unchanged.
+func setLogLevel(name string) {
+	if level := slices.Index(logLevelNames, name); level >= 0 {
+		minLogLevel.Store(int32(level))
+	}
+}
+
+// logf logs a message at level unless log_level is set above it
+func logf(level int, format string, args ...any) {
+	if int32(level) < minLogLevel.Load() {
+		return
+	}
+	log.Printf(format, args...)
+}
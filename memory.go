@@ -1,6 +1,102 @@
 package main
 
-import "sync"
+import (
+	"sync"
+	"unsafe"
+)
+
+// mapEntryOverhead approximates what a Go map spends per entry beyond the
+// key and value: its share of the bucket's hash bytes and the free slots
+// kept by the load factor
+const mapEntryOverhead = 16
+
+// valueMemoryUsage estimates the bytes a stored value takes. Collections
+// are measured on up to samples elements, 0 meaning all of them, and the
+// average scaled to their length.
+func valueMemoryUsage(value any, samples int) int {
+	switch v := value.(type) {
+	case []byte:
+		return len(v)
+
+	case *List:
+		v.mutex.RLock()
+		defer v.mutex.RUnlock()
+		sum, n := 0, 0
+		for node := v.head; node != nil && (samples == 0 || n < samples); node = node.next {
+			sum += int(unsafe.Sizeof(*node)) + len(node.value)
+			n++
+		}
+		return int(unsafe.Sizeof(*v)) + scaleSample(sum, n, v.length)
+
+	case *Set:
+		v.mutex.RLock()
+		defer v.mutex.RUnlock()
+		sum, n := 0, 0
+		for member := range v.members {
+			if samples > 0 && n == samples {
+				break
+			}
+			sum += int(unsafe.Sizeof(member)) + len(member) + mapEntryOverhead
+			n++
+		}
+		return int(unsafe.Sizeof(*v)) + scaleSample(sum, n, len(v.members))
+
+	case *Hash:
+		v.mutex.RLock()
+		defer v.mutex.RUnlock()
+		sum, n := 0, 0
+		for field, val := range v.fields {
+			if samples > 0 && n == samples {
+				break
+			}
+			sum += int(unsafe.Sizeof(field)+unsafe.Sizeof(val)) + len(field) + len(val) + mapEntryOverhead
+			n++
+		}
+		return int(unsafe.Sizeof(*v)) + scaleSample(sum, n, len(v.fields))
+
+	case *ZSet:
+		v.mutex.RLock()
+		defer v.mutex.RUnlock()
+		// Every member is in the dict and in a skip list node with, on
+		// average, two levels
+		perMember := int(unsafe.Sizeof("")+unsafe.Sizeof(float64(0))) + mapEntryOverhead +
+			int(unsafe.Sizeof(SkipListNode{})) + 2*int(unsafe.Sizeof(SkipListLevel{}))
+		sum, n := 0, 0
+		for member := range v.dict {
+			if samples > 0 && n == samples {
+				break
+			}
+			sum += perMember + len(member)
+			n++
+		}
+		return int(unsafe.Sizeof(*v)) + scaleSample(sum, n, len(v.dict))
+
+	case *Stream:
+		v.mutex.RLock()
+		defer v.mutex.RUnlock()
+		sum, n := 0, 0
+		for _, entry := range v.entries {
+			if samples > 0 && n == samples {
+				break
+			}
+			sum += int(unsafe.Sizeof(entry))
+			for _, field := range entry.Fields {
+				sum += int(unsafe.Sizeof(field)) + len(field)
+			}
+			n++
+		}
+		return int(unsafe.Sizeof(*v)) + scaleSample(sum, n, len(v.entries))
+	}
+	return 0
+}
+
+// scaleSample extrapolates the size of n sampled elements to total
+func scaleSample(sum, n, total int) int {
+	if n == 0 {
+		return 0
+	}
+	return sum * total / n
+}
 
 func NewBytePool() *BytePool {
 	return &BytePool{
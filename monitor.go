@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// monitorBuffer is how many lines a monitor may fall behind before lines
+// are dropped rather than slowing every command down
+const monitorBuffer = 1024
+
+// monitorConn is the wire protocol a monitor is served over
+type monitorConn interface {
+	readMessage() (*Message, error)
+	writeLine(line string) error       // One monitored command
+	writeStatus(response []byte) error // Reply to a command sent while monitoring
+	flush() error
+}
+
+func (c *binaryConn) writeLine(line string) error {
+	return c.writeStatus(c.server.createResponse(RESP_OK, []byte(line)))
+}
+
+func (c *binaryConn) writeStatus(response []byte) error {
+	return c.server.writeFramedResponse(c.writer, response, c.protocol, c.server.wireCompression(), 0)
+}
+
+func (c *respConn) writeLine(line string) error {
+	c.writer.WriteSimple(line)
+	return nil
+}
+
+func (c *respConn) writeStatus(response []byte) error {
+	c.writer.WriteResponse(respText, response)
+	return nil
+}
+
+// feedMonitors sends every monitor a line describing msg, run by state:
+// "timestamp [db client] COMMAND key"
+func (s *GoFastServer) feedMonitors(state *connState, msg *Message) {
+	s.monitorsMutex.RLock()
+	defer s.monitorsMutex.RUnlock()
+	if len(s.monitors) == 0 {
+		return
+	}
+
+	db, client := 0, "internal"
+	if state != nil {
+		db, client = state.activeDB, state.clientAddr
+	}
+	now := time.Now()
+	line := fmt.Sprintf("%d.%06d [%d %s] %s", now.Unix(), now.Nanosecond()/1000, db, client, commandName(msg.Command))
+	if len(msg.Key) > 0 {
+		line += " " + quoteMonitorArg(msg.Key)
+	}
+
+	for _, monitor := range s.monitors {
+		select {
+		case monitor <- line:
+		default:
+		}
+	}
+}
+
+// quoteMonitorArg quotes arg as Redis does in MONITOR output, escaping
+// quotes, backslashes and control characters and writing other bytes
+// outside printable ASCII as \xHH, so a key cannot break the line
+func quoteMonitorArg(arg []byte) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range arg {
+		switch c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if c < 0x20 || c > 0x7e {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// serveMonitor runs a connection in monitor mode after MONITOR, streaming
+// a line for every command the server runs. It returns nil once the client
+// sends RESET, handing the reader back to the normal command loop.
+func (s *GoFastServer) serveMonitor(conn monitorConn, state *connState) error {
+	lines := make(chan string, monitorBuffer)
+	s.monitorsMutex.Lock()
+	s.monitors = append(s.monitors, lines)
+	s.monitorsMutex.Unlock()
+	defer s.removeMonitor(lines)
+
+	if err := conn.writeStatus(s.createResponse(RESP_OK, []byte("OK"))); err != nil {
+		return err
+	}
+	conn.flush()
+
+	// As in subscription mode, the reader goroutine waits to hear whether
+	// the connection is still monitoring before reading on
+	commands := make(chan *Message)
+	resume := make(chan bool)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			msg, err := conn.readMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case commands <- msg:
+			case <-done:
+				return
+			}
+			select {
+			case stay := <-resume:
+				if !stay {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case line := <-lines:
+			if err := conn.writeLine(line); err != nil {
+				return err
+			}
+			// Batch whatever else is queued into one flush
+			for len(lines) > 0 {
+				if err := conn.writeLine(<-lines); err != nil {
+					return err
+				}
+			}
+			conn.flush()
+
+		case msg := <-commands:
+			s.incrementStat("total_ops")
+			stay := msg.Command != CMD_RESET
+			if stay {
+				conn.writeStatus(s.createResponse(RESP_ERROR, []byte("ERR only RESET is allowed in monitor mode")))
+			} else {
+				conn.writeStatus(s.handleReset(state))
+			}
+			conn.flush()
+			resume <- stay
+			if !stay {
+				return nil
+			}
+
+		case err := <-readErr:
+			return err
+		}
+	}
+}
+
+func (s *GoFastServer) removeMonitor(lines chan string) {
+	s.monitorsMutex.Lock()
+	defer s.monitorsMutex.Unlock()
+	for i, monitor := range s.monitors {
+		if monitor == lines {
+			s.monitors = append(s.monitors[:i], s.monitors[i+1:]...)
+			return
+		}
+	}
+}
+
+// handleReset returns the connection to its initial state: out of any
+// transaction, watching nothing and on database 0. Authentication is kept.
+func (s *GoFastServer) handleReset(state *connState) []byte {
+	if state != nil {
+		s.unwatch(state)
+		state.reset()
+		state.activeDB = 0
+	}
+	return s.createResponse(RESP_OK, []byte("RESET"))
+}
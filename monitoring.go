@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics mirrors ServerStats as Prometheus metrics. Each server has its
+// own registry, so several servers can run in one process.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	counters     map[string]prometheus.Counter // By incrementStat name
+	bytesRead    prometheus.Counter
+	bytesWritten prometheus.Counter
+
+	commandDuration prometheus.Histogram
+}
+
+// metricStats maps the incrementStat names exported as counters to their
+// metric names and help text
+var metricStats = map[string]prometheus.CounterOpts{
+	"total_ops":    {Name: "gofast_commands_total", Help: "Commands processed."},
+	"get_ops":      {Name: "gofast_get_ops_total", Help: "GET commands processed."},
+	"set_ops":      {Name: "gofast_set_ops_total", Help: "SET commands processed."},
+	"del_ops":      {Name: "gofast_del_ops_total", Help: "DEL commands processed."},
+	"connections":  {Name: "gofast_connections_total", Help: "Client connections accepted."},
+	"evicted_keys": {Name: "gofast_evicted_keys_total", Help: "Keys evicted to stay under max_memory."},
+	"hits":         {Name: "gofast_keyspace_hits_total", Help: "GET commands that found their key."},
+	"misses":       {Name: "gofast_keyspace_misses_total", Help: "GET commands whose key was missing or expired."},
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		counters: make(map[string]prometheus.Counter, len(metricStats)),
+		bytesRead: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gofast_bytes_read_total",
+			Help: "Bytes of binary protocol requests read.",
+		}),
+		bytesWritten: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "gofast_bytes_written_total",
+			Help: "Bytes of binary protocol responses written.",
+		}),
+		commandDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gofast_command_duration_seconds",
+			Help:    "Time taken to process a command.",
+			Buckets: []float64{1e-6, 1e-5, 1e-4, 1e-3, 1e-2, 1e-1, 1},
+		}),
+	}
+	for stat, opts := range metricStats {
+		m.counters[stat] = prometheus.NewCounter(opts)
+		m.registry.MustRegister(m.counters[stat])
+	}
+	m.registry.MustRegister(m.bytesRead, m.bytesWritten, m.commandDuration)
+	return m
+}
+
+// count increments the counter mirroring an incrementStat statistic
+func (m *Metrics) count(stat string) {
+	if counter, ok := m.counters[stat]; ok {
+		counter.Inc()
+	}
+}
+
+// observeCommand records the duration of a command
+func (m *Metrics) observeCommand(elapsed time.Duration) {
+	m.commandDuration.Observe(elapsed.Seconds())
+}
+
+// StartMetricsServer serves the server's metrics for Prometheus to scrape
+// on /metrics at addr, in the background
+func StartMetricsServer(s *GoFastServer, addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start metrics server: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}))
+	httpServer := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logf(LOG_ERROR, "Metrics server error: %v", err)
+		}
+	}()
+	return httpServer, nil
+}
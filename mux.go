@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"sync"
+)
+
+// muxReadyQueueSize bounds how many finished requests may wait for the
+// writer before workers block
+const muxReadyQueueSize = 1024
+
+// muxMaxInFlight bounds how many requests of one connection may run at
+// once. Once that many are running, the connection is not read from until
+// one finishes.
+const muxMaxInFlight = 128
+
+// muxConn serves a protocol version 2 connection. Every request carries an
+// ID and runs in its own goroutine, and its response is written as soon as
+// it is ready with the same ID, so replies may arrive out of order.
+type muxConn struct {
+	server   *GoFastServer
+	writer   *bufio.Writer
+	protocol uint8 // Fixed for the connection's life, even across HELLO
+
+	pending sync.Map      // Request ID -> chan []byte receiving its response
+	ready   chan uint32   // IDs whose response is waiting in pending
+	slots   chan struct{} // One per running worker, up to muxMaxInFlight
+	workers sync.WaitGroup
+}
+
+// serveMux runs a multiplexed connection until it fails, or returns nil
+// once HELLO switches it to another protocol version. In-flight requests are
+// answered before it returns; when the connection failed, blocked ones are
+// woken first.
+func (s *GoFastServer) serveMux(reader *bufio.Reader, writer *bufio.Writer, state *connState) (err error) {
+	c := &muxConn{
+		server:   s,
+		writer:   writer,
+		protocol: state.protocol,
+		ready:    make(chan uint32, muxReadyQueueSize),
+		slots:    make(chan struct{}, muxMaxInFlight),
+	}
+
+	writeDone := make(chan struct{})
+	go func() {
+		c.writeLoop()
+		close(writeDone)
+	}()
+	defer func() {
+		if err != nil {
+			state.disconnect()
+		}
+		c.workers.Wait()
+		close(c.ready)
+		<-writeDone
+	}()
+
+	for {
+		msg, err := s.readFramedMessage(reader, c.protocol, s.wireCompression())
+		if err != nil {
+			return err
+		}
+
+		// Responses are looked up by ID, so a client may not reuse one
+		// until its response has arrived
+		result := make(chan []byte, 1)
+		if _, inFlight := c.pending.LoadOrStore(msg.RequestID, result); inFlight {
+			return fmt.Errorf("request ID %d is already in flight", msg.RequestID)
+		}
+
+		switch {
+		case s.rateLimited(state):
+			c.respond(msg.RequestID, result, s.createResponse(RESP_ERROR, []byte("ERR rate limit exceeded")))
+
+		case msg.Command == CMD_HELLO:
+			// The framing may change, so earlier requests finish first
+			c.workers.Wait()
+			c.respond(msg.RequestID, result, s.handleHello(state, msg.Value))
+			if state.protocol != c.protocol {
+				return nil
+			}
+
+		case msg.Command == CMD_AUTH:
+			// Workers read the user, so it only changes between them
+			c.workers.Wait()
+			c.respond(msg.RequestID, result, s.handleAuth(state, msg.Value))
+
+		case msg.Command == CMD_SUBSCRIBE || msg.Command == CMD_PSUBSCRIBE:
+			s.incrementStat("total_ops")
+			c.respond(msg.RequestID, result, s.createResponse(RESP_ERROR, []byte("SUBSCRIBE is not supported on multiplexed connections")))
+
+		case state.inMulti || isTransactionCommand(msg.Command):
+			// Queuing and EXEC depend on the order commands arrived in, and
+			// EXEC may SELECT
+			c.workers.Wait()
+			c.respond(msg.RequestID, result, s.processTransaction(state, msg))
+
+		case msg.Command == CMD_SELECT || msg.Command == CMD_RESET || msg.Command == CMD_PIPELINE:
+			// Workers read the database and watched keys, so they only
+			// change between them, and a pipeline may SELECT
+			c.workers.Wait()
+			c.respond(msg.RequestID, result, s.processCommand(state, msg))
+
+		default:
+			c.slots <- struct{}{} // Waits for a worker to finish when full
+			c.workers.Add(1)
+			go func() {
+				defer c.workers.Done()
+				defer func() { <-c.slots }()
+				c.respond(msg.RequestID, result, s.processCommand(state, msg))
+			}()
+		}
+	}
+}
+
+// respond hands a finished response to the writer
+func (c *muxConn) respond(requestID uint32, result chan []byte, response []byte) {
+	result <- response
+	c.ready <- requestID
+}
+
+// writeLoop writes responses in the order they finish, flushing whenever
+// no other response is waiting. After a write error it keeps draining so
+// workers never block; the read side sees the broken connection.
+func (c *muxConn) writeLoop() {
+	var writeErr error
+	for requestID := range c.ready {
+		result, _ := c.pending.LoadAndDelete(requestID)
+		response := <-result.(chan []byte)
+		if writeErr != nil {
+			continue
+		}
+
+		writeErr = c.server.writeFramedResponse(c.writer, response, c.protocol, c.server.wireCompression(), requestID)
+		if writeErr == nil && len(c.ready) == 0 {
+			writeErr = c.writer.Flush()
+		}
+		if writeErr != nil {
+			logf(LOG_WARN, "Write error: %v", writeErr)
+		}
+	}
+}
@@ -0,0 +1,437 @@
+// Package persist stores the server's data on disk
+package persist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// AOF_SYNC_INTERVAL is how often buffered records are flushed and synced to
+// disk, bounding what a crash can lose
+const AOF_SYNC_INTERVAL = time.Second
+
+// aofMaxRecord bounds a single record so a corrupt length cannot make
+// replay allocate arbitrary memory
+const aofMaxRecord = 1 << 30
+
+// aofHeaderSize is the fixed part of a record after its length:
+// [time:8][db:1][cmd:1][ttl:4][keylen:4]
+const aofHeaderSize = 18
+
+// ErrTruncatedAOF reports a file whose last record was only partly written,
+// as happens when the server dies mid-append
+var ErrTruncatedAOF = errors.New("AOF ends with a truncated record")
+
+// errCorruptRecord reports a record whose lengths are out of range
+var errCorruptRecord = errors.New("corrupt AOF record")
+
+// ErrAOFClosed is returned when appending to a closed AOF
+var ErrAOFClosed = errors.New("AOF is closed")
+
+// Record is one logged command
+type Record struct {
+	Time    int64 // Unix seconds the command ran at, so relative TTLs replay exactly
+	DB      uint8
+	Command uint8
+	TTL     uint32
+	Key     []byte
+	Value   []byte
+}
+
+// AOFWriter appends records to an append-only file. Writes are buffered
+// and flushed every AOF_SYNC_INTERVAL, or by Flush. With a cipher each
+// record is encrypted as one chunk, so a file cut short by a crash still
+// ends cleanly at its last whole record.
+type AOFWriter struct {
+	path   string
+	cipher *Cipher
+	file   *os.File
+	writer *bufio.Writer
+	out    io.Writer // writer, or an EncryptingWriter over it
+	mutex  sync.Mutex
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// OpenAOF opens path for appending, creating it if needed. cipher encrypts
+// the file and is nil for plain text.
+func OpenAOF(path string, cipher *Cipher) (*AOFWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open AOF: %w", err)
+	}
+
+	w := &AOFWriter{
+		path:   path,
+		cipher: cipher,
+		file:   file,
+		writer: bufio.NewWriterSize(file, 64*1024),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	w.out = w.writer
+	if cipher != nil {
+		if w.out, err = resumeAOF(path, w.writer, cipher); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to open AOF: %w", err)
+		}
+	}
+	go w.syncLoop()
+	return w, nil
+}
+
+func resumeAOF(path string, w io.Writer, cipher *Cipher) (*EncryptingWriter, error) {
+	existing, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer existing.Close()
+	return ResumeEncryptingWriter(existing, w, cipher)
+}
+
+// Append logs one record
+func (w *AOFWriter) Append(record Record) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return ErrAOFClosed
+	}
+	return writeRecord(w.out, record)
+}
+
+// writeRecord encodes one record with a single Write, which makes it one
+// chunk when encrypted
+// Format: [len:4][time:8][db:1][cmd:1][ttl:4][keylen:4][key][value]
+func writeRecord(w io.Writer, record Record) error {
+	buf := make([]byte, 4+aofHeaderSize, 4+aofHeaderSize+len(record.Key)+len(record.Value))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(aofHeaderSize+len(record.Key)+len(record.Value)))
+	binary.BigEndian.PutUint64(buf[4:12], uint64(record.Time))
+	buf[12] = record.DB
+	buf[13] = record.Command
+	binary.BigEndian.PutUint32(buf[14:18], record.TTL)
+	binary.BigEndian.PutUint32(buf[18:22], uint32(len(record.Key)))
+	buf = append(buf, record.Key...)
+	buf = append(buf, record.Value...)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// readRecord decodes the next record and its encoded size. A clean end of
+// the records is io.EOF and a partial record io.ErrUnexpectedEOF.
+func readRecord(r io.Reader) (Record, int64, error) {
+	var lengthBuf [4]byte
+	if _, err := io.ReadFull(r, lengthBuf[:]); err != nil {
+		return Record{}, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length < aofHeaderSize || length > aofMaxRecord {
+		return Record{}, 0, errCorruptRecord
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, 0, err
+	}
+
+	keyLen := binary.BigEndian.Uint32(data[14:18])
+	if uint64(keyLen) > uint64(length-aofHeaderSize) {
+		return Record{}, 0, errCorruptRecord
+	}
+	record := Record{
+		Time:    int64(binary.BigEndian.Uint64(data[0:8])),
+		DB:      data[8],
+		Command: data[9],
+		TTL:     binary.BigEndian.Uint32(data[10:14]),
+		Key:     data[aofHeaderSize : aofHeaderSize+keyLen],
+		Value:   data[aofHeaderSize+keyLen:],
+	}
+	return record, 4 + int64(length), nil
+}
+
+// Flush writes buffered records and syncs the file to disk
+func (w *AOFWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Offset flushes buffered records and returns the length of the file, the
+// point a rewrite started from
+func (w *AOFWriter) Offset() (int64, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err := w.writer.Flush(); err != nil {
+		return 0, err
+	}
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Rewrite compacts the file: records, which must recreate the state the
+// first base bytes of the file left behind, replace those bytes. Appends
+// continue while the records are written and are only held while the
+// records appended since base are copied after them and the new file is
+// renamed into place.
+func (w *AOFWriter) Rewrite(records []Record, base int64) error {
+	file, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".rewrite-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // No-op once renamed
+
+	// On success the file becomes the AOF and stays open
+	if err := w.rewrite(file, records, base); err != nil {
+		file.Close()
+		return err
+	}
+	return nil
+}
+
+func (w *AOFWriter) rewrite(file *os.File, records []Record, base int64) error {
+	writer := bufio.NewWriterSize(file, 64*1024)
+	var out io.Writer = writer
+	if w.cipher != nil {
+		encrypting, err := NewEncryptingWriter(writer, w.cipher)
+		if err != nil {
+			return err
+		}
+		out = encrypting
+	}
+	for _, record := range records {
+		if err := writeRecord(out, record); err != nil {
+			return err
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return ErrAOFClosed
+	}
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+
+	// Copy the records appended while the new ones were written, which in
+	// an encrypted file are re-encrypted under the new file's nonce
+	old, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	var tail io.Reader
+	if w.cipher != nil {
+		if tail, err = SeekDecryptingReader(old, w.cipher, base); err != nil {
+			return err
+		}
+	} else {
+		if _, err := old.Seek(base, io.SeekStart); err != nil {
+			return err
+		}
+		tail = old
+	}
+	tail = bufio.NewReaderSize(tail, 64*1024)
+	for {
+		record, _, err := readRecord(tail)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeRecord(out, record); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), w.path); err != nil {
+		return err
+	}
+	// The renamed file is still open at its end, ready to take appends
+	w.file.Close()
+	w.file = file
+	w.writer = writer
+	w.out = out
+	return nil
+}
+
+func (w *AOFWriter) syncLoop() {
+	defer close(w.done)
+	ticker := time.NewTicker(AOF_SYNC_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.Flush(); err != nil {
+				log.Printf("AOF sync error: %v", err)
+			}
+		}
+	}
+}
+
+// Close flushes every record and closes the file. Later appends fail with
+// ErrAOFClosed.
+func (w *AOFWriter) Close() error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mutex.Unlock()
+
+	close(w.stop)
+	<-w.done
+
+	err := w.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// TruncateAOF drops what follows the valid prefix ReplayAOF returned. An
+// encrypted file is copied under a fresh nonce instead of cut, since the
+// next append would otherwise reuse the nonce of the dropped chunk.
+func TruncateAOF(path string, cipher *Cipher, valid int64) error {
+	if cipher == nil || valid == 0 {
+		return os.Truncate(path, valid)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".truncate-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // No-op once renamed
+
+	err = copyValidPrefix(file, path, cipher, valid)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// copyValidPrefix re-encrypts the records in the first valid bytes of the
+// encrypted file at path into file
+func copyValidPrefix(file *os.File, path string, cipher *Cipher, valid int64) error {
+	old, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	decrypting, err := NewDecryptingReader(bufio.NewReaderSize(io.LimitReader(old, valid), 64*1024), cipher)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriterSize(file, 64*1024)
+	out, err := NewEncryptingWriter(writer, cipher)
+	if err != nil {
+		return err
+	}
+	for {
+		record, _, err := readRecord(decrypting)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeRecord(out, record); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// ReplayAOF calls apply with every record in path, in order, and returns
+// the length of the valid prefix. A missing file replays nothing. When the
+// file ends with a partial record the error is ErrTruncatedAOF, and the
+// caller may pass the returned length to TruncateAOF to keep appending.
+func ReplayAOF(path string, cipher *Cipher, apply func(Record) error) (int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open AOF: %w", err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = bufio.NewReaderSize(file, 64*1024)
+	var valid, read int64
+	offset := func() int64 { return read }
+	if cipher != nil {
+		decrypting, err := NewDecryptingReader(reader, cipher)
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return 0, ErrTruncatedAOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		// Records are whole chunks, so they end where a chunk does
+		reader, offset = decrypting, decrypting.Offset
+	}
+
+	for {
+		record, size, err := readRecord(reader)
+		switch {
+		case err == io.EOF:
+			return valid, nil
+		case err == io.ErrUnexpectedEOF:
+			return valid, ErrTruncatedAOF
+		case err == errCorruptRecord:
+			return valid, fmt.Errorf("corrupt AOF record at offset %d", valid)
+		case err != nil:
+			return valid, err
+		}
+
+		if err := apply(record); err != nil {
+			return valid, err
+		}
+		read += size
+		valid = offset()
+	}
+}
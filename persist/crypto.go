@@ -0,0 +1,226 @@
+package persist
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// encryptionNonceSize is the length of the random nonce every encrypted
+// file starts with
+const encryptionNonceSize = 12
+
+// encryptionKeyInfo binds derived keys to their use, so the same key file
+// yields different keys anywhere else it is used
+const encryptionKeyInfo = "gofast persistence encryption"
+
+// ErrDecrypt reports data that fails authentication: the file was written
+// with another key, or has been modified
+var ErrDecrypt = errors.New("failed to decrypt: wrong encryption key or corrupt data")
+
+// Cipher encrypts persistence files with AES-256-GCM
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// LoadCipher derives the AES-256 key from the contents of keyFile with
+// HKDF-SHA256
+func LoadCipher(keyFile string) (*Cipher, error) {
+	secret, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("encryption key file %s is empty", keyFile)
+	}
+
+	key, err := hkdf.Key(sha256.New, secret, nil, encryptionKeyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// chunkNonce derives the nonce of the counter-th chunk from the file's
+// nonce, so no two chunks share one and chunks cannot be reordered
+func chunkNonce(base [encryptionNonceSize]byte, counter uint64) []byte {
+	nonce := base
+	binary.BigEndian.PutUint64(nonce[4:], binary.BigEndian.Uint64(nonce[4:])^counter)
+	return nonce[:]
+}
+
+// EncryptingWriter encrypts everything written to it. Output starts with
+// the file's nonce, then every Write is sealed as one chunk:
+// [len:4][ciphertext and tag]. Callers choose the chunk boundaries, which
+// are the points a reader can stop at cleanly.
+type EncryptingWriter struct {
+	w       io.Writer
+	cipher  *Cipher
+	nonce   [encryptionNonceSize]byte
+	counter uint64
+}
+
+// NewEncryptingWriter starts an encrypted file on w with a random nonce
+func NewEncryptingWriter(w io.Writer, c *Cipher) (*EncryptingWriter, error) {
+	e := &EncryptingWriter{w: w, cipher: c}
+	if _, err := rand.Read(e.nonce[:]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(e.nonce[:]); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ResumeEncryptingWriter continues the encrypted file read by existing,
+// writing the chunks that follow its last one to w. An empty file is
+// started afresh.
+func ResumeEncryptingWriter(existing io.ReadSeeker, w io.Writer, c *Cipher) (*EncryptingWriter, error) {
+	end, err := existing.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if end == 0 {
+		return NewEncryptingWriter(w, c)
+	}
+
+	nonce, counter, err := walkChunks(existing, end)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptingWriter{w: w, cipher: c, nonce: nonce, counter: counter}, nil
+}
+
+func (e *EncryptingWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	chunk := make([]byte, 4, 4+len(p)+e.cipher.aead.Overhead())
+	chunk = e.cipher.aead.Seal(chunk, chunkNonce(e.nonce, e.counter), p, nil)
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(chunk)-4))
+	e.counter++
+
+	if _, err := e.w.Write(chunk); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// DecryptingReader reads what an EncryptingWriter wrote. A file cut off
+// inside a chunk reads as io.ErrUnexpectedEOF.
+type DecryptingReader struct {
+	r       io.Reader
+	cipher  *Cipher
+	nonce   [encryptionNonceSize]byte
+	counter uint64
+	plain   []byte // Decrypted bytes not yet read
+	offset  int64  // File bytes up to the end of the last chunk read
+}
+
+// NewDecryptingReader reads the file's nonce from r. An empty file returns
+// io.EOF.
+func NewDecryptingReader(r io.Reader, c *Cipher) (*DecryptingReader, error) {
+	d := &DecryptingReader{r: r, cipher: c, offset: encryptionNonceSize}
+	if _, err := io.ReadFull(r, d.nonce[:]); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SeekDecryptingReader reads the encrypted file r from offset, which must
+// be a chunk boundary
+func SeekDecryptingReader(r io.ReadSeeker, c *Cipher, offset int64) (*DecryptingReader, error) {
+	nonce, counter, err := walkChunks(r, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &DecryptingReader{r: r, cipher: c, nonce: nonce, counter: counter, offset: offset}, nil
+}
+
+func (d *DecryptingReader) Read(p []byte) (int, error) {
+	if len(d.plain) == 0 {
+		if err := d.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *DecryptingReader) readChunk() error {
+	var lengthBuf [4]byte
+	if _, err := io.ReadFull(d.r, lengthBuf[:]); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length > aofMaxRecord+uint32(d.cipher.aead.Overhead()) {
+		return ErrDecrypt
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	plain, err := d.cipher.aead.Open(sealed[:0], chunkNonce(d.nonce, d.counter), sealed, nil)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.counter++
+	d.offset += 4 + int64(length)
+	d.plain = plain
+	return nil
+}
+
+// Offset returns the length of the file up to the end of the last chunk
+// read
+func (d *DecryptingReader) Offset() int64 {
+	return d.offset
+}
+
+// walkChunks reads the nonce of the encrypted file r and skips its chunks
+// up to end without decrypting them, returning the counter of the chunk at
+// end. r is left positioned at end.
+func walkChunks(r io.ReadSeeker, end int64) ([encryptionNonceSize]byte, uint64, error) {
+	var nonce [encryptionNonceSize]byte
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nonce, 0, err
+	}
+	if _, err := io.ReadFull(r, nonce[:]); err != nil {
+		return nonce, 0, fmt.Errorf("encrypted file has no nonce: %w", err)
+	}
+
+	var counter uint64
+	var lengthBuf [4]byte
+	for offset := int64(encryptionNonceSize); offset < end; counter++ {
+		if _, err := io.ReadFull(r, lengthBuf[:]); err != nil {
+			return nonce, 0, fmt.Errorf("encrypted file ends inside a chunk: %w", err)
+		}
+		offset += 4 + int64(binary.BigEndian.Uint32(lengthBuf[:]))
+		if offset > end {
+			return nonce, 0, fmt.Errorf("offset %d is not a chunk boundary", end)
+		}
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nonce, 0, err
+		}
+	}
+	return nonce, counter, nil
+}
@@ -0,0 +1,160 @@
+package persist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// RDB_MAGIC opens every snapshot file
+const RDB_MAGIC = "GOFAST"
+
+// RDB_VERSION is the snapshot format written by WriteRDB
+const RDB_VERSION = 1
+
+// ErrRDBChecksum reports a snapshot whose contents do not match its checksum
+var ErrRDBChecksum = errors.New("RDB checksum mismatch")
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Entry is one key of a snapshot. Value is encoded by the caller according
+// to Type.
+type Entry struct {
+	DB        uint8
+	Key       []byte
+	Type      uint8
+	ExpiresAt int64 // Unix seconds, 0 means no expiration
+	Value     []byte
+}
+
+// WriteRDB writes a snapshot holding entries
+// Format: [magic:6][version:2][numkeys:8][entry1][entry2]...[checksum:8]
+// with each entry [db:1][keylen:4][key][type:1][ttl:8][valuelen:4][value].
+// The checksum is the CRC-64 (ECMA) of everything before it.
+func WriteRDB(w io.Writer, entries []Entry) error {
+	hash := crc64.New(crcTable)
+	// The buffered writer keeps its first error for Flush to report
+	writer := bufio.NewWriterSize(io.MultiWriter(w, hash), 64*1024)
+
+	header := make([]byte, 0, len(RDB_MAGIC)+10)
+	header = append(header, RDB_MAGIC...)
+	header = binary.BigEndian.AppendUint16(header, RDB_VERSION)
+	header = binary.BigEndian.AppendUint64(header, uint64(len(entries)))
+	writer.Write(header)
+
+	var fixed [13]byte
+	for _, entry := range entries {
+		fixed[0] = entry.DB
+		binary.BigEndian.PutUint32(fixed[1:5], uint32(len(entry.Key)))
+		writer.Write(fixed[:5])
+		writer.Write(entry.Key)
+
+		fixed[0] = entry.Type
+		binary.BigEndian.PutUint64(fixed[1:9], uint64(entry.ExpiresAt))
+		binary.BigEndian.PutUint32(fixed[9:13], uint32(len(entry.Value)))
+		writer.Write(fixed[:13])
+		writer.Write(entry.Value)
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	_, err := w.Write(binary.BigEndian.AppendUint64(nil, hash.Sum64()))
+	return err
+}
+
+// ReadRDB reads a snapshot written by WriteRDB. Entries are only returned
+// once the whole file has matched its checksum.
+func ReadRDB(r io.Reader) ([]Entry, error) {
+	hash := crc64.New(crcTable)
+	buffered := bufio.NewReaderSize(r, 64*1024)
+	reader := io.TeeReader(buffered, hash)
+
+	header := make([]byte, len(RDB_MAGIC)+10)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("failed to read RDB header: %w", err)
+	}
+	if string(header[:len(RDB_MAGIC)]) != RDB_MAGIC {
+		return nil, fmt.Errorf("not an RDB file")
+	}
+	if version := binary.BigEndian.Uint16(header[len(RDB_MAGIC):]); version != RDB_VERSION {
+		return nil, fmt.Errorf("unsupported RDB version %d", version)
+	}
+	count := binary.BigEndian.Uint64(header[len(RDB_MAGIC)+2:])
+
+	var entries []Entry
+	var fixed [13]byte
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(reader, fixed[:5]); err != nil {
+			return nil, fmt.Errorf("truncated RDB entry %d: %w", i, err)
+		}
+		entry := Entry{DB: fixed[0]}
+		key, err := readBytes(reader, binary.BigEndian.Uint32(fixed[1:5]))
+		if err != nil {
+			return nil, fmt.Errorf("truncated RDB entry %d: %w", i, err)
+		}
+		entry.Key = key
+
+		if _, err := io.ReadFull(reader, fixed[:13]); err != nil {
+			return nil, fmt.Errorf("truncated RDB entry %d: %w", i, err)
+		}
+		entry.Type = fixed[0]
+		entry.ExpiresAt = int64(binary.BigEndian.Uint64(fixed[1:9]))
+		if entry.Value, err = readBytes(reader, binary.BigEndian.Uint32(fixed[9:13])); err != nil {
+			return nil, fmt.Errorf("truncated RDB entry %d: %w", i, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	var checksum [8]byte
+	if _, err := io.ReadFull(buffered, checksum[:]); err != nil {
+		return nil, fmt.Errorf("failed to read RDB checksum: %w", err)
+	}
+	if binary.BigEndian.Uint64(checksum[:]) != hash.Sum64() {
+		return nil, ErrRDBChecksum
+	}
+	return entries, nil
+}
+
+// readBytes reads n bytes without trusting n for the allocation up front,
+// so a corrupt length fails at the end of the file instead of exhausting
+// memory
+func readBytes(r io.Reader, n uint32) ([]byte, error) {
+	if n > aofMaxRecord {
+		return nil, fmt.Errorf("length %d out of range", n)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, int64(n)))
+	if err == nil && len(data) < int(n) {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+// WriteAtomic writes a file through a temporary file in the same directory
+// that is synced and renamed over path, so readers and crashes only ever
+// see the old or the new contents
+func WriteAtomic(path string, write func(io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // No-op once renamed
+
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
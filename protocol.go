@@ -2,16 +2,29 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"time"
 )
 
-// readMessage reads a binary message from the connection
+// readMessage reads a version 1 binary message without compression flags
 func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
-	// Read length (4 bytes)
-	lengthBytes := s.bytePool.Get(4)
+	return s.readFramedMessage(reader, PROTOCOL_VERSION_1, false)
+}
+
+// readFramedMessage reads a binary message framed for the protocol version
+// negotiated on the connection. With wire compression enabled, a flags byte
+// follows the version byte and flagged payloads arrive LZ4 compressed.
+func (s *GoFastServer) readFramedMessage(reader *bufio.Reader, protocol uint8, compressed bool) (*Message, error) {
+	// Read length (4 bytes, or 8 from version 2)
+	headerLen := 4
+	if protocol >= PROTOCOL_VERSION_2 {
+		headerLen = 8
+	}
+	lengthBytes := s.bytePool.Get(headerLen)
 	defer s.bytePool.Put(lengthBytes)
 
 	_, err := io.ReadFull(reader, lengthBytes)
@@ -19,10 +32,23 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		return nil, err
 	}
 
-	length := binary.BigEndian.Uint32(lengthBytes)
+	var length uint64
+	if headerLen == 8 {
+		length = binary.BigEndian.Uint64(lengthBytes)
+	} else {
+		length = uint64(binary.BigEndian.Uint32(lengthBytes))
+	}
+	// Refuse oversized frames before their length sizes any allocation
+	if maxFrame := s.maxFrameSize(); length > maxFrame {
+		return nil, fmt.Errorf("message length %d exceeds max_frame_size %d", length, maxFrame)
+	}
+	if length < 2 || (compressed && length < 3) {
+		return nil, fmt.Errorf("invalid message length")
+	}
 	s.stats.mutex.Lock()
-	s.stats.BytesRead += uint64(length) + 4
+	s.stats.BytesRead += length + uint64(headerLen)
 	s.stats.mutex.Unlock()
+	s.metrics.bytesRead.Add(float64(length + uint64(headerLen)))
 
 	// Read version (1 byte)
 	versionByte := s.bytePool.Get(1)
@@ -32,6 +58,15 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		return nil, err
 	}
 
+	// Read compression flags (1 byte, only with wire compression)
+	var flags uint8
+	if compressed {
+		if flags, err = reader.ReadByte(); err != nil {
+			return nil, err
+		}
+		length--
+	}
+
 	// Read command (1 byte)
 	commandByte := s.bytePool.Get(1)
 	defer s.bytePool.Put(commandByte)
@@ -47,13 +82,54 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 	}
 
 	// Check protocol version
-	if msg.Version != PROTOCOL_VERSION {
-		return nil, fmt.Errorf("unsupported protocol version: %d (expected %d)", msg.Version, PROTOCOL_VERSION)
+	if msg.Version != protocol {
+		return nil, fmt.Errorf("unsupported protocol version: %d (expected %d)", msg.Version, protocol)
 	}
 
 	// Read remaining payload based on command
 	remaining := int(length) - 2 // Subtract version and command bytes
 
+	// Read request ID (4 bytes, from version 2)
+	if protocol >= PROTOCOL_VERSION_2 {
+		if remaining < 4 {
+			return nil, fmt.Errorf("invalid message length")
+		}
+		var requestID [4]byte
+		if _, err := io.ReadFull(reader, requestID[:]); err != nil {
+			return nil, err
+		}
+		msg.RequestID = binary.BigEndian.Uint32(requestID[:])
+		remaining -= 4
+	}
+
+	// Read a large payload as it arrives, so a declared length the client
+	// never sends cannot allocate memory up front. The handlers below then
+	// size their buffers from bytes that were received.
+	if remaining > frameStreamThreshold {
+		var payload bytes.Buffer
+		if _, err := io.CopyN(&payload, reader, int64(remaining)); err != nil {
+			return nil, err
+		}
+		reader = bufio.NewReader(&payload)
+	}
+
+	// Inflate a compressed payload and parse it in place of the stream
+	if flags&COMPRESS_LZ4 != 0 {
+		if remaining < 4 {
+			return nil, fmt.Errorf("invalid compressed message length")
+		}
+		payload := make([]byte, remaining)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return nil, err
+		}
+		payload, err = decompressPayload(payload, s.maxFrameSize())
+		if err != nil {
+			return nil, err
+		}
+		reader = bufio.NewReader(bytes.NewReader(payload))
+		remaining = len(payload)
+	}
+
 	switch msg.Command {
 	case CMD_SET:
 		// Format: [keylen:4][key][ttl:4][valuelen:4][value]
@@ -66,6 +142,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		defer s.bytePool.Put(keyLenBytes)
 		io.ReadFull(reader, keyLenBytes)
 		keyLen := binary.BigEndian.Uint32(keyLenBytes)
+		if int(keyLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Key = s.bytePool.Get(int(keyLen))
 		io.ReadFull(reader, msg.Key)
@@ -79,11 +158,14 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		valueLenBytes := make([]byte, 4)
 		io.ReadFull(reader, valueLenBytes)
 		valueLen := binary.BigEndian.Uint32(valueLenBytes)
+		if int(valueLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Value = s.bytePool.Get(int(valueLen))
 		io.ReadFull(reader, msg.Value)
 
-	case CMD_GET, CMD_DEL, CMD_EXISTS, CMD_TTL, CMD_LLEN, CMD_SMEMBERS, CMD_SCARD, CMD_HGETALL, CMD_HLEN:
+	case CMD_GET, CMD_DEL, CMD_EXISTS, CMD_TTL, CMD_LLEN, CMD_SMEMBERS, CMD_SCARD, CMD_HGETALL, CMD_HLEN, CMD_HKEYS, CMD_HVALS, CMD_ZCARD, CMD_XLEN, CMD_EXPIRETIME, CMD_PEXPIRETIME:
 		// Format: [keylen:4][key]
 		if remaining < 4 {
 			return nil, fmt.Errorf("invalid message length")
@@ -92,6 +174,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		keyLenBytes := make([]byte, 4)
 		io.ReadFull(reader, keyLenBytes)
 		keyLen := binary.BigEndian.Uint32(keyLenBytes)
+		if int(keyLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Key = s.bytePool.Get(int(keyLen))
 		io.ReadFull(reader, msg.Key)
@@ -105,6 +190,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		keyLenBytes := make([]byte, 4)
 		io.ReadFull(reader, keyLenBytes)
 		keyLen := binary.BigEndian.Uint32(keyLenBytes)
+		if int(keyLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Key = make([]byte, keyLen)
 		io.ReadFull(reader, msg.Key)
@@ -114,43 +202,76 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		io.ReadFull(reader, ttlBytes)
 		msg.TTL = binary.BigEndian.Uint32(ttlBytes)
 
-	case CMD_LPUSH, CMD_RPUSH, CMD_SADD:
-		// Format: [keylen:4][key][valuelen:4][value]
+	case CMD_MEMORY_USAGE:
+		// Format: [keylen:4][key][samples:4]
 		if remaining < 8 {
-			return nil, fmt.Errorf("invalid list/set operation message length")
+			return nil, fmt.Errorf("invalid MEMORY USAGE message length")
 		}
 
 		keyLenBytes := make([]byte, 4)
 		io.ReadFull(reader, keyLenBytes)
 		keyLen := binary.BigEndian.Uint32(keyLenBytes)
+		if int(keyLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Key = make([]byte, keyLen)
 		io.ReadFull(reader, msg.Key)
 
-		valueLenBytes := s.bytePool.Get(4)
-		defer s.bytePool.Put(valueLenBytes)
-		io.ReadFull(reader, valueLenBytes)
-		valueLen := binary.BigEndian.Uint32(valueLenBytes)
+		samplesBytes := make([]byte, 4)
+		io.ReadFull(reader, samplesBytes)
+		msg.TTL = binary.BigEndian.Uint32(samplesBytes) // Reusing TTL for samples
 
-		msg.Value = s.bytePool.Get(int(valueLen))
-		io.ReadFull(reader, msg.Value)
+	case CMD_LPUSH, CMD_RPUSH:
+		// Format: [keylen:4][key][numvalues:4][val1len:4][val1]...
+		// or the single-value form [keylen:4][key][valuelen:4][value]
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid list push message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_SADD, CMD_SREM:
+		// Format: [keylen:4][key][nummembers:4][member1len:4][member1]...
+		// or the single-member form [keylen:4][key][memberlen:4][member]
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid set operation message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
 
-	case CMD_LPOP, CMD_RPOP, CMD_SREM, CMD_SISMEMBER:
-		// Format: [keylen:4][key][valuelen:4][value] (for operations that need a value)
-		// or just [keylen:4][key] (for LPOP/RPOP)
+	case CMD_LPOP, CMD_RPOP:
+		// Format: [keylen:4][key] or [keylen:4][key][flags:1][count:4]
+		if remaining < 4 {
+			return nil, fmt.Errorf("invalid list pop message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_SISMEMBER:
+		// Format: [keylen:4][key][valuelen:4][value]
 		keyLenBytes := make([]byte, 4)
 		io.ReadFull(reader, keyLenBytes)
 		keyLen := binary.BigEndian.Uint32(keyLenBytes)
+		if int(keyLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Key = make([]byte, keyLen)
 		io.ReadFull(reader, msg.Key)
 
-		// Check if there's more data (for SREM, SISMEMBER)
+		// Check if there's more data (the member)
 		remainingAfterKey := remaining - 4 - int(keyLen)
-		if remainingAfterKey > 0 && (msg.Command == CMD_SREM || msg.Command == CMD_SISMEMBER) {
+		if remainingAfterKey > 0 {
 			valueLenBytes := make([]byte, 4)
 			io.ReadFull(reader, valueLenBytes)
 			valueLen := binary.BigEndian.Uint32(valueLenBytes)
+			if int(valueLen) > remaining {
+				return nil, fmt.Errorf("field length exceeds message length")
+			}
 
 			msg.Value = make([]byte, valueLen)
 			io.ReadFull(reader, msg.Value)
@@ -165,6 +286,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		keyLenBytes := make([]byte, 4)
 		io.ReadFull(reader, keyLenBytes)
 		keyLen := binary.BigEndian.Uint32(keyLenBytes)
+		if int(keyLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Key = make([]byte, keyLen)
 		io.ReadFull(reader, msg.Key)
@@ -183,6 +307,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		keyLenBytes := make([]byte, 4)
 		io.ReadFull(reader, keyLenBytes)
 		keyLen := binary.BigEndian.Uint32(keyLenBytes)
+		if int(keyLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Key = make([]byte, keyLen)
 		io.ReadFull(reader, msg.Key)
@@ -209,6 +336,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		keyLenBytes := make([]byte, 4)
 		io.ReadFull(reader, keyLenBytes)
 		keyLen := binary.BigEndian.Uint32(keyLenBytes)
+		if int(keyLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Key = make([]byte, keyLen)
 		io.ReadFull(reader, msg.Key)
@@ -216,6 +346,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		fieldLenBytes := make([]byte, 4)
 		io.ReadFull(reader, fieldLenBytes)
 		fieldLen := binary.BigEndian.Uint32(fieldLenBytes)
+		if int(fieldLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		// Store field in TTL area temporarily (we'll parse it in processCommand)
 		fieldBytes := s.bytePool.Get(int(fieldLen))
@@ -228,6 +361,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 			valueLenBytes := make([]byte, 4)
 			io.ReadFull(reader, valueLenBytes)
 			valueLen := binary.BigEndian.Uint32(valueLenBytes)
+			if int(valueLen) > remaining {
+				return nil, fmt.Errorf("field length exceeds message length")
+			}
 
 			msg.Value = s.bytePool.Get(len(fieldBytes) + 4 + int(valueLen))
 			// Pack: [fieldlen:4][field][value]
@@ -239,6 +375,152 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 			msg.Value = fieldBytes
 		}
 
+	case CMD_HSETNX:
+		// Format: [keylen:4][key][fieldlen:4][field][valuelen:4][value]
+		if remaining < 12 {
+			return nil, fmt.Errorf("invalid HSETNX message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_HRANDFIELD:
+		// Format: [keylen:4][key][count:4][flags:1]
+		if remaining < 9 {
+			return nil, fmt.Errorf("invalid HRANDFIELD message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_HINCRBY, CMD_HINCRBYFLOAT:
+		// Format: [keylen:4][key][fieldlen:4][field][delta:8]
+		if remaining < 16 {
+			return nil, fmt.Errorf("invalid hash increment message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZADD:
+		// Format: [keylen:4][key][flags:1][numentries:4][score:8][memberlen:4][member]...
+		if remaining < 21 {
+			return nil, fmt.Errorf("invalid ZADD message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZINCRBY:
+		// Format: [keylen:4][key][delta:8][memberlen:4][member]
+		if remaining < 16 {
+			return nil, fmt.Errorf("invalid ZINCRBY message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZREM, CMD_ZMSCORE:
+		// Format: [keylen:4][key][count:4][member1len:4][member1]...
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid ZREM message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZDIFF:
+		// Format: [numkeys:4][key1len:4][key1]...[keyNlen:4][keyN][flags:1]
+		if remaining < 4 {
+			return nil, fmt.Errorf("invalid ZDIFF message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZUNIONSTORE, CMD_ZINTERSTORE, CMD_ZDIFFSTORE:
+		// Format: [dstlen:4][dst][numkeys:4][keys...][flags:1][weights:8*N][aggregate:1]
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid sorted set store message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZREMRANGEBYSCORE, CMD_ZREMRANGEBYRANK:
+		// Format: [keylen:4][key][min:8][max:8], float64 scores or int64 ranks
+		if remaining < 20 {
+			return nil, fmt.Errorf("invalid sorted set range removal message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZCOUNT, CMD_ZLEXCOUNT:
+		// Format: [keylen:4][key][minlen:4][min][maxlen:4][max]
+		if remaining < 12 {
+			return nil, fmt.Errorf("invalid ZCOUNT message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZPOPMIN, CMD_ZPOPMAX:
+		// Format: [keylen:4][key] or [keylen:4][key][count:4]
+		if remaining < 4 {
+			return nil, fmt.Errorf("invalid sorted set pop message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZSCORE:
+		// Format: [keylen:4][key][memberlen:4][member]
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid ZSCORE message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZRANK, CMD_ZREVRANK:
+		// Format: [keylen:4][key][memberlen:4][member][flags:1]
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid ZRANK message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZRANGE, CMD_ZRANGEBYSCORE, CMD_ZREVRANGEBYSCORE, CMD_ZRANGEBYLEX:
+		// Format: [keylen:4][key][flags:1][startlen:4][start][stoplen:4][stop][limitoffset:4][limitcount:4]
+		if remaining < 13 {
+			return nil, fmt.Errorf("invalid ZRANGE message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_ZRANGESTORE:
+		// Format: [dstlen:4][dst][srclen:4][src][ZRANGE flags and args]
+		if remaining < 17 {
+			return nil, fmt.Errorf("invalid ZRANGESTORE message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_HMSET, CMD_HMGET, CMD_HGETDEL:
+		// Format: [keylen:4][key][numfields:4][field1len:4][field1][val1len:4][val1]... (HMSET)
+		// or [keylen:4][key][numfields:4][field1len:4][field1]... (HMGET, HGETDEL)
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid hash multi-field message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
 	case CMD_MGET:
 		// Format: [count:4][key1_len:4][key1][key2_len:4][key2]...
 		if remaining < 4 {
@@ -269,6 +551,147 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		msg.Value = s.bytePool.Get(remaining)
 		io.ReadFull(reader, msg.Value)
 
+	case CMD_SETBIT, CMD_GETBIT:
+		// Format: [keylen:4][key][offset:4][bit:1] (SETBIT) or [keylen:4][key][offset:4] (GETBIT)
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid bit operation message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_BITCOUNT:
+		// Format: [keylen:4][key][rangeflags:1][start:4 signed][end:4 signed]
+		if remaining < 13 {
+			return nil, fmt.Errorf("invalid BITCOUNT message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_BITPOS:
+		// Format: [keylen:4][key][bit:1][rangeflags:1][start:4 signed][end:4 signed]
+		if remaining < 14 {
+			return nil, fmt.Errorf("invalid BITPOS message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_BITFIELD:
+		// Format: [keylen:4][key][numops:4] then per op [op:1] followed by
+		// [type:1][offset:4] (GET), [type:1][offset:4][value:8] (SET, INCRBY)
+		// or [overflow:1] (OVERFLOW)
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid BITFIELD message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_BITOP:
+		// Format: [op:1][dstlen:4][dst][numkeys:4][key1len:4][key1]...
+		if remaining < 9 {
+			return nil, fmt.Errorf("invalid BITOP message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_XADD:
+		// Format: [keylen:4][key][flags:1][thresholdlen:4][threshold] (with MAXLEN or MINID)
+		// [idlen:4][id][numargs:4][field1len:4][field1][value1len:4][value1]...
+		if remaining < 13 {
+			return nil, fmt.Errorf("invalid XADD message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_XRANGE, CMD_XREVRANGE:
+		// Format: [keylen:4][key][startlen:4][start][endlen:4][end][count:4 signed, negative = no limit]
+		// XREVRANGE sends the end bound first, as in Redis
+		if remaining < 16 {
+			return nil, fmt.Errorf("invalid XRANGE message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_XDEL, CMD_XTRIM:
+		// Format: [keylen:4][key][numids:4][id1len:4][id1]... (XDEL)
+		// or [keylen:4][key][flags:1][thresholdlen:4][threshold] (XTRIM)
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid stream operation message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_XACK, CMD_XPENDING:
+		// Format: [keylen:4][key][grouplen:4][group][numids:4][id1len:4][id1]... (XACK)
+		// or [keylen:4][key][grouplen:4][group] (XPENDING)
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid consumer group message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_XAUTOCLAIM:
+		// Format: [keylen:4][key][grouplen:4][group][consumerlen:4][consumer][minidle:8 ms]
+		// [startidlen:4][startid][count:4 (0 = default of 100)]
+		if remaining < 28 {
+			return nil, fmt.Errorf("invalid XAUTOCLAIM message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_XINFO:
+		// Format: [subcommand:1][keylen:4][key] followed by [grouplen:4][group] for CONSUMERS
+		if remaining < 5 {
+			return nil, fmt.Errorf("invalid XINFO message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_XGROUP:
+		// Format: [subcommand:1][keylen:4][key][grouplen:4][group] followed by
+		// [idlen:4][id][flags:1] (CREATE), [idlen:4][id] (SETID) or
+		// [consumerlen:4][consumer] (CREATECONSUMER, DELCONSUMER)
+		if remaining < 9 {
+			return nil, fmt.Errorf("invalid XGROUP message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_XREADGROUP:
+		// Format: [grouplen:4][group][consumerlen:4][consumer][count:4 (0 = no limit)][flags:1]
+		// [numkeys:4][key1len:4][key1]...[numids:4][id1len:4][id1]...
+		if remaining < 21 {
+			return nil, fmt.Errorf("invalid XREADGROUP message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_XREAD:
+		// Format: [count:4 (0 = no limit)][numkeys:4][key1len:4][key1]...[numids:4][id1len:4][id1]...
+		if remaining < 12 {
+			return nil, fmt.Errorf("invalid XREAD message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
 	case CMD_INCR, CMD_DECR:
 		// Format: [keylen:4][key] (simple key-only commands)
 		if remaining < 4 {
@@ -277,6 +700,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		keyLenBytes := make([]byte, 4)
 		io.ReadFull(reader, keyLenBytes)
 		keyLen := binary.BigEndian.Uint32(keyLenBytes)
+		if int(keyLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Key = make([]byte, keyLen)
 		io.ReadFull(reader, msg.Key)
@@ -289,6 +715,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		keyLenBytes := make([]byte, 4)
 		io.ReadFull(reader, keyLenBytes)
 		keyLen := binary.BigEndian.Uint32(keyLenBytes)
+		if int(keyLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Key = make([]byte, keyLen)
 		io.ReadFull(reader, msg.Key)
@@ -296,6 +725,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		valueLenBytes := make([]byte, 4)
 		io.ReadFull(reader, valueLenBytes)
 		valueLen := binary.BigEndian.Uint32(valueLenBytes)
+		if int(valueLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Value = make([]byte, valueLen)
 		io.ReadFull(reader, msg.Value)
@@ -308,6 +740,9 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		patternLenBytes := make([]byte, 4)
 		io.ReadFull(reader, patternLenBytes)
 		patternLen := binary.BigEndian.Uint32(patternLenBytes)
+		if int(patternLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
 
 		msg.Value = make([]byte, patternLen) // Store pattern in Value field
 		io.ReadFull(reader, msg.Value)
@@ -321,19 +756,558 @@ func (s *GoFastServer) readMessage(reader *bufio.Reader) (*Message, error) {
 		io.ReadFull(reader, cursorBytes)
 		msg.TTL = binary.BigEndian.Uint32(cursorBytes) // Reuse TTL field for cursor
 
-		patternLenBytes := make([]byte, 4)
-		io.ReadFull(reader, patternLenBytes)
-		patternLen := binary.BigEndian.Uint32(patternLenBytes)
+		patternLenBytes := make([]byte, 4)
+		io.ReadFull(reader, patternLenBytes)
+		patternLen := binary.BigEndian.Uint32(patternLenBytes)
+		if int(patternLen) > remaining {
+			return nil, fmt.Errorf("field length exceeds message length")
+		}
+
+		msg.Value = make([]byte, patternLen)
+		io.ReadFull(reader, msg.Value)
+
+	case CMD_EXPIREAT, CMD_PEXPIREAT:
+		// Format: [keylen:4][key][at:8]
+		if remaining < 12 {
+			return nil, fmt.Errorf("invalid EXPIREAT message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_LSET, CMD_LINSERT, CMD_LTRIM, CMD_LPOS, CMD_LMOVE, CMD_LREM:
+		// Format: [keylen:4][key][arguments...], decoded by the list handlers
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid list operation message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_SUNIONSTORE, CMD_SINTERSTORE, CMD_SDIFFSTORE:
+		// Format: [dstlen:4][dst][numkeys:4][key1len:4][key1]...
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid set algebra store message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_SMOVE:
+		// Format: [srclen:4][src][dstlen:4][dst][memberlen:4][member]
+		if remaining < 12 {
+			return nil, fmt.Errorf("invalid SMOVE message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_SSCAN, CMD_HSCAN, CMD_ZSCAN:
+		// Format: [keylen:4][key][cursor:4][patternlen:4][pattern] with an
+		// optional trailing [count:4]
+		if remaining < 12 {
+			return nil, fmt.Errorf("invalid key scan message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_SPOP, CMD_SRANDMEMBER:
+		// Format: [keylen:4][key][count:4]
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid set sampling message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_BLPOP, CMD_BRPOP, CMD_BZPOPMIN, CMD_BZPOPMAX:
+		// Format: [numkeys:4][key1len:4][key1]...[timeout:4 float32 seconds]
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid blocking pop message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_SUNION, CMD_SINTER, CMD_SDIFF:
+		// Format: [numkeys:4][key1len:4][key1]...[keyNlen:4][keyN]
+		if remaining < 4 {
+			return nil, fmt.Errorf("invalid set algebra message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_SINTERCARD:
+		// Format: [numkeys:4][key1len:4][key1]...[keyNlen:4][keyN][limit:4]
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid SINTERCARD message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_LMPOP:
+		// Format: [numkeys:4][key1len:4][key1]...[direction:1][count:4]
+		if remaining < 9 {
+			return nil, fmt.Errorf("invalid LMPOP message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_BZMPOP:
+		// Format: [timeout:4 float32 seconds][numkeys:4][key1len:4][key1]...[direction:1][count:4]
+		if remaining < 13 {
+			return nil, fmt.Errorf("invalid BZMPOP message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_OBJECT:
+		// Format: [subcommand:1][keylen:4][key]
+		if remaining < 5 {
+			return nil, fmt.Errorf("invalid OBJECT message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_PUBLISH:
+		// Format: [channellen:4][channel][message]
+		if remaining < 4 {
+			return nil, fmt.Errorf("invalid PUBLISH message length")
+		}
+		if err := s.readKeyPayload(reader, msg, remaining); err != nil {
+			return nil, err
+		}
+
+	case CMD_SUBSCRIBE, CMD_UNSUBSCRIBE, CMD_PSUBSCRIBE, CMD_PUNSUBSCRIBE:
+		// Format: [numchannels:4][channel1len:4][channel1]...
+		if remaining < 4 {
+			return nil, fmt.Errorf("invalid subscription message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_MULTI, CMD_EXEC, CMD_DISCARD:
+		// Format: no payload
+
+	case CMD_BGSAVE, CMD_BGREWRITEAOF, CMD_MONITOR, CMD_RESET, CMD_COMMANDSTATS:
+		// Format: no payload
+
+	case CMD_SELECT:
+		// Format: [dbindex:4]
+		if remaining != 4 {
+			return nil, fmt.Errorf("invalid SELECT message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_SHUTDOWN:
+		// Format: [flags:1]
+		if remaining != 1 {
+			return nil, fmt.Errorf("invalid SHUTDOWN message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_AUTH:
+		// Format: [userlen:4][username][passlen:4][password]
+		if remaining < 8 {
+			return nil, fmt.Errorf("invalid AUTH message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_HELLO:
+		// Format: [version:1]
+		if remaining != 1 {
+			return nil, fmt.Errorf("invalid HELLO message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_WATCH:
+		// Format: [numkeys:4][key1len:4][key1]...
+		if remaining < 4 {
+			return nil, fmt.Errorf("invalid WATCH message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_PUBSUB:
+		// Format: [subcommand:1] followed by [patternlen:4][pattern] (CHANNELS)
+		// or [numchannels:4][channel1len:4][channel1]... (NUMSUB)
+		if remaining < 1 {
+			return nil, fmt.Errorf("invalid PUBSUB message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_INFO:
+		// Format: [section], empty for every section
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_SLOWLOG:
+		// Format: [subcommand:1] followed by [count:4] (GET)
+		if remaining < 1 {
+			return nil, fmt.Errorf("invalid SLOWLOG message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_LATENCY:
+		// Format: [subcommand:1] followed by [eventlen:4][event]
+		if remaining < 1 {
+			return nil, fmt.Errorf("invalid LATENCY message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_CONFIG:
+		// Format: [subcommand:1][arguments...]
+		if remaining < 1 {
+			return nil, fmt.Errorf("invalid CONFIG message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	case CMD_CLIENT:
+		// Format: [subcommand:1]
+		if remaining < 1 {
+			return nil, fmt.Errorf("invalid CLIENT message length")
+		}
+		msg.Value = make([]byte, remaining)
+		if _, err := io.ReadFull(reader, msg.Value); err != nil {
+			return nil, err
+		}
+
+	}
+	return msg, nil
+}
+
+// argReader decodes handler arguments from a message payload. The first
+// decoding failure is kept in err and every later read returns a zero value.
+type argReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func newArgReader(data []byte) *argReader {
+	return &argReader{data: data}
+}
+
+func (r *argReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.offset+n > len(r.data) {
+		r.err = fmt.Errorf("insufficient data")
+		return nil
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+func (r *argReader) uint8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *argReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *argReader) int32() int32 {
+	return int32(r.uint32())
+}
+
+func (r *argReader) uint64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *argReader) int64() int64 {
+	return int64(r.uint64())
+}
+
+// bytes reads a [len:4][data] field and returns a copy of data
+func (r *argReader) bytes() []byte {
+	n := r.uint32()
+	b := r.next(int(n))
+	if b == nil {
+		return nil
+	}
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
+
+// string reads a [len:4][data] field as a string
+func (r *argReader) string() string {
+	n := r.uint32()
+	return string(r.next(int(n)))
+}
+
+// keyList decodes a [numkeys:4][key1len:4][key1]... key list
+func (r *argReader) keyList() []string {
+	count := r.uint32()
+	if r.err != nil || int(count) > len(r.data)/4 {
+		r.err = fmt.Errorf("invalid key count")
+		return nil
+	}
+	keys := make([]string, 0, count)
+	for range count {
+		keys = append(keys, r.string())
+	}
+	return keys
+}
+
+// parsePopCount decodes the optional [flags:1][count:4] suffix of LPOP/RPOP,
+// returning -1 when no count was sent
+func parsePopCount(data []byte) int {
+	args := newArgReader(data)
+	flags := args.uint8()
+	if flags&POP_FLAG_COUNT == 0 {
+		return -1
+	}
+	count := args.uint32()
+	if args.err != nil {
+		return -1
+	}
+	return int(count)
+}
+
+// parseBlockingArgs decodes [numkeys:4][keys...][timeout:4 float32 seconds]
+func parseBlockingArgs(data []byte) ([]string, time.Duration, error) {
+	args := newArgReader(data)
+	keys := args.keyList()
+	seconds := math.Float32frombits(args.uint32())
+	if args.err != nil {
+		return nil, 0, args.err
+	}
+	timeout, ok := blockingTimeout(seconds)
+	if len(keys) == 0 || !ok {
+		return nil, 0, fmt.Errorf("invalid blocking arguments")
+	}
+	return keys, timeout, nil
+}
+
+// blockingTimeout converts a blocking command's timeout in seconds, refusing
+// negative, NaN and infinite values and any too long for a time.Duration
+func blockingTimeout(seconds float32) (time.Duration, bool) {
+	limit := float64(math.MaxInt64) / float64(time.Second)
+	if math.IsNaN(float64(seconds)) || seconds < 0 || float64(seconds) >= limit {
+		return 0, false
+	}
+	return time.Duration(float64(seconds) * float64(time.Second)), true
+}
+
+// parseBitRange decodes [rangeflags:1][start:4][end:4]. Without the range
+// flag the whole string is covered.
+func parseBitRange(data []byte) (start, end int, byBit bool, err error) {
+	args := newArgReader(data)
+	flags := args.uint8()
+	start = int(args.int32())
+	end = int(args.int32())
+	if args.err != nil {
+		return 0, 0, false, args.err
+	}
+	if flags&BITRANGE_RANGE == 0 {
+		return 0, -1, false, nil
+	}
+	return start, end, flags&BITRANGE_BIT != 0, nil
+}
+
+// parseBitFieldOps decodes [numops:4] followed by the BITFIELD subcommands
+func parseBitFieldOps(data []byte) ([]BitFieldOp, error) {
+	args := newArgReader(data)
+	count := int(args.uint32())
+	if args.err != nil || count > len(data) {
+		return nil, fmt.Errorf("invalid BITFIELD subcommand count")
+	}
+
+	ops := make([]BitFieldOp, 0, count)
+	for range count {
+		op := BitFieldOp{Op: args.uint8()}
+		switch op.Op {
+		case BITFIELD_OVERFLOW:
+			op.Overflow = args.uint8()
+		case BITFIELD_GET, BITFIELD_SET, BITFIELD_INCRBY:
+			typ := args.uint8()
+			op.Signed = typ&BITFIELD_SIGNED != 0
+			op.Width = int(typ &^ BITFIELD_SIGNED)
+			op.Offset = int(args.uint32())
+			if op.Op != BITFIELD_GET {
+				op.Value = args.int64()
+			}
+		default:
+			return nil, fmt.Errorf("unknown BITFIELD subcommand")
+		}
+		if args.err != nil {
+			return nil, args.err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// decodeMessage parses a request payload built in memory by a gateway. It
+// frames the payload and runs it through readMessage rather than filling in
+// Message fields by hand.
+func (s *GoFastServer) decodeMessage(command uint8, payload []byte) (*Message, error) {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+2))
+	frame = append(frame, PROTOCOL_VERSION_1, command)
+	frame = append(frame, payload...)
+	msg, err := s.readMessage(bufio.NewReader(bytes.NewReader(frame)))
+	if err != nil {
+		return nil, err
+	}
+
+	// readMessage may hand out pooled buffers that are recycled by the next
+	// read, while gateways may decode several messages before running any
+	msg.Key = bytes.Clone(msg.Key)
+	msg.Value = bytes.Clone(msg.Value)
+	return msg, nil
+}
+
+// readKeyPayload reads [keylen:4][key] into msg.Key and the rest of the
+// message body into msg.Value, leaving argument parsing to the handler
+func (s *GoFastServer) readKeyPayload(reader *bufio.Reader, msg *Message, remaining int) error {
+	keyLenBytes := make([]byte, 4)
+	if _, err := io.ReadFull(reader, keyLenBytes); err != nil {
+		return err
+	}
+	keyLen := int(binary.BigEndian.Uint32(keyLenBytes))
+	if keyLen > remaining-4 {
+		return fmt.Errorf("key length exceeds message length")
+	}
+
+	msg.Key = make([]byte, keyLen)
+	if _, err := io.ReadFull(reader, msg.Key); err != nil {
+		return err
+	}
+
+	msg.Value = make([]byte, remaining-4-keyLen)
+	_, err := io.ReadFull(reader, msg.Value)
+	return err
+}
+
+// maxFrameSize returns the longest binary frame a client may send
+func (s *GoFastServer) maxFrameSize() uint64 {
+	if s.config == nil || s.config.MaxFrameSize < 1 {
+		return DefaultMaxFrameSize
+	}
+	return uint64(s.config.MaxFrameSize)
+}
+
+// processCommand handles cache operations for a connection, or for a
+// trusted internal caller when state is nil. Commands share execMutex, so
+// none runs while an EXEC holds it.
+func (s *GoFastServer) processCommand(state *connState, msg *Message) []byte {
+	s.execMutex.RLock()
+	defer s.execMutex.RUnlock()
+	return s.executeCommand(state, msg)
+}
 
-		msg.Value = make([]byte, patternLen)
-		io.ReadFull(reader, msg.Value)
+// executeCommand runs msg for processCommand, or for EXEC, which already
+// holds execMutex
+func (s *GoFastServer) executeCommand(state *connState, msg *Message) []byte {
+	defer s.commandDone(state, msg, time.Now())
 
+	if s.renames.Disabled(msg.Command) {
+		return s.createResponse(RESP_ERROR, []byte("ERR unknown command"))
 	}
-	return msg, nil
+	if denied := s.checkACL(state, msg); denied != nil {
+		return denied
+	}
+
+	switch msg.Command {
+	case CMD_SELECT:
+		s.incrementStat("total_ops")
+		return s.handleSelect(state, msg.Value)
+	case CMD_BGSAVE:
+		s.incrementStat("total_ops")
+		return s.handleBGSave()
+	case CMD_BGREWRITEAOF:
+		s.incrementStat("total_ops")
+		return s.handleBGRewriteAOF()
+	case CMD_RESET:
+		s.incrementStat("total_ops")
+		return s.handleReset(state)
+	case CMD_CLIENT:
+		s.incrementStat("total_ops")
+		return s.handleClient(state, msg.Value)
+	case CMD_SHUTDOWN:
+		s.incrementStat("total_ops")
+		if !s.isAdmin(state) {
+			return s.createResponse(RESP_ERROR, []byte("NOPERM SHUTDOWN needs a user allowed every command"))
+		}
+		return s.handleShutdown(msg.Value[0]&SHUTDOWN_SAVE != 0)
+	case CMD_MONITOR:
+		return s.createResponse(RESP_ERROR, []byte("ERR MONITOR is not allowed in transactions or pipelines"))
+	}
+
+	if !s.enforceMemoryLimit(msg) {
+		return s.createResponse(RESP_ERROR, []byte(ERR_OOM))
+	}
+	db := s.database(state)
+	now := time.Now().Unix()
+	return s.logWrite(db, msg, now, func() []byte {
+		return db.runCommand(state, msg, now)
+	})
 }
 
-// processCommand handles cache operations
-func (s *GoFastServer) processCommand(msg *Message) []byte {
+// runCommand runs a command against this database as of now, the Unix time
+// relative TTLs count from
+func (s *DatabaseState) runCommand(state *connState, msg *Message, now int64) (response []byte) {
+	defer func() {
+		s.notifyCommand(msg, response)
+		s.touchCommand(msg, response)
+		s.recordAccess(msg, now)
+	}()
+
 	if msg.Command != CMD_PIPELINE {
 		s.incrementStat("total_ops")
 	} else {
@@ -347,7 +1321,6 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 	}
 
 	key := string(msg.Key)
-	now := time.Now().Unix()
 
 	switch msg.Command {
 	case CMD_SET:
@@ -358,6 +1331,7 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 			Value:     msg.Value,
 			CreatedAt: now,
 		}
+		s.compressValue(item)
 
 		if msg.TTL > 0 {
 			item.ExpiresAt = now + int64(msg.TTL)
@@ -374,6 +1348,7 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 
 		value, exists := s.storage.Load(key)
 		if !exists {
+			s.incrementStat("misses")
 			return s.createResponse(RESP_NOT_FOUND, nil)
 		}
 
@@ -381,10 +1356,8 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 
 		// Check if expired
 		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
+			s.expireKey(key)
+			s.incrementStat("misses")
 			return s.createResponse(RESP_NOT_FOUND, nil)
 		}
 
@@ -392,7 +1365,8 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
 		}
 
-		return s.createResponse(RESP_OK, item.Value.([]byte))
+		s.incrementStat("hits")
+		return s.createResponse(RESP_OK, item.stringValue())
 
 	case CMD_MGET:
 		return s.handleMGet(msg.Value, now)
@@ -401,7 +1375,7 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 		return s.handleMSet(msg.Value, now)
 
 	case CMD_PIPELINE:
-		return s.handlePipeline(msg.Value, now)
+		return s.handlePipeline(state, msg.Value, now)
 
 	// List operations
 	case CMD_LPUSH:
@@ -411,10 +1385,10 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 		return s.handleListPush(key, msg.Value, false, now)
 
 	case CMD_LPOP:
-		return s.handleListPop(key, true, now)
+		return s.handleListPop(key, true, parsePopCount(msg.Value), now)
 
 	case CMD_RPOP:
-		return s.handleListPop(key, false, now)
+		return s.handleListPop(key, false, parsePopCount(msg.Value), now)
 
 	case CMD_LLEN:
 		return s.handleListLen(key, now)
@@ -426,12 +1400,105 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 		end := int(binary.BigEndian.Uint32(msg.Value))
 		return s.handleListRange(key, int(msg.TTL), end, now)
 
+	case CMD_LSET:
+		args := newArgReader(msg.Value)
+		index := int(args.int32())
+		value := args.bytes()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LSET data"))
+		}
+		return s.handleListSet(key, index, value, now)
+
+	case CMD_LINSERT:
+		args := newArgReader(msg.Value)
+		after := args.uint8() != 0
+		pivot := args.bytes()
+		value := args.bytes()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LINSERT data"))
+		}
+		return s.handleListInsert(key, pivot, value, after, now)
+
+	case CMD_LTRIM:
+		args := newArgReader(msg.Value)
+		start := int(args.int32())
+		end := int(args.int32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LTRIM data"))
+		}
+		return s.handleListTrim(key, start, end, now)
+
+	case CMD_LPOS:
+		args := newArgReader(msg.Value)
+		element := args.bytes()
+		rank := int(args.int32())
+		count := int(args.int32())
+		maxLen := int(args.int32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LPOS data"))
+		}
+		return s.handleListPos(key, element, rank, count, maxLen, now)
+
+	case CMD_LMOVE:
+		args := newArgReader(msg.Value)
+		dst := args.string()
+		flags := args.uint8()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LMOVE data"))
+		}
+		return s.handleListMove(key, dst, flags&LMOVE_SRC_LEFT != 0, flags&LMOVE_DST_LEFT != 0, now)
+
+	case CMD_LREM:
+		args := newArgReader(msg.Value)
+		count := int(args.int32())
+		element := args.bytes()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LREM data"))
+		}
+		return s.handleListRem(key, count, element, now)
+
+	case CMD_BLPOP, CMD_BRPOP:
+		keys, timeout, err := parseBlockingArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid blocking pop data"))
+		}
+		return s.handleBlockingPop(keys, timeout, msg.Command == CMD_BLPOP, state.gone(), now)
+
+	case CMD_BZPOPMIN, CMD_BZPOPMAX:
+		keys, timeout, err := parseBlockingArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid blocking pop data"))
+		}
+		return s.handleBlockingZPop(keys, timeout, msg.Command == CMD_BZPOPMAX, state.gone(), now)
+
+	case CMD_BZMPOP:
+		args := newArgReader(msg.Value)
+		seconds := math.Float32frombits(args.uint32())
+		keys := args.keyList()
+		direction := args.uint8()
+		count := int(args.uint32())
+		timeout, ok := blockingTimeout(seconds)
+		if args.err != nil || len(keys) == 0 || !ok {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BZMPOP data"))
+		}
+		return s.handleBlockingZMPop(keys, timeout, direction != 0, count, state.gone(), now)
+
+	case CMD_LMPOP:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		direction := args.uint8()
+		count := int(args.uint32())
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LMPOP data"))
+		}
+		return s.handleListMPop(keys, direction == 0, count, now)
+
 	// Set operations
 	case CMD_SADD:
-		return s.handleSetAdd(key, string(msg.Value), now)
+		return s.handleSetAdd(key, msg.Value, now)
 
 	case CMD_SREM:
-		return s.handleSetRem(key, string(msg.Value), now)
+		return s.handleSetRem(key, msg.Value, now)
 
 	case CMD_SMEMBERS:
 		return s.handleSetMembers(key, now)
@@ -442,6 +1509,63 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 	case CMD_SISMEMBER:
 		return s.handleSetIsMember(key, string(msg.Value), now)
 
+	case CMD_SUNION, CMD_SINTER, CMD_SDIFF:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid set algebra data"))
+		}
+		return s.handleSetAlgebra(msg.Command, keys, now)
+
+	case CMD_SINTERCARD:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		limit := int(args.uint32())
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SINTERCARD data"))
+		}
+		return s.handleSetInterCard(keys, limit, now)
+
+	case CMD_SUNIONSTORE, CMD_SINTERSTORE, CMD_SDIFFSTORE:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid set algebra data"))
+		}
+		return s.handleSetAlgebraStore(msg.Command, key, keys, now)
+
+	case CMD_SMOVE:
+		args := newArgReader(msg.Value)
+		dst := args.string()
+		member := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SMOVE data"))
+		}
+		return s.handleSetMove(key, dst, member, now)
+
+	case CMD_SPOP:
+		args := newArgReader(msg.Value)
+		count := int(args.uint32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SPOP data"))
+		}
+		return s.handleSetPop(key, count, now)
+
+	case CMD_SSCAN:
+		cursor, pattern, count, err := parseScanArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SSCAN data"))
+		}
+		return s.handleSetScan(key, cursor, pattern, count, now)
+
+	case CMD_SRANDMEMBER:
+		args := newArgReader(msg.Value)
+		count := int(args.int32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SRANDMEMBER data"))
+		}
+		return s.handleSetRandMember(key, count, now)
+
 	// Hash operations
 	case CMD_HSET:
 		return s.handleHashSet(key, msg.Value, now)
@@ -461,6 +1585,249 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 	case CMD_HEXISTS:
 		return s.handleHashExists(key, string(msg.Value), now)
 
+	case CMD_HKEYS:
+		return s.handleHashKeys(key, now)
+
+	case CMD_HVALS:
+		return s.handleHashVals(key, now)
+
+	case CMD_HMSET:
+		return s.handleHashMSet(key, msg.Value, now)
+
+	case CMD_HSETNX:
+		args := newArgReader(msg.Value)
+		field := args.string()
+		value := args.bytes()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HSETNX data"))
+		}
+		return s.handleHashSetNX(key, field, value, now)
+
+	case CMD_HSCAN:
+		cursor, pattern, count, err := parseScanArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HSCAN data"))
+		}
+		return s.handleHashScan(key, cursor, pattern, count, now)
+
+	case CMD_HRANDFIELD:
+		args := newArgReader(msg.Value)
+		count := int(args.int32())
+		flags := args.uint8()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HRANDFIELD data"))
+		}
+		return s.handleHashRandField(key, count, flags&HRANDFIELD_WITHVALUES != 0, now)
+
+	case CMD_HINCRBY:
+		args := newArgReader(msg.Value)
+		field := args.string()
+		delta := args.int64()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HINCRBY data"))
+		}
+		return s.handleHashIncrBy(key, field, delta, now)
+
+	case CMD_HINCRBYFLOAT:
+		args := newArgReader(msg.Value)
+		field := args.string()
+		delta := math.Float64frombits(args.uint64())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HINCRBYFLOAT data"))
+		}
+		return s.handleHashIncrByFloat(key, field, delta, now)
+
+	case CMD_HMGET:
+		args := newArgReader(msg.Value)
+		fields := args.keyList()
+		if args.err != nil || len(fields) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HMGET data"))
+		}
+		return s.handleHashMGet(key, fields, now)
+
+	case CMD_HGETDEL:
+		args := newArgReader(msg.Value)
+		fields := args.keyList()
+		if args.err != nil || len(fields) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HGETDEL data"))
+		}
+		return s.handleHashGetDel(key, fields, now)
+
+	// Sorted set operations
+	case CMD_ZADD:
+		entries, flags, err := parseZAddArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZADD data"))
+		}
+		return s.handleZAdd(key, entries, flags, now)
+
+	case CMD_ZRANGE:
+		start, stop, opts, err := parseZRangeArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANGE data"))
+		}
+		return s.handleZRange(key, start, stop, opts, now)
+
+	case CMD_ZRANGEBYSCORE, CMD_ZREVRANGEBYSCORE:
+		// Same layout as ZRANGE; only the WITHSCORES and LIMIT flags apply
+		min, max, opts, err := parseZRangeArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANGEBYSCORE data"))
+		}
+		opts.ByScore, opts.ByLex = true, false
+		opts.Rev = msg.Command == CMD_ZREVRANGEBYSCORE
+		return s.handleZRange(key, min, max, opts, now)
+
+	case CMD_ZRANGEBYLEX:
+		// Same layout as ZRANGE; only the LIMIT flag applies
+		min, max, opts, err := parseZRangeArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANGEBYLEX data"))
+		}
+		opts.ByScore, opts.ByLex, opts.Rev = false, true, false
+		return s.handleZRange(key, min, max, opts, now)
+
+	case CMD_ZINCRBY:
+		args := newArgReader(msg.Value)
+		delta := math.Float64frombits(args.uint64())
+		member := args.string()
+		if args.err != nil || math.IsNaN(delta) {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZINCRBY data"))
+		}
+		return s.handleZIncrBy(key, member, delta, now)
+
+	case CMD_ZREM:
+		args := newArgReader(msg.Value)
+		members := args.keyList()
+		if args.err != nil || len(members) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZREM data"))
+		}
+		return s.handleZRem(key, members, now)
+
+	case CMD_ZCARD:
+		return s.handleZCard(key, now)
+
+	case CMD_ZSCAN:
+		cursor, pattern, count, err := parseScanArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZSCAN data"))
+		}
+		return s.handleZScan(key, cursor, pattern, count, now)
+
+	case CMD_ZUNIONSTORE, CMD_ZINTERSTORE:
+		keys, weights, aggregate, err := parseZStoreArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid sorted set store data"))
+		}
+		return s.handleZSetAggregateStore(msg.Command, key, keys, weights, aggregate, now)
+
+	case CMD_ZDIFF:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		var flags uint8
+		if args.err == nil && args.offset < len(msg.Value) {
+			flags = args.uint8()
+		}
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZDIFF data"))
+		}
+		return s.handleZDiff(keys, flags&ZDIFF_WITHSCORES != 0, now)
+
+	case CMD_ZDIFFSTORE:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZDIFFSTORE data"))
+		}
+		return s.handleZDiffStore(key, keys, now)
+
+	case CMD_ZRANGESTORE:
+		args := newArgReader(msg.Value)
+		src := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANGESTORE data"))
+		}
+		start, stop, opts, err := parseZRangeArgs(msg.Value[args.offset:])
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANGESTORE data"))
+		}
+		return s.handleZRangeStore(key, src, start, stop, opts, now)
+
+	case CMD_ZCOUNT:
+		args := newArgReader(msg.Value)
+		min := args.string()
+		max := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZCOUNT data"))
+		}
+		return s.handleZCount(key, min, max, now)
+
+	case CMD_ZREMRANGEBYSCORE:
+		args := newArgReader(msg.Value)
+		min := ScoreBound{Value: math.Float64frombits(args.uint64())}
+		max := ScoreBound{Value: math.Float64frombits(args.uint64())}
+		if args.err != nil || math.IsNaN(min.Value) || math.IsNaN(max.Value) {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZREMRANGEBYSCORE data"))
+		}
+		return s.handleZRemRange(key, true, min, max, 0, 0, now)
+
+	case CMD_ZREMRANGEBYRANK:
+		args := newArgReader(msg.Value)
+		start := int(args.int64())
+		stop := int(args.int64())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZREMRANGEBYRANK data"))
+		}
+		return s.handleZRemRange(key, false, ScoreBound{}, ScoreBound{}, start, stop, now)
+
+	case CMD_ZLEXCOUNT:
+		args := newArgReader(msg.Value)
+		min := args.string()
+		max := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZLEXCOUNT data"))
+		}
+		return s.handleZLexCount(key, min, max, now)
+
+	case CMD_ZPOPMIN, CMD_ZPOPMAX:
+		count := 1
+		if len(msg.Value) > 0 {
+			args := newArgReader(msg.Value)
+			count = int(args.uint32())
+			if args.err != nil {
+				return s.createResponse(RESP_ERROR, []byte("Invalid sorted set pop data"))
+			}
+		}
+		return s.handleZPop(key, count, msg.Command == CMD_ZPOPMAX, now)
+
+	case CMD_ZSCORE:
+		args := newArgReader(msg.Value)
+		member := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZSCORE data"))
+		}
+		return s.handleZScore(key, member, now)
+
+	case CMD_ZMSCORE:
+		args := newArgReader(msg.Value)
+		members := args.keyList()
+		if args.err != nil || len(members) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZMSCORE data"))
+		}
+		return s.handleZMScore(key, members, now)
+
+	case CMD_ZRANK, CMD_ZREVRANK:
+		args := newArgReader(msg.Value)
+		member := args.string()
+		var flags uint8
+		if args.err == nil && args.offset < len(msg.Value) {
+			flags = args.uint8()
+		}
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANK data"))
+		}
+		return s.handleZRank(key, member, flags&ZRANK_WITHSCORE != 0, msg.Command == CMD_ZREVRANK, now)
+
 	case CMD_DEL:
 		s.incrementStat("del_ops")
 
@@ -482,10 +1849,7 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 		item := value.(*CacheItem)
 		// Check if expired
 		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
+			s.expireKey(key)
 			return s.createResponse(RESP_OK, []byte("0"))
 		}
 
@@ -526,10 +1890,7 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 
 		ttl := item.ExpiresAt - now
 		if ttl <= 0 {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
+			s.expireKey(key)
 			return s.createResponse(RESP_OK, []byte("-2"))
 		}
 
@@ -544,6 +1905,161 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 	case CMD_GETSET:
 		return s.handleGetSet(key, msg.Value, now)
 
+	case CMD_SETBIT:
+		args := newArgReader(msg.Value)
+		offset := int(args.uint32())
+		bit := args.uint8()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SETBIT data"))
+		}
+		return s.handleSetBit(key, offset, bit, now)
+
+	case CMD_GETBIT:
+		args := newArgReader(msg.Value)
+		offset := int(args.uint32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid GETBIT data"))
+		}
+		return s.handleGetBit(key, offset, now)
+
+	case CMD_BITCOUNT:
+		start, end, byBit, err := parseBitRange(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BITCOUNT data"))
+		}
+		return s.handleBitCount(key, start, end, byBit, now)
+
+	case CMD_BITFIELD:
+		ops, err := parseBitFieldOps(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BITFIELD data"))
+		}
+		return s.handleBitField(key, ops, now)
+
+	case CMD_BITPOS:
+		if len(msg.Value) < 1 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BITPOS data"))
+		}
+		start, end, byBit, err := parseBitRange(msg.Value[1:])
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BITPOS data"))
+		}
+		return s.handleBitPos(key, msg.Value[0], start, end, byBit, msg.Value[1]&BITRANGE_RANGE != 0, now)
+
+	case CMD_BITOP:
+		args := newArgReader(msg.Value)
+		op := args.uint8()
+		dst := args.string()
+		keys := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BITOP data"))
+		}
+		return s.handleBitOp(op, dst, keys, now)
+
+	// Stream operations
+	case CMD_XADD:
+		args := newArgReader(msg.Value)
+		flags := args.uint8()
+		var threshold string
+		if flags&(XTRIM_MAXLEN|XTRIM_MINID) != 0 {
+			threshold = args.string()
+		}
+		id := args.string()
+		fields := args.keyList()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XADD data"))
+		}
+		return s.handleXAdd(key, id, fields, flags, threshold, now)
+
+	case CMD_XREAD:
+		args := newArgReader(msg.Value)
+		count := int(args.uint32())
+		keys := args.keyList()
+		ids := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XREAD data"))
+		}
+		return s.handleXRead(keys, ids, count, now)
+
+	case CMD_XRANGE, CMD_XREVRANGE:
+		args := newArgReader(msg.Value)
+		start := args.string()
+		end := args.string()
+		count := int(args.int32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XRANGE data"))
+		}
+		return s.handleXRange(key, start, end, count, msg.Command == CMD_XREVRANGE, now)
+
+	case CMD_XLEN:
+		return s.handleXLen(key, now)
+
+	case CMD_XDEL:
+		args := newArgReader(msg.Value)
+		ids := args.keyList()
+		if args.err != nil || len(ids) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XDEL data"))
+		}
+		return s.handleXDel(key, ids, now)
+
+	case CMD_XTRIM:
+		args := newArgReader(msg.Value)
+		flags := args.uint8()
+		threshold := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XTRIM data"))
+		}
+		return s.handleXTrim(key, flags, threshold, now)
+
+	// Stream consumer group operations
+	case CMD_XGROUP:
+		return s.handleXGroup(msg.Value, now)
+
+	case CMD_XINFO:
+		return s.handleXInfo(msg.Value, now)
+
+	case CMD_XAUTOCLAIM:
+		args := newArgReader(msg.Value)
+		group := args.string()
+		consumer := args.string()
+		minIdle := args.int64()
+		start := args.string()
+		count := int(args.uint32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XAUTOCLAIM data"))
+		}
+		return s.handleXAutoClaim(key, group, consumer, minIdle, start, count, now)
+
+	case CMD_XREADGROUP:
+		args := newArgReader(msg.Value)
+		group := args.string()
+		consumer := args.string()
+		count := int(args.uint32())
+		flags := args.uint8()
+		keys := args.keyList()
+		ids := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XREADGROUP data"))
+		}
+		return s.handleXReadGroup(group, consumer, keys, ids, count, flags&XREADGROUP_NOACK != 0, now)
+
+	case CMD_XACK:
+		args := newArgReader(msg.Value)
+		group := args.string()
+		ids := args.keyList()
+		if args.err != nil || len(ids) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XACK data"))
+		}
+		return s.handleXAck(key, group, ids, now)
+
+	case CMD_XPENDING:
+		args := newArgReader(msg.Value)
+		group := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XPENDING data"))
+		}
+		return s.handleXPending(key, group, now)
+
 	case CMD_KEYS:
 		return s.handleKeys(string(msg.Value), now)
 
@@ -551,15 +2067,74 @@ func (s *GoFastServer) processCommand(msg *Message) []byte {
 		// Parse cursor from msg.TTL field and pattern from msg.Value
 		return s.handleScan(msg.TTL, string(msg.Value), 10, now)
 
+	case CMD_EXPIREAT, CMD_PEXPIREAT:
+		if len(msg.Value) < 8 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid EXPIREAT data"))
+		}
+		at := int64(binary.BigEndian.Uint64(msg.Value[0:8]))
+		if msg.Command == CMD_PEXPIREAT {
+			at = (at + 999) / 1000 // Round milliseconds up to whole seconds
+		}
+		return s.handleExpireAt(key, at, now)
+
+	case CMD_EXPIRETIME:
+		return s.handleExpireTime(key, false, now)
+
+	case CMD_PEXPIRETIME:
+		return s.handleExpireTime(key, true, now)
+
+	case CMD_OBJECT:
+		return s.handleObject(msg.Value, now)
+
+	case CMD_MEMORY_USAGE:
+		return s.handleMemoryUsage(key, int(msg.TTL), now)
+
+	case CMD_PUBLISH:
+		return s.handlePublish(key, msg.Value)
+
+	case CMD_PUBSUB:
+		return s.handlePubSub(msg.Value)
+
+	case CMD_SLOWLOG:
+		return s.handleSlowLog(msg.Value)
+
+	case CMD_INFO:
+		return s.handleInfo(string(msg.Value))
+
+	case CMD_LATENCY:
+		return s.handleLatency(msg.Value)
+
+	case CMD_COMMANDSTATS:
+		return s.handleCommandStats()
+
+	case CMD_CONFIG:
+		return s.handleConfig(msg.Value)
+
+	case CMD_SUBSCRIBE, CMD_PSUBSCRIBE:
+		// Subscription mode is entered by the connection loop, so these only
+		// reach here from a pipeline
+		return s.createResponse(RESP_ERROR, []byte("SUBSCRIBE is not allowed in a pipeline"))
+
+	case CMD_UNSUBSCRIBE, CMD_PUNSUBSCRIBE:
+		return s.createResponse(RESP_OK, []byte("0"))
+
+	case CMD_MULTI, CMD_EXEC, CMD_DISCARD, CMD_WATCH:
+		// Transactions are tracked per connection, so these only reach here
+		// from a pipeline or a queued transaction
+		return s.createResponse(RESP_ERROR, []byte("Transaction commands are not allowed in a pipeline"))
+
 	default:
 		return s.createResponse(RESP_ERROR, []byte("Unknown command"))
 	}
 }
 
 // New processIndividualCommand() function (add after parsePipelineMessage()):
-func (s *GoFastServer) processIndividualCommand(msg *Message, now int64) []byte {
+func (s *DatabaseState) processIndividualCommand(msg *Message, now int64) []byte {
 	// This is the same logic as processCommand but without pipeline handling
 	// and without incrementing total_ops (we'll increment it once per pipeline)
+	if s.renames.Disabled(msg.Command) {
+		return s.createResponse(RESP_ERROR, []byte("ERR unknown command"))
+	}
 
 	key := string(msg.Key)
 
@@ -571,6 +2146,7 @@ func (s *GoFastServer) processIndividualCommand(msg *Message, now int64) []byte
 			Value:     msg.Value,
 			CreatedAt: now,
 		}
+		s.compressValue(item)
 		if msg.TTL > 0 {
 			item.ExpiresAt = now + int64(msg.TTL)
 			s.ttlMutex.Lock()
@@ -584,20 +2160,20 @@ func (s *GoFastServer) processIndividualCommand(msg *Message, now int64) []byte
 		s.incrementStat("get_ops")
 		value, exists := s.storage.Load(key)
 		if !exists {
+			s.incrementStat("misses")
 			return s.createResponse(RESP_NOT_FOUND, nil)
 		}
 		item := value.(*CacheItem)
 		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
+			s.expireKey(key)
+			s.incrementStat("misses")
 			return s.createResponse(RESP_NOT_FOUND, nil)
 		}
 		if item.DataType != TYPE_STRING {
 			return s.createResponse(RESP_ERROR, []byte("WRONGTYPE Operation against a key holding the wrong kind of value"))
 		}
-		return s.createResponse(RESP_OK, item.Value.([]byte))
+		s.incrementStat("hits")
+		return s.createResponse(RESP_OK, item.stringValue())
 
 	case CMD_DEL:
 		s.incrementStat("del_ops")
@@ -617,10 +2193,7 @@ func (s *GoFastServer) processIndividualCommand(msg *Message, now int64) []byte
 		}
 		item := value.(*CacheItem)
 		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
+			s.expireKey(key)
 			return s.createResponse(RESP_OK, []byte("0"))
 		}
 		return s.createResponse(RESP_OK, []byte("1"))
@@ -656,10 +2229,7 @@ func (s *GoFastServer) processIndividualCommand(msg *Message, now int64) []byte
 		}
 		ttl := item.ExpiresAt - now
 		if ttl <= 0 {
-			s.storage.Delete(key)
-			s.ttlMutex.Lock()
-			delete(s.ttlIndex, key)
-			s.ttlMutex.Unlock()
+			s.expireKey(key)
 			return s.createResponse(RESP_OK, []byte("-2"))
 		}
 		return s.createResponse(RESP_OK, []byte(fmt.Sprintf("%d", ttl)))
@@ -670,17 +2240,17 @@ func (s *GoFastServer) processIndividualCommand(msg *Message, now int64) []byte
 	case CMD_RPUSH:
 		return s.handleListPush(key, msg.Value, false, now)
 	case CMD_LPOP:
-		return s.handleListPop(key, true, now)
+		return s.handleListPop(key, true, parsePopCount(msg.Value), now)
 	case CMD_RPOP:
-		return s.handleListPop(key, false, now)
+		return s.handleListPop(key, false, parsePopCount(msg.Value), now)
 	case CMD_LLEN:
 		return s.handleListLen(key, now)
 
 	// Set operations
 	case CMD_SADD:
-		return s.handleSetAdd(key, string(msg.Value), now)
+		return s.handleSetAdd(key, msg.Value, now)
 	case CMD_SREM:
-		return s.handleSetRem(key, string(msg.Value), now)
+		return s.handleSetRem(key, msg.Value, now)
 	case CMD_SMEMBERS:
 		return s.handleSetMembers(key, now)
 	case CMD_SCARD:
@@ -688,6 +2258,63 @@ func (s *GoFastServer) processIndividualCommand(msg *Message, now int64) []byte
 	case CMD_SISMEMBER:
 		return s.handleSetIsMember(key, string(msg.Value), now)
 
+	case CMD_SUNION, CMD_SINTER, CMD_SDIFF:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid set algebra data"))
+		}
+		return s.handleSetAlgebra(msg.Command, keys, now)
+
+	case CMD_SINTERCARD:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		limit := int(args.uint32())
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SINTERCARD data"))
+		}
+		return s.handleSetInterCard(keys, limit, now)
+
+	case CMD_SUNIONSTORE, CMD_SINTERSTORE, CMD_SDIFFSTORE:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid set algebra data"))
+		}
+		return s.handleSetAlgebraStore(msg.Command, key, keys, now)
+
+	case CMD_SMOVE:
+		args := newArgReader(msg.Value)
+		dst := args.string()
+		member := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SMOVE data"))
+		}
+		return s.handleSetMove(key, dst, member, now)
+
+	case CMD_SPOP:
+		args := newArgReader(msg.Value)
+		count := int(args.uint32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SPOP data"))
+		}
+		return s.handleSetPop(key, count, now)
+
+	case CMD_SSCAN:
+		cursor, pattern, count, err := parseScanArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SSCAN data"))
+		}
+		return s.handleSetScan(key, cursor, pattern, count, now)
+
+	case CMD_SRANDMEMBER:
+		args := newArgReader(msg.Value)
+		count := int(args.int32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SRANDMEMBER data"))
+		}
+		return s.handleSetRandMember(key, count, now)
+
 	// Hash operations
 	case CMD_HSET:
 		return s.handleHashSet(key, msg.Value, now)
@@ -701,6 +2328,239 @@ func (s *GoFastServer) processIndividualCommand(msg *Message, now int64) []byte
 		return s.handleHashLen(key, now)
 	case CMD_HEXISTS:
 		return s.handleHashExists(key, string(msg.Value), now)
+	case CMD_HKEYS:
+		return s.handleHashKeys(key, now)
+	case CMD_HVALS:
+		return s.handleHashVals(key, now)
+	case CMD_HMSET:
+		return s.handleHashMSet(key, msg.Value, now)
+	case CMD_HSETNX:
+		args := newArgReader(msg.Value)
+		field := args.string()
+		value := args.bytes()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HSETNX data"))
+		}
+		return s.handleHashSetNX(key, field, value, now)
+	case CMD_HSCAN:
+		cursor, pattern, count, err := parseScanArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HSCAN data"))
+		}
+		return s.handleHashScan(key, cursor, pattern, count, now)
+	case CMD_HRANDFIELD:
+		args := newArgReader(msg.Value)
+		count := int(args.int32())
+		flags := args.uint8()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HRANDFIELD data"))
+		}
+		return s.handleHashRandField(key, count, flags&HRANDFIELD_WITHVALUES != 0, now)
+	case CMD_HINCRBY:
+		args := newArgReader(msg.Value)
+		field := args.string()
+		delta := args.int64()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HINCRBY data"))
+		}
+		return s.handleHashIncrBy(key, field, delta, now)
+	case CMD_HINCRBYFLOAT:
+		args := newArgReader(msg.Value)
+		field := args.string()
+		delta := math.Float64frombits(args.uint64())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HINCRBYFLOAT data"))
+		}
+		return s.handleHashIncrByFloat(key, field, delta, now)
+	case CMD_HMGET:
+		args := newArgReader(msg.Value)
+		fields := args.keyList()
+		if args.err != nil || len(fields) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HMGET data"))
+		}
+		return s.handleHashMGet(key, fields, now)
+	case CMD_HGETDEL:
+		args := newArgReader(msg.Value)
+		fields := args.keyList()
+		if args.err != nil || len(fields) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid HGETDEL data"))
+		}
+		return s.handleHashGetDel(key, fields, now)
+
+	// Sorted set operations
+	case CMD_ZADD:
+		entries, flags, err := parseZAddArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZADD data"))
+		}
+		return s.handleZAdd(key, entries, flags, now)
+
+	case CMD_ZRANGE:
+		start, stop, opts, err := parseZRangeArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANGE data"))
+		}
+		return s.handleZRange(key, start, stop, opts, now)
+
+	case CMD_ZRANGEBYSCORE, CMD_ZREVRANGEBYSCORE:
+		// Same layout as ZRANGE; only the WITHSCORES and LIMIT flags apply
+		min, max, opts, err := parseZRangeArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANGEBYSCORE data"))
+		}
+		opts.ByScore, opts.ByLex = true, false
+		opts.Rev = msg.Command == CMD_ZREVRANGEBYSCORE
+		return s.handleZRange(key, min, max, opts, now)
+
+	case CMD_ZRANGEBYLEX:
+		// Same layout as ZRANGE; only the LIMIT flag applies
+		min, max, opts, err := parseZRangeArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANGEBYLEX data"))
+		}
+		opts.ByScore, opts.ByLex, opts.Rev = false, true, false
+		return s.handleZRange(key, min, max, opts, now)
+
+	case CMD_ZINCRBY:
+		args := newArgReader(msg.Value)
+		delta := math.Float64frombits(args.uint64())
+		member := args.string()
+		if args.err != nil || math.IsNaN(delta) {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZINCRBY data"))
+		}
+		return s.handleZIncrBy(key, member, delta, now)
+
+	case CMD_ZREM:
+		args := newArgReader(msg.Value)
+		members := args.keyList()
+		if args.err != nil || len(members) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZREM data"))
+		}
+		return s.handleZRem(key, members, now)
+
+	case CMD_ZCARD:
+		return s.handleZCard(key, now)
+
+	case CMD_ZSCAN:
+		cursor, pattern, count, err := parseScanArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZSCAN data"))
+		}
+		return s.handleZScan(key, cursor, pattern, count, now)
+
+	case CMD_ZUNIONSTORE, CMD_ZINTERSTORE:
+		keys, weights, aggregate, err := parseZStoreArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid sorted set store data"))
+		}
+		return s.handleZSetAggregateStore(msg.Command, key, keys, weights, aggregate, now)
+
+	case CMD_ZDIFF:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		var flags uint8
+		if args.err == nil && args.offset < len(msg.Value) {
+			flags = args.uint8()
+		}
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZDIFF data"))
+		}
+		return s.handleZDiff(keys, flags&ZDIFF_WITHSCORES != 0, now)
+
+	case CMD_ZDIFFSTORE:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZDIFFSTORE data"))
+		}
+		return s.handleZDiffStore(key, keys, now)
+
+	case CMD_ZRANGESTORE:
+		args := newArgReader(msg.Value)
+		src := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANGESTORE data"))
+		}
+		start, stop, opts, err := parseZRangeArgs(msg.Value[args.offset:])
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANGESTORE data"))
+		}
+		return s.handleZRangeStore(key, src, start, stop, opts, now)
+
+	case CMD_ZCOUNT:
+		args := newArgReader(msg.Value)
+		min := args.string()
+		max := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZCOUNT data"))
+		}
+		return s.handleZCount(key, min, max, now)
+
+	case CMD_ZREMRANGEBYSCORE:
+		args := newArgReader(msg.Value)
+		min := ScoreBound{Value: math.Float64frombits(args.uint64())}
+		max := ScoreBound{Value: math.Float64frombits(args.uint64())}
+		if args.err != nil || math.IsNaN(min.Value) || math.IsNaN(max.Value) {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZREMRANGEBYSCORE data"))
+		}
+		return s.handleZRemRange(key, true, min, max, 0, 0, now)
+
+	case CMD_ZREMRANGEBYRANK:
+		args := newArgReader(msg.Value)
+		start := int(args.int64())
+		stop := int(args.int64())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZREMRANGEBYRANK data"))
+		}
+		return s.handleZRemRange(key, false, ScoreBound{}, ScoreBound{}, start, stop, now)
+
+	case CMD_ZLEXCOUNT:
+		args := newArgReader(msg.Value)
+		min := args.string()
+		max := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZLEXCOUNT data"))
+		}
+		return s.handleZLexCount(key, min, max, now)
+
+	case CMD_ZPOPMIN, CMD_ZPOPMAX:
+		count := 1
+		if len(msg.Value) > 0 {
+			args := newArgReader(msg.Value)
+			count = int(args.uint32())
+			if args.err != nil {
+				return s.createResponse(RESP_ERROR, []byte("Invalid sorted set pop data"))
+			}
+		}
+		return s.handleZPop(key, count, msg.Command == CMD_ZPOPMAX, now)
+
+	case CMD_ZSCORE:
+		args := newArgReader(msg.Value)
+		member := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZSCORE data"))
+		}
+		return s.handleZScore(key, member, now)
+
+	case CMD_ZMSCORE:
+		args := newArgReader(msg.Value)
+		members := args.keyList()
+		if args.err != nil || len(members) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZMSCORE data"))
+		}
+		return s.handleZMScore(key, members, now)
+
+	case CMD_ZRANK, CMD_ZREVRANK:
+		args := newArgReader(msg.Value)
+		member := args.string()
+		var flags uint8
+		if args.err == nil && args.offset < len(msg.Value) {
+			flags = args.uint8()
+		}
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid ZRANK data"))
+		}
+		return s.handleZRank(key, member, flags&ZRANK_WITHSCORE != 0, msg.Command == CMD_ZREVRANK, now)
 
 	case CMD_LINDEX:
 		return s.handleListIndex(key, int(msg.TTL), now) // TTL field reused for index
@@ -709,16 +2569,282 @@ func (s *GoFastServer) processIndividualCommand(msg *Message, now int64) []byte
 		end := int(binary.BigEndian.Uint32(msg.Value))
 		return s.handleListRange(key, int(msg.TTL), end, now)
 
+	case CMD_LSET:
+		args := newArgReader(msg.Value)
+		index := int(args.int32())
+		value := args.bytes()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LSET data"))
+		}
+		return s.handleListSet(key, index, value, now)
+
+	case CMD_LINSERT:
+		args := newArgReader(msg.Value)
+		after := args.uint8() != 0
+		pivot := args.bytes()
+		value := args.bytes()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LINSERT data"))
+		}
+		return s.handleListInsert(key, pivot, value, after, now)
+
+	case CMD_LTRIM:
+		args := newArgReader(msg.Value)
+		start := int(args.int32())
+		end := int(args.int32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LTRIM data"))
+		}
+		return s.handleListTrim(key, start, end, now)
+
+	case CMD_LPOS:
+		args := newArgReader(msg.Value)
+		element := args.bytes()
+		rank := int(args.int32())
+		count := int(args.int32())
+		maxLen := int(args.int32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LPOS data"))
+		}
+		return s.handleListPos(key, element, rank, count, maxLen, now)
+
+	case CMD_LMOVE:
+		args := newArgReader(msg.Value)
+		dst := args.string()
+		flags := args.uint8()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LMOVE data"))
+		}
+		return s.handleListMove(key, dst, flags&LMOVE_SRC_LEFT != 0, flags&LMOVE_DST_LEFT != 0, now)
+
+	case CMD_LREM:
+		args := newArgReader(msg.Value)
+		count := int(args.int32())
+		element := args.bytes()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LREM data"))
+		}
+		return s.handleListRem(key, count, element, now)
+
+	case CMD_BLPOP, CMD_BRPOP:
+		keys, timeout, err := parseBlockingArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid blocking pop data"))
+		}
+		return s.handleBlockingPop(keys, timeout, msg.Command == CMD_BLPOP, noWait, now)
+
+	case CMD_BZPOPMIN, CMD_BZPOPMAX:
+		keys, timeout, err := parseBlockingArgs(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid blocking pop data"))
+		}
+		return s.handleBlockingZPop(keys, timeout, msg.Command == CMD_BZPOPMAX, noWait, now)
+
+	case CMD_BZMPOP:
+		args := newArgReader(msg.Value)
+		seconds := math.Float32frombits(args.uint32())
+		keys := args.keyList()
+		direction := args.uint8()
+		count := int(args.uint32())
+		timeout, ok := blockingTimeout(seconds)
+		if args.err != nil || len(keys) == 0 || !ok {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BZMPOP data"))
+		}
+		return s.handleBlockingZMPop(keys, timeout, direction != 0, count, noWait, now)
+
+	case CMD_LMPOP:
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		direction := args.uint8()
+		count := int(args.uint32())
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid LMPOP data"))
+		}
+		return s.handleListMPop(keys, direction == 0, count, now)
+
 	case CMD_INCR:
 		return s.handleIncr(key, now)
 	case CMD_DECR:
 		return s.handleDecr(key, now)
 	case CMD_GETSET:
 		return s.handleGetSet(key, msg.Value, now)
+	case CMD_SETBIT:
+		args := newArgReader(msg.Value)
+		offset := int(args.uint32())
+		bit := args.uint8()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SETBIT data"))
+		}
+		return s.handleSetBit(key, offset, bit, now)
+	case CMD_GETBIT:
+		args := newArgReader(msg.Value)
+		offset := int(args.uint32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid GETBIT data"))
+		}
+		return s.handleGetBit(key, offset, now)
+	case CMD_BITCOUNT:
+		start, end, byBit, err := parseBitRange(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BITCOUNT data"))
+		}
+		return s.handleBitCount(key, start, end, byBit, now)
+	case CMD_BITFIELD:
+		ops, err := parseBitFieldOps(msg.Value)
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BITFIELD data"))
+		}
+		return s.handleBitField(key, ops, now)
+	case CMD_BITPOS:
+		if len(msg.Value) < 1 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BITPOS data"))
+		}
+		start, end, byBit, err := parseBitRange(msg.Value[1:])
+		if err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BITPOS data"))
+		}
+		return s.handleBitPos(key, msg.Value[0], start, end, byBit, msg.Value[1]&BITRANGE_RANGE != 0, now)
+	case CMD_BITOP:
+		args := newArgReader(msg.Value)
+		op := args.uint8()
+		dst := args.string()
+		keys := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid BITOP data"))
+		}
+		return s.handleBitOp(op, dst, keys, now)
+	case CMD_XADD:
+		args := newArgReader(msg.Value)
+		flags := args.uint8()
+		var threshold string
+		if flags&(XTRIM_MAXLEN|XTRIM_MINID) != 0 {
+			threshold = args.string()
+		}
+		id := args.string()
+		fields := args.keyList()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XADD data"))
+		}
+		return s.handleXAdd(key, id, fields, flags, threshold, now)
+	case CMD_XREAD:
+		args := newArgReader(msg.Value)
+		count := int(args.uint32())
+		keys := args.keyList()
+		ids := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XREAD data"))
+		}
+		return s.handleXRead(keys, ids, count, now)
+	case CMD_XRANGE, CMD_XREVRANGE:
+		args := newArgReader(msg.Value)
+		start := args.string()
+		end := args.string()
+		count := int(args.int32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XRANGE data"))
+		}
+		return s.handleXRange(key, start, end, count, msg.Command == CMD_XREVRANGE, now)
+	case CMD_XLEN:
+		return s.handleXLen(key, now)
+	case CMD_XDEL:
+		args := newArgReader(msg.Value)
+		ids := args.keyList()
+		if args.err != nil || len(ids) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XDEL data"))
+		}
+		return s.handleXDel(key, ids, now)
+	case CMD_XTRIM:
+		args := newArgReader(msg.Value)
+		flags := args.uint8()
+		threshold := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XTRIM data"))
+		}
+		return s.handleXTrim(key, flags, threshold, now)
+	case CMD_XGROUP:
+		return s.handleXGroup(msg.Value, now)
+	case CMD_XINFO:
+		return s.handleXInfo(msg.Value, now)
+	case CMD_XAUTOCLAIM:
+		args := newArgReader(msg.Value)
+		group := args.string()
+		consumer := args.string()
+		minIdle := args.int64()
+		start := args.string()
+		count := int(args.uint32())
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XAUTOCLAIM data"))
+		}
+		return s.handleXAutoClaim(key, group, consumer, minIdle, start, count, now)
+	case CMD_XREADGROUP:
+		args := newArgReader(msg.Value)
+		group := args.string()
+		consumer := args.string()
+		count := int(args.uint32())
+		flags := args.uint8()
+		keys := args.keyList()
+		ids := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XREADGROUP data"))
+		}
+		return s.handleXReadGroup(group, consumer, keys, ids, count, flags&XREADGROUP_NOACK != 0, now)
+	case CMD_XACK:
+		args := newArgReader(msg.Value)
+		group := args.string()
+		ids := args.keyList()
+		if args.err != nil || len(ids) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XACK data"))
+		}
+		return s.handleXAck(key, group, ids, now)
+	case CMD_XPENDING:
+		args := newArgReader(msg.Value)
+		group := args.string()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid XPENDING data"))
+		}
+		return s.handleXPending(key, group, now)
 	case CMD_KEYS:
 		return s.handleKeys(string(msg.Value), now)
 	case CMD_SCAN:
 		return s.handleScan(msg.TTL, string(msg.Value), 10, now)
+	case CMD_EXPIREAT, CMD_PEXPIREAT:
+		if len(msg.Value) < 8 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid EXPIREAT data"))
+		}
+		at := int64(binary.BigEndian.Uint64(msg.Value[0:8]))
+		if msg.Command == CMD_PEXPIREAT {
+			at = (at + 999) / 1000
+		}
+		return s.handleExpireAt(key, at, now)
+
+	case CMD_EXPIRETIME:
+		return s.handleExpireTime(key, false, now)
+
+	case CMD_PEXPIRETIME:
+		return s.handleExpireTime(key, true, now)
+
+	case CMD_OBJECT:
+		return s.handleObject(msg.Value, now)
+	case CMD_MEMORY_USAGE:
+		return s.handleMemoryUsage(key, int(msg.TTL), now)
+	case CMD_PUBLISH:
+		return s.handlePublish(key, msg.Value)
+	case CMD_PUBSUB:
+		return s.handlePubSub(msg.Value)
+	case CMD_SLOWLOG:
+		return s.handleSlowLog(msg.Value)
+	case CMD_INFO:
+		return s.handleInfo(string(msg.Value))
+	case CMD_LATENCY:
+		return s.handleLatency(msg.Value)
+	case CMD_COMMANDSTATS:
+		return s.handleCommandStats()
+	case CMD_CONFIG:
+		return s.handleConfig(msg.Value)
+	case CMD_SUBSCRIBE, CMD_PSUBSCRIBE:
+		return s.createResponse(RESP_ERROR, []byte("SUBSCRIBE is not allowed in a pipeline"))
+	case CMD_UNSUBSCRIBE, CMD_PUNSUBSCRIBE:
+		return s.createResponse(RESP_OK, []byte("0"))
 
 	default:
 		return s.createResponse(RESP_ERROR, []byte("Unknown command in pipeline"))
@@ -739,6 +2865,7 @@ func (s *GoFastServer) createResponse(status uint8, data []byte) []byte {
 	s.stats.mutex.Lock()
 	s.stats.BytesWritten += uint64(len(response))
 	s.stats.mutex.Unlock()
+	s.metrics.bytesWritten.Add(float64(len(response)))
 
 	return response
 }
@@ -748,3 +2875,40 @@ func (s *GoFastServer) writeResponse(writer *bufio.Writer, response []byte) erro
 	_, err := writer.Write(response)
 	return err
 }
+
+// writeFramedResponse writes response with the header of the connection's
+// protocol version. Version 2 widens the length to 8 bytes, taken from the
+// buffer itself since createResponse can only record 32 bits, and follows
+// it with the request ID. With wire compression a flags byte follows the
+// status, and data over the configured threshold is sent LZ4 compressed
+// when that makes it smaller.
+func (s *GoFastServer) writeFramedResponse(writer *bufio.Writer, response []byte, protocol uint8, compressed bool, requestID uint32) error {
+	if protocol < PROTOCOL_VERSION_2 && !compressed {
+		return s.writeResponse(writer, response)
+	}
+
+	data := response[5:]
+	header := []byte{response[0]}
+	if compressed {
+		var flags uint8
+		if len(data) > s.config.CompressionThreshold {
+			if packed, ok := compressPayload(data); ok {
+				data = packed
+				flags |= COMPRESS_LZ4
+			}
+		}
+		header = append(header, flags)
+	}
+	if protocol >= PROTOCOL_VERSION_2 {
+		header = binary.BigEndian.AppendUint64(header, uint64(len(data)))
+		header = binary.BigEndian.AppendUint32(header, requestID)
+	} else {
+		header = binary.BigEndian.AppendUint32(header, uint32(len(data)))
+	}
+
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	_, err := writer.Write(data)
+	return err
+}
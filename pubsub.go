@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// subscriberOutboxSize bounds the push frames queued for one subscriber.
+// Frames beyond it are dropped so a slow reader never stalls PUBLISH.
+const subscriberOutboxSize = 1024
+
+// PubSubBroker tracks channel and pattern subscriptions and fans published
+// messages out to them
+type PubSubBroker struct {
+	channels map[string]map[*Subscriber]struct{}
+	patterns map[string]map[*Subscriber]struct{}
+	match    func(pattern, channel string) bool
+	mutex    sync.RWMutex
+}
+
+// Subscriber is a connection in subscription mode. Its channel and pattern
+// sets are guarded by the broker mutex.
+type Subscriber struct {
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	outbox   chan []byte
+}
+
+func NewPubSubBroker(match func(pattern, channel string) bool) *PubSubBroker {
+	return &PubSubBroker{
+		channels: make(map[string]map[*Subscriber]struct{}),
+		patterns: make(map[string]map[*Subscriber]struct{}),
+		match:    match,
+	}
+}
+
+func NewSubscriber() *Subscriber {
+	return &Subscriber{
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		outbox:   make(chan []byte, subscriberOutboxSize),
+	}
+}
+
+// Count returns the number of channels and patterns sub is subscribed to
+func (b *PubSubBroker) Count(sub *Subscriber) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// Subscribe adds channel subscriptions and returns the subscriber's total
+func (b *PubSubBroker) Subscribe(sub *Subscriber, channels []string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	subscribe(b.channels, sub.channels, sub, channels)
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// PSubscribe adds pattern subscriptions and returns the subscriber's total
+func (b *PubSubBroker) PSubscribe(sub *Subscriber, patterns []string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	subscribe(b.patterns, sub.patterns, sub, patterns)
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// Unsubscribe drops channel subscriptions, or all of them when channels is
+// empty, and returns the subscriber's remaining total
+func (b *PubSubBroker) Unsubscribe(sub *Subscriber, channels []string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	unsubscribe(b.channels, sub.channels, sub, channels)
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// PUnsubscribe drops pattern subscriptions, or all of them when patterns is
+// empty, and returns the subscriber's remaining total
+func (b *PubSubBroker) PUnsubscribe(sub *Subscriber, patterns []string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	unsubscribe(b.patterns, sub.patterns, sub, patterns)
+	return len(sub.channels) + len(sub.patterns)
+}
+
+func subscribe(registry map[string]map[*Subscriber]struct{}, own map[string]struct{}, sub *Subscriber, names []string) {
+	for _, name := range names {
+		subs, ok := registry[name]
+		if !ok {
+			subs = make(map[*Subscriber]struct{})
+			registry[name] = subs
+		}
+		subs[sub] = struct{}{}
+		own[name] = struct{}{}
+	}
+}
+
+func unsubscribe(registry map[string]map[*Subscriber]struct{}, own map[string]struct{}, sub *Subscriber, names []string) {
+	if len(names) == 0 {
+		names = make([]string, 0, len(own))
+		for name := range own {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		if subs, ok := registry[name]; ok {
+			delete(subs, sub)
+			if len(subs) == 0 {
+				delete(registry, name)
+			}
+		}
+		delete(own, name)
+	}
+}
+
+// Publish queues frame for every subscription matching channel and returns
+// how many subscriptions received it
+func (b *PubSubBroker) Publish(channel string, frame []byte) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	receivers := 0
+	deliver := func(subs map[*Subscriber]struct{}) {
+		for sub := range subs {
+			select {
+			case sub.outbox <- frame:
+			default:
+			}
+			receivers++
+		}
+	}
+
+	deliver(b.channels[channel])
+	for pattern, subs := range b.patterns {
+		if b.match(pattern, channel) {
+			deliver(subs)
+		}
+	}
+	return receivers
+}
+
+// Channels returns the channels with at least one subscriber that match
+// pattern, sorted
+func (b *PubSubBroker) Channels(pattern string) []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	channels := make([]string, 0, len(b.channels))
+	for channel := range b.channels {
+		if b.match(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// NumSub returns the subscriber count of each channel
+func (b *PubSubBroker) NumSub(channels []string) []int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	counts := make([]int, len(channels))
+	for i, channel := range channels {
+		counts[i] = len(b.channels[channel])
+	}
+	return counts
+}
+
+// NumPat returns the number of distinct subscribed patterns
+func (b *PubSubBroker) NumPat() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.patterns)
+}
+
+// HasSubscribers reports whether any channel or pattern has a subscriber
+func (b *PubSubBroker) HasSubscribers() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.channels) > 0 || len(b.patterns) > 0
+}
+
+// KeyspaceFlags selects which keyspace notifications are published
+type KeyspaceFlags uint16
+
+const (
+	NOTIFY_KEYSPACE KeyspaceFlags = 0x001 // K: publish to __keyspace@<db>__:<key>
+	NOTIFY_KEYEVENT KeyspaceFlags = 0x002 // E: publish to __keyevent@<db>__:<event>
+	NOTIFY_GENERIC  KeyspaceFlags = 0x004 // g: DEL, EXPIRE
+	NOTIFY_STRING   KeyspaceFlags = 0x008 // $: SET, INCR, DECR, GETSET, SETBIT
+	NOTIFY_LIST     KeyspaceFlags = 0x010 // l: LPUSH, RPUSH, LPOP, RPOP, LSET, LINSERT, LTRIM, LREM
+	NOTIFY_SET      KeyspaceFlags = 0x020 // s: SADD, SREM, SPOP
+	NOTIFY_HASH     KeyspaceFlags = 0x040 // h: HSET, HMSET, HSETNX, HDEL, HINCRBY, HINCRBYFLOAT
+	NOTIFY_ZSET     KeyspaceFlags = 0x080 // z: ZADD, ZINCRBY, ZREM, ZPOPMIN, ZPOPMAX
+	NOTIFY_STREAM   KeyspaceFlags = 0x100 // t: XADD, XDEL, XTRIM
+	NOTIFY_EXPIRED  KeyspaceFlags = 0x200 // x: keys removed because their TTL ran out
+
+	// A: every event class
+	NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET |
+		NOTIFY_HASH | NOTIFY_ZSET | NOTIFY_STREAM | NOTIFY_EXPIRED
+)
+
+var keyspaceFlagChars = map[rune]KeyspaceFlags{
+	'K': NOTIFY_KEYSPACE,
+	'E': NOTIFY_KEYEVENT,
+	'g': NOTIFY_GENERIC,
+	'$': NOTIFY_STRING,
+	'l': NOTIFY_LIST,
+	's': NOTIFY_SET,
+	'h': NOTIFY_HASH,
+	'z': NOTIFY_ZSET,
+	't': NOTIFY_STREAM,
+	'x': NOTIFY_EXPIRED,
+	'A': NOTIFY_ALL,
+}
+
+// KeyspaceConfig holds the parsed notify_keyspace_events setting
+type KeyspaceConfig struct {
+	Flags KeyspaceFlags
+}
+
+// ParseKeyspaceConfig parses a Redis-style notify-keyspace-events string
+// such as "Ex" or "KEA". An empty string disables notifications.
+func ParseKeyspaceConfig(events string) (KeyspaceConfig, error) {
+	var config KeyspaceConfig
+	for _, c := range events {
+		flag, ok := keyspaceFlagChars[c]
+		if !ok {
+			return KeyspaceConfig{}, fmt.Errorf("invalid keyspace event flag: %q", c)
+		}
+		config.Flags |= flag
+	}
+	return config, nil
+}
+
+// Enabled reports whether events of class are published to at least one of
+// the keyspace or keyevent channels
+func (c KeyspaceConfig) Enabled(class KeyspaceFlags) bool {
+	return c.Flags&class != 0 && c.Flags&(NOTIFY_KEYSPACE|NOTIFY_KEYEVENT) != 0
+}
+
+// keyspaceEvent is the notification a mutating command raises on its key
+type keyspaceEvent struct {
+	class KeyspaceFlags
+	name  string
+	count bool // The reply is a count of changes, and "0" means nothing changed
+}
+
+var keyspaceEvents = map[uint8]keyspaceEvent{
+	CMD_SET:          {NOTIFY_STRING, "set", false},
+	CMD_GETSET:       {NOTIFY_STRING, "set", false},
+	CMD_INCR:         {NOTIFY_STRING, "incrby", false},
+	CMD_DECR:         {NOTIFY_STRING, "decrby", false},
+	CMD_SETBIT:       {NOTIFY_STRING, "setbit", false},
+	CMD_DEL:          {NOTIFY_GENERIC, "del", true},
+	CMD_EXPIRE:       {NOTIFY_GENERIC, "expire", true},
+	CMD_EXPIREAT:     {NOTIFY_GENERIC, "expire", true},
+	CMD_PEXPIREAT:    {NOTIFY_GENERIC, "expire", true},
+	CMD_LPUSH:        {NOTIFY_LIST, "lpush", false},
+	CMD_RPUSH:        {NOTIFY_LIST, "rpush", false},
+	CMD_LPOP:         {NOTIFY_LIST, "lpop", false},
+	CMD_RPOP:         {NOTIFY_LIST, "rpop", false},
+	CMD_LSET:         {NOTIFY_LIST, "lset", false},
+	CMD_LINSERT:      {NOTIFY_LIST, "linsert", false},
+	CMD_LTRIM:        {NOTIFY_LIST, "ltrim", false},
+	CMD_LREM:         {NOTIFY_LIST, "lrem", true},
+	CMD_SADD:         {NOTIFY_SET, "sadd", true},
+	CMD_SREM:         {NOTIFY_SET, "srem", true},
+	CMD_SPOP:         {NOTIFY_SET, "spop", false},
+	CMD_HSET:         {NOTIFY_HASH, "hset", false},
+	CMD_HMSET:        {NOTIFY_HASH, "hset", false},
+	CMD_HSETNX:       {NOTIFY_HASH, "hset", true},
+	CMD_HDEL:         {NOTIFY_HASH, "hdel", true},
+	CMD_HINCRBY:      {NOTIFY_HASH, "hincrby", false},
+	CMD_HINCRBYFLOAT: {NOTIFY_HASH, "hincrbyfloat", false},
+	CMD_ZADD:         {NOTIFY_ZSET, "zadd", false},
+	CMD_ZINCRBY:      {NOTIFY_ZSET, "zincr", false},
+	CMD_ZREM:         {NOTIFY_ZSET, "zrem", true},
+	CMD_ZPOPMIN:      {NOTIFY_ZSET, "zpopmin", false},
+	CMD_ZPOPMAX:      {NOTIFY_ZSET, "zpopmax", false},
+	CMD_XADD:         {NOTIFY_STREAM, "xadd", false},
+	CMD_XDEL:         {NOTIFY_STREAM, "xdel", true},
+	CMD_XTRIM:        {NOTIFY_STREAM, "xtrim", true},
+}
+
+// notifyCommand raises the keyspace event for msg once it has succeeded
+func (s *DatabaseState) notifyCommand(msg *Message, response []byte) {
+	event, ok := keyspaceEvents[msg.Command]
+	if !ok || len(response) < 5 || response[0] != RESP_OK {
+		return
+	}
+	if event.count && string(response[5:]) == "0" {
+		return
+	}
+	s.notifyKeyspaceEvent(event.class, event.name, string(msg.Key))
+}
+
+// notifyKeyspaceEvent publishes event on key to the keyspace and keyevent
+// channels enabled in the server configuration
+func (s *DatabaseState) notifyKeyspaceEvent(class KeyspaceFlags, event, key string) {
+	if !s.keyspace.Enabled(class) || !s.pubsub.HasSubscribers() {
+		return
+	}
+	if s.keyspace.Flags&NOTIFY_KEYSPACE != 0 {
+		s.publish(fmt.Sprintf("__keyspace@%d__:%s", s.index, key), []byte(event))
+	}
+	if s.keyspace.Flags&NOTIFY_KEYEVENT != 0 {
+		s.publish(fmt.Sprintf("__keyevent@%d__:%s", s.index, event), []byte(key))
+	}
+}
+
+// Pub/Sub handlers
+
+func (s *GoFastServer) handlePublish(channel string, message []byte) []byte {
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(s.publish(channel, message))))
+}
+
+// publish delivers message to the subscribers of channel as a RESP_PUSH
+// frame and returns how many subscriptions received it
+func (s *GoFastServer) publish(channel string, message []byte) int {
+	// Format: [channellen:4][channel][msglen:4][msg]
+	payload := make([]byte, 8+len(channel)+len(message))
+	binary.BigEndian.PutUint32(payload[0:4], uint32(len(channel)))
+	copy(payload[4:], channel)
+	offset := 4 + len(channel)
+	binary.BigEndian.PutUint32(payload[offset:offset+4], uint32(len(message)))
+	copy(payload[offset+4:], message)
+
+	return s.pubsub.Publish(channel, s.createResponse(RESP_PUSH, payload))
+}
+
+func (s *GoFastServer) handlePubSub(data []byte) []byte {
+	args := newArgReader(data)
+	switch args.uint8() {
+	case PUBSUB_CHANNELS:
+		pattern := ""
+		if args.offset < len(data) {
+			pattern = args.string()
+		}
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid PUBSUB data"))
+		}
+		channels := s.pubsub.Channels(pattern)
+		values := make([][]byte, len(channels))
+		for i, channel := range channels {
+			values[i] = []byte(channel)
+		}
+		return s.createResponse(RESP_OK, s.encodeArray(values))
+
+	case PUBSUB_NUMSUB:
+		channels := args.keyList()
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid PUBSUB data"))
+		}
+		counts := s.pubsub.NumSub(channels)
+		values := make([][]byte, 0, 2*len(channels))
+		for i, channel := range channels {
+			values = append(values, []byte(channel), []byte(strconv.Itoa(counts[i])))
+		}
+		return s.createResponse(RESP_OK, s.encodeArray(values))
+
+	case PUBSUB_NUMPAT:
+		return s.createResponse(RESP_OK, []byte(strconv.Itoa(s.pubsub.NumPat())))
+
+	default:
+		return s.createResponse(RESP_ERROR, []byte("Unknown PUBSUB subcommand"))
+	}
+}
+
+// handleSubscription applies a (P)SUBSCRIBE or (P)UNSUBSCRIBE for sub and
+// replies with its remaining subscription count
+func (s *GoFastServer) handleSubscription(sub *Subscriber, msg *Message) []byte {
+	switch msg.Command {
+	case CMD_SUBSCRIBE, CMD_PSUBSCRIBE, CMD_UNSUBSCRIBE, CMD_PUNSUBSCRIBE:
+	default:
+		return s.createResponse(RESP_ERROR, []byte("Only SUBSCRIBE, UNSUBSCRIBE, PSUBSCRIBE and PUNSUBSCRIBE are allowed in subscription mode"))
+	}
+
+	args := newArgReader(msg.Value)
+	names := args.keyList()
+	if args.err != nil {
+		return s.createResponse(RESP_ERROR, []byte("Invalid subscription data"))
+	}
+
+	var count int
+	switch msg.Command {
+	case CMD_SUBSCRIBE, CMD_PSUBSCRIBE:
+		if len(names) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid subscription data"))
+		}
+		if msg.Command == CMD_SUBSCRIBE {
+			count = s.pubsub.Subscribe(sub, names)
+		} else {
+			count = s.pubsub.PSubscribe(sub, names)
+		}
+	case CMD_UNSUBSCRIBE:
+		count = s.pubsub.Unsubscribe(sub, names)
+	case CMD_PUNSUBSCRIBE:
+		count = s.pubsub.PUnsubscribe(sub, names)
+	}
+	return s.createResponse(RESP_OK, []byte(strconv.Itoa(count)))
+}
+
+// subscriberConn is the wire protocol a subscriber is served over
+type subscriberConn interface {
+	readMessage() (*Message, error)
+	writeReply(msg *Message, response []byte) error
+	writePush(frame []byte) error
+	flush() error
+}
+
+// binaryConn serves subscribers over the native binary protocol, where
+// replies and RESP_PUSH frames are written as-is
+type binaryConn struct {
+	server   *GoFastServer
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	protocol uint8
+}
+
+func (c *binaryConn) readMessage() (*Message, error) {
+	return c.server.readFramedMessage(c.reader, c.protocol, c.server.wireCompression())
+}
+
+func (c *binaryConn) writeReply(msg *Message, response []byte) error {
+	return c.server.writeFramedResponse(c.writer, response, c.protocol, c.server.wireCompression(), msg.RequestID)
+}
+
+func (c *binaryConn) writePush(frame []byte) error {
+	return c.server.writeFramedResponse(c.writer, frame, c.protocol, c.server.wireCompression(), 0)
+}
+
+func (c *binaryConn) flush() error {
+	return c.writer.Flush()
+}
+
+// serveSubscriber runs a connection in subscription mode, starting with the
+// (P)SUBSCRIBE in msg, which state has already been allowed to run.
+// Published messages are pushed between command replies. It returns nil once
+// the connection has dropped every subscription, handing the reader back to
+// the normal command loop.
+func (s *GoFastServer) serveSubscriber(conn subscriberConn, state *connState, msg *Message) error {
+	sub := NewSubscriber()
+	defer s.pubsub.Unsubscribe(sub, nil)
+	defer s.pubsub.PUnsubscribe(sub, nil)
+
+	response := s.handleSubscription(sub, msg)
+	if err := conn.writeReply(msg, response); err != nil {
+		return err
+	}
+	conn.flush()
+	if s.pubsub.Count(sub) == 0 {
+		return nil
+	}
+
+	// The reader goroutine hands over one command at a time and waits to
+	// hear whether the connection is still subscribed before reading on,
+	// so nothing is consumed from the connection after subscription mode ends
+	commands := make(chan *Message)
+	resume := make(chan bool)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			msg, err := conn.readMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			select {
+			case commands <- msg:
+			case <-done:
+				return
+			}
+			select {
+			case stay := <-resume:
+				if !stay {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case frame := <-sub.outbox:
+			if err := conn.writePush(frame); err != nil {
+				return err
+			}
+			conn.flush()
+
+		case msg := <-commands:
+			s.incrementStat("total_ops")
+			response := s.checkACL(state, msg)
+			if response == nil {
+				response = s.handleSubscription(sub, msg)
+			}
+			stay := s.pubsub.Count(sub) > 0
+			if !stay {
+				// Deliver what was published before the last unsubscribe
+				for len(sub.outbox) > 0 {
+					if err := conn.writePush(<-sub.outbox); err != nil {
+						return err
+					}
+				}
+			}
+			if err := conn.writeReply(msg, response); err != nil {
+				return err
+			}
+			conn.flush()
+			resume <- stay
+			if !stay {
+				return nil
+			}
+
+		case err := <-readErr:
+			return err
+		}
+	}
+}
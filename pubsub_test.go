@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// decodePush splits a RESP_PUSH frame into its channel and message
+func decodePush(t *testing.T, frame []byte) (channel, message string) {
+	t.Helper()
+	if len(frame) < 5 || frame[0] != RESP_PUSH {
+		t.Fatalf("not a push frame: %q", frame)
+	}
+	args := newArgReader(frame[5:])
+	channel = args.string()
+	message = args.string()
+	if args.err != nil {
+		t.Fatalf("malformed push frame: %v", args.err)
+	}
+	return channel, message
+}
+
+func TestExpiryNotification(t *testing.T) {
+	s := NewGoFastServer(0)
+	config := DefaultConfig()
+	config.NotifyKeyspaceEvents = "Ex"
+	s.SetConfig(config)
+
+	sub := NewSubscriber()
+	s.pubsub.Subscribe(sub, []string{"__keyevent@0__:expired"})
+
+	response := s.processCommand(nil, &Message{Command: CMD_SET, Key: []byte("session"), Value: []byte("v"), TTL: 1})
+	if response[0] != RESP_OK {
+		t.Fatalf("SET failed: %q", response)
+	}
+
+	// Run the expiry cycle as of a time past the TTL
+	s.databases[0].removeExpiredKeys(time.Now().Unix() + 2)
+
+	select {
+	case frame := <-sub.outbox:
+		channel, key := decodePush(t, frame)
+		if channel != "__keyevent@0__:expired" || key != "session" {
+			t.Errorf("got %q on %q, want session on __keyevent@0__:expired", key, channel)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no expired notification")
+	}
+
+	if _, exists := s.databases[0].storage.Load("session"); exists {
+		t.Error("expired key is still stored")
+	}
+}
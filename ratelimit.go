@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimitPenalty is how long a throttled request is held before its
+// error is sent, so a flooding client slows down instead of spinning
+const rateLimitPenalty = 10 * time.Millisecond
+
+// TokenBucket allows bursts of up to capacity requests and refills
+// continuously at the configured rate
+type TokenBucket struct {
+	tokens   float64
+	lastSeen time.Time // Last refill, also used to find idle buckets
+	mutex    sync.Mutex
+}
+
+// RateLimiter throttles requests per client IP with one token bucket each
+type RateLimiter struct {
+	buckets  sync.Map // IP -> *TokenBucket
+	capacity float64
+	rate     float64 // Tokens added per second
+	window   time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewRateLimiter allows ops requests per window to each client IP
+func NewRateLimiter(ops uint64, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		capacity: float64(ops),
+		rate:     float64(ops) / window.Seconds(),
+		window:   window,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Allow takes a token from ip's bucket, reporting false when it is empty
+func (l *RateLimiter) Allow(ip string) bool {
+	now := time.Now()
+	value, ok := l.buckets.Load(ip)
+	if !ok {
+		value, _ = l.buckets.LoadOrStore(ip, &TokenBucket{tokens: l.capacity, lastSeen: now})
+	}
+	bucket := value.(*TokenBucket)
+
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	bucket.tokens = min(l.capacity, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*l.rate)
+	bucket.lastSeen = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// Start prunes buckets idle for longer than the window until Stop. An idle
+// bucket has refilled completely, so dropping it changes nothing.
+func (l *RateLimiter) Start() {
+	go func() {
+		ticker := time.NewTicker(l.window)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.stop:
+				return
+			case now := <-ticker.C:
+				l.prune(now)
+			}
+		}
+	}()
+}
+
+func (l *RateLimiter) prune(now time.Time) {
+	l.buckets.Range(func(key, value any) bool {
+		bucket := value.(*TokenBucket)
+		bucket.mutex.Lock()
+		idle := now.Sub(bucket.lastSeen) > l.window
+		bucket.mutex.Unlock()
+		if idle {
+			l.buckets.Delete(key)
+		}
+		return true
+	})
+}
+
+func (l *RateLimiter) Stop() {
+	l.stopOnce.Do(func() { close(l.stop) })
+}
+
+// rateLimited reports whether the connection's client has run out of
+// requests, after holding it for rateLimitPenalty. Clients without an IP,
+// such as Unix socket peers, are never limited.
+func (s *GoFastServer) rateLimited(state *connState) bool {
+	if s.rateLimiter == nil || state.clientIP == "" {
+		return false
+	}
+	if s.rateLimiter.Allow(state.clientIP) {
+		return false
+	}
+	time.Sleep(rateLimitPenalty)
+	return true
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gofast/persist"
+)
+
+// dataPath resolves a persistence file, relative paths being inside the
+// data directory
+func dataPath(config *Config, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(config.DataDir, file)
+}
+
+// loadData restores the dataset on startup and opens the AOF. The AOF
+// logs every write, so the snapshot is only loaded when there is no AOF
+// to replay.
+func (s *GoFastServer) loadData() error {
+	if err := os.MkdirAll(s.config.DataDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	aofFile := dataPath(s.config, s.config.AOFFile)
+	if info, err := os.Stat(aofFile); err != nil || info.Size() == 0 {
+		rdbFile := dataPath(s.config, s.config.RDBFile)
+		loaded, err := s.loadRDB(rdbFile)
+		if err != nil {
+			return fmt.Errorf("failed to load RDB: %v", err)
+		}
+		if loaded > 0 {
+			logf(LOG_INFO, "Loaded %d keys from %s", loaded, rdbFile)
+		}
+	}
+	return s.openAOF(aofFile)
+}
+
+// SaveSnapshot writes the RDB file synchronously when persistence is
+// enabled, returning the number of keys saved. A save that takes longer
+// than DrainTimeout is abandoned, leaving the previous snapshot in place.
+func (s *GoFastServer) SaveSnapshot() (int, error) {
+	if s.config == nil || !s.config.EnablePersist {
+		return 0, nil
+	}
+
+	type result struct {
+		saved int
+		err   error
+	}
+	path := dataPath(s.config, s.config.RDBFile)
+	done := make(chan result, 1)
+	go func() {
+		saved, err := s.saveRDB(path)
+		done <- result{saved, err}
+	}()
+
+	timer := time.NewTimer(s.config.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return 0, r.err
+		}
+		s.stats.mutex.Lock()
+		s.stats.LastSaveTime = time.Now().Unix()
+		s.stats.mutex.Unlock()
+		return r.saved, nil
+	case <-timer.C:
+		return 0, fmt.Errorf("save to %s did not finish within %v", path, s.config.DrainTimeout)
+	}
+}
+
+// persistCipher returns the cipher persistence files are encrypted with,
+// or nil when encryption is disabled
+func (s *GoFastServer) persistCipher() (*persist.Cipher, error) {
+	if s.config == nil || !s.config.EncryptionEnabled {
+		return nil, nil
+	}
+	return persist.LoadCipher(s.config.EncryptionKeyFile)
+}
+
+// saveRDB writes a snapshot of every database to path, returning the
+// number of keys saved
+func (s *GoFastServer) saveRDB(path string) (int, error) {
+	cipher, err := s.persistCipher()
+	if err != nil {
+		return 0, err
+	}
+
+	var saved int
+	err = persist.WriteAtomic(path, func(w io.Writer) error {
+		if cipher != nil {
+			// Chunks follow the snapshot writer's buffer flushes
+			encrypting, err := persist.NewEncryptingWriter(w, cipher)
+			if err != nil {
+				return err
+			}
+			w = encrypting
+		}
+		var err error
+		saved, err = s.handleRDBWrite(w)
+		return err
+	})
+	return saved, err
+}
+
+// handleRDBWrite writes a snapshot of every database to w, skipping
+// expired keys, and returns the number of keys written
+func (s *GoFastServer) handleRDBWrite(w io.Writer) (int, error) {
+	entries := s.snapshotEntries()
+	return len(entries), persist.WriteRDB(w, entries)
+}
+
+// snapshotEntries encodes every live key of every database
+func (s *GoFastServer) snapshotEntries() []persist.Entry {
+	now := time.Now().Unix()
+	var entries []persist.Entry
+	for _, db := range s.databases {
+		db.storage.Range(func(key, value any) bool {
+			item := value.(*CacheItem)
+			if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+				return true
+			}
+			entries = append(entries, persist.Entry{
+				DB:        uint8(db.index),
+				Key:       []byte(key.(string)),
+				Type:      uint8(item.DataType),
+				ExpiresAt: item.ExpiresAt,
+				Value:     encodeSnapshotValue(item),
+			})
+			return true
+		})
+	}
+	return entries
+}
+
+// loadRDB restores the keys of the snapshot at path, skipping keys that
+// expired since it was taken. A missing file loads nothing.
+func (s *GoFastServer) loadRDB(path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	cipher, err := s.persistCipher()
+	if err != nil {
+		return 0, err
+	}
+	if cipher != nil {
+		if reader, err = persist.NewDecryptingReader(file, cipher); err != nil {
+			return 0, fmt.Errorf("failed to read RDB: %w", err)
+		}
+	}
+
+	entries, err := persist.ReadRDB(reader)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	loaded := 0
+	for _, entry := range entries {
+		if int(entry.DB) >= MAX_DATABASES {
+			return loaded, fmt.Errorf("key %q in database %d", entry.Key, entry.DB)
+		}
+		if entry.ExpiresAt > 0 && entry.ExpiresAt <= now {
+			continue
+		}
+		err := s.databases[entry.DB].restoreKey(string(entry.Key), DataType(entry.Type), entry.Value, entry.ExpiresAt, now)
+		if err != nil {
+			return loaded, err
+		}
+		loaded++
+	}
+	return loaded, nil
+}
+
+// restoreKey stores a key from its snapshot encoding, replacing any value
+func (s *DatabaseState) restoreKey(key string, dataType DataType, data []byte, expiresAt, now int64) error {
+	value, err := decodeSnapshotValue(dataType, data)
+	if err != nil {
+		return fmt.Errorf("key %q: %v", key, err)
+	}
+
+	item := &CacheItem{
+		DataType:  dataType,
+		Value:     value,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	if dataType == TYPE_STRING {
+		s.compressValue(item)
+	}
+	s.storage.Store(key, item)
+	s.ttlMutex.Lock()
+	if expiresAt > 0 {
+		s.ttlIndex[key] = expiresAt
+	} else {
+		delete(s.ttlIndex, key)
+	}
+	s.ttlMutex.Unlock()
+	return nil
+}
+
+// encodeSnapshotValue encodes a value for the snapshot:
+//   - string: the raw bytes
+//   - list, set: [count:4][len1:4][value1]...
+//   - hash: [count:4][fieldlen:4][field][valuelen:4][value]...
+//   - sorted set: [count:4][memberlen:4][member][score:8]... in score order
+//   - stream: see Stream.encodeSnapshot
+func encodeSnapshotValue(item *CacheItem) []byte {
+	var buf []byte
+	switch value := item.Value.(type) {
+	case []byte:
+		return item.stringValue()
+
+	case *List:
+		values := value.Range(0, math.MaxInt)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(values)))
+		for _, v := range values {
+			buf = appendLenPrefixed(buf, v)
+		}
+
+	case *Set:
+		members := value.Members()
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(members)))
+		for _, member := range members {
+			buf = appendLenPrefixed(buf, []byte(member))
+		}
+
+	case *Hash:
+		fields := value.GetAll()
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(fields)))
+		for field, v := range fields {
+			buf = appendLenPrefixed(buf, []byte(field))
+			buf = appendLenPrefixed(buf, v)
+		}
+
+	case *ZSet:
+		entries := value.Range(0, -1, false)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(entries)))
+		for _, entry := range entries {
+			buf = appendLenPrefixed(buf, []byte(entry.Member))
+			buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(entry.Score))
+		}
+
+	case *Stream:
+		buf = value.encodeSnapshot()
+	}
+	return buf
+}
+
+func decodeSnapshotValue(dataType DataType, data []byte) (any, error) {
+	args := newArgReader(data)
+	var value any
+
+	switch dataType {
+	case TYPE_STRING:
+		return data, nil
+
+	case TYPE_LIST:
+		list := NewList()
+		for range snapshotCount(args) {
+			list.RightPush(args.bytes())
+		}
+		value = list
+
+	case TYPE_SET:
+		set := NewSet()
+		for range snapshotCount(args) {
+			set.Add(args.string())
+		}
+		value = set
+
+	case TYPE_HASH:
+		hash := NewHash()
+		for range snapshotCount(args) {
+			field := args.string()
+			hash.Set(field, args.bytes())
+		}
+		value = hash
+
+	case TYPE_ZSET:
+		zset := NewZSet()
+		for range snapshotCount(args) {
+			member := args.string()
+			zset.Add(member, math.Float64frombits(args.uint64()))
+		}
+		value = zset
+
+	case TYPE_STREAM:
+		value = decodeStreamSnapshot(args)
+
+	default:
+		return nil, fmt.Errorf("unknown type %d", dataType)
+	}
+
+	if args.err != nil {
+		return nil, args.err
+	}
+	return value, nil
+}
+
+// encodeSnapshot encodes the stream with its consumer groups
+// Format: [lastid:16][count:4][entries][numgroups:4][groups]
+// Entry: [id:16][numfields:4][field1len:4][field1]...
+// Group: [namelen:4][name][lastdelivered:16][numpending:4][pending]
+// [numconsumers:4][namelen:4][name][seenat:8]...
+// Pending: [id:16][consumerlen:4][consumer][deliveredat:8][deliveries:4]
+// IDs are [millis:8][seq:8].
+func (st *Stream) encodeSnapshot() []byte {
+	st.mutex.RLock()
+	defer st.mutex.RUnlock()
+
+	buf := appendStreamID(nil, st.lastID)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(st.entries)))
+	for _, entry := range st.entries {
+		buf = appendStreamID(buf, entry.ID)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(entry.Fields)))
+		for _, field := range entry.Fields {
+			buf = appendLenPrefixed(buf, []byte(field))
+		}
+	}
+
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(st.groups)))
+	for _, group := range st.groups {
+		buf = appendLenPrefixed(buf, []byte(group.name))
+		buf = appendStreamID(buf, group.lastDeliveredID)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(group.pel)))
+		for id, pending := range group.pel {
+			buf = appendStreamID(buf, id)
+			buf = appendLenPrefixed(buf, []byte(pending.Consumer))
+			buf = binary.BigEndian.AppendUint64(buf, uint64(pending.DeliveredAt))
+			buf = binary.BigEndian.AppendUint32(buf, uint32(pending.DeliveryCount))
+		}
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(group.consumers)))
+		for _, consumer := range group.consumers {
+			buf = appendLenPrefixed(buf, []byte(consumer.name))
+			buf = binary.BigEndian.AppendUint64(buf, uint64(consumer.seenAt))
+		}
+	}
+	return buf
+}
+
+func decodeStreamSnapshot(args *argReader) *Stream {
+	stream := NewStream()
+	stream.lastID = readStreamID(args)
+	for range snapshotCount(args) {
+		entry := StreamEntry{ID: readStreamID(args)}
+		for range snapshotCount(args) {
+			entry.Fields = append(entry.Fields, args.string())
+		}
+		stream.entries = append(stream.entries, entry)
+	}
+
+	for range snapshotCount(args) {
+		if stream.groups == nil {
+			stream.groups = make(map[string]*ConsumerGroup)
+		}
+		group := &ConsumerGroup{
+			name:            args.string(),
+			lastDeliveredID: readStreamID(args),
+			pel:             make(map[StreamID]*PendingEntry),
+			consumers:       make(map[string]*StreamConsumer),
+		}
+		for range snapshotCount(args) {
+			id := readStreamID(args)
+			group.pel[id] = &PendingEntry{
+				Consumer:      args.string(),
+				DeliveredAt:   args.int64(),
+				DeliveryCount: int(args.uint32()),
+			}
+		}
+		for range snapshotCount(args) {
+			consumer := &StreamConsumer{name: args.string(), seenAt: args.int64()}
+			group.consumers[consumer.name] = consumer
+		}
+		stream.groups[group.name] = group
+	}
+	return stream
+}
+
+// snapshotCount reads an element count, failing when the data left could
+// not hold that many elements so corrupt counts stop the decoding early
+func snapshotCount(args *argReader) uint32 {
+	count := args.uint32()
+	if args.err == nil && int(count) > (len(args.data)-args.offset)/4 {
+		args.err = fmt.Errorf("invalid element count")
+	}
+	if args.err != nil {
+		return 0
+	}
+	return count
+}
+
+func appendStreamID(buf []byte, id StreamID) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, id.Millis)
+	return binary.BigEndian.AppendUint64(buf, id.Seq)
+}
+
+func readStreamID(args *argReader) StreamID {
+	return StreamID{Millis: args.uint64(), Seq: args.uint64()}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestSnapshotRoundTrip saves a snapshot as shutdown does and loads it
+// into a fresh server as a restart does
+func TestSnapshotRoundTrip(t *testing.T) {
+	config := DefaultConfig()
+	config.EnablePersist = true
+	config.DataDir = t.TempDir()
+
+	s := NewGoFastServer(0)
+	s.SetConfig(config)
+	now := time.Now().Unix()
+	run := func(db int, msg *Message) {
+		if response := s.databases[db].runCommand(nil, msg, now); response[0] != RESP_OK {
+			t.Fatalf("%s failed: %q", commandName(msg.Command), response)
+		}
+	}
+	run(0, &Message{Command: CMD_SET, Key: []byte("greeting"), Value: []byte("hello")})
+	run(0, &Message{Command: CMD_SET, Key: []byte("session"), Value: []byte("token"), TTL: 3600})
+	run(0, &Message{Command: CMD_LPUSH, Key: []byte("queue"), Value: append(binary.BigEndian.AppendUint32(nil, 3), "job"...)})
+	run(3, &Message{Command: CMD_SET, Key: []byte("other"), Value: []byte("db3")})
+
+	saved, err := s.SaveSnapshot()
+	if err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	if saved != 4 {
+		t.Errorf("saved %d keys, want 4", saved)
+	}
+
+	restarted := NewGoFastServer(0)
+	restarted.SetConfig(config)
+	loaded, err := restarted.loadRDB(dataPath(config, config.RDBFile))
+	if err != nil {
+		t.Fatalf("loadRDB: %v", err)
+	}
+	if loaded != saved {
+		t.Errorf("loaded %d keys, want %d", loaded, saved)
+	}
+
+	get := func(db int, key string) *CacheItem {
+		value, ok := restarted.databases[db].storage.Load(key)
+		if !ok {
+			t.Fatalf("%q missing from db %d after reload", key, db)
+		}
+		return value.(*CacheItem)
+	}
+	if value := string(get(0, "greeting").stringValue()); value != "hello" {
+		t.Errorf("greeting = %q, want hello", value)
+	}
+	if session := get(0, "session"); session.ExpiresAt < now+3600 {
+		t.Errorf("session expires at %d, want its TTL kept (%d)", session.ExpiresAt, now+3600)
+	}
+	if queue := get(0, "queue"); queue.DataType != TYPE_LIST || queue.Value.(*List).Length() != 1 {
+		t.Errorf("queue reloaded as type %d, want a one-element list", queue.DataType)
+	}
+	if value := string(get(3, "other").stringValue()); value != "db3" {
+		t.Errorf("db 3 other = %q, want db3", value)
+	}
+}
+
+// TestShutdownOnSIGTERM runs a server until SIGTERM as the serve command
+// does, then checks a server started on the same data directory has the
+// data written before the signal
+func TestShutdownOnSIGTERM(t *testing.T) {
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.EnablePersist = true
+	config.DataDir = t.TempDir()
+	config.UnixSocket = filepath.Join(config.DataDir, "gofast.sock")
+
+	s := NewGoFastServer(0)
+	s.SetConfig(config)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.RunUntilSignal(signals) }()
+
+	var client *Client
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if client, err = DialClient(config); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("server did not come up: %v", err)
+	}
+	set := appendLenPrefixed(nil, []byte("greeting"))
+	set = binary.BigEndian.AppendUint32(set, 0) // No TTL
+	set = appendLenPrefixed(set, []byte("hello"))
+	_, err = client.Call(CMD_SET, set)
+	client.Close()
+	if err != nil {
+		t.Fatalf("SET: %v", err)
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("sending SIGTERM: %v", err)
+	}
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("RunUntilSignal: %v", err)
+		}
+	case <-time.After(config.DrainTimeout + 5*time.Second):
+		t.Fatal("server did not shut down after SIGTERM")
+	}
+	if _, err := os.Stat(dataPath(config, config.RDBFile)); err != nil {
+		t.Fatalf("no snapshot saved on shutdown: %v", err)
+	}
+
+	restarted := NewGoFastServer(0)
+	restarted.SetConfig(config)
+	if err := restarted.loadData(); err != nil {
+		t.Fatalf("loadData: %v", err)
+	}
+	defer restarted.Stop()
+	value, ok := restarted.databases[0].storage.Load("greeting")
+	if !ok {
+		t.Fatal("greeting missing after restart")
+	}
+	if got := string(value.(*CacheItem).stringValue()); got != "hello" {
+		t.Errorf("greeting = %q after restart, want hello", got)
+	}
+}
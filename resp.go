@@ -0,0 +1,1169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Limits on RESP requests, matching the Redis defaults. Bulk strings are
+// further bounded by max_frame_size.
+const (
+	respMaxArgs    = 1024 * 1024
+	respMaxBulkLen = 512 * 1024 * 1024
+	respMaxLineLen = 64 * 1024 // Inline commands and array and bulk headers
+)
+
+var (
+	errRESPQuit   = errors.New("client sent QUIT")
+	errRESPSyntax = errors.New("syntax error")
+)
+
+// RESPReader reads RESP2 requests: arrays of bulk strings as sent by Redis
+// clients, or space separated inline commands typed into a terminal
+type RESPReader struct {
+	reader     *bufio.Reader
+	maxBulkLen uint64
+}
+
+// NewRESPReader reads requests whose bulk strings are at most maxBulkLen
+// bytes, and never more than respMaxBulkLen
+func NewRESPReader(reader *bufio.Reader, maxBulkLen uint64) *RESPReader {
+	return &RESPReader{reader: reader, maxBulkLen: min(maxBulkLen, respMaxBulkLen)}
+}
+
+// ReadCommand returns the next command name followed by its arguments
+func (r *RESPReader) ReadCommand() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count < 0 || count > respMaxArgs {
+		return nil, fmt.Errorf("invalid RESP array length")
+	}
+
+	args := make([][]byte, count)
+	for i := range args {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected RESP bulk string")
+		}
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < 0 || uint64(n) > r.maxBulkLen {
+			return nil, fmt.Errorf("invalid RESP bulk length")
+		}
+
+		// Read the payload and its trailing CRLF together. As for binary
+		// frames, a large payload is read as it arrives so a declared length
+		// the client never sends cannot allocate memory up front.
+		if n > frameStreamThreshold {
+			var bulk bytes.Buffer
+			if _, err := io.CopyN(&bulk, r.reader, int64(n)+2); err != nil {
+				return nil, err
+			}
+			args[i] = bulk.Bytes()[:n]
+			continue
+		}
+		bulk := make([]byte, n+2)
+		if _, err := io.ReadFull(r.reader, bulk); err != nil {
+			return nil, err
+		}
+		args[i] = bulk[:n]
+	}
+	return args, nil
+}
+
+// readLine reads one CRLF terminated line of at most respMaxLineLen bytes
+func (r *RESPReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > respMaxLineLen {
+			return nil, fmt.Errorf("RESP line longer than %d bytes", respMaxLineLen)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+}
+
+// RESPWriter writes RESP replies, framed as RESP2 until the client upgrades
+// the connection with HELLO 3. The RESP3-only types fall back to their RESP2
+// equivalents. Write errors are sticky in the underlying bufio.Writer and
+// reported by Flush.
+type RESPWriter struct {
+	writer  *bufio.Writer
+	version int
+}
+
+func NewRESPWriter(writer *bufio.Writer) *RESPWriter {
+	return &RESPWriter{writer: writer, version: 2}
+}
+
+func (w *RESPWriter) WriteSimple(s string) {
+	w.writer.WriteString("+" + s + "\r\n")
+}
+
+func (w *RESPWriter) WriteError(s string) {
+	w.writer.WriteString("-" + s + "\r\n")
+}
+
+func (w *RESPWriter) WriteInteger(n int64) {
+	w.writer.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *RESPWriter) WriteBulk(b []byte) {
+	w.writer.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	w.writer.Write(b)
+	w.writer.WriteString("\r\n")
+}
+
+func (w *RESPWriter) WriteNull() {
+	if w.version >= 3 {
+		w.writer.WriteString("_\r\n")
+		return
+	}
+	w.writer.WriteString("$-1\r\n")
+}
+
+func (w *RESPWriter) WriteArrayHeader(n int) {
+	w.writer.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w *RESPWriter) WriteNullArray() {
+	if w.version >= 3 {
+		w.writer.WriteString("_\r\n")
+		return
+	}
+	w.writer.WriteString("*-1\r\n")
+}
+
+// WriteMapHeader starts a map of n key/value pairs, a flat array of 2n
+// elements in RESP2
+func (w *RESPWriter) WriteMapHeader(n int) {
+	if w.version >= 3 {
+		w.writer.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	w.WriteArrayHeader(2 * n)
+}
+
+func (w *RESPWriter) WriteSetHeader(n int) {
+	if w.version >= 3 {
+		w.writer.WriteString("~" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	w.WriteArrayHeader(n)
+}
+
+// WritePushHeader starts an out-of-band message such as a pub/sub delivery
+func (w *RESPWriter) WritePushHeader(n int) {
+	if w.version >= 3 {
+		w.writer.WriteString(">" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	w.WriteArrayHeader(n)
+}
+
+func (w *RESPWriter) WriteDouble(f float64) {
+	if w.version >= 3 {
+		w.writer.WriteString("," + formatScore(f) + "\r\n")
+		return
+	}
+	w.WriteBulk([]byte(formatScore(f)))
+}
+
+func (w *RESPWriter) WriteBoolean(b bool) {
+	switch {
+	case w.version < 3 && b:
+		w.WriteInteger(1)
+	case w.version < 3:
+		w.WriteInteger(0)
+	case b:
+		w.writer.WriteString("#t\r\n")
+	default:
+		w.writer.WriteString("#f\r\n")
+	}
+}
+
+func (w *RESPWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+// WriteResponse converts a createResponse buffer into the RESP reply for
+// the command it answered
+func (w *RESPWriter) WriteResponse(reply respReply, response []byte) {
+	status, data := response[0], response[5:]
+	switch {
+	case status == RESP_ERROR:
+		w.WriteError(respError(data))
+
+	case status == RESP_NOT_FOUND:
+		if reply == respArray || reply == respPairs || reply == respSet || reply == respScores {
+			w.WriteNullArray()
+		} else {
+			w.WriteNull()
+		}
+
+	case reply == respOK:
+		w.WriteSimple("OK")
+
+	case reply == respText:
+		w.WriteSimple(string(data))
+
+	case reply == respInteger:
+		n, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			w.WriteBulk(data)
+			return
+		}
+		w.WriteInteger(n)
+
+	case reply == respBulk:
+		w.WriteBulk(data)
+
+	case reply == respDouble:
+		f, err := strconv.ParseFloat(string(data), 64)
+		if err != nil {
+			w.WriteBulk(data)
+			return
+		}
+		w.WriteDouble(f)
+
+	case reply == respArray:
+		w.writeArray(data, 1)
+
+	case reply == respSet:
+		values, ok := decodeArray(data, 1)
+		if !ok {
+			w.WriteError("ERR malformed reply")
+			return
+		}
+		w.WriteSetHeader(len(values))
+		for _, value := range values {
+			w.WriteBulk(value)
+		}
+
+	case reply == respPairs:
+		values, ok := decodeArray(data, 2)
+		if !ok {
+			w.WriteError("ERR malformed reply")
+			return
+		}
+		w.WriteMapHeader(len(values) / 2)
+		for _, value := range values {
+			w.WriteBulk(value)
+		}
+
+	case reply == respScores:
+		w.writeScores(data)
+
+	case reply == respScan:
+		if len(data) < 4 {
+			w.WriteError("ERR malformed reply")
+			return
+		}
+		w.WriteArrayHeader(2)
+		w.WriteBulk([]byte(strconv.FormatUint(uint64(binary.BigEndian.Uint32(data[0:4])), 10)))
+		w.writeArray(data[4:], 1)
+	}
+}
+
+// writeArray writes an encodeArray, encodeMGetResponse or encodeHashMap
+// payload, where each counted entry holds width values
+func (w *RESPWriter) writeArray(data []byte, width int) {
+	values, ok := decodeArray(data, width)
+	if !ok {
+		w.WriteError("ERR malformed reply")
+		return
+	}
+	w.WriteArrayHeader(len(values))
+	for _, value := range values {
+		if value == nil {
+			w.WriteNull()
+		} else {
+			w.WriteBulk(value)
+		}
+	}
+}
+
+// writeScores writes a member/score list from ZRANGE WITHSCORES. RESP3
+// clients get [member, score] pairs with the score as a double, as Redis 7
+// sends them.
+func (w *RESPWriter) writeScores(data []byte) {
+	values, ok := decodeArray(data, 1)
+	if !ok || len(values)%2 != 0 {
+		w.WriteError("ERR malformed reply")
+		return
+	}
+	if w.version < 3 {
+		w.writeArray(data, 1)
+		return
+	}
+
+	w.WriteArrayHeader(len(values) / 2)
+	for i := 0; i < len(values); i += 2 {
+		score, err := strconv.ParseFloat(string(values[i+1]), 64)
+		if err != nil {
+			w.WriteError("ERR malformed reply")
+			return
+		}
+		w.WriteArrayHeader(2)
+		w.WriteBulk(values[i])
+		w.WriteDouble(score)
+	}
+}
+
+// respError prefixes server error messages with the generic ERR code unless
+// they already start with one, such as WRONGTYPE or EXECABORT
+func respError(data []byte) string {
+	msg := string(data)
+	code, _, _ := strings.Cut(msg, " ")
+	if len(code) > 1 && strings.ToUpper(code) == code && strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == "" {
+		return msg
+	}
+	return "ERR " + msg
+}
+
+// respReply says how a binary response is rendered as a RESP reply
+type respReply uint8
+
+const (
+	respOK      respReply = iota // +OK whatever the payload
+	respInteger                  // ASCII integer, written as :n
+	respBulk                     // Bulk string, nil when not found
+	respDouble                   // Formatted score, a double in RESP3
+	respArray                    // encodeArray layout, nil array when not found
+	respSet                      // encodeArray layout, a set in RESP3
+	respPairs                    // encodeHashMap layout, a map in RESP3
+	respScores                   // Member/score encodeArray, pairs of doubles in RESP3
+	respScan                     // [cursor:4] followed by an encodeArray payload
+	respText                     // Simple string holding the payload
+)
+
+// respCommand maps a Redis command onto a binary command. encode turns the
+// arguments after the command name into the binary payload.
+type respCommand struct {
+	command uint8
+	arity   int // Minimum number of arguments after the name
+	encode  func(args [][]byte) ([]byte, respReply, error)
+
+	// Commands that take one key or field in the binary protocol but many in
+	// Redis run once per split arguments and sum the integer replies. When
+	// keyed, every run is prefixed with the first argument.
+	split int
+	keyed bool
+}
+
+var respCommands = map[string]respCommand{
+	"GET":    {command: CMD_GET, arity: 1, encode: respKey(respBulk)},
+	"SET":    {command: CMD_SET, arity: 2, encode: encodeRESPSet},
+	"GETSET": {command: CMD_GETSET, arity: 2, encode: respKeyField(respBulk)},
+	"DEL":    {command: CMD_DEL, arity: 1, encode: respKey(respInteger), split: 1},
+	"EXISTS": {command: CMD_EXISTS, arity: 1, encode: respKey(respInteger), split: 1},
+	"EXPIRE": {command: CMD_EXPIRE, arity: 2, encode: encodeRESPExpire},
+	"TTL":    {command: CMD_TTL, arity: 1, encode: respKey(respInteger)},
+	"INCR":   {command: CMD_INCR, arity: 1, encode: respKey(respInteger)},
+	"DECR":   {command: CMD_DECR, arity: 1, encode: respKey(respInteger)},
+	"MGET":   {command: CMD_MGET, arity: 1, encode: respValues(respArray)},
+	"MSET":   {command: CMD_MSET, arity: 2, encode: encodeRESPMSet},
+	"KEYS":   {command: CMD_KEYS, arity: 1, encode: respKey(respArray)},
+	"SCAN":   {command: CMD_SCAN, arity: 1, encode: encodeRESPScan},
+
+	"LPUSH":  {command: CMD_LPUSH, arity: 2, encode: respKeyValues(respInteger)},
+	"RPUSH":  {command: CMD_RPUSH, arity: 2, encode: respKeyValues(respInteger)},
+	"LPOP":   {command: CMD_LPOP, arity: 1, encode: encodeRESPPop},
+	"RPOP":   {command: CMD_RPOP, arity: 1, encode: encodeRESPPop},
+	"LLEN":   {command: CMD_LLEN, arity: 1, encode: respKey(respInteger)},
+	"LINDEX": {command: CMD_LINDEX, arity: 2, encode: encodeRESPIndex(respBulk)},
+	"LRANGE": {command: CMD_LRANGE, arity: 3, encode: encodeRESPIndex(respArray)},
+
+	"SADD":      {command: CMD_SADD, arity: 2, encode: respKeyValues(respInteger)},
+	"SREM":      {command: CMD_SREM, arity: 2, encode: respKeyValues(respInteger)},
+	"SMEMBERS":  {command: CMD_SMEMBERS, arity: 1, encode: respKey(respSet)},
+	"SCARD":     {command: CMD_SCARD, arity: 1, encode: respKey(respInteger)},
+	"SISMEMBER": {command: CMD_SISMEMBER, arity: 2, encode: respKeyField(respInteger)},
+
+	"HSET":    {command: CMD_HSET, arity: 3, encode: encodeRESPHashSet, split: 2, keyed: true},
+	"HGET":    {command: CMD_HGET, arity: 2, encode: respKeyField(respBulk)},
+	"HDEL":    {command: CMD_HDEL, arity: 2, encode: respKeyField(respInteger), split: 1, keyed: true},
+	"HEXISTS": {command: CMD_HEXISTS, arity: 2, encode: respKeyField(respInteger)},
+	"HLEN":    {command: CMD_HLEN, arity: 1, encode: respKey(respInteger)},
+	"HKEYS":   {command: CMD_HKEYS, arity: 1, encode: respKey(respArray)},
+	"HVALS":   {command: CMD_HVALS, arity: 1, encode: respKey(respArray)},
+	"HGETALL": {command: CMD_HGETALL, arity: 1, encode: respKey(respPairs)},
+	"HMGET":   {command: CMD_HMGET, arity: 2, encode: respKeyValues(respArray)},
+	"HINCRBY": {command: CMD_HINCRBY, arity: 3, encode: encodeRESPHashIncrBy},
+
+	"ZADD":     {command: CMD_ZADD, arity: 3, encode: encodeRESPZAdd},
+	"ZINCRBY":  {command: CMD_ZINCRBY, arity: 3, encode: encodeRESPZIncrBy},
+	"ZSCORE":   {command: CMD_ZSCORE, arity: 2, encode: respKeyField(respDouble)},
+	"ZREM":     {command: CMD_ZREM, arity: 2, encode: respKeyValues(respInteger)},
+	"ZCARD":    {command: CMD_ZCARD, arity: 1, encode: respKey(respInteger)},
+	"ZRANK":    {command: CMD_ZRANK, arity: 2, encode: encodeRESPZRank},
+	"ZREVRANK": {command: CMD_ZREVRANK, arity: 2, encode: encodeRESPZRank},
+	"ZRANGE":   {command: CMD_ZRANGE, arity: 3, encode: encodeRESPZRange},
+
+	"PUBLISH":      {command: CMD_PUBLISH, arity: 2, encode: encodeRESPPublish},
+	"SUBSCRIBE":    {command: CMD_SUBSCRIBE, arity: 1, encode: respValues(respInteger)},
+	"UNSUBSCRIBE":  {command: CMD_UNSUBSCRIBE, arity: 0, encode: respValues(respInteger)},
+	"PSUBSCRIBE":   {command: CMD_PSUBSCRIBE, arity: 1, encode: respValues(respInteger)},
+	"PUNSUBSCRIBE": {command: CMD_PUNSUBSCRIBE, arity: 0, encode: respValues(respInteger)},
+
+	"MULTI":   {command: CMD_MULTI, arity: 0, encode: respNone},
+	"EXEC":    {command: CMD_EXEC, arity: 0, encode: respNone},
+	"DISCARD": {command: CMD_DISCARD, arity: 0, encode: respNone},
+	"WATCH":   {command: CMD_WATCH, arity: 1, encode: respValues(respOK)},
+
+	"SELECT": {command: CMD_SELECT, arity: 1, encode: encodeRESPSelect},
+
+	"BGSAVE":       {command: CMD_BGSAVE, arity: 0, encode: respStatus},
+	"BGREWRITEAOF": {command: CMD_BGREWRITEAOF, arity: 0, encode: respStatus},
+
+	"INFO":    {command: CMD_INFO, arity: 0, encode: encodeRESPInfo},
+	"MONITOR": {command: CMD_MONITOR, arity: 0, encode: respNone},
+	"RESET":   {command: CMD_RESET, arity: 0, encode: respStatus},
+	"CONFIG":  {command: CMD_CONFIG, arity: 1, encode: encodeRESPConfig},
+	"CLIENT":  {command: CMD_CLIENT, arity: 1, encode: encodeRESPClient},
+
+	"SHUTDOWN": {command: CMD_SHUTDOWN, arity: 0, encode: encodeRESPShutdown},
+}
+
+// respCommandNames gives the lower-case Redis name of subscription commands
+// for their confirmation replies
+var respCommandNames = map[uint8]string{
+	CMD_SUBSCRIBE:    "subscribe",
+	CMD_UNSUBSCRIBE:  "unsubscribe",
+	CMD_PSUBSCRIBE:   "psubscribe",
+	CMD_PUNSUBSCRIBE: "punsubscribe",
+}
+
+func appendRESPList(buf []byte, args [][]byte) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(args)))
+	for _, arg := range args {
+		buf = appendLenPrefixed(buf, arg)
+	}
+	return buf
+}
+
+// encodeRESPInfo encodes INFO [section] as the raw section name
+func encodeRESPInfo(args [][]byte) ([]byte, respReply, error) {
+	if len(args) == 0 {
+		return nil, respBulk, nil
+	}
+	return args[0], respBulk, nil
+}
+
+// configSubcommands maps CONFIG subcommand names to their codes
+var configSubcommands = map[string]uint8{
+	"RESETSTAT": CONFIG_RESETSTAT,
+	"GET":       CONFIG_GET,
+	"SET":       CONFIG_SET,
+}
+
+// encodeRESPConfig encodes CONFIG subcommand [arguments] as [subcommand:1]
+// followed by the length-prefixed arguments
+func encodeRESPConfig(args [][]byte) ([]byte, respReply, error) {
+	subcommand, ok := configSubcommands[strings.ToUpper(string(args[0]))]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown CONFIG subcommand '%s'", args[0])
+	}
+	payload := []byte{subcommand}
+	switch subcommand {
+	case CONFIG_GET:
+		if len(args) != 2 {
+			return nil, 0, fmt.Errorf("wrong number of arguments for 'config|get' command")
+		}
+		return appendLenPrefixed(payload, args[1]), respArray, nil
+	case CONFIG_SET:
+		if len(args) != 3 {
+			return nil, 0, fmt.Errorf("wrong number of arguments for 'config|set' command")
+		}
+		return appendLenPrefixed(appendLenPrefixed(payload, args[1]), args[2]), respOK, nil
+	}
+	return payload, respText, nil
+}
+
+// clientSubcommands maps CLIENT subcommand names to their codes
+var clientSubcommands = map[string]uint8{
+	"LIST":    CLIENT_LIST,
+	"KILL":    CLIENT_KILL,
+	"SETNAME": CLIENT_SETNAME,
+	"GETNAME": CLIENT_GETNAME,
+	"ID":      CLIENT_ID,
+}
+
+// encodeRESPClient encodes CLIENT subcommand [arguments] as [subcommand:1]
+// followed by the length-prefixed arguments
+func encodeRESPClient(args [][]byte) ([]byte, respReply, error) {
+	subcommand, ok := clientSubcommands[strings.ToUpper(string(args[0]))]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown CLIENT subcommand '%s'", args[0])
+	}
+	payload := []byte{subcommand}
+	switch subcommand {
+	case CLIENT_KILL:
+		if len(args) != 3 {
+			return nil, 0, fmt.Errorf("wrong number of arguments for 'client|kill' command")
+		}
+		return appendLenPrefixed(appendLenPrefixed(payload, args[1]), args[2]), respOK, nil
+	case CLIENT_SETNAME:
+		if len(args) != 2 {
+			return nil, 0, fmt.Errorf("wrong number of arguments for 'client|setname' command")
+		}
+		return appendLenPrefixed(payload, args[1]), respOK, nil
+	case CLIENT_ID:
+		return payload, respInteger, nil
+	}
+	return payload, respBulk, nil
+}
+
+func respNone(args [][]byte) ([]byte, respReply, error) {
+	return nil, respOK, nil
+}
+
+// respStatus sends no payload and replies with the server's status text
+func respStatus(args [][]byte) ([]byte, respReply, error) {
+	return nil, respText, nil
+}
+
+// respKey encodes [keylen:4][key]
+func respKey(reply respReply) func([][]byte) ([]byte, respReply, error) {
+	return func(args [][]byte) ([]byte, respReply, error) {
+		return appendLenPrefixed(nil, args[0]), reply, nil
+	}
+}
+
+// respKeyField encodes [keylen:4][key][fieldlen:4][field]
+func respKeyField(reply respReply) func([][]byte) ([]byte, respReply, error) {
+	return func(args [][]byte) ([]byte, respReply, error) {
+		return appendLenPrefixed(appendLenPrefixed(nil, args[0]), args[1]), reply, nil
+	}
+}
+
+// respKeyValues encodes [keylen:4][key][count:4][val1len:4][val1]...
+func respKeyValues(reply respReply) func([][]byte) ([]byte, respReply, error) {
+	return func(args [][]byte) ([]byte, respReply, error) {
+		return appendRESPList(appendLenPrefixed(nil, args[0]), args[1:]), reply, nil
+	}
+}
+
+// respValues encodes [count:4][val1len:4][val1]...
+func respValues(reply respReply) func([][]byte) ([]byte, respReply, error) {
+	return func(args [][]byte) ([]byte, respReply, error) {
+		return appendRESPList(nil, args), reply, nil
+	}
+}
+
+func parseRESPInt(arg []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value is not an integer or out of range")
+	}
+	return n, nil
+}
+
+func parseRESPFloat(arg []byte) (float64, error) {
+	f, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, fmt.Errorf("value is not a valid float")
+	}
+	return f, nil
+}
+
+func encodeRESPSet(args [][]byte) ([]byte, respReply, error) {
+	var ttl int64
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if (option != "EX" && option != "PX") || i+1 >= len(args) {
+			return nil, 0, errRESPSyntax
+		}
+		n, err := parseRESPInt(args[i+1])
+		if err != nil || n <= 0 || n > math.MaxUint32 {
+			return nil, 0, fmt.Errorf("invalid expire time in 'set' command")
+		}
+		if option == "PX" {
+			n = (n + 999) / 1000 // Round milliseconds up to whole seconds
+		}
+		ttl = n
+		i++
+	}
+
+	buf := appendLenPrefixed(nil, args[0])
+	buf = binary.BigEndian.AppendUint32(buf, uint32(ttl))
+	return appendLenPrefixed(buf, args[1]), respOK, nil
+}
+
+func encodeRESPExpire(args [][]byte) ([]byte, respReply, error) {
+	seconds, err := parseRESPInt(args[1])
+	if err != nil || seconds < 0 || seconds > math.MaxUint32 {
+		return nil, 0, fmt.Errorf("invalid expire time in 'expire' command")
+	}
+	return binary.BigEndian.AppendUint32(appendLenPrefixed(nil, args[0]), uint32(seconds)), respInteger, nil
+}
+
+func encodeRESPMSet(args [][]byte) ([]byte, respReply, error) {
+	if len(args)%2 != 0 {
+		return nil, 0, fmt.Errorf("wrong number of arguments for 'mset' command")
+	}
+	buf := binary.BigEndian.AppendUint32(nil, uint32(len(args)/2))
+	for i := 0; i < len(args); i += 2 {
+		buf = appendLenPrefixed(appendLenPrefixed(buf, args[i]), args[i+1])
+		buf = binary.BigEndian.AppendUint32(buf, 0) // No TTL
+	}
+	return buf, respOK, nil
+}
+
+// encodeRESPScan encodes SCAN cursor [MATCH pattern]. COUNT is accepted but
+// the binary SCAN picks its own page size.
+func encodeRESPScan(args [][]byte) ([]byte, respReply, error) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid cursor")
+	}
+
+	var pattern []byte
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, 0, errRESPSyntax
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+		default:
+			return nil, 0, errRESPSyntax
+		}
+	}
+	return appendLenPrefixed(binary.BigEndian.AppendUint32(nil, uint32(cursor)), pattern), respScan, nil
+}
+
+func encodeRESPPop(args [][]byte) ([]byte, respReply, error) {
+	buf := appendLenPrefixed(nil, args[0])
+	if len(args) == 1 {
+		return buf, respBulk, nil
+	}
+	count, err := parseRESPInt(args[1])
+	if err != nil || count < 0 || count > math.MaxUint32 {
+		return nil, 0, fmt.Errorf("value is out of range, must be positive")
+	}
+	buf = append(buf, POP_FLAG_COUNT)
+	return binary.BigEndian.AppendUint32(buf, uint32(count)), respArray, nil
+}
+
+// encodeRESPIndex encodes [keylen:4][key] followed by one (LINDEX) or two
+// (LRANGE) signed 32-bit indexes
+func encodeRESPIndex(reply respReply) func([][]byte) ([]byte, respReply, error) {
+	return func(args [][]byte) ([]byte, respReply, error) {
+		buf := appendLenPrefixed(nil, args[0])
+		for _, arg := range args[1:] {
+			n, err := parseRESPInt(arg)
+			if err != nil || n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, 0, fmt.Errorf("value is not an integer or out of range")
+			}
+			buf = binary.BigEndian.AppendUint32(buf, uint32(int32(n)))
+		}
+		return buf, reply, nil
+	}
+}
+
+func encodeRESPHashSet(args [][]byte) ([]byte, respReply, error) {
+	return appendLenPrefixed(appendLenPrefixed(appendLenPrefixed(nil, args[0]), args[1]), args[2]), respInteger, nil
+}
+
+func encodeRESPHashIncrBy(args [][]byte) ([]byte, respReply, error) {
+	delta, err := parseRESPInt(args[2])
+	if err != nil {
+		return nil, 0, err
+	}
+	buf := appendLenPrefixed(appendLenPrefixed(nil, args[0]), args[1])
+	return binary.BigEndian.AppendUint64(buf, uint64(delta)), respInteger, nil
+}
+
+func encodeRESPZAdd(args [][]byte) ([]byte, respReply, error) {
+	pairs := args[1:]
+	if len(pairs)%2 != 0 {
+		return nil, 0, errRESPSyntax
+	}
+	buf := append(appendLenPrefixed(nil, args[0]), 0) // No ZADD flags
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(pairs)/2))
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := parseRESPFloat(pairs[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(score))
+		buf = appendLenPrefixed(buf, pairs[i+1])
+	}
+	return buf, respInteger, nil
+}
+
+func encodeRESPZIncrBy(args [][]byte) ([]byte, respReply, error) {
+	delta, err := parseRESPFloat(args[1])
+	if err != nil {
+		return nil, 0, err
+	}
+	buf := binary.BigEndian.AppendUint64(appendLenPrefixed(nil, args[0]), math.Float64bits(delta))
+	return appendLenPrefixed(buf, args[2]), respDouble, nil
+}
+
+func encodeRESPZRank(args [][]byte) ([]byte, respReply, error) {
+	return append(appendLenPrefixed(appendLenPrefixed(nil, args[0]), args[1]), 0), respInteger, nil
+}
+
+func encodeRESPZRange(args [][]byte) ([]byte, respReply, error) {
+	var flags uint8
+	reply := respArray
+	for _, arg := range args[3:] {
+		if !strings.EqualFold(string(arg), "WITHSCORES") {
+			return nil, 0, errRESPSyntax
+		}
+		flags |= ZRANGE_WITHSCORES
+		reply = respScores
+	}
+	buf := append(appendLenPrefixed(nil, args[0]), flags)
+	return appendLenPrefixed(appendLenPrefixed(buf, args[1]), args[2]), reply, nil
+}
+
+func encodeRESPSelect(args [][]byte) ([]byte, respReply, error) {
+	index, err := parseRESPInt(args[0])
+	if err != nil || index < 0 || index > math.MaxUint32 {
+		return nil, 0, fmt.Errorf("invalid DB index")
+	}
+	return binary.BigEndian.AppendUint32(nil, uint32(index)), respOK, nil
+}
+
+// encodeRESPShutdown encodes SHUTDOWN [SAVE|NOSAVE] as [flags:1], not
+// saving unless asked to
+func encodeRESPShutdown(args [][]byte) ([]byte, respReply, error) {
+	var flags byte
+	for _, arg := range args {
+		switch strings.ToUpper(string(arg)) {
+		case "SAVE":
+			flags |= SHUTDOWN_SAVE
+		case "NOSAVE":
+			flags &^= SHUTDOWN_SAVE
+		default:
+			return nil, 0, fmt.Errorf("syntax error")
+		}
+	}
+	return []byte{flags}, respOK, nil
+}
+
+func encodeRESPPublish(args [][]byte) ([]byte, respReply, error) {
+	return append(appendLenPrefixed(nil, args[0]), args[1]...), respInteger, nil
+}
+
+// respMessages translates RESP arguments into binary messages, so both
+// protocols share one parser. Split commands produce one message per run.
+func (s *GoFastServer) respMessages(spec respCommand, args [][]byte) ([]*Message, respReply, error) {
+	groups := [][][]byte{args}
+	if spec.split > 0 {
+		prefix := 0
+		if spec.keyed {
+			prefix = 1
+		}
+		rest := args[prefix:]
+		if len(rest) == 0 || len(rest)%spec.split != 0 {
+			return nil, 0, fmt.Errorf("wrong number of arguments")
+		}
+		groups = groups[:0]
+		for i := 0; i < len(rest); i += spec.split {
+			group := append(append([][]byte{}, args[:prefix]...), rest[i:i+spec.split]...)
+			groups = append(groups, group)
+		}
+	}
+
+	var reply respReply
+	msgs := make([]*Message, len(groups))
+	for i, group := range groups {
+		payload, r, err := spec.encode(group)
+		if err != nil {
+			return nil, 0, err
+		}
+		reply = r
+
+		msgs[i], err = s.decodeMessage(spec.command, payload)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return msgs, reply, nil
+}
+
+// respPending is a command queued inside MULTI, remembered so EXEC can
+// render its binary responses
+type respPending struct {
+	reply respReply
+	parts int
+}
+
+// respConn is a client connection speaking RESP
+type respConn struct {
+	server  *GoFastServer
+	reader  *RESPReader
+	writer  *RESPWriter
+	state   *connState
+	pending []respPending
+}
+
+// serveRESP runs a connection speaking RESP until it disconnects
+func (s *GoFastServer) serveRESP(reader *bufio.Reader, writer *bufio.Writer, state *connState) {
+	conn := &respConn{
+		server: s,
+		reader: NewRESPReader(reader, s.maxFrameSize()),
+		writer: NewRESPWriter(writer),
+		state:  state,
+	}
+
+	for {
+		args, err := conn.reader.ReadCommand()
+		if err != nil {
+			if err != io.EOF {
+				logf(LOG_WARN, "Read error: %v", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if err := conn.execute(args); err != nil {
+			if err != errRESPQuit && err != io.EOF {
+				logf(LOG_WARN, "Subscriber error: %v", err)
+			}
+			return
+		}
+
+		if err := conn.writer.Flush(); err != nil {
+			logf(LOG_WARN, "Write error: %v", err)
+			return
+		}
+	}
+}
+
+// execute runs one RESP command and writes its reply
+func (c *respConn) execute(args [][]byte) error {
+	if c.server.rateLimited(c.state) {
+		c.writer.WriteError("ERR rate limit exceeded")
+		return nil
+	}
+
+	name, ok := c.server.renames.Resolve(strings.ToUpper(string(args[0])))
+	if !ok {
+		c.writer.WriteError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return nil
+	}
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			c.writer.WriteBulk(args[1])
+		} else {
+			c.writer.WriteSimple("PONG")
+		}
+		return nil
+
+	case "ECHO":
+		if len(args) != 2 {
+			c.writer.WriteError("ERR wrong number of arguments for 'echo' command")
+			return nil
+		}
+		c.writer.WriteBulk(args[1])
+		return nil
+
+	case "QUIT":
+		c.writer.WriteSimple("OK")
+		c.writer.Flush()
+		return errRESPQuit
+
+	case "COMMAND":
+		// Clients probe COMMAND DOCS on connect; an empty reply is accepted
+		c.writer.WriteArrayHeader(0)
+		return nil
+
+	case "HELLO":
+		c.hello(args[1:])
+		return nil
+
+	case "AUTH":
+		c.auth(args[1:])
+		return nil
+	}
+
+	spec, ok := respCommands[name]
+	if !ok {
+		c.writer.WriteError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return nil
+	}
+	if len(args)-1 < spec.arity {
+		c.writer.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return nil
+	}
+
+	msgs, reply, err := c.server.respMessages(spec, args[1:])
+	if err != nil {
+		c.writer.WriteError("ERR " + err.Error())
+		return nil
+	}
+
+	switch {
+	case c.state.inMulti || isTransactionCommand(spec.command):
+		c.executeTransaction(spec.command, msgs, reply)
+		return nil
+
+	case (spec.command == CMD_SUBSCRIBE || spec.command == CMD_PSUBSCRIBE) && c.server.checkACL(c.state, msgs[0]) == nil:
+		c.server.incrementStat("total_ops")
+		return c.server.serveSubscriber(c, c.state, msgs[0])
+
+	case spec.command == CMD_MONITOR && c.server.checkACL(c.state, msgs[0]) == nil:
+		c.server.incrementStat("total_ops")
+		return c.server.serveMonitor(c, c.state)
+	}
+
+	responses := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		responses[i] = c.server.processCommand(c.state, msg)
+	}
+	c.writeResponses(reply, responses)
+	return nil
+}
+
+// auth handles AUTH [username] password
+func (c *respConn) auth(args [][]byte) {
+	if len(args) < 1 || len(args) > 2 {
+		c.writer.WriteError("ERR wrong number of arguments for 'auth' command")
+		return
+	}
+	var username []byte
+	if len(args) == 2 {
+		username = args[0]
+	}
+	payload := appendLenPrefixed(nil, username)
+	payload = appendLenPrefixed(payload, args[len(args)-1])
+	c.writer.WriteResponse(respOK, c.server.handleAuth(c.state, payload))
+}
+
+// hello handles HELLO [protover], switching the connection to RESP3 when
+// asked, and replies with the server properties
+func (c *respConn) hello(args [][]byte) {
+	if len(args) > 1 {
+		c.writer.WriteError("ERR syntax error in HELLO option")
+		return
+	}
+	if len(args) == 1 {
+		protover, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			c.writer.WriteError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if protover != 2 && protover != 3 {
+			c.writer.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		c.writer.version = protover
+	}
+
+	c.writer.WriteMapHeader(6)
+	c.writer.WriteBulk([]byte("server"))
+	c.writer.WriteBulk([]byte("gofast"))
+	c.writer.WriteBulk([]byte("version"))
+	c.writer.WriteBulk([]byte(version))
+	c.writer.WriteBulk([]byte("proto"))
+	c.writer.WriteInteger(int64(c.writer.version))
+	c.writer.WriteBulk([]byte("mode"))
+	c.writer.WriteBulk([]byte("standalone"))
+	c.writer.WriteBulk([]byte("role"))
+	c.writer.WriteBulk([]byte("master"))
+	c.writer.WriteBulk([]byte("modules"))
+	c.writer.WriteArrayHeader(0)
+}
+
+// writeResponses writes the reply to one RESP command, summing the integer
+// replies of split commands
+func (c *respConn) writeResponses(reply respReply, responses [][]byte) {
+	if len(responses) == 1 {
+		c.writer.WriteResponse(reply, responses[0])
+		return
+	}
+
+	var total int64
+	for _, response := range responses {
+		if response[0] == RESP_ERROR {
+			c.writer.WriteResponse(reply, response)
+			return
+		}
+		n, _ := strconv.ParseInt(string(response[5:]), 10, 64)
+		total += n
+	}
+	c.writer.WriteInteger(total)
+}
+
+// executeTransaction handles MULTI, EXEC, DISCARD and WATCH, and queues
+// other commands while inside MULTI
+func (c *respConn) executeTransaction(command uint8, msgs []*Message, reply respReply) {
+	switch command {
+	case CMD_EXEC:
+		pending := c.pending
+		c.pending = nil
+		response := c.server.processTransaction(c.state, msgs[0])
+		if response[0] != RESP_OK {
+			c.writer.WriteResponse(respArray, response)
+			return
+		}
+
+		responses, ok := splitPipelineResponse(response[5:])
+		if !ok {
+			c.writer.WriteError("ERR malformed reply")
+			return
+		}
+		c.writer.WriteArrayHeader(len(pending))
+		for _, p := range pending {
+			c.writeResponses(p.reply, responses[:p.parts])
+			responses = responses[p.parts:]
+		}
+
+	case CMD_MULTI, CMD_DISCARD, CMD_WATCH:
+		response := c.server.processTransaction(c.state, msgs[0])
+		if command == CMD_DISCARD && response[0] == RESP_OK {
+			c.pending = nil
+		}
+		c.writer.WriteResponse(respOK, response)
+
+	default:
+		for _, msg := range msgs {
+			if response := c.server.processTransaction(c.state, msg); response[0] == RESP_ERROR {
+				c.writer.WriteResponse(reply, response)
+				return
+			}
+		}
+		c.pending = append(c.pending, respPending{reply: reply, parts: len(msgs)})
+		c.writer.WriteSimple("QUEUED")
+	}
+}
+
+// splitPipelineResponse splits an encodePipelineResponse payload into the
+// individual createResponse buffers
+func splitPipelineResponse(data []byte) ([][]byte, bool) {
+	if len(data) < 4 {
+		return nil, false
+	}
+	count := int(binary.BigEndian.Uint32(data[0:4]))
+	if count > len(data)/5 {
+		return nil, false
+	}
+
+	responses := make([][]byte, count)
+	offset := 4
+	for i := range responses {
+		if offset+5 > len(data) {
+			return nil, false
+		}
+		end := offset + 5 + int(binary.BigEndian.Uint32(data[offset+1:offset+5]))
+		if end > len(data) {
+			return nil, false
+		}
+		responses[i] = data[offset:end]
+		offset = end
+	}
+	return responses, true
+}
+
+// readMessage reads the next command in subscription or monitor mode.
+// Anything other than a subscription command or RESET becomes an empty
+// message, which both modes reject.
+func (c *respConn) readMessage() (*Message, error) {
+	for {
+		args, err := c.reader.ReadCommand()
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		name, allowed := c.server.renames.Resolve(strings.ToUpper(string(args[0])))
+		spec, ok := respCommands[name]
+		if !allowed || !ok || (respCommandNames[spec.command] == "" && spec.command != CMD_RESET) || len(args)-1 < spec.arity {
+			return &Message{}, nil
+		}
+		msgs, _, err := c.server.respMessages(spec, args[1:])
+		if err != nil {
+			return &Message{}, nil
+		}
+		return msgs[0], nil
+	}
+}
+
+// writeReply confirms a (P)SUBSCRIBE or (P)UNSUBSCRIBE with one
+// [kind, channel, count] array per channel, as Redis does
+func (c *respConn) writeReply(msg *Message, response []byte) error {
+	if response[0] != RESP_OK {
+		c.writer.WriteResponse(respOK, response)
+		return nil
+	}
+
+	kind := []byte(respCommandNames[msg.Command])
+	count, _ := strconv.Atoi(string(response[5:]))
+	channels := newArgReader(msg.Value).keyList()
+	if len(channels) == 0 {
+		c.writer.WritePushHeader(3)
+		c.writer.WriteBulk(kind)
+		c.writer.WriteNull()
+		c.writer.WriteInteger(int64(count))
+		return nil
+	}
+
+	// The broker applies the whole command at once, so the running count
+	// after each channel is reconstructed from the final one
+	for i, channel := range channels {
+		remaining := len(channels) - 1 - i
+		n := count + remaining
+		if msg.Command == CMD_SUBSCRIBE || msg.Command == CMD_PSUBSCRIBE {
+			n = max(count-remaining, 1)
+		}
+		c.writer.WritePushHeader(3)
+		c.writer.WriteBulk(kind)
+		c.writer.WriteBulk([]byte(channel))
+		c.writer.WriteInteger(int64(n))
+	}
+	return nil
+}
+
+// writePush turns a RESP_PUSH frame into a ["message", channel, payload]
+// array, or a push message in RESP3
+func (c *respConn) writePush(frame []byte) error {
+	args := newArgReader(frame[5:])
+	channel := args.bytes()
+	message := args.bytes()
+	if args.err != nil {
+		return args.err
+	}
+	c.writer.WritePushHeader(3)
+	c.writer.WriteBulk([]byte("message"))
+	c.writer.WriteBulk(channel)
+	c.writer.WriteBulk(message)
+	return nil
+}
+
+func (c *respConn) flush() error {
+	return c.writer.Flush()
+}
@@ -2,25 +2,105 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"os"
+	"sync"
 	"time"
 )
 
+// tlsHandshakeTimeout bounds how long a client may take to complete the TLS
+// handshake, so slow or idle connects cannot hold connections open
+const tlsHandshakeTimeout = 10 * time.Second
+
+// connState is the per-connection protocol and MULTI/EXEC state
+type connState struct {
+	protocol   uint8       // Binary protocol version negotiated with HELLO
+	username   string      // ACL user after AUTH, empty until then
+	clientIP   string      // Remote IP, empty for Unix socket clients
+	clientAddr string      // Remote "ip:port", or "unix" for Unix socket clients
+	activeDB   int         // Database chosen with SELECT
+	client     *ClientInfo // Entry in CLIENT LIST, nil for internal callers
+
+	inMulti     bool
+	queued      []Message
+	watchedKeys map[watchKey]uint64 // Key -> version at WATCH time
+	aborted     bool                // A command was refused while queuing
+
+	closed    chan struct{} // Closed once the client has gone
+	closeOnce sync.Once
+}
+
+func newConnState() *connState {
+	return &connState{
+		protocol:    PROTOCOL_VERSION_1,
+		watchedKeys: make(map[watchKey]uint64),
+		closed:      make(chan struct{}),
+	}
+}
+
+// disconnect marks the client gone, waking its blocked commands
+func (c *connState) disconnect() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// gone returns a channel closed once the client has gone, or noWait while
+// its EXEC runs. Internal callers have none and are never woken.
+func (c *connState) gone() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	if c.inMulti {
+		return noWait
+	}
+	return c.closed
+}
+
+// reset leaves MULTI and drops the queued commands
+func (c *connState) reset() {
+	c.inMulti = false
+	c.queued = nil
+	c.aborted = false
+}
+
 func (s *GoFastServer) SetConfig(config *Config) {
 	s.config = config
+	setLogLevel(config.LogLevel)
+
+	// Validate has already rejected malformed event flags
+	s.keyspace, _ = ParseKeyspaceConfig(config.NotifyKeyspaceEvents)
+	s.aclManager.Configure(config)
+	s.ipFilter, _ = ParseIPFilter(config.AllowedCIDRs, config.DeniedCIDRs)
+	s.renames, _ = ParseCommandRenames(config.RenamedCommands)
+	s.maxMemory, _ = config.ParseMemorySize()
+
+	s.rateLimiter = nil
+	if config.RateLimitEnabled {
+		s.rateLimiter = NewRateLimiter(config.RateLimitOps, time.Duration(config.RateLimitWindowSec)*time.Second)
+	}
 }
 
 func NewGoFastServer(port int) *GoFastServer {
-	return &GoFastServer{
+	s := &GoFastServer{
 		port:     port,
-		ttlIndex: make(map[string]int64),
 		stats:    &ServerStats{},
+		metrics:  NewMetrics(),
 		bytePool: NewBytePool(),
 		config:   nil, // Will be set later
+
+		startTime: time.Now(),
+
+		watchedKeys: make(map[watchKey]*keyWatch),
+	}
+	for i := range s.databases {
+		s.databases[i] = &DatabaseState{GoFastServer: s, index: i, ttlIndex: make(map[string]int64)}
 	}
+	s.pubsub = NewPubSubBroker(s.matchPattern)
+	s.aclManager = NewACLManager(s.matchPattern)
+	return s
 }
 
 // Start begins listening for connections
@@ -33,68 +113,383 @@ func (s *GoFastServer) Start() error {
 		host = s.config.Host
 	}
 
+	// Restore the data before any client can connect
+	if s.config != nil && s.config.EnablePersist {
+		if err := s.loadData(); err != nil {
+			return fmt.Errorf("failed to start server: %v", err)
+		}
+	}
+
 	address := fmt.Sprintf("%s:%d", host, s.port)
 	s.listener, err = net.Listen("tcp", address)
 	if err != nil {
 		return fmt.Errorf("failed to start server: %v", err)
 	}
 
-	s.running = true
-	log.Printf("GoFast server started on %s", address)
+	if s.config != nil && s.config.TLSEnabled {
+		tlsConfig, err := loadTLSConfig(s.config)
+		if err != nil {
+			s.listener.Close()
+			return fmt.Errorf("failed to start server: %v", err)
+		}
+		s.listener = tls.NewListener(s.listener, tlsConfig)
+	}
+
+	if s.config != nil && s.config.UnixSocket != "" {
+		// Clear a socket file left behind by an unclean shutdown
+		os.Remove(s.config.UnixSocket)
+		s.unixListener, err = net.Listen("unix", s.config.UnixSocket)
+		if err != nil {
+			s.listener.Close()
+			return fmt.Errorf("failed to listen on unix socket: %v", err)
+		}
+	}
+
+	if s.config != nil && s.config.WSPort != 0 {
+		s.wsGateway = NewWebSocketGateway(s, fmt.Sprintf("%s:%d", host, s.config.WSPort))
+		if err := s.wsGateway.Start(); err != nil {
+			s.wsGateway = nil
+			s.Stop()
+			return err
+		}
+		logf(LOG_INFO, "GoFast WebSocket gateway listening on %s:%d", host, s.config.WSPort)
+	}
+
+	if s.config != nil && s.config.HTTPPort != 0 {
+		s.httpGateway = NewHTTPGateway(s, fmt.Sprintf("%s:%d", host, s.config.HTTPPort))
+		if err := s.httpGateway.Start(); err != nil {
+			s.httpGateway = nil
+			s.Stop()
+			return err
+		}
+		logf(LOG_INFO, "GoFast HTTP gateway listening on %s:%d", host, s.config.HTTPPort)
+	}
+
+	if s.config != nil && s.config.MonitoringAddr != "" {
+		s.metricsHTTP, err = StartMetricsServer(s, s.config.MonitoringAddr)
+		if err != nil {
+			s.Stop()
+			return err
+		}
+		logf(LOG_INFO, "GoFast metrics listening on %s/metrics", s.config.MonitoringAddr)
+	}
+
+	if s.rateLimiter != nil {
+		s.rateLimiter.Start()
+	}
+
+	s.running.Store(true)
+	logf(LOG_INFO, "GoFast server started on %s", address)
 
 	// Start background cleanup goroutine
 	go s.cleanupExpiredKeys()
 
-	// Accept connections
-	for s.running {
-		conn, err := s.listener.Accept()
+	if s.unixListener != nil {
+		logf(LOG_INFO, "GoFast server listening on unix socket %s", s.config.UnixSocket)
+		go s.acceptConnections(s.unixListener)
+	}
+
+	s.acceptConnections(s.listener)
+	return nil
+}
+
+// acceptConnections serves connections from listener until the server stops
+func (s *GoFastServer) acceptConnections(listener net.Listener) {
+	for s.running.Load() {
+		conn, err := listener.Accept()
 		if err != nil {
-			if s.running {
-				log.Printf("Accept error: %v", err)
+			if s.running.Load() {
+				logf(LOG_ERROR, "Accept error: %v", err)
 			}
 			continue
 		}
 
+		if !s.ipFilter.AllowsAddr(conn.RemoteAddr().String()) {
+			go s.rejectConnection(conn, "ERR client IP not allowed")
+			continue
+		}
+
 		// Handle connection in goroutine
 		go s.handleConnection(conn)
 		s.incrementStat("connections")
 	}
+}
 
-	return nil
+// maxClients returns how many connections may be open at once, which
+// CONFIG SET max_clients changes at runtime
+func (s *GoFastServer) maxClients() int {
+	if s.config == nil {
+		return DefaultConfig().MaxClients
+	}
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.config.MaxClients
+}
+
+// rejectConnection tells a client the server will not serve why it is
+// being dropped and closes the connection
+func (s *GoFastServer) rejectConnection(conn net.Conn, reason string) {
+	defer conn.Close()
+	logf(LOG_WARN, "Rejected connection from %s: %s", conn.RemoteAddr(), reason)
+
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	writer := bufio.NewWriter(conn)
+	response := s.createResponse(RESP_ERROR, []byte(reason))
+	if err := s.writeFramedResponse(writer, response, PROTOCOL_VERSION_1, s.wireCompression(), 0); err == nil {
+		writer.Flush()
+	}
+}
+
+// loadTLSConfig builds the listener TLS configuration from the certificate
+// pair and, when set, the CA used to verify client certificates
+func loadTLSConfig(config *Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if config.TLSCAFile != "" {
+		caPEM, err := os.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in TLS CA file %s", config.TLSCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if config.TLSClientAuth {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// tlsConnectionStater is a connection that went through a TLS handshake
+type tlsConnectionStater interface {
+	ConnectionState() tls.ConnectionState
+}
+
+// clientCertUser returns the ACL user a verified client certificate
+// authenticates as, its subject common name, when tls_client_auth is set
+func (s *GoFastServer) clientCertUser(state tls.ConnectionState) string {
+	if s.config == nil || !s.config.TLSClientAuth || len(state.VerifiedChains) == 0 {
+		return ""
+	}
+	return state.PeerCertificates[0].Subject.CommonName
 }
 
 // Stop gracefully shuts down the server
 func (s *GoFastServer) Stop() {
-	s.running = false
+	s.running.Store(false)
 	if s.listener != nil {
 		s.listener.Close()
 	}
+	if s.unixListener != nil {
+		s.unixListener.Close()
+		os.Remove(s.config.UnixSocket)
+	}
+	if s.wsGateway != nil {
+		s.wsGateway.Stop()
+	}
+	if s.httpGateway != nil {
+		s.httpGateway.Stop()
+	}
+	if s.metricsHTTP != nil {
+		s.metricsHTTP.Close()
+	}
+	if s.rateLimiter != nil {
+		s.rateLimiter.Stop()
+	}
+	if s.aof != nil {
+		if err := s.aof.Close(); err != nil {
+			logf(LOG_ERROR, "AOF close error: %v", err)
+		}
+	}
+}
+
+// RunUntilSignal starts the server and serves until a signal arrives on
+// signals, then shuts it down. It returns early with the error Start failed
+// with.
+func (s *GoFastServer) RunUntilSignal(signals <-chan os.Signal) error {
+	started := make(chan error, 1)
+	go func() {
+		if err := s.Start(); err != nil {
+			started <- err
+		}
+	}()
+
+	select {
+	case err := <-started:
+		return err
+	case sig := <-signals:
+		logf(LOG_INFO, "Shutting down on %v", sig)
+	}
+	s.Shutdown()
+	return nil
 }
 
-// handleConnection processes client connections
+// Shutdown saves a snapshot when persistence is enabled, the previous one
+// staying in place if the save fails or outlasts DrainTimeout, and stops the
+// server
+func (s *GoFastServer) Shutdown() {
+	if s.config != nil && s.config.EnablePersist {
+		saved, err := s.SaveSnapshot()
+		if err != nil {
+			logf(LOG_ERROR, "Shutdown save incomplete: %v", err)
+		} else {
+			logf(LOG_INFO, "Saved %d keys to %s", saved, dataPath(s.config, s.config.RDBFile))
+		}
+	}
+	s.Stop()
+}
+
+// handleShutdown stops the server and exits the process, saving a snapshot
+// first when save is set. It only returns, with an error, when the save
+// could not be made.
+func (s *GoFastServer) handleShutdown(save bool) []byte {
+	if save {
+		if s.config == nil || !s.config.EnablePersist {
+			return s.createResponse(RESP_ERROR, []byte("ERR persistence is disabled"))
+		}
+		saved, err := s.SaveSnapshot()
+		if err != nil {
+			logf(LOG_ERROR, "SHUTDOWN save failed: %v", err)
+			return s.createResponse(RESP_ERROR, []byte("ERR Errors trying to SHUTDOWN. Check logs."))
+		}
+		logf(LOG_INFO, "Saved %d keys before SHUTDOWN", saved)
+	}
+
+	logf(LOG_INFO, "Shutting down on SHUTDOWN command")
+	s.Stop()
+	os.Exit(0)
+	return nil
+}
+
+// handleConnection processes client connections, refusing any beyond
+// max_clients whichever listener or gateway they arrived on
 func (s *GoFastServer) handleConnection(conn net.Conn) {
+	clients := s.connectedClients.Add(1)
+	defer s.connectedClients.Add(-1)
+	if clients > int64(s.maxClients()) {
+		s.rejectConnection(conn, "ERR max number of clients reached")
+		return
+	}
 	defer conn.Close()
 
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			logf(LOG_WARN, "TLS handshake error: %v", err)
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+
 	reader := bufio.NewReader(conn)
 	writer := bufio.NewWriter(conn)
 
+	state := newConnState()
+	state.clientIP = remoteIP(conn.RemoteAddr())
+	state.clientAddr = conn.RemoteAddr().String()
+	if state.clientIP == "" {
+		state.clientAddr = "unix"
+	}
+	defer s.unregisterClient(s.registerClient(state, conn))
+	defer s.unwatch(state)
+	defer state.disconnect()
+
+	// A verified client certificate stands in for AUTH
+	if certConn, ok := conn.(tlsConnectionStater); ok {
+		state.username = s.clientCertUser(certConn.ConnectionState())
+	}
+
+	// Redis clients open with a RESP array ('*') or simple string ('+'). A
+	// binary frame starts with its big-endian length, whose first byte is
+	// zero for any request under 16MB.
+	if first, err := reader.Peek(1); err == nil && (first[0] == '*' || first[0] == '+') {
+		s.serveRESP(reader, writer, state)
+		return
+	}
+
 	for {
-		// Read message from client
-		msg, err := s.readMessage(reader)
+		// Version 2 connections are multiplexed until HELLO switches back
+		if state.protocol >= PROTOCOL_VERSION_2 {
+			if err := s.serveMux(reader, writer, state); err != nil {
+				if err != io.EOF {
+					logf(LOG_WARN, "Read error: %v", err)
+				}
+				break
+			}
+			continue
+		}
+
+		// Read message from client. The reply is framed in the version the
+		// request arrived in, even when the request was HELLO.
+		protocol := state.protocol
+		msg, err := s.readFramedMessage(reader, protocol, s.wireCompression())
 		if err != nil {
 			if err != io.EOF {
-				log.Printf("Read error: %v", err)
+				logf(LOG_WARN, "Read error: %v", err)
 			}
 			break
 		}
 
-		// Process the command
-		response := s.processCommand(msg)
+		// Throttled requests are answered without running
+		limited := s.rateLimited(state)
+
+		// SUBSCRIBE and PSUBSCRIBE take over the connection until every
+		// subscription is dropped; a denied one falls through to its error
+		if !limited && !state.inMulti && (msg.Command == CMD_SUBSCRIBE || msg.Command == CMD_PSUBSCRIBE) && s.checkACL(state, msg) == nil {
+			s.incrementStat("total_ops")
+			subConn := &binaryConn{server: s, reader: reader, writer: writer, protocol: protocol}
+			if err := s.serveSubscriber(subConn, state, msg); err != nil {
+				if err != io.EOF {
+					logf(LOG_WARN, "Subscriber error: %v", err)
+				}
+				break
+			}
+			continue
+		}
+
+		// MONITOR streams every command run until the client sends RESET
+		if !limited && !state.inMulti && msg.Command == CMD_MONITOR && s.checkACL(state, msg) == nil {
+			s.incrementStat("total_ops")
+			monitorConn := &binaryConn{server: s, reader: reader, writer: writer, protocol: protocol}
+			if err := s.serveMonitor(monitorConn, state); err != nil {
+				if err != io.EOF {
+					logf(LOG_WARN, "Monitor error: %v", err)
+				}
+				break
+			}
+			continue
+		}
+
+		// Process the command, or let the transaction state queue it
+		var response []byte
+		if limited {
+			response = s.createResponse(RESP_ERROR, []byte("ERR rate limit exceeded"))
+		} else if msg.Command == CMD_HELLO {
+			response = s.handleHello(state, msg.Value)
+		} else if msg.Command == CMD_AUTH {
+			response = s.handleAuth(state, msg.Value)
+		} else if state.inMulti || isTransactionCommand(msg.Command) {
+			response = s.processTransaction(state, msg)
+		} else {
+			response = s.processCommand(state, msg)
+		}
 
 		// Send response
-		err = s.writeResponse(writer, response)
+		err = s.writeFramedResponse(writer, response, protocol, s.wireCompression(), msg.RequestID)
 		if err != nil {
-			log.Printf("Write error: %v", err)
+			logf(LOG_WARN, "Write error: %v", err)
 			break
 		}
 
@@ -102,31 +497,79 @@ func (s *GoFastServer) handleConnection(conn net.Conn) {
 	}
 }
 
+// wireCompression reports whether binary connections carry compression flags
+func (s *GoFastServer) wireCompression() bool {
+	return s.config != nil && s.config.CompressionEnabled
+}
+
+// handleHello switches the connection to another binary protocol version,
+// up to the one the server is configured for
+// Format: [version:1]
+func (s *GoFastServer) handleHello(state *connState, data []byte) []byte {
+	s.incrementStat("total_ops")
+
+	maxVersion := uint8(PROTOCOL_VERSION)
+	if s.config != nil {
+		maxVersion = uint8(s.config.ProtocolVersion)
+	}
+	if len(data) != 1 || data[0] < PROTOCOL_VERSION_1 || data[0] > maxVersion {
+		return s.createResponse(RESP_ERROR, []byte(fmt.Sprintf("Unsupported protocol version, this server speaks 1 to %d", maxVersion)))
+	}
+
+	state.protocol = data[0]
+	return s.createResponse(RESP_OK, []byte(fmt.Sprintf("%d", state.protocol)))
+}
+
+// expireKey removes a key whose TTL has run out and raises its expired event
+func (s *DatabaseState) expireKey(key string) {
+	s.storage.Delete(key)
+	s.ttlMutex.Lock()
+	delete(s.ttlIndex, key)
+	s.ttlMutex.Unlock()
+
+	s.touchKey(key)
+	s.notifyKeyspaceEvent(NOTIFY_EXPIRED, "expired", key)
+}
+
 func (s *GoFastServer) cleanupExpiredKeys() {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
-	for s.running {
+	for s.running.Load() {
 		<-ticker.C
-		now := time.Now().Unix()
-		s.ttlMutex.Lock()
-
-		var expiredKeys []string
-		for key, expiresAt := range s.ttlIndex {
-			if expiresAt <= now {
-				expiredKeys = append(expiredKeys, key)
-			}
+		start := time.Now()
+		for _, db := range s.databases {
+			db.removeExpiredKeys(start.Unix())
 		}
+		s.recordLatencySample(LATENCY_EVENT_EXPIRY_CYCLE, time.Since(start))
+		s.pruneAuthTracker(start.Unix())
+	}
+}
+
+// removeExpiredKeys drops every key of the database whose TTL has passed
+func (s *DatabaseState) removeExpiredKeys(now int64) {
+	s.ttlMutex.Lock()
 
-		for _, key := range expiredKeys {
-			s.storage.Delete(key)
-			delete(s.ttlIndex, key)
+	var expiredKeys []string
+	for key, expiresAt := range s.ttlIndex {
+		if expiresAt <= now {
+			expiredKeys = append(expiredKeys, key)
 		}
+	}
 
-		s.ttlMutex.Unlock()
+	for _, key := range expiredKeys {
+		s.storage.Delete(key)
+		delete(s.ttlIndex, key)
+	}
 
-		if len(expiredKeys) > 0 {
-			log.Printf("Cleaned up %d expired keys", len(expiredKeys))
-		}
+	s.ttlMutex.Unlock()
+
+	for _, key := range expiredKeys {
+		s.touchKey(key)
+		s.notifyKeyspaceEvent(NOTIFY_EXPIRED, "expired", key)
+	}
+
+	if len(expiredKeys) > 0 {
+		logf(LOG_DEBUG, "Cleaned up %d expired keys in database %d", len(expiredKeys), s.index)
 	}
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+	"time"
+)
+
+// SlowLogMaxLen is how many entries the slow log keeps, the oldest being
+// dropped first
+const SlowLogMaxLen = 128
+
+// SlowLogEntry is a command that took longer than slowlog_threshold_us
+type SlowLogEntry struct {
+	ID         uint64
+	Timestamp  int64 // Unix seconds the command finished at
+	DurationUs int64
+	Command    byte
+	Key        string
+}
+
+// recordSlowCommand adds msg to the slow log when it took at least the
+// configured threshold
+func (s *GoFastServer) recordSlowCommand(msg *Message, elapsed time.Duration) {
+	if s.config == nil {
+		return
+	}
+	s.configMutex.RLock()
+	enabled, thresholdUs := s.config.SlowLogEnabled, s.config.SlowLogThresholdUs
+	s.configMutex.RUnlock()
+	if !enabled || elapsed.Microseconds() < thresholdUs {
+		return
+	}
+
+	s.slowLogMutex.Lock()
+	defer s.slowLogMutex.Unlock()
+
+	entry := SlowLogEntry{
+		ID:         s.slowLogNextID,
+		Timestamp:  time.Now().Unix(),
+		DurationUs: elapsed.Microseconds(),
+		Command:    msg.Command,
+		Key:        string(msg.Key),
+	}
+	s.slowLogNextID++
+	if len(s.slowLog) == SlowLogMaxLen {
+		copy(s.slowLog, s.slowLog[1:])
+		s.slowLog = s.slowLog[:SlowLogMaxLen-1]
+	}
+	s.slowLog = append(s.slowLog, entry)
+}
+
+// handleSlowLog reads or clears the slow log
+// Format: [subcommand:1] followed by [count:4] for GET, which returns
+// the newest entries first as [id:8][timestamp:8][durationus:8][cmd:1][key]
+func (s *GoFastServer) handleSlowLog(data []byte) []byte {
+	args := newArgReader(data)
+	subcommand := args.uint8()
+
+	s.slowLogMutex.Lock()
+	defer s.slowLogMutex.Unlock()
+
+	switch subcommand {
+	case SLOWLOG_GET:
+		count := 10
+		if args.offset < len(data) {
+			count = int(args.uint32())
+		}
+		if args.err != nil {
+			return s.createResponse(RESP_ERROR, []byte("Invalid SLOWLOG data"))
+		}
+		count = min(count, len(s.slowLog))
+
+		values := make([][]byte, 0, count)
+		for i := len(s.slowLog) - 1; i >= len(s.slowLog)-count; i-- {
+			entry := s.slowLog[i]
+			value := binary.BigEndian.AppendUint64(nil, entry.ID)
+			value = binary.BigEndian.AppendUint64(value, uint64(entry.Timestamp))
+			value = binary.BigEndian.AppendUint64(value, uint64(entry.DurationUs))
+			value = append(value, entry.Command)
+			values = append(values, append(value, entry.Key...))
+		}
+		return s.createResponse(RESP_OK, s.encodeArray(values))
+
+	case SLOWLOG_LEN:
+		return s.createResponse(RESP_OK, []byte(strconv.Itoa(len(s.slowLog))))
+
+	case SLOWLOG_RESET:
+		s.slowLog = nil
+		return s.createResponse(RESP_OK, []byte("OK"))
+
+	default:
+		return s.createResponse(RESP_ERROR, []byte("Unknown SLOWLOG subcommand"))
+	}
+}
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"math"
+	"time"
+)
+
 // incrementStat atomically increments a statistic
 func (s *GoFastServer) incrementStat(stat string) {
 	s.stats.mutex.Lock()
@@ -16,7 +21,14 @@ func (s *GoFastServer) incrementStat(stat string) {
 		s.stats.DelOps++
 	case "connections":
 		s.stats.Connections++
+	case "evicted_keys":
+		s.stats.EvictedKeys++
+	case "hits":
+		s.stats.Hits++
+	case "misses":
+		s.stats.Misses++
 	}
+	s.metrics.count(stat)
 }
 
 // GetStats returns current server statistics
@@ -24,11 +36,15 @@ func (s *GoFastServer) GetStats() *ServerStats {
 	s.stats.mutex.RLock()
 	defer s.stats.mutex.RUnlock()
 
-	// Calculate hit rate
-	if s.stats.GetOps > 0 {
-		// This is a simplified hit rate calculation
-		// In practice, you'd track hits vs misses separately
-		s.stats.HitRate = float64(s.stats.GetOps-s.stats.DelOps) / float64(s.stats.GetOps)
+	var hitRate float64
+	if lookups := s.stats.Hits + s.stats.Misses; lookups > 0 {
+		hitRate = float64(s.stats.Hits) / float64(lookups)
+	}
+
+	latencies := make(map[byte]*LatencyBuckets, len(s.stats.CmdLatencies))
+	for command, buckets := range s.stats.CmdLatencies {
+		copied := *buckets
+		latencies[command] = &copied
 	}
 
 	// Return a copy to avoid race conditions
@@ -37,9 +53,114 @@ func (s *GoFastServer) GetStats() *ServerStats {
 		GetOps:       s.stats.GetOps,
 		SetOps:       s.stats.SetOps,
 		DelOps:       s.stats.DelOps,
-		HitRate:      s.stats.HitRate,
+		HitRate:      hitRate,
 		BytesRead:    s.stats.BytesRead,
 		BytesWritten: s.stats.BytesWritten,
 		Connections:  s.stats.Connections,
+		LastSaveTime: s.stats.LastSaveTime,
+		EvictedKeys:  s.stats.EvictedKeys,
+		Hits:         s.stats.Hits,
+		Misses:       s.stats.Misses,
+		CmdLatencies: latencies,
+	}
+}
+
+// latencyBounds are the upper bounds of the latency buckets, commands
+// slower than the last falling in a final overflow bucket
+var latencyBounds = [...]time.Duration{
+	time.Microsecond, 10 * time.Microsecond, 100 * time.Microsecond,
+	time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second,
+}
+
+// LatencyBuckets is a histogram of command processing times
+type LatencyBuckets struct {
+	Counts [len(latencyBounds) + 1]uint64
+	Sums   [len(latencyBounds) + 1]time.Duration // Total time of each bucket's commands
+}
+
+// record adds one command that took elapsed
+func (b *LatencyBuckets) record(elapsed time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && elapsed > latencyBounds[i] {
+		i++
+	}
+	b.Counts[i]++
+	b.Sums[i] += elapsed
+}
+
+// Percentile estimates the latency p percent of commands stayed within,
+// as the mean latency of the bucket holding that rank
+func (b *LatencyBuckets) Percentile(p float64) time.Duration {
+	var total uint64
+	for _, count := range b.Counts {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(p / 100 * float64(total)))
+	var seen uint64
+	for i, count := range b.Counts {
+		seen += count
+		if seen >= rank && count > 0 {
+			return b.Sums[i] / time.Duration(count)
+		}
 	}
+	return 0
+}
+
+// recordLatency adds how long a command took to its latency histogram
+func (s *GoFastServer) recordLatency(command byte, elapsed time.Duration) {
+	s.stats.mutex.Lock()
+	defer s.stats.mutex.Unlock()
+
+	if s.stats.CmdLatencies == nil {
+		s.stats.CmdLatencies = make(map[byte]*LatencyBuckets)
+	}
+	buckets, ok := s.stats.CmdLatencies[command]
+	if !ok {
+		buckets = &LatencyBuckets{}
+		s.stats.CmdLatencies[command] = buckets
+	}
+	buckets.record(elapsed)
+}
+
+// commandDone records the statistics of a command state ran that started
+// at start, and shows it to monitors
+func (s *GoFastServer) commandDone(state *connState, msg *Message, start time.Time) {
+	if msg.Command != CMD_PIPELINE {
+		s.feedMonitors(state, msg) // Pipelines feed each of their commands
+	}
+	elapsed := time.Since(start)
+	s.metrics.observeCommand(elapsed)
+	s.recordLatency(msg.Command, elapsed)
+	s.recordCommandStat(msg.Command, elapsed)
+	s.recordSlowCommand(msg, elapsed)
+	s.recordLatencySample(LATENCY_EVENT_COMMAND, elapsed)
+	if state != nil && state.client != nil {
+		state.client.recordCommand(msg.Command, state.activeDB)
+	}
+}
+
+// resetStats zeroes the command stats and the counters INFO reports. The
+// Prometheus counters keep counting, as counters must only grow.
+func (s *GoFastServer) resetStats() {
+	s.cmdStatsMutex.Lock()
+	s.cmdStats = nil
+	s.cmdStatsMutex.Unlock()
+
+	s.stats.mutex.Lock()
+	defer s.stats.mutex.Unlock()
+	s.stats.TotalOps = 0
+	s.stats.GetOps = 0
+	s.stats.SetOps = 0
+	s.stats.DelOps = 0
+	s.stats.BytesRead = 0
+	s.stats.BytesWritten = 0
+	s.stats.Connections = 0
+	s.stats.EvictedKeys = 0
+	s.stats.Hits = 0
+	s.stats.Misses = 0
+	s.stats.CmdLatencies = nil
 }
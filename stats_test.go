@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyspaceHitsAndMisses(t *testing.T) {
+	s := NewGoFastServer(0)
+	get := func(key string) byte {
+		return s.processCommand(nil, &Message{Command: CMD_GET, Key: []byte(key)})[0]
+	}
+
+	s.processCommand(nil, &Message{Command: CMD_SET, Key: []byte("present"), Value: []byte("v")})
+	s.processCommand(nil, &Message{Command: CMD_SET, Key: []byte("stale"), Value: []byte("v"), TTL: 60})
+	value, _ := s.databases[0].storage.Load("stale")
+	value.(*CacheItem).ExpiresAt = time.Now().Unix() - 1
+
+	if status := get("present"); status != RESP_OK {
+		t.Errorf("GET present = %d, want RESP_OK", status)
+	}
+	if status := get("present"); status != RESP_OK {
+		t.Errorf("GET present = %d, want RESP_OK", status)
+	}
+	if status := get("missing"); status != RESP_NOT_FOUND {
+		t.Errorf("GET missing = %d, want RESP_NOT_FOUND", status)
+	}
+	if status := get("stale"); status != RESP_NOT_FOUND {
+		t.Errorf("GET of an expired key = %d, want RESP_NOT_FOUND", status)
+	}
+
+	stats := s.GetStats()
+	if stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("hits, misses = %d, %d, want 2, 2", stats.Hits, stats.Misses)
+	}
+	if stats.HitRate != 0.5 {
+		t.Errorf("HitRate = %v, want 0.5", stats.HitRate)
+	}
+}
+
+func TestHitRateWithoutLookups(t *testing.T) {
+	s := NewGoFastServer(0)
+	if rate := s.GetStats().HitRate; rate != 0 {
+		t.Errorf("HitRate = %v before any GET, want 0", rate)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// NumShards is the number of independently locked segments of a
+// ShardedMap. It must be a power of two.
+const NumShards = 256
+
+// ShardedMap is the keyspace of a database: keys are spread over NumShards
+// maps, each behind its own lock, so writes to different keys rarely
+// contend. Its methods match sync.Map's, which it replaces, and the zero
+// value is ready to use.
+type ShardedMap struct {
+	shards [NumShards]shard
+}
+
+type shard struct {
+	sync.RWMutex
+	data map[string]*CacheItem
+}
+
+// shardFor hashes key with 32-bit FNV-1a
+func (m *ShardedMap) shardFor(key string) *shard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return &m.shards[hash&(NumShards-1)]
+}
+
+// Load returns the value stored for key, if any
+func (m *ShardedMap) Load(key any) (value any, ok bool) {
+	sh := m.shardFor(key.(string))
+	sh.RLock()
+	item, ok := sh.data[key.(string)]
+	sh.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return item, true
+}
+
+// Store sets the value for key, which must be a *CacheItem
+func (m *ShardedMap) Store(key, value any) {
+	sh := m.shardFor(key.(string))
+	sh.Lock()
+	if sh.data == nil {
+		sh.data = make(map[string]*CacheItem)
+	}
+	sh.data[key.(string)] = value.(*CacheItem)
+	sh.Unlock()
+}
+
+// Delete removes key
+func (m *ShardedMap) Delete(key any) {
+	m.LoadAndDelete(key)
+}
+
+// LoadAndDelete removes key, returning its value if it had one
+func (m *ShardedMap) LoadAndDelete(key any) (value any, loaded bool) {
+	sh := m.shardFor(key.(string))
+	sh.Lock()
+	item, loaded := sh.data[key.(string)]
+	delete(sh.data, key.(string))
+	sh.Unlock()
+	if !loaded {
+		return nil, false
+	}
+	return item, true
+}
+
+// Len returns the number of keys, expired ones included until removed
+func (m *ShardedMap) Len() int {
+	n := 0
+	for i := range m.shards {
+		m.shards[i].RLock()
+		n += len(m.shards[i].data)
+		m.shards[i].RUnlock()
+	}
+	return n
+}
+
+// Range calls f for every key until f returns false. Like sync.Map's, it
+// is not a consistent snapshot: f may modify the map, and keys stored or
+// deleted meanwhile may or may not be visited. Iteration starts at a
+// random shard, so the first keys visited are a random sample.
+func (m *ShardedMap) Range(f func(key, value any) bool) {
+	type entry struct {
+		key  string
+		item *CacheItem
+	}
+
+	start := rand.IntN(NumShards)
+	var entries []entry
+	for i := range NumShards {
+		sh := &m.shards[(start+i)&(NumShards-1)]
+
+		// Copy the shard so f runs without its lock held
+		sh.RLock()
+		entries = entries[:0]
+		for key, item := range sh.data {
+			entries = append(entries, entry{key, item})
+		}
+		sh.RUnlock()
+
+		for _, e := range entries {
+			if !f(e.key, e.item) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestShardedMapConcurrent mixes Store, Load, Delete and Range from many
+// goroutines; run it with -race
+func TestShardedMapConcurrent(t *testing.T) {
+	var m ShardedMap
+	const workers, keys = 8, 1000
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range keys {
+				key := fmt.Sprintf("w%d:k%d", w, i)
+				m.Store(key, &CacheItem{Value: []byte(key)})
+				if value, ok := m.Load(key); !ok || string(value.(*CacheItem).Value.([]byte)) != key {
+					t.Errorf("Load(%q) = %v, %v right after Store", key, value, ok)
+					return
+				}
+				if i%2 == 1 {
+					m.Delete(key)
+				}
+				if i%100 == 0 {
+					m.Range(func(key, value any) bool {
+						_ = value.(*CacheItem)
+						return true
+					})
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := m.Len(); n != workers*keys/2 {
+		t.Errorf("Len() = %d, want %d", n, workers*keys/2)
+	}
+	seen := 0
+	m.Range(func(key, value any) bool {
+		seen++
+		return true
+	})
+	if seen != workers*keys/2 {
+		t.Errorf("Range visited %d keys, want %d", seen, workers*keys/2)
+	}
+	if _, ok := m.LoadAndDelete("w0:k0"); !ok {
+		t.Error("LoadAndDelete missed a stored key")
+	}
+	if _, ok := m.Load("w0:k0"); ok {
+		t.Error("key still present after LoadAndDelete")
+	}
+}
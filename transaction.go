@@ -0,0 +1,215 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// keyWatch tracks a key watched by at least one connection. version is
+// bumped on every write so EXEC can tell whether the key changed since WATCH.
+type keyWatch struct {
+	version  atomic.Uint64
+	watchers int
+}
+
+// watchKey names a watched key within its database
+type watchKey struct {
+	db  int
+	key string
+}
+
+// singleKeyWrites are commands that only modify the key in msg.Key
+var singleKeyWrites = map[uint8]bool{
+	CMD_SET: true, CMD_DEL: true, CMD_EXPIRE: true, CMD_EXPIREAT: true, CMD_PEXPIREAT: true,
+	CMD_INCR: true, CMD_DECR: true, CMD_GETSET: true, CMD_SETBIT: true, CMD_BITFIELD: true,
+	CMD_LPUSH: true, CMD_RPUSH: true, CMD_LPOP: true, CMD_RPOP: true, CMD_LSET: true,
+	CMD_LINSERT: true, CMD_LTRIM: true, CMD_LREM: true,
+	CMD_SADD: true, CMD_SREM: true, CMD_SPOP: true,
+	CMD_HSET: true, CMD_HDEL: true, CMD_HMSET: true, CMD_HINCRBY: true, CMD_HINCRBYFLOAT: true,
+	CMD_HSETNX: true, CMD_HGETDEL: true,
+	CMD_ZADD: true, CMD_ZINCRBY: true, CMD_ZREM: true, CMD_ZPOPMIN: true, CMD_ZPOPMAX: true,
+	CMD_ZREMRANGEBYSCORE: true, CMD_ZREMRANGEBYRANK: true,
+	CMD_XADD: true, CMD_XDEL: true, CMD_XTRIM: true, CMD_XACK: true, CMD_XAUTOCLAIM: true,
+}
+
+// multiKeyWrites are commands whose modified keys live in the payload. They
+// invalidate every watched key rather than decoding their arguments again.
+var multiKeyWrites = map[uint8]bool{
+	CMD_MSET:  true,
+	CMD_LMOVE: true, CMD_BLPOP: true, CMD_BRPOP: true, CMD_LMPOP: true,
+	CMD_SMOVE: true, CMD_SUNIONSTORE: true, CMD_SINTERSTORE: true, CMD_SDIFFSTORE: true,
+	CMD_BITOP:       true,
+	CMD_ZUNIONSTORE: true, CMD_ZINTERSTORE: true, CMD_ZDIFFSTORE: true, CMD_ZRANGESTORE: true,
+	CMD_BZPOPMIN: true, CMD_BZPOPMAX: true, CMD_BZMPOP: true,
+	CMD_XGROUP: true, CMD_XREADGROUP: true,
+}
+
+func isTransactionCommand(command uint8) bool {
+	return command == CMD_MULTI || command == CMD_EXEC || command == CMD_DISCARD || command == CMD_WATCH
+}
+
+// touchCommand invalidates WATCHes on the keys msg may have modified
+func (s *DatabaseState) touchCommand(msg *Message, response []byte) {
+	if len(response) > 0 && response[0] == RESP_ERROR {
+		return
+	}
+	if singleKeyWrites[msg.Command] {
+		s.touchKey(string(msg.Key))
+	} else if multiKeyWrites[msg.Command] {
+		s.touchAllKeys()
+	}
+}
+
+// touchKey marks key as modified for every connection watching it
+func (s *DatabaseState) touchKey(key string) {
+	s.watchMutex.RLock()
+	defer s.watchMutex.RUnlock()
+	if w, ok := s.watchedKeys[watchKey{s.index, key}]; ok {
+		w.version.Add(1)
+	}
+}
+
+func (s *DatabaseState) touchAllKeys() {
+	s.watchMutex.RLock()
+	defer s.watchMutex.RUnlock()
+	for key, w := range s.watchedKeys {
+		if key.db == s.index {
+			w.version.Add(1)
+		}
+	}
+}
+
+// watch records the current version of each key in the connection's
+// database for state
+func (s *GoFastServer) watch(state *connState, keys []string) {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+
+	for _, name := range keys {
+		key := watchKey{state.activeDB, name}
+		if _, ok := state.watchedKeys[key]; ok {
+			continue
+		}
+		w, ok := s.watchedKeys[key]
+		if !ok {
+			w = &keyWatch{}
+			s.watchedKeys[key] = w
+		}
+		w.watchers++
+		state.watchedKeys[key] = w.version.Load()
+	}
+}
+
+// unwatch drops every key watched by state
+func (s *GoFastServer) unwatch(state *connState) {
+	s.watchMutex.Lock()
+	defer s.watchMutex.Unlock()
+
+	for key := range state.watchedKeys {
+		if w, ok := s.watchedKeys[key]; ok {
+			w.watchers--
+			if w.watchers == 0 {
+				delete(s.watchedKeys, key)
+			}
+		}
+		delete(state.watchedKeys, key)
+	}
+}
+
+// watchesIntact reports whether none of state's watched keys were modified
+func (s *GoFastServer) watchesIntact(state *connState) bool {
+	s.watchMutex.RLock()
+	defer s.watchMutex.RUnlock()
+
+	for key, version := range state.watchedKeys {
+		if w, ok := s.watchedKeys[key]; !ok || w.version.Load() != version {
+			return false
+		}
+	}
+	return true
+}
+
+// processTransaction handles MULTI, EXEC, DISCARD and WATCH, and queues
+// every other command while the connection is inside MULTI
+func (s *GoFastServer) processTransaction(state *connState, msg *Message) []byte {
+	if denied := s.checkACL(state, msg); denied != nil {
+		if state.inMulti && !isTransactionCommand(msg.Command) {
+			state.aborted = true
+		}
+		return denied
+	}
+
+	switch msg.Command {
+	case CMD_MULTI:
+		s.incrementStat("total_ops")
+		if state.inMulti {
+			return s.createResponse(RESP_ERROR, []byte("MULTI calls can not be nested"))
+		}
+		state.inMulti = true
+		return s.createResponse(RESP_OK, nil)
+
+	case CMD_EXEC:
+		s.incrementStat("total_ops")
+		if !state.inMulti {
+			return s.createResponse(RESP_ERROR, []byte("EXEC without MULTI"))
+		}
+		return s.execTransaction(state)
+
+	case CMD_DISCARD:
+		s.incrementStat("total_ops")
+		if !state.inMulti {
+			return s.createResponse(RESP_ERROR, []byte("DISCARD without MULTI"))
+		}
+		state.reset()
+		s.unwatch(state)
+		return s.createResponse(RESP_OK, nil)
+
+	case CMD_WATCH:
+		s.incrementStat("total_ops")
+		if state.inMulti {
+			return s.createResponse(RESP_ERROR, []byte("WATCH inside MULTI is not allowed"))
+		}
+		args := newArgReader(msg.Value)
+		keys := args.keyList()
+		if args.err != nil || len(keys) == 0 {
+			return s.createResponse(RESP_ERROR, []byte("Invalid WATCH data"))
+		}
+		s.watch(state, keys)
+		return s.createResponse(RESP_OK, nil)
+	}
+
+	// Subscription commands cannot run inside EXEC, so the whole
+	// transaction is refused like Redis does for queuing errors. Blocking
+	// pops are queued and reply nil at once when there is nothing to pop.
+	switch msg.Command {
+	case CMD_SUBSCRIBE, CMD_PSUBSCRIBE:
+		state.aborted = true
+		return s.createResponse(RESP_ERROR, []byte("Command not allowed inside a transaction"))
+	}
+
+	state.queued = append(state.queued, *msg)
+	return s.createResponse(RESP_OK, []byte("QUEUED"))
+}
+
+// execTransaction runs the queued commands back to back and replies with
+// their responses, or RESP_NOT_FOUND when a watched key was modified
+func (s *GoFastServer) execTransaction(state *connState) []byte {
+	defer s.unwatch(state)
+	defer state.reset()
+
+	if state.aborted {
+		return s.createResponse(RESP_ERROR, []byte("EXECABORT Transaction discarded because of previous errors"))
+	}
+
+	s.execMutex.Lock()
+	defer s.execMutex.Unlock()
+
+	if !s.watchesIntact(state) {
+		return s.createResponse(RESP_NOT_FOUND, nil)
+	}
+
+	responses := make([][]byte, len(state.queued))
+	for i := range state.queued {
+		responses[i] = s.executeCommand(state, &state.queued[i])
+	}
+	return s.createResponse(RESP_OK, s.encodePipelineResponse(responses))
+}
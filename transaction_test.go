@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestWatchAbortsOnConcurrentWrite runs WATCH/GET/MULTI/SET/EXEC increments
+// against a client running plain INCRs on the same key. Every increment
+// must survive: a lost one means a write landed inside an EXEC whose WATCH
+// had already been checked.
+func TestWatchAbortsOnConcurrentWrite(t *testing.T) {
+	const writes = 2000
+	s := NewGoFastServer(0)
+	key := []byte("counter")
+	s.processCommand(nil, &Message{Command: CMD_SET, Key: key, Value: []byte("0")})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writer := newConnState()
+		for range writes {
+			if response := s.processCommand(writer, &Message{Command: CMD_INCR, Key: key}); response[0] != RESP_OK {
+				t.Errorf("INCR failed: %q", response)
+				return
+			}
+		}
+	}()
+
+	state := newConnState()
+	watch := appendLenPrefixed(binary.BigEndian.AppendUint32(nil, 1), key)
+	committed, aborted := 0, 0
+	for committed < writes/4 {
+		s.processTransaction(state, &Message{Command: CMD_WATCH, Value: watch})
+		value, err := strconv.Atoi(string(s.processCommand(state, &Message{Command: CMD_GET, Key: key})[5:]))
+		if err != nil {
+			t.Fatalf("GET counter: %v", err)
+		}
+		s.processTransaction(state, &Message{Command: CMD_MULTI})
+		for range 100 { // Widen the gap between the WATCH check and the SET
+			s.processTransaction(state, &Message{Command: CMD_GET, Key: []byte("other")})
+		}
+		s.processTransaction(state, &Message{Command: CMD_SET, Key: key, Value: []byte(strconv.Itoa(value + 1))})
+		switch response := s.processTransaction(state, &Message{Command: CMD_EXEC}); response[0] {
+		case RESP_OK:
+			committed++
+		case RESP_NOT_FOUND:
+			aborted++
+		default:
+			t.Fatalf("EXEC failed: %q", response)
+		}
+	}
+	wg.Wait()
+
+	final, _ := strconv.Atoi(string(s.processCommand(nil, &Message{Command: CMD_GET, Key: key})[5:]))
+	if final != writes+committed {
+		t.Errorf("counter = %d after %d INCRs and %d committed transactions, want %d (%d aborted)",
+			final, writes, committed, writes+committed, aborted)
+	}
+}
+
+// TestBlockingPopInExec checks a BLPOP queued in MULTI on an empty list
+// replies nil inside EXEC instead of blocking it
+func TestBlockingPopInExec(t *testing.T) {
+	s := NewGoFastServer(0)
+	state := newConnState()
+	keys := appendLenPrefixed(binary.BigEndian.AppendUint32(nil, 1), []byte("queue"))
+	blpop := &Message{Command: CMD_BLPOP, Value: binary.BigEndian.AppendUint32(keys, math.Float32bits(0))}
+
+	s.processTransaction(state, &Message{Command: CMD_MULTI})
+	if response := s.processTransaction(state, blpop); response[0] != RESP_OK {
+		t.Fatalf("queuing BLPOP = %q, want QUEUED", response)
+	}
+	done := make(chan []byte, 1)
+	go func() { done <- s.processTransaction(state, &Message{Command: CMD_EXEC}) }()
+	select {
+	case response := <-done:
+		// [status:1][len:4] then [count:4] and the one BLPOP response
+		want := []byte{RESP_OK, 0, 0, 0, 9, 0, 0, 0, 1, RESP_NOT_FOUND, 0, 0, 0, 0}
+		if string(response) != string(want) {
+			t.Errorf("EXEC = %q, want one nil reply %q", response, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("EXEC blocked on BLPOP")
+	}
+}
@@ -2,21 +2,48 @@ package main
 
 import (
 	"net"
+	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"gofast/persist"
 )
 
 // Message represents a cache operation
 type Message struct {
-	Length  uint32
-	Version uint8
-	Command uint8
-	Key     []byte
-	Value   []byte
-	TTL     uint32 // Time to live in seconds
+	Length    uint64
+	Version   uint8
+	Command   uint8
+	RequestID uint32 // Echoed in the response, from protocol version 2
+	Key       []byte
+	Value     []byte
+	TTL       uint32 // Time to live in seconds
 }
 
-// Protocol version
-const PROTOCOL_VERSION = 0x01
+// Protocol versions. Version 1 frames carry a 4-byte length; version 2,
+// negotiated per connection with HELLO, carries an 8-byte length so a single
+// message can exceed 4GB, plus a request ID so requests can be multiplexed.
+const (
+	PROTOCOL_VERSION_1 = 0x01
+	PROTOCOL_VERSION_2 = 0x02
+	PROTOCOL_VERSION   = PROTOCOL_VERSION_2 // Highest version the server speaks
+)
+
+// DefaultMaxFrameSize is the default max_frame_size, the longest binary
+// frame a client may send
+const DefaultMaxFrameSize = 512 << 20
+
+// frameStreamThreshold is the payload size above which a frame is read
+// into a buffer that grows as data arrives, rather than trusting the
+// declared length for allocations
+const frameStreamThreshold = 64 << 10
+
+// Wire compression flags, sent after the request version byte and the
+// response status byte when compression_enabled is set
+const (
+	COMPRESS_LZ4 = 0x01 // Payload is [rawlen:4][LZ4 block]
+)
 
 // Command constants
 const (
@@ -34,13 +61,22 @@ const (
 	CMD_PIPELINE = 0x09
 
 	// List operations
-	CMD_LPUSH  = 0x10
-	CMD_RPUSH  = 0x11
-	CMD_LPOP   = 0x12
-	CMD_RPOP   = 0x13
-	CMD_LLEN   = 0x14
-	CMD_LINDEX = 0x15
-	CMD_LRANGE = 0x16
+	CMD_LPUSH   = 0x10
+	CMD_RPUSH   = 0x11
+	CMD_LPOP    = 0x12
+	CMD_RPOP    = 0x13
+	CMD_LLEN    = 0x14
+	CMD_LINDEX  = 0x15
+	CMD_LRANGE  = 0x16
+	CMD_LSET    = 0x17
+	CMD_LINSERT = 0x18
+	CMD_LTRIM   = 0x19
+	CMD_LPOS    = 0x1A
+	CMD_LMOVE   = 0x1B
+	CMD_BLPOP   = 0x1C
+	CMD_BRPOP   = 0x1D
+	CMD_LMPOP   = 0x1E
+	CMD_LREM    = 0x1F
 
 	// Set operations
 	CMD_SADD      = 0x20
@@ -48,6 +84,18 @@ const (
 	CMD_SMEMBERS  = 0x22
 	CMD_SCARD     = 0x23
 	CMD_SISMEMBER = 0x24
+	CMD_SUNION    = 0x25
+	CMD_SINTER    = 0x26
+	CMD_SDIFF     = 0x27
+
+	CMD_SUNIONSTORE = 0x28
+	CMD_SINTERSTORE = 0x29
+	CMD_SDIFFSTORE  = 0x2A
+	CMD_SMOVE       = 0x2B
+	CMD_SPOP        = 0x2C
+	CMD_SRANDMEMBER = 0x2D
+	CMD_SINTERCARD  = 0x2E
+	CMD_SSCAN       = 0x2F
 
 	// Hash operations
 	CMD_HSET    = 0x30
@@ -56,12 +104,303 @@ const (
 	CMD_HGETALL = 0x33
 	CMD_HLEN    = 0x34
 	CMD_HEXISTS = 0x35
+	CMD_HMSET   = 0x36
+	CMD_HMGET   = 0x37
+	CMD_HKEYS   = 0x38
+	CMD_HVALS   = 0x39
+
+	CMD_HINCRBY      = 0x3A
+	CMD_HINCRBYFLOAT = 0x3B
+	CMD_HSCAN        = 0x3C
+	CMD_HRANDFIELD   = 0x3D
+	CMD_HSETNX       = 0x3E
+	CMD_HGETDEL      = 0x3F
 
 	CMD_INCR   = 0x40
 	CMD_DECR   = 0x41
 	CMD_GETSET = 0x42
 	CMD_KEYS   = 0x43
 	CMD_SCAN   = 0x44
+
+	// Bit operations
+	CMD_SETBIT   = 0x70
+	CMD_GETBIT   = 0x71
+	CMD_BITCOUNT = 0x72
+	CMD_BITOP    = 0x73
+	CMD_BITPOS   = 0x74
+	CMD_BITFIELD = 0x75
+
+	// Pub/Sub operations
+	CMD_PUBLISH      = 0x80
+	CMD_SUBSCRIBE    = 0x81
+	CMD_UNSUBSCRIBE  = 0x82
+	CMD_PSUBSCRIBE   = 0x83
+	CMD_PUNSUBSCRIBE = 0x84
+	CMD_PUBSUB       = 0x85
+
+	// Stream operations
+	CMD_XADD      = 0x90
+	CMD_XREAD     = 0x91
+	CMD_XRANGE    = 0x92
+	CMD_XREVRANGE = 0x93
+	CMD_XLEN      = 0x94
+	CMD_XDEL      = 0x95
+	CMD_XTRIM     = 0x96
+
+	// Stream consumer group operations
+	CMD_XGROUP     = 0xA0
+	CMD_XREADGROUP = 0xA1
+	CMD_XACK       = 0xA2
+	CMD_XPENDING   = 0xA3
+	CMD_XINFO      = 0xA4
+	CMD_XAUTOCLAIM = 0xA5
+
+	// Sorted set operations
+	CMD_ZADD     = 0xB0
+	CMD_ZRANGE   = 0xB1
+	CMD_ZRANK    = 0xB2
+	CMD_ZREVRANK = 0xB3
+	CMD_ZSCORE   = 0xB4
+	CMD_ZINCRBY  = 0xB5
+	CMD_ZREM     = 0xB6
+	CMD_ZCARD    = 0xB7
+	CMD_ZCOUNT   = 0xB8
+	CMD_ZPOPMIN  = 0xB9
+	CMD_ZPOPMAX  = 0xBA
+
+	CMD_ZUNIONSTORE = 0xBB
+	CMD_ZINTERSTORE = 0xBC
+	CMD_ZDIFF       = 0xBD
+	CMD_ZDIFFSTORE  = 0xBE
+
+	CMD_ZRANGEBYSCORE    = 0xBF
+	CMD_ZREVRANGEBYSCORE = 0xC0
+	CMD_ZRANGEBYLEX      = 0xC1
+	CMD_ZLEXCOUNT        = 0xC2
+	CMD_ZREMRANGEBYSCORE = 0xC3
+	CMD_ZREMRANGEBYRANK  = 0xC4
+	CMD_ZSCAN            = 0xC5
+	CMD_BZPOPMIN         = 0xC6
+	CMD_BZPOPMAX         = 0xC7
+	CMD_ZRANGESTORE      = 0xC8
+	CMD_ZMSCORE          = 0xC9
+	CMD_BZMPOP           = 0xCA
+
+	// Transaction operations
+	CMD_MULTI   = 0xD0
+	CMD_EXEC    = 0xD1
+	CMD_DISCARD = 0xD2
+	CMD_WATCH   = 0xD3
+
+	// Connection operations
+	CMD_HELLO  = 0xD4
+	CMD_AUTH   = 0xD5
+	CMD_SELECT = 0xD6
+
+	// Persistence operations
+	CMD_BGSAVE       = 0xD7
+	CMD_BGREWRITEAOF = 0xD8
+
+	// Server management operations
+	CMD_SHUTDOWN = 0xD9
+	CMD_CONFIG   = 0xDA
+
+	// Absolute expiry operations
+	CMD_EXPIREAT    = 0x5B
+	CMD_PEXPIREAT   = 0x5C
+	CMD_EXPIRETIME  = 0x5D
+	CMD_PEXPIRETIME = 0x5E
+
+	// Introspection operations
+	CMD_MEMORY_USAGE = 0xE0
+	CMD_SLOWLOG      = 0xE1
+	CMD_INFO         = 0xE2
+	CMD_MONITOR      = 0xE3
+	CMD_LATENCY      = 0xE4
+	CMD_COMMANDSTATS = 0xE5
+	CMD_RESET        = 0xE6
+	CMD_OBJECT       = 0xE7
+
+	// Client management operations
+	CMD_CLIENT = 0xF0
+)
+
+// CLIENT subcommands
+const (
+	CLIENT_LIST    = 0x00
+	CLIENT_KILL    = 0x01
+	CLIENT_SETNAME = 0x02
+	CLIENT_GETNAME = 0x03
+	CLIENT_ID      = 0x04
+)
+
+// OBJECT subcommands
+const (
+	CMD_OBJECT_ENCODING = 0x60
+)
+
+// LMOVE direction flags
+const (
+	LMOVE_SRC_LEFT = 0x01 // Pop from the head of the source list
+	LMOVE_DST_LEFT = 0x02 // Push to the head of the destination list
+)
+
+// ZAddFlags are the ZADD modifier flags
+type ZAddFlags uint8
+
+const (
+	ZADD_NX   ZAddFlags = 0x01 // Only add new members
+	ZADD_XX   ZAddFlags = 0x02 // Only update existing members
+	ZADD_GT   ZAddFlags = 0x04 // Only update when the new score is greater
+	ZADD_LT   ZAddFlags = 0x08 // Only update when the new score is less
+	ZADD_INCR ZAddFlags = 0x10 // Treat the score as an increment
+)
+
+// ZRANGE flags
+const (
+	ZRANGE_BYSCORE    = 0x01 // start/stop are score bounds
+	ZRANGE_BYLEX      = 0x02 // start/stop are lexicographical bounds
+	ZRANGE_REV        = 0x04 // Order from the highest score
+	ZRANGE_WITHSCORES = 0x08 // Interleave scores with the returned members
+	ZRANGE_LIMIT      = 0x10 // Apply the LIMIT offset and count
+)
+
+// ZUNIONSTORE/ZINTERSTORE flags
+const (
+	ZSTORE_WEIGHTS   = 0x01 // Per-key weights follow the key list
+	ZSTORE_AGGREGATE = 0x02 // An aggregate byte follows the weights
+)
+
+// ZUNIONSTORE/ZINTERSTORE aggregate functions
+const (
+	ZAGGREGATE_SUM = 0x00
+	ZAGGREGATE_MIN = 0x01
+	ZAGGREGATE_MAX = 0x02
+)
+
+// ZDIFF flags
+const (
+	ZDIFF_WITHSCORES = 0x01 // Interleave scores with the returned members
+)
+
+// ZRANK/ZREVRANK flags
+const (
+	ZRANK_WITHSCORE = 0x01 // Return the member's score along with its rank
+)
+
+// ZRangeOpts are the decoded ZRANGE modifiers
+type ZRangeOpts struct {
+	ByScore    bool
+	ByLex      bool
+	Rev        bool
+	WithScores bool
+	Offset     int
+	Count      int // Negative means no limit
+}
+
+// HRANDFIELD flags
+const (
+	HRANDFIELD_WITHVALUES = 0x01 // Interleave values with the returned fields
+)
+
+// BITCOUNT/BITPOS range flags
+const (
+	BITRANGE_RANGE = 0x01 // Start and end restrict the scan
+	BITRANGE_BIT   = 0x02 // Start and end are bit indices rather than bytes
+)
+
+// BITOP operations
+const (
+	BITOP_AND = 0x00
+	BITOP_OR  = 0x01
+	BITOP_XOR = 0x02
+	BITOP_NOT = 0x03
+)
+
+// BITFIELD subcommands
+const (
+	BITFIELD_GET      = 0x00
+	BITFIELD_SET      = 0x01
+	BITFIELD_INCRBY   = 0x02
+	BITFIELD_OVERFLOW = 0x03
+)
+
+// BITFIELD overflow behaviors
+const (
+	BITFIELD_WRAP = 0x00 // Wrap around, the default
+	BITFIELD_SAT  = 0x01 // Saturate at the minimum or maximum value
+	BITFIELD_FAIL = 0x02 // Skip the write and reply nil
+)
+
+// BITFIELD type byte: the high bit marks a signed integer, the low bits
+// hold the width (i16 = 0x90, u8 = 0x08)
+const BITFIELD_SIGNED = 0x80
+
+// XADD/XTRIM flags
+const (
+	XADD_NOMKSTREAM = 0x01 // Do not create a missing stream
+	XTRIM_MAXLEN    = 0x02 // Threshold is the maximum number of entries
+	XTRIM_MINID     = 0x04 // Threshold is the lowest ID to keep
+)
+
+// PUBSUB subcommands
+const (
+	PUBSUB_CHANNELS = 0x00
+	PUBSUB_NUMSUB   = 0x01
+	PUBSUB_NUMPAT   = 0x02
+)
+
+// SLOWLOG subcommands
+const (
+	SLOWLOG_GET   = 0x00
+	SLOWLOG_LEN   = 0x01
+	SLOWLOG_RESET = 0x02
+)
+
+// SHUTDOWN flags
+const (
+	SHUTDOWN_SAVE = 0x01 // Save a snapshot before exiting
+)
+
+// CONFIG subcommands
+const (
+	CONFIG_RESETSTAT = 0x00
+	CONFIG_GET       = 0x01
+	CONFIG_SET       = 0x02
+)
+
+// LATENCY subcommands
+const (
+	LATENCY_HISTORY = 0x00
+	LATENCY_RESET   = 0x01
+	LATENCY_GRAPH   = 0x02
+)
+
+// XGROUP subcommands
+const (
+	XGROUP_CREATE         = 0x00
+	XGROUP_DESTROY        = 0x01
+	XGROUP_SETID          = 0x02
+	XGROUP_CREATECONSUMER = 0x03
+	XGROUP_DELCONSUMER    = 0x04
+)
+
+// XINFO subcommands
+const (
+	XINFO_STREAM    = 0x00
+	XINFO_GROUPS    = 0x01
+	XINFO_CONSUMERS = 0x02
+)
+
+// XGROUP CREATE and XREADGROUP flags
+const (
+	XGROUP_MKSTREAM  = 0x01 // Create the stream if it is missing
+	XREADGROUP_NOACK = 0x01 // Deliver without adding entries to the PEL
+)
+
+// LPOP/RPOP flags
+const (
+	POP_FLAG_COUNT = 0x01 // A count field follows the flags byte
 )
 
 // Response constants
@@ -69,6 +408,7 @@ const (
 	RESP_OK        = 0x00
 	RESP_ERROR     = 0x01
 	RESP_NOT_FOUND = 0x02
+	RESP_PUSH      = 0x03 // Unsolicited message delivered in subscription mode
 )
 
 // DataType represents the type of stored data
@@ -79,14 +419,32 @@ const (
 	TYPE_LIST   = 0x02
 	TYPE_SET    = 0x03
 	TYPE_HASH   = 0x04
+	TYPE_ZSET   = 0x05
+	TYPE_STREAM = 0x07
 )
 
 // CacheItem represents a stored cache item with type information
 type CacheItem struct {
 	DataType  DataType
-	Value     any   // Can be []byte, *List, *Set, or *Hash
+	Value     any   // Can be []byte, *List, *Set, *Hash, *ZSet, or *Stream
 	ExpiresAt int64 // Unix timestamp, 0 means no expiration
 	CreatedAt int64
+
+	Compressed bool // Value is a string held as [rawlen:4][LZ4 block], see stringValue
+
+	LastAccessedAt int64  // Unix seconds of the last command on the key, updated atomically
+	AccessCount    uint64 // Logarithmic LFU counter, updated atomically
+}
+
+// BitFieldOp is one BITFIELD subcommand. Value holds the SET value or the
+// INCRBY increment; Overflow is only used by OVERFLOW.
+type BitFieldOp struct {
+	Op       uint8
+	Signed   bool
+	Width    int
+	Offset   int
+	Value    int64
+	Overflow uint8
 }
 
 // List represents a doubly-linked list
@@ -115,21 +473,214 @@ type Hash struct {
 	mutex  sync.RWMutex
 }
 
+// ZSet represents a sorted set: a skip list ordered by (score, member) for
+// ranked access plus a member to score map for O(1) lookups
+type ZSet struct {
+	dict  map[string]float64
+	zsl   *SkipList
+	mutex sync.RWMutex
+}
+
+// Stream is an append-only log of entries ordered by ID
+type Stream struct {
+	entries []StreamEntry
+	lastID  StreamID // Highest ID ever added, kept across deletes and trims
+	groups  map[string]*ConsumerGroup
+	mutex   sync.RWMutex
+}
+
+// ConsumerGroup tracks what has been delivered to the consumers of a group.
+// The PEL (pending entries list) holds entries delivered but not yet
+// acknowledged.
+type ConsumerGroup struct {
+	name            string
+	lastDeliveredID StreamID
+	pel             map[StreamID]*PendingEntry
+	consumers       map[string]*StreamConsumer
+}
+
+// PendingEntry records who an unacknowledged entry was delivered to
+type PendingEntry struct {
+	Consumer      string
+	DeliveredAt   int64 // Unix milliseconds of the last delivery
+	DeliveryCount int
+}
+
+// StreamConsumer is a named reader within a consumer group
+type StreamConsumer struct {
+	name   string
+	seenAt int64 // Unix milliseconds of the last read
+}
+
+// StreamID identifies a stream entry: a millisecond timestamp plus a sequence
+// number for entries added within the same millisecond
+type StreamID struct {
+	Millis uint64
+	Seq    uint64
+}
+
+// StreamEntry is one stream record. Fields alternate field names and values
+// in the order they were added.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// StreamGroupInfo is a snapshot of a consumer group for XINFO GROUPS
+type StreamGroupInfo struct {
+	Name            string
+	Consumers       int
+	Pending         int
+	LastDeliveredID StreamID
+}
+
+// StreamConsumerInfo is a snapshot of a group consumer for XINFO CONSUMERS
+type StreamConsumerInfo struct {
+	Name    string
+	Pending int
+	SeenAt  int64 // Unix milliseconds of the last read
+}
+
+// ZEntry is a sorted set member with its score
+type ZEntry struct {
+	Member string
+	Score  float64
+}
+
+// ScoreBound is one end of a score range such as "5", "(5" or "+inf"
+type ScoreBound struct {
+	Value     float64
+	Exclusive bool
+}
+
+// LexBound is one end of a lexicographical range such as "[a", "(a", "-"
+// or "+". Inf is -1 for "-", 1 for "+" and 0 for a member bound.
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Inf       int
+}
+
+// SkipList keeps sorted set entries ordered by score, then member
+type SkipList struct {
+	header *SkipListNode
+	tail   *SkipListNode
+	length int
+	level  int
+}
+
+type SkipListNode struct {
+	member   string
+	score    float64
+	backward *SkipListNode
+	levels   []SkipListLevel
+}
+
+// SkipListLevel is a forward pointer and the number of nodes it skips,
+// which lets ranks be computed while descending the list
+type SkipListLevel struct {
+	forward *SkipListNode
+	span    int
+}
+
 type BytePool struct {
 	pool sync.Pool
 }
 
-// GoFastServer is the main server structure
-type GoFastServer struct {
-	storage  sync.Map         // Thread-safe storage
+// MAX_DATABASES is the number of logical databases SELECT switches between
+const MAX_DATABASES = 16
+
+// DatabaseState is one logical database. It embeds the server, so data
+// commands run as its methods see its own keyspace while sharing the
+// server's configuration, statistics and pub/sub.
+type DatabaseState struct {
+	*GoFastServer
+	index int
+
+	storage  ShardedMap       // Thread-safe storage
 	ttlIndex map[string]int64 // TTL index for efficient expiration
 	ttlMutex sync.RWMutex     // Protect TTL index
-	stats    *ServerStats     // Performance statistics
-	bytePool *BytePool        // ADD THIS LINE - Memory pool for byte slices
+
+	listBlockers sync.Map // Clients blocked in BLPOP/BRPOP, keyed by list key
+	zsetBlockers sync.Map // Clients blocked in BZPOPMIN/BZPOPMAX, keyed by sorted set key
+}
+
+// GoFastServer is the main server structure
+type GoFastServer struct {
+	databases [MAX_DATABASES]*DatabaseState // Selected per connection with SELECT
+
+	stats    *ServerStats // Performance statistics
+	metrics  *Metrics     // ServerStats mirrored for Prometheus
+	bytePool *BytePool    // ADD THIS LINE - Memory pool for byte slices
 	listener net.Listener
 	port     int
-	running  bool
+	running  atomic.Bool
 	config   *Config
+
+	configMutex sync.RWMutex // Guards the settings CONFIG SET changes; the rest are fixed once the server starts
+
+	startTime        time.Time    // When the server was created, for uptime
+	connectedClients atomic.Int64 // Open client connections
+	blockedClients   atomic.Int64 // Connections waiting in a blocking command
+
+	clients      sync.Map      // Client ID -> *ClientInfo of each open connection
+	nextClientID atomic.Uint64 // Last client ID handed out
+
+	unixListener net.Listener      // Optional Unix domain socket listener
+	wsGateway    *WebSocketGateway // Optional WebSocket listener
+	httpGateway  *HTTPGateway      // Optional JSON REST API listener
+	metricsHTTP  *http.Server      // Optional Prometheus /metrics listener
+
+	blockMutex sync.Mutex // Serializes wake-ups of blocked clients
+
+	pubsub   *PubSubBroker  // Channel and pattern subscriptions
+	keyspace KeyspaceConfig // Keyspace notifications to publish
+
+	aclManager *ACLManager // Users and their command and key permissions
+	ipFilter   *IPFilter   // Client addresses allowed to connect, nil for all
+
+	rateLimiter *RateLimiter // Per client IP request limits, nil when disabled
+
+	renames *CommandRenames // Renamed and disabled commands
+
+	authTracker sync.Map // Client IP -> *AuthRecord of recent AUTH failures
+
+	aof          *persist.AOFWriter // Append-only log of writes, nil without persistence
+	aofMutex     sync.Mutex         // Keeps AOF records in the order writes took effect
+	servedPops   []persist.Record   // Pops handed to blocked clients, logged after the write that woke them; guarded by aofMutex
+	savingRDB    atomic.Bool        // A BGSAVE is writing a snapshot
+	rewritingAOF atomic.Bool        // A BGREWRITEAOF is compacting the AOF
+
+	maxMemory       int64         // Parsed max_memory in bytes, 0 for no limit
+	memoryUsed      atomic.Uint64 // Heap size as of memoryCheckedAt
+	memoryCheckedAt atomic.Int64  // Unix nanoseconds memoryUsed was sampled at
+
+	monitors      []chan string // Lines for the connections in MONITOR mode
+	monitorsMutex sync.RWMutex  // Protects monitors
+
+	cmdStats      map[byte]*CmdStat // Calls and time per command since CONFIG RESETSTAT
+	cmdStatsMutex sync.Mutex        // Protects cmdStats
+
+	latencySamples map[string][]LatencySample // Per event, one sample per second
+	latencyMutex   sync.Mutex                 // Protects latencySamples
+
+	slowLog       []SlowLogEntry // Commands over slowlog_threshold_us, oldest first
+	slowLogNextID uint64         // ID of the next slow log entry
+	slowLogMutex  sync.Mutex     // Protects slowLog and slowLogNextID
+
+	watchedKeys map[watchKey]*keyWatch // Keys under WATCH by any connection
+	watchMutex  sync.RWMutex           // Protects watchedKeys
+	execMutex   sync.RWMutex           // Held by EXEC, shared by every other command
+}
+
+// blockedClient is a connection parked in a blocking pop until data
+// arrives on one of its keys or its timeout fires
+type blockedClient struct {
+	keys     []string
+	fromHead bool          // Pop from the head (left) side, or the lowest score
+	count    int           // Sorted set members to pop per wake-up
+	done     bool          // Served or timed out, guarded by blockMutex
+	result   chan [][]byte // Buffered, receives the delivered reply
 }
 
 // ServerStats tracks performance metrics
@@ -142,5 +693,12 @@ type ServerStats struct {
 	BytesRead    uint64
 	BytesWritten uint64
 	Connections  uint64
-	mutex        sync.RWMutex
+	LastSaveTime int64 // Unix seconds of the last successful snapshot
+	EvictedKeys  uint64
+	Hits         uint64 // GETs that found a live string
+	Misses       uint64 // GETs of missing or expired keys
+
+	CmdLatencies map[byte]*LatencyBuckets // Processing time of each command
+
+	mutex sync.RWMutex
 }
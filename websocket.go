@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"nhooyr.io/websocket"
+)
+
+// WebSocketGateway serves the binary protocol over WebSocket for clients
+// that cannot open raw TCP sockets, such as browsers and sandboxed
+// serverless runtimes. Clients send each request frame as one binary
+// message. Each connection is handed to handleConnection, so it gets the
+// same protocol negotiation and authentication as a TCP connection.
+type WebSocketGateway struct {
+	server     *GoFastServer
+	httpServer *http.Server
+}
+
+func NewWebSocketGateway(server *GoFastServer, address string) *WebSocketGateway {
+	g := &WebSocketGateway{server: server}
+	g.httpServer = &http.Server{Addr: address, Handler: g}
+	return g
+}
+
+// Start listens on the gateway address and serves connections in the
+// background. With TLS enabled the gateway speaks wss:// using the server
+// certificate.
+func (g *WebSocketGateway) Start() error {
+	listener, err := net.Listen("tcp", g.httpServer.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start WebSocket gateway: %v", err)
+	}
+
+	config := g.server.config
+	if config != nil && config.TLSEnabled {
+		tlsConfig, err := loadTLSConfig(config)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("failed to start WebSocket gateway: %v", err)
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	go func() {
+		if err := g.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logf(LOG_ERROR, "WebSocket gateway error: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (g *WebSocketGateway) Stop() {
+	g.httpServer.Close()
+}
+
+// ServeHTTP upgrades the request and bridges the WebSocket to the binary
+// protocol until either side closes it
+func (g *WebSocketGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !g.server.ipFilter.AllowsAddr(r.RemoteAddr) {
+		http.Error(w, "ERR client IP not allowed", http.StatusForbidden)
+		return
+	}
+
+	ws, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		logf(LOG_WARN, "WebSocket upgrade error: %v", err)
+		return
+	}
+
+	conn := websocket.NetConn(context.Background(), ws, websocket.MessageBinary)
+	g.server.incrementStat("connections")
+	if r.TLS != nil {
+		conn = &wsTLSConn{Conn: conn, state: *r.TLS}
+	}
+	g.server.handleConnection(conn)
+}
+
+// wsTLSConn carries the TLS state of the HTTPS request a WebSocket was
+// upgraded from, so client certificates identify the connection
+type wsTLSConn struct {
+	net.Conn
+	state tls.ConnectionState
+}
+
+func (c *wsTLSConn) ConnectionState() tls.ConnectionState {
+	return c.state
+}